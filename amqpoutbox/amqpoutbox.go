@@ -0,0 +1,224 @@
+// Package amqpoutbox provides a transactional outbox for AMQP.
+//
+// The messages are written to an outbox table (or collection) in the same transaction as the business data.
+// A Relay reads the pending messages approximately in insertion order, produces them, and marks them as sent.
+// The order is not guaranteed across concurrent transactions, see the stores documentation.
+// It guarantees that a message is produced if and only if the transaction is committed.
+//
+// The delivery is "at least once": a message can be produced more than once, if the relay fails to mark it as sent.
+//
+// Stores are provided for database/sql (MySQL) and MongoDB.
+package amqpoutbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/ctxutils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+	"github.com/streadway/amqp"
+)
+
+// Message is a message stored in the outbox.
+type Message struct {
+	// ID is defined by the Store.
+	ID         string
+	Exchange   string
+	RoutingKey string
+	Publishing amqp.Publishing
+}
+
+// Store stores the outbox messages.
+type Store interface {
+	// Fetch returns the pending messages, approximately in the insertion order.
+	// It returns at most limit messages.
+	Fetch(ctx context.Context, limit int) ([]*Message, error)
+	// MarkSent marks messages as sent.
+	MarkSent(ctx context.Context, msgs []*Message) error
+	// Lease acquires or renews the relay lease for an owner, until now + ttl.
+	// It returns false if the lease is held by another owner and is not expired.
+	Lease(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+}
+
+const (
+	relayBatchSizeDefault  = 100
+	relayIntervalDefault   = 1 * time.Second
+	relayLeaseTTLDefault   = 30 * time.Second
+	relayMaxBackoffDefault = 1 * time.Minute
+)
+
+// Relay produces the pending messages of a Store.
+//
+// Several relays can run concurrently on the same Store (e.g. in several instances of the application).
+// Only the relay that holds the lease produces messages, the others wait.
+// The lease is renewed before each batch, so LeaseTTL must be longer than the duration of a batch.
+type Relay struct {
+	Store    Store
+	Producer amqputils.Producer
+	// Owner identifies the relay for the lease.
+	// The default value is generated from the host name and the process ID.
+	Owner string
+	// BatchSize is the maximum number of messages per batch.
+	// The default value is 100.
+	BatchSize int
+	// Interval is the duration to wait if there are no more pending messages, or if the lease is held by another relay.
+	// The default value is 1 second.
+	Interval time.Duration
+	// LeaseTTL is the duration of the lease.
+	// The default value is 30 seconds.
+	LeaseTTL time.Duration
+	// MaxBackoff is the maximum duration to wait after an error.
+	// The duration is doubled after each consecutive error, starting from Interval.
+	// The default value is 1 minute.
+	MaxBackoff time.Duration
+
+	ownerOnce sync.Once
+	owner     string
+}
+
+// Run runs the Relay in a loop until the context is canceled.
+func (r *Relay) Run(ctx context.Context, errFunc func(context.Context, error)) {
+	var backoff time.Duration
+	for !ctxutils.IsDone(ctx) {
+		n, err := r.RelayBatch(ctx)
+		var wait time.Duration
+		if err != nil {
+			err = errors.Wrap(err, "AMQP outbox relay")
+			errFunc(ctx, err)
+			backoff = r.getNextBackoff(backoff)
+			wait = backoff
+		} else {
+			backoff = 0
+			if n < r.getBatchSize() {
+				wait = r.getInterval()
+			}
+		}
+		if wait > 0 {
+			relayWait(ctx, wait)
+		}
+	}
+}
+
+func relayWait(ctx context.Context, d time.Duration) {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+	case <-tm.C:
+	}
+}
+
+// RelayBatch produces a batch of pending messages.
+//
+// It returns the number of produced messages.
+// It returns 0 if the lease is held by another relay.
+// If a message can't be produced, the previous messages of the batch are marked as sent, and the following messages are not produced.
+func (r *Relay) RelayBatch(ctx context.Context) (n int, err error) {
+	span, spanFinish := tracingutils.StartChildSpan(&ctx, "amqpoutbox.relay_batch", &err)
+	defer spanFinish()
+	owner := r.getOwner()
+	span.SetTag("amqpoutbox.owner", owner)
+	ok, err := r.Store.Lease(ctx, owner, r.getLeaseTTL())
+	if err != nil {
+		err = errors.Wrap(err, "lease")
+		err = errors.WithValue(err, "amqpoutbox.owner", owner)
+		return 0, err
+	}
+	span.SetTag("amqpoutbox.lease", ok)
+	if !ok {
+		return 0, nil
+	}
+	msgs, err := r.Store.Fetch(ctx, r.getBatchSize())
+	if err != nil {
+		return 0, errors.Wrap(err, "fetch")
+	}
+	defer func() {
+		span.SetTag("amqpoutbox.produced", n)
+	}()
+	for _, msg := range msgs {
+		err = r.produce(ctx, msg)
+		if err != nil {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		merr := r.Store.MarkSent(ctx, msgs[:n])
+		if merr != nil {
+			merr = errors.Wrap(merr, "mark sent")
+			if err != nil {
+				merr = errors.Wrapf(merr, "produce error: %v", err)
+			}
+			return n, merr
+		}
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "produce")
+	}
+	return n, nil
+}
+
+func (r *Relay) produce(ctx context.Context, msg *Message) error {
+	err := r.Producer(ctx, msg.Exchange, msg.RoutingKey, false, false, msg.Publishing)
+	if err != nil {
+		err = errors.WithValue(err, "amqpoutbox.message_id", msg.ID)
+		err = errors.WithValue(err, "amqp.exchange", msg.Exchange)
+		err = errors.WithValue(err, "amqp.routing_key", msg.RoutingKey)
+		return err
+	}
+	return nil
+}
+
+func (r *Relay) getOwner() string {
+	if r.Owner != "" {
+		return r.Owner
+	}
+	r.ownerOnce.Do(func() {
+		host, _ := os.Hostname()
+		r.owner = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), timeutils.Now().UnixNano())
+	})
+	return r.owner
+}
+
+func (r *Relay) getBatchSize() int {
+	if r.BatchSize > 0 {
+		return r.BatchSize
+	}
+	return relayBatchSizeDefault
+}
+
+func (r *Relay) getInterval() time.Duration {
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return relayIntervalDefault
+}
+
+func (r *Relay) getLeaseTTL() time.Duration {
+	if r.LeaseTTL > 0 {
+		return r.LeaseTTL
+	}
+	return relayLeaseTTLDefault
+}
+
+func (r *Relay) getNextBackoff(backoff time.Duration) time.Duration {
+	max := r.MaxBackoff
+	if max <= 0 {
+		max = relayMaxBackoffDefault
+	}
+	if backoff <= 0 {
+		backoff = r.getInterval()
+	} else {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
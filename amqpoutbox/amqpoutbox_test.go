@@ -0,0 +1,241 @@
+package amqpoutbox_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqpoutbox"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+type testStore struct {
+	mu         sync.Mutex
+	msgs       []*amqpoutbox.Message
+	sent       map[string]bool
+	leaseOwner string
+	leaseUntil time.Time
+}
+
+func newTestStore(count int) *testStore {
+	s := &testStore{
+		sent: make(map[string]bool),
+	}
+	for i := 0; i < count; i++ {
+		s.msgs = append(s.msgs, &amqpoutbox.Message{
+			ID:         strconv.Itoa(i),
+			Exchange:   "exchange",
+			RoutingKey: "key",
+			Publishing: amqp.Publishing{
+				Body: []byte(strconv.Itoa(i)),
+			},
+		})
+	}
+	return s
+}
+
+func (s *testStore) Fetch(ctx context.Context, limit int) ([]*amqpoutbox.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*amqpoutbox.Message
+	for _, msg := range s.msgs {
+		if len(res) >= limit {
+			break
+		}
+		if !s.sent[msg.ID] {
+			res = append(res, msg)
+		}
+	}
+	return res, nil
+}
+
+func (s *testStore) MarkSent(ctx context.Context, msgs []*amqpoutbox.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range msgs {
+		s.sent[msg.ID] = true
+	}
+	return nil
+}
+
+func (s *testStore) Lease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := timeutils.Now()
+	if s.leaseOwner != owner && now.Before(s.leaseUntil) {
+		return false, nil
+	}
+	s.leaseOwner = owner
+	s.leaseUntil = now.Add(ttl)
+	return true, nil
+}
+
+func (s *testStore) sentCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+type testProducer struct {
+	mu     sync.Mutex
+	bodies []string
+	fail   string
+}
+
+func (p *testProducer) produce(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if string(msg.Body) == p.fail {
+		return errors.New("error")
+	}
+	p.bodies = append(p.bodies, string(msg.Body))
+	return nil
+}
+
+func TestRelayBatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(5)
+	p := new(testProducer)
+	r := &amqpoutbox.Relay{
+		Store:     s,
+		Producer:  p.produce,
+		BatchSize: 3,
+	}
+	n, err := r.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 3 {
+		t.Fatalf("unexpected count: got %d, want 3", n)
+	}
+	n, err = r.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 2 {
+		t.Fatalf("unexpected count: got %d, want 2", n)
+	}
+	testutils.Compare(t, "unexpected bodies", p.bodies, []string{"0", "1", "2", "3", "4"})
+	if c := s.sentCount(); c != 5 {
+		t.Fatalf("unexpected sent count: got %d, want 5", c)
+	}
+}
+
+func TestRelayBatchErrorProduce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(5)
+	p := &testProducer{
+		fail: "2",
+	}
+	r := &amqpoutbox.Relay{
+		Store:    s,
+		Producer: p.produce,
+	}
+	n, err := r.RelayBatch(ctx)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if n != 2 {
+		t.Fatalf("unexpected count: got %d, want 2", n)
+	}
+	// The messages produced before the error are marked as sent.
+	if c := s.sentCount(); c != 2 {
+		t.Fatalf("unexpected sent count: got %d, want 2", c)
+	}
+	// The order is preserved: the failed message is retried first.
+	p.fail = ""
+	_, err = r.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected bodies", p.bodies, []string{"0", "1", "2", "3", "4"})
+}
+
+func TestRelayBatchLease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	s := newTestStore(5)
+	p := new(testProducer)
+	r1 := &amqpoutbox.Relay{
+		Store:     s,
+		Producer:  p.produce,
+		Owner:     "r1",
+		BatchSize: 1,
+		LeaseTTL:  10 * time.Second,
+	}
+	r2 := &amqpoutbox.Relay{
+		Store:     s,
+		Producer:  p.produce,
+		Owner:     "r2",
+		BatchSize: 1,
+		LeaseTTL:  10 * time.Second,
+	}
+	n, err := r1.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 1 {
+		t.Fatalf("unexpected count: got %d, want 1", n)
+	}
+	// The lease is held by r1.
+	n, err = r2.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 0 {
+		t.Fatalf("unexpected count: got %d, want 0", n)
+	}
+	// The lease of r1 is expired.
+	timeutils.SetFixed(now.Add(11 * time.Second))
+	n, err = r2.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 1 {
+		t.Fatalf("unexpected count: got %d, want 1", n)
+	}
+	n, err = r1.RelayBatch(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n != 0 {
+		t.Fatalf("unexpected count: got %d, want 0", n)
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := newTestStore(10)
+	p := &testProducer{
+		fail: "5",
+	}
+	r := &amqpoutbox.Relay{
+		Store:      s,
+		Producer:   p.produce,
+		BatchSize:  3,
+		Interval:   1 * time.Millisecond,
+		MaxBackoff: 1 * time.Millisecond,
+	}
+	errFunc := func(ctx context.Context, err error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.fail = ""
+	}
+	go func() {
+		for s.sentCount() < 10 {
+			time.Sleep(1 * time.Millisecond)
+		}
+		cancel()
+	}()
+	r.Run(ctx, errFunc)
+	testutils.Compare(t, "unexpected bodies", p.bodies, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"})
+}
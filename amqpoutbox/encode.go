@@ -0,0 +1,117 @@
+package amqpoutbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+)
+
+// properties are the properties of an amqp.Publishing, without the body.
+//
+// They are stored as JSON.
+// In the headers, the integers are decoded as int64, the other numbers as float64, and the objects as amqp.Table.
+// Other header types (time, byte array, decimal) are not preserved.
+type properties struct {
+	Headers         amqp.Table `json:"headers,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationID   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageID       string     `json:"message_id,omitempty"`
+	Timestamp       *time.Time `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserID          string     `json:"user_id,omitempty"`
+	AppID           string     `json:"app_id,omitempty"`
+}
+
+func encodeProperties(msg amqp.Publishing) ([]byte, error) {
+	p := properties{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationID:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageID:       msg.MessageId,
+		Type:            msg.Type,
+		UserID:          msg.UserId,
+		AppID:           msg.AppId,
+	}
+	if !msg.Timestamp.IsZero() {
+		p.Timestamp = &msg.Timestamp
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "JSON marshal")
+	}
+	return b, nil
+}
+
+func decodeProperties(b []byte, body []byte) (amqp.Publishing, error) {
+	var p properties
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err := dec.Decode(&p)
+	if err != nil {
+		return amqp.Publishing{}, errors.Wrap(err, "JSON unmarshal")
+	}
+	msg := amqp.Publishing{
+		Headers:         decodeTable(p.Headers),
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationID,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageID,
+		Type:            p.Type,
+		UserId:          p.UserID,
+		AppId:           p.AppID,
+		Body:            body,
+	}
+	if p.Timestamp != nil {
+		msg.Timestamp = *p.Timestamp
+	}
+	return msg, nil
+}
+
+func decodeTable(t amqp.Table) amqp.Table {
+	if t == nil {
+		return nil
+	}
+	res := make(amqp.Table, len(t))
+	for k, v := range t {
+		res[k] = decodeValue(v)
+	}
+	return res
+}
+
+func decodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		if err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		return decodeTable(v)
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, vv := range v {
+			res[i] = decodeValue(vv)
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,40 @@
+package amqpoutbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestEncodeDecodeProperties(t *testing.T) {
+	msg := amqp.Publishing{
+		Headers: amqp.Table{
+			"string": "test",
+			"int":    int64(123),
+			"float":  1.5,
+			"bool":   true,
+			"table": amqp.Table{
+				"int": int64(456),
+			},
+			"array": []interface{}{int64(1), "a"},
+		},
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      5,
+		CorrelationId: "correlation",
+		MessageId:     "message",
+		Timestamp:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Body:          []byte("test"),
+	}
+	b, err := encodeProperties(msg)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	res, err := decodeProperties(b, msg.Body)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected message", res, msg)
+}
@@ -0,0 +1,175 @@
+package amqpoutbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/mongo"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoLeaseIDDefault = "amqp_outbox"
+
+type mongoMessage struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Exchange   string             `bson:"exchange"`
+	RoutingKey string             `bson:"routing_key"`
+	Properties string             `bson:"properties"`
+	Body       []byte             `bson:"body"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	SentAt     *time.Time         `bson:"sent_at"`
+}
+
+// MongoStore is a Store for MongoDB.
+//
+// The messages are sorted by ObjectID.
+// The order is strict only if the messages are inserted by the same process, or in different seconds.
+type MongoStore struct {
+	// Collection is the messages collection.
+	// It should have an index on {sent_at: 1, _id: 1}, see CreateIndexes().
+	Collection *mongo.Collection
+	// LeaseCollection is the lease collection.
+	LeaseCollection *mongo.Collection
+	// LeaseID is the ID of the lease document.
+	// The default value is "amqp_outbox".
+	LeaseID string
+}
+
+// CreateIndexes creates the indexes of the messages collection.
+func (s *MongoStore) CreateIndexes(ctx context.Context) error {
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "sent_at", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "create index")
+	}
+	return nil
+}
+
+// Insert inserts a message in the outbox.
+//
+// It should be called with the SessionContext of the business transaction.
+func (s *MongoStore) Insert(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	props, err := encodeProperties(msg)
+	if err != nil {
+		return errors.Wrap(err, "encode properties")
+	}
+	body := msg.Body
+	if body == nil {
+		body = []byte{}
+	}
+	_, err = s.Collection.InsertOne(ctx, mongoMessage{
+		Exchange:   exchange,
+		RoutingKey: key,
+		Properties: string(props),
+		Body:       body,
+		CreatedAt:  timeutils.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "insert")
+	}
+	return nil
+}
+
+// Fetch implements Store.
+func (s *MongoStore) Fetch(ctx context.Context, limit int) ([]*Message, error) {
+	filter := bson.M{
+		"sent_at": nil,
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cur, err := s.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "find")
+	}
+	var docs []mongoMessage
+	err = cur.All(ctx, &docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "all")
+	}
+	msgs := make([]*Message, 0, len(docs))
+	for _, doc := range docs {
+		msg := &Message{
+			ID:         doc.ID.Hex(),
+			Exchange:   doc.Exchange,
+			RoutingKey: doc.RoutingKey,
+		}
+		msg.Publishing, err = decodeProperties([]byte(doc.Properties), doc.Body)
+		if err != nil {
+			err = errors.Wrap(err, "decode properties")
+			err = errors.WithValue(err, "amqpoutbox.message_id", msg.ID)
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// MarkSent implements Store.
+func (s *MongoStore) MarkSent(ctx context.Context, msgs []*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(msgs))
+	for i, msg := range msgs {
+		id, err := primitive.ObjectIDFromHex(msg.ID)
+		if err != nil {
+			err = errors.Wrap(err, "parse ID")
+			err = errors.WithValue(err, "amqpoutbox.message_id", msg.ID)
+			return err
+		}
+		ids[i] = id
+	}
+	filter := bson.M{
+		"_id": bson.M{"$in": ids},
+	}
+	update := bson.M{
+		"$set": bson.M{"sent_at": timeutils.Now()},
+	}
+	_, err := s.Collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.Wrap(err, "update")
+	}
+	return nil
+}
+
+// Lease implements Store.
+func (s *MongoStore) Lease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	now := timeutils.Now()
+	filter := bson.M{
+		"_id": s.getLeaseID(),
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"owner":      owner,
+			"expires_at": now.Add(ttl),
+		},
+	}
+	_, err := s.LeaseCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		// The lease document exists, but it doesn't match the filter, so the upsert tries to insert a new document with the same ID.
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "update")
+	}
+	return true, nil
+}
+
+func (s *MongoStore) getLeaseID() string {
+	if s.LeaseID != "" {
+		return s.LeaseID
+	}
+	return mongoLeaseIDDefault
+}
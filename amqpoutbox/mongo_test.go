@@ -0,0 +1,36 @@
+package amqpoutbox_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/amqpoutbox"
+	"github.com/siddhant2408/golang-libraries/mongotest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestMongoStore(t *testing.T) {
+	ctx := context.Background()
+	db := mongotest.GetDatabase(t)
+	s := &amqpoutbox.MongoStore{
+		Collection:      db.Collection("outbox"),
+		LeaseCollection: db.Collection("outbox_lease"),
+	}
+	err := s.CreateIndexes(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for _, body := range []string{"a", "b", "c"} {
+		err = s.Insert(ctx, "exchange", "key", amqp.Publishing{
+			Headers: amqp.Table{
+				"foo": int64(1),
+			},
+			Body: []byte(body),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	testStoreImpl(ctx, t, s)
+}
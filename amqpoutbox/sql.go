@@ -0,0 +1,181 @@
+package amqpoutbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/sqltracing"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+const (
+	sqlTableDefault      = "amqp_outbox"
+	sqlLeaseTableDefault = "amqp_outbox_lease"
+)
+
+// SQLExecer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLStore is a Store for database/sql.
+//
+// The queries are written for MySQL.
+// The DB should be opened with a connector wrapped by sqltracing (see mysqltracing).
+//
+// The messages are sorted by AUTO_INCREMENT id.
+// The ids are allocated at insertion, not at commit, so the messages of concurrent transactions can be read out of commit order.
+type SQLStore struct {
+	DB *sql.DB
+	// Table is the name of the messages table.
+	// The default value is "amqp_outbox".
+	Table string
+	// LeaseTable is the name of the lease table.
+	// The default value is "amqp_outbox_lease".
+	LeaseTable string
+}
+
+// CreateTables creates the tables if they don't exist.
+func (s *SQLStore) CreateTables(ctx context.Context) error {
+	queries := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, "+
+			"exchange VARCHAR(255) NOT NULL, "+
+			"routing_key VARCHAR(255) NOT NULL, "+
+			"properties TEXT NOT NULL, "+
+			"body LONGBLOB NOT NULL, "+
+			"created_at DATETIME(6) NOT NULL, "+
+			"sent_at DATETIME(6) NULL, "+
+			"PRIMARY KEY (id), "+
+			"KEY sent_at_id (sent_at, id)"+
+			");", s.getTable()),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"name VARCHAR(255) NOT NULL, "+
+			"owner VARCHAR(255) NOT NULL, "+
+			"expires_at DATETIME(6) NOT NULL, "+
+			"PRIMARY KEY (name)"+
+			");", s.getLeaseTable()),
+	}
+	for _, q := range queries {
+		_, err := s.DB.ExecContext(ctx, q)
+		if err != nil {
+			return errors.Wrap(err, "create table")
+		}
+	}
+	return nil
+}
+
+// Insert inserts a message in the outbox.
+//
+// It should be called with the *sql.Tx of the business transaction.
+func (s *SQLStore) Insert(ctx context.Context, ex SQLExecer, exchange, key string, msg amqp.Publishing) error {
+	props, err := encodeProperties(msg)
+	if err != nil {
+		return errors.Wrap(err, "encode properties")
+	}
+	body := msg.Body
+	if body == nil {
+		body = []byte{}
+	}
+	q := fmt.Sprintf("INSERT INTO %s (exchange, routing_key, properties, body, created_at) VALUES (?, ?, ?, ?, ?);", s.getTable())
+	_, err = ex.ExecContext(ctx, q, exchange, key, string(props), body, timeutils.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "insert")
+	}
+	return nil
+}
+
+// Fetch implements Store.
+func (s *SQLStore) Fetch(ctx context.Context, limit int) ([]*Message, error) {
+	q := fmt.Sprintf("SELECT id, exchange, routing_key, properties, body FROM %s WHERE sent_at IS NULL ORDER BY id LIMIT ?;", s.getTable())
+	rows, err := s.DB.QueryContext(ctx, q, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+	var msgs []*Message
+	err = sqltracing.RowsIterate(ctx, rows, func(ctx context.Context, rows *sql.Rows) error {
+		var id uint64
+		var props string
+		var body []byte
+		msg := new(Message)
+		err := rows.Scan(&id, &msg.Exchange, &msg.RoutingKey, &props, &body)
+		if err != nil {
+			return errors.Wrap(err, "scan")
+		}
+		msg.ID = strconv.FormatUint(id, 10)
+		msg.Publishing, err = decodeProperties([]byte(props), body)
+		if err != nil {
+			err = errors.Wrap(err, "decode properties")
+			err = errors.WithValue(err, "amqpoutbox.message_id", msg.ID)
+			return err
+		}
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "iterate")
+	}
+	return msgs, nil
+}
+
+// MarkSent implements Store.
+func (s *SQLStore) MarkSent(ctx context.Context, msgs []*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(msgs)+1)
+	args = append(args, timeutils.Now().UTC())
+	for _, msg := range msgs {
+		args = append(args, msg.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(msgs)), ", ")
+	q := fmt.Sprintf("UPDATE %s SET sent_at = ? WHERE id IN (%s);", s.getTable(), placeholders)
+	_, err := s.DB.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrap(err, "update")
+	}
+	return nil
+}
+
+// Lease implements Store.
+func (s *SQLStore) Lease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	now := timeutils.Now().UTC()
+	expiresAt := now.Add(ttl)
+	// The assignments are evaluated from left to right, so expires_at is updated only if the owner was updated.
+	q := fmt.Sprintf("INSERT INTO %s (name, owner, expires_at) VALUES (?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE "+
+		"owner = IF(owner = VALUES(owner) OR expires_at < ?, VALUES(owner), owner), "+
+		"expires_at = IF(owner = VALUES(owner), VALUES(expires_at), expires_at);", s.getLeaseTable())
+	name := s.getTable()
+	_, err := s.DB.ExecContext(ctx, q, name, owner, expiresAt, now)
+	if err != nil {
+		return false, errors.Wrap(err, "upsert")
+	}
+	q = fmt.Sprintf("SELECT owner FROM %s WHERE name = ?;", s.getLeaseTable())
+	var current string
+	err = sqltracing.RowScan(ctx, s.DB.QueryRowContext(ctx, q, name), &current)
+	if err != nil {
+		return false, errors.Wrap(err, "select")
+	}
+	return current == owner, nil
+}
+
+func (s *SQLStore) getTable() string {
+	if s.Table != "" {
+		return s.Table
+	}
+	return sqlTableDefault
+}
+
+func (s *SQLStore) getLeaseTable() string {
+	if s.LeaseTable != "" {
+		return s.LeaseTable
+	}
+	return sqlLeaseTableDefault
+}
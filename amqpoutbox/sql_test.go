@@ -0,0 +1,115 @@
+package amqpoutbox_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqpoutbox"
+	"github.com/siddhant2408/golang-libraries/mysqltest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db := mysqltest.GetDatabase(t)
+	s := &amqpoutbox.SQLStore{
+		DB: db,
+	}
+	err := s.CreateTables(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for _, body := range []string{"a", "b", "c"} {
+		err = s.Insert(ctx, tx, "exchange", "key", amqp.Publishing{
+			Headers: amqp.Table{
+				"foo": int64(1),
+			},
+			Body: []byte(body),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testStoreImpl(ctx, t, s)
+}
+
+func testStoreImpl(ctx context.Context, t *testing.T, s amqpoutbox.Store) {
+	t.Helper()
+	t.Run("Lease", func(t *testing.T) {
+		testStoreLease(ctx, t, s)
+	})
+	t.Run("Fetch", func(t *testing.T) {
+		testStoreFetch(ctx, t, s)
+	})
+	t.Run("MarkSent", func(t *testing.T) {
+		testStoreMarkSent(ctx, t, s)
+	})
+}
+
+func testStoreLease(ctx context.Context, t *testing.T, s amqpoutbox.Store) {
+	t.Helper()
+	ok, err := s.Lease(ctx, "r1", 1*time.Minute)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !ok {
+		t.Fatal("lease not acquired")
+	}
+	ok, err = s.Lease(ctx, "r1", 1*time.Minute)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !ok {
+		t.Fatal("lease not renewed")
+	}
+	ok, err = s.Lease(ctx, "r2", 1*time.Minute)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if ok {
+		t.Fatal("lease acquired by another owner")
+	}
+}
+
+func testStoreFetch(ctx context.Context, t *testing.T, s amqpoutbox.Store) {
+	t.Helper()
+	msgs, err := s.Fetch(ctx, 2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Publishing.Body) != "a" || string(msgs[1].Publishing.Body) != "b" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	if msgs[0].Exchange != "exchange" || msgs[0].RoutingKey != "key" || msgs[0].Publishing.Headers["foo"] != int64(1) {
+		t.Fatalf("unexpected message: %v", msgs[0])
+	}
+}
+
+func testStoreMarkSent(ctx context.Context, t *testing.T, s amqpoutbox.Store) {
+	t.Helper()
+	msgs, err := s.Fetch(ctx, 2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = s.MarkSent(ctx, msgs)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	msgs, err = s.Fetch(ctx, 2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Publishing.Body) != "c" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+}
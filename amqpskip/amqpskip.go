@@ -26,7 +26,7 @@ type Checker struct {
 func (c *Checker) Check(ctx context.Context, dlv amqp.Delivery) (err error) {
 	span, spanFinish := tracingutils.StartChildSpan(&ctx, "amqpskip.checker", &err)
 	defer spanFinish()
-	orgID, err := c.getOrganizationID(dlv)
+	orgID, err := getOrganizationID(dlv)
 	if err != nil {
 		err = amqputils.ErrorWithAcknowledger(err, amqputils.NackDiscard)
 		err = errors.Wrap(err, "get organization ID")
@@ -51,7 +51,7 @@ func (c *Checker) Check(ctx context.Context, dlv amqp.Delivery) (err error) {
 	return err
 }
 
-func (c *Checker) getOrganizationID(dlv amqp.Delivery) (int64, error) {
+func getOrganizationID(dlv amqp.Delivery) (int64, error) {
 	v, ok := dlv.Headers[headerOrganizationID]
 	if !ok {
 		return 0, errors.Newf("missing header: %q", headerOrganizationID)
@@ -83,7 +83,7 @@ func (c *Checker) isSkipped(ctx context.Context, orgID int64) (bool, error) {
 // Forwarder forwards messages.
 type Forwarder struct {
 	Topology    func(orgID int64) (tpi TopologyInit, exchange string, key string)
-	ChannelPool func(context.Context, func(context.Context, *amqp.Channel) error) error
+	ChannelPool func(context.Context, func(context.Context, amqputils.Channel) error) error
 	Producer    amqputils.Producer
 }
 
@@ -104,7 +104,7 @@ func (f *Forwarder) Forward(ctx context.Context, dlv amqp.Delivery, orgID int64)
 }
 
 // TopologyInit is a function type for *amqputils.Topology.Init().
-type TopologyInit func(context.Context, *amqp.Channel) error
+type TopologyInit func(context.Context, amqputils.Channel) error
 
 // ConsumerProcessor checks that the organization is not skipped and forwards to a ConsumerProcessor.
 type ConsumerProcessor struct {
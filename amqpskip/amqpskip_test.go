@@ -185,7 +185,7 @@ func TestFowarder(t *testing.T) {
 	dlv := amqp.Delivery{}
 	orgID := int64(123)
 	var tpiCalled testutils.CallCounter
-	tpi := func(context.Context, *amqp.Channel) error { //nolint:unparam // The error is always nil.
+	tpi := func(context.Context, amqputils.Channel) error { //nolint:unparam // The error is always nil.
 		tpiCalled.Call()
 		return nil
 	}
@@ -194,7 +194,7 @@ func TestFowarder(t *testing.T) {
 		topologyCalled.Call()
 		return tpi, "exchange", "key"
 	}
-	channelPool := func(ctx context.Context, f func(context.Context, *amqp.Channel) error) error {
+	channelPool := func(ctx context.Context, f func(context.Context, amqputils.Channel) error) error {
 		chn := new(amqp.Channel)
 		return f(ctx, chn)
 	}
@@ -221,13 +221,13 @@ func TestFowarderErrorTopologyInit(t *testing.T) {
 	ctx := context.Background()
 	dlv := amqp.Delivery{}
 	orgID := int64(123)
-	tpi := func(context.Context, *amqp.Channel) error {
+	tpi := func(context.Context, amqputils.Channel) error {
 		return errors.New("error")
 	}
 	topology := func(orgID int64) (_ TopologyInit, exchange string, key string) {
 		return tpi, "exchange", "key"
 	}
-	channelPool := func(ctx context.Context, f func(context.Context, *amqp.Channel) error) error {
+	channelPool := func(ctx context.Context, f func(context.Context, amqputils.Channel) error) error {
 		chn := new(amqp.Channel)
 		return f(ctx, chn)
 	}
@@ -245,13 +245,13 @@ func TestFowarderErrorProducer(t *testing.T) {
 	ctx := context.Background()
 	dlv := amqp.Delivery{}
 	orgID := int64(123)
-	tpi := func(context.Context, *amqp.Channel) error {
+	tpi := func(context.Context, amqputils.Channel) error {
 		return nil
 	}
 	topology := func(orgID int64) (_ TopologyInit, exchange string, key string) {
 		return tpi, "exchange", "key"
 	}
-	channelPool := func(ctx context.Context, f func(context.Context, *amqp.Channel) error) error {
+	channelPool := func(ctx context.Context, f func(context.Context, amqputils.Channel) error) error {
 		chn := new(amqp.Channel)
 		return f(ctx, chn)
 	}
@@ -0,0 +1,406 @@
+package amqpskip
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/loadutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+	"github.com/streadway/amqp"
+	"golang.org/x/time/rate"
+)
+
+// Action is the action of a Rule.
+type Action string
+
+// Action values.
+const (
+	// ActionAccept processes the message normally.
+	// It allows to stop the evaluation of the following rules.
+	ActionAccept Action = "accept"
+	// ActionSkip acknowledges the message without processing it.
+	ActionSkip Action = "skip"
+	// ActionForward produces the message to another exchange, and acknowledges it.
+	ActionForward Action = "forward"
+	// ActionDelay produces the message with a delay (with Policy.Delayer), and acknowledges it.
+	// A message is delayed only once by a rule.
+	ActionDelay Action = "delay"
+	// ActionRateLimit limits the rate of messages per organization.
+	// If the rate is exceeded, the message reserves a slot, and is delayed (with Policy.Delayer) until this slot.
+	// A delayed message is not limited again by the rule, so a burst is spread over time.
+	ActionRateLimit Action = "rate_limit"
+)
+
+// Decision is the decision taken for a message.
+type Decision string
+
+// Decision values.
+const (
+	DecisionAccept      Decision = "accept"
+	DecisionSkip        Decision = "skip"
+	DecisionForward     Decision = "forward"
+	DecisionDelay       Decision = "delay"
+	DecisionRateLimited Decision = "rate_limited"
+)
+
+// headerDelayedRule contains the name of the rule that delayed (or rate limited) the message.
+const headerDelayedRule = "amqpskip-delayed-rule"
+
+// Rule is a message policy rule.
+//
+// All the match conditions must be satisfied.
+// An empty condition matches all the messages.
+type Rule struct {
+	// Name identifies the rule in the traces, the counters and the rate limiters.
+	Name string
+
+	// Exchange matches the exchange of the message.
+	Exchange string
+	// RoutingKey matches the routing key of the message.
+	RoutingKey string
+	// Headers match the header values of the message.
+	Headers amqp.Table
+
+	Action Action
+	// ForwardExchange is the destination exchange for ActionForward.
+	ForwardExchange string
+	// ForwardRoutingKey is the destination routing key for ActionForward.
+	ForwardRoutingKey string
+	// Delay is the delay for ActionDelay.
+	Delay time.Duration
+	// Rate is the number of messages per second for ActionRateLimit.
+	// It must be greater than 0.
+	Rate float64
+	// Burst is the maximum number of messages at once for ActionRateLimit.
+	// The default value is 1.
+	Burst int
+}
+
+// Match returns true if the message matches the rule.
+func (r *Rule) Match(dlv amqp.Delivery) bool {
+	if r.Exchange != "" && r.Exchange != dlv.Exchange {
+		return false
+	}
+	if r.RoutingKey != "" && r.RoutingKey != dlv.RoutingKey {
+		return false
+	}
+	for k, v := range r.Headers {
+		dv, ok := dlv.Headers[k]
+		if !ok || !headerValueEqual(dv, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValueEqual returns true if 2 header values are equal.
+//
+// The numbers are compared by value, because the type of a rule value depends on its source (e.g. float64 if it is decoded from JSON).
+func headerValueEqual(a, b interface{}) (eq bool) {
+	if na, ok := getHeaderNumber(a); ok {
+		nb, ok := getHeaderNumber(b)
+		return ok && na == nb
+	}
+	// The values can be non comparable (e.g. table or array).
+	defer func() {
+		if recover() != nil {
+			eq = false
+		}
+	}()
+	return a == b
+}
+
+func getHeaderNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	i, ok := getHeaderInteger(v)
+	return float64(i), ok
+}
+
+func getHeaderInteger(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// RuleSource returns the rules of an organization, in priority order.
+type RuleSource func(ctx context.Context, orgID int64) ([]*Rule, error)
+
+var expvarDecisions = expvar.NewMap("amqpskip_policy_decisions")
+
+// Policy applies per-organization rules to messages.
+//
+// It requires to have the organization ID in the "organization-id" header with the type int64.
+// The first matching rule is applied.
+// If no rule matches, the message is accepted.
+//
+// Check can be used as ConsumerProcessor.Checker.
+type Policy struct {
+	Source RuleSource
+	// Loader caches the rules per organization.
+	// It is optional.
+	Loader *loadutils.Loader
+	// Producer is required by ActionForward.
+	Producer amqputils.Producer
+	// Delayer is required by ActionDelay and ActionRateLimit.
+	// The rules are rejected when they are loaded, if a required dependency is missing.
+	Delayer *amqputils.Delayer
+	// Exchange and Key are the destination of the messages delayed by ActionDelay and ActionRateLimit.
+	// The messages must be delivered again to the consumed queue only, so Exchange is usually empty (default exchange) and Key is the name of the queue.
+	// Key is required by ActionDelay and ActionRateLimit.
+	Exchange string
+	Key      string
+	// Counter is called for each decision.
+	// rule is empty if no rule matches.
+	// The default value increments the "amqpskip_policy_decisions" expvar map, with the keys "<decision>" and "<rule>.<decision>".
+	Counter func(ctx context.Context, rule string, decision Decision)
+
+	mu       sync.Mutex
+	limiters map[policyLimiterKey]*rate.Limiter
+}
+
+type policyLimiterKey struct {
+	orgID int64
+	rule  string
+}
+
+// Check checks the rules of the organization of the message.
+//
+// If the message is accepted, it returns no error.
+// Otherwise, it returns an error that is ignored and acknowledges the current message.
+func (p *Policy) Check(ctx context.Context, dlv amqp.Delivery) (err error) {
+	span, spanFinish := tracingutils.StartChildSpan(&ctx, "amqpskip.policy", &err)
+	defer spanFinish()
+	orgID, err := getOrganizationID(dlv)
+	if err != nil {
+		err = amqputils.ErrorWithAcknowledger(err, amqputils.NackDiscard)
+		err = errors.Wrap(err, "get organization ID")
+		return err
+	}
+	span.SetTag("organization.id", orgID)
+	rules, err := p.getRules(ctx, orgID)
+	if err != nil {
+		err = errors.Wrap(err, "get rules")
+		err = errors.WithTagInt64(err, "organization.id", orgID)
+		return err
+	}
+	rule, decision, err := p.apply(ctx, dlv, orgID, rules)
+	var ruleName string
+	if rule != nil {
+		ruleName = rule.Name
+		span.SetTag("amqpskip.rule", ruleName)
+	}
+	if err != nil {
+		err = errors.WithTagInt64(err, "organization.id", orgID)
+		err = errors.WithValue(err, "amqpskip.rule", ruleName)
+		return err
+	}
+	span.SetTag("amqpskip.decision", string(decision))
+	p.count(ctx, ruleName, decision)
+	if decision == DecisionAccept {
+		return nil
+	}
+	err = errors.Newf("policy %s", decision)
+	err = errors.Ignore(err)
+	err = amqputils.ErrorWithAcknowledger(err, amqputils.Ack)
+	return err
+}
+
+func (p *Policy) getRules(ctx context.Context, orgID int64) ([]*Rule, error) {
+	load := func(ctx context.Context) (interface{}, bool, error) {
+		rules, err := p.Source(ctx, orgID)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "source")
+		}
+		err = p.checkRules(rules)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "check")
+		}
+		return rules, true, nil
+	}
+	if p.Loader == nil {
+		rules, _, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return rules.([]*Rule), nil
+	}
+	v, err := p.Loader.Load(ctx, strconv.FormatInt(orgID, 10), load)
+	if err != nil {
+		return nil, errors.Wrap(err, "load")
+	}
+	return v.([]*Rule), nil
+}
+
+// checkRules checks that the Policy has the dependencies required by the rules actions.
+func (p *Policy) checkRules(rules []*Rule) error {
+	for _, r := range rules {
+		switch {
+		case r.Action == ActionForward && p.Producer == nil:
+			return errors.Newf("rule %q: no Producer for action %s", r.Name, r.Action)
+		case (r.Action == ActionDelay || r.Action == ActionRateLimit) && p.Delayer == nil:
+			return errors.Newf("rule %q: no Delayer for action %s", r.Name, r.Action)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) apply(ctx context.Context, dlv amqp.Delivery, orgID int64, rules []*Rule) (*Rule, Decision, error) {
+	for _, r := range rules {
+		if !r.Match(dlv) {
+			continue
+		}
+		if (r.Action == ActionDelay || r.Action == ActionRateLimit) && dlv.Headers[headerDelayedRule] == r.Name {
+			// The message was already delayed by this rule.
+			continue
+		}
+		decision, err := p.applyRule(ctx, dlv, orgID, r)
+		return r, decision, err
+	}
+	return nil, DecisionAccept, nil
+}
+
+func (p *Policy) applyRule(ctx context.Context, dlv amqp.Delivery, orgID int64, r *Rule) (Decision, error) {
+	switch r.Action {
+	case ActionAccept:
+		return DecisionAccept, nil
+	case ActionSkip:
+		return DecisionSkip, nil
+	case ActionForward:
+		return p.applyForward(ctx, dlv, r)
+	case ActionDelay:
+		return p.applyDelay(ctx, dlv, r)
+	case ActionRateLimit:
+		return p.applyRateLimit(ctx, dlv, orgID, r)
+	}
+	return "", errors.Newf("unknown action %q", r.Action)
+}
+
+func (p *Policy) applyForward(ctx context.Context, dlv amqp.Delivery, r *Rule) (Decision, error) {
+	err := amqputils.Reproduce(ctx, p.Producer, r.ForwardExchange, r.ForwardRoutingKey, false, false, dlv)
+	if err != nil {
+		return "", errors.Wrap(err, "forward")
+	}
+	return DecisionForward, nil
+}
+
+func (p *Policy) applyDelay(ctx context.Context, dlv amqp.Delivery, r *Rule) (Decision, error) {
+	err := p.delay(ctx, dlv, r.Delay, r.Name)
+	if err != nil {
+		return "", errors.Wrap(err, "delay")
+	}
+	return DecisionDelay, nil
+}
+
+func (p *Policy) applyRateLimit(ctx context.Context, dlv amqp.Delivery, orgID int64, r *Rule) (Decision, error) {
+	if r.Rate <= 0 {
+		return "", errors.New("rate limit: rate must be greater than 0")
+	}
+	rsv, wait := p.reserve(orgID, r)
+	if wait <= 0 {
+		return DecisionAccept, nil
+	}
+	err := p.delay(ctx, dlv, wait, r.Name)
+	if err != nil {
+		// The message was not delayed, so the slot is given back.
+		rsv.CancelAt(timeutils.Now())
+		return "", errors.Wrap(err, "rate limit delay")
+	}
+	return DecisionRateLimited, nil
+}
+
+func (p *Policy) delay(ctx context.Context, dlv amqp.Delivery, d time.Duration, ruleName string) error {
+	if p.Key == "" {
+		return errors.New("no destination key for the delayed message")
+	}
+	t := timeutils.Now().Add(d)
+	pr := func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+		if msg.Headers == nil {
+			msg.Headers = make(amqp.Table)
+		}
+		msg.Headers[headerDelayedRule] = ruleName
+		return p.Delayer.ProduceAt(ctx, t, exchange, key, msg)
+	}
+	return amqputils.Reproduce(ctx, pr, p.Exchange, p.Key, false, false, dlv)
+}
+
+// reserve reserves a token of the rate limiter, and returns the duration to wait before it is available.
+//
+// The reservation is kept, so each delayed message has its own slot.
+func (p *Policy) reserve(orgID int64, r *Rule) (*rate.Reservation, time.Duration) {
+	lim := p.getLimiter(orgID, r)
+	now := timeutils.Now()
+	rsv := lim.ReserveN(now, 1)
+	if !rsv.OK() {
+		return rsv, rate.InfDuration
+	}
+	return rsv, rsv.DelayFrom(now)
+}
+
+func (p *Policy) getLimiter(orgID int64, r *Rule) *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limiters == nil {
+		p.limiters = make(map[policyLimiterKey]*rate.Limiter)
+	}
+	k := policyLimiterKey{
+		orgID: orgID,
+		rule:  r.Name,
+	}
+	lim, ok := p.limiters[k]
+	burst := r.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(r.Rate), burst)
+		p.limiters[k] = lim
+	} else if lim.Limit() != rate.Limit(r.Rate) || lim.Burst() != burst {
+		// The rule was updated.
+		now := timeutils.Now()
+		lim.SetLimitAt(now, rate.Limit(r.Rate))
+		lim.SetBurstAt(now, burst)
+	}
+	return lim
+}
+
+func (p *Policy) count(ctx context.Context, rule string, decision Decision) {
+	if p.Counter != nil {
+		p.Counter(ctx, rule, decision)
+		return
+	}
+	expvarDecisions.Add(string(decision), 1)
+	if rule != "" {
+		expvarDecisions.Add(rule+"."+string(decision), 1)
+	}
+}
@@ -0,0 +1,394 @@
+package amqpskip
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/inmemorycache"
+	"github.com/siddhant2408/golang-libraries/loadutils"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+type testPolicyPublish struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+type testPolicyDecision struct {
+	rule     string
+	decision Decision
+}
+
+func newTestPolicy(rules ...*Rule) (*Policy, *[]testPolicyPublish, *[]testPolicyDecision) {
+	var pubs []testPolicyPublish
+	var decisions []testPolicyDecision
+	pr := func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+		pubs = append(pubs, testPolicyPublish{
+			exchange: exchange,
+			key:      key,
+			msg:      msg,
+		})
+		return nil
+	}
+	p := &Policy{
+		Source: func(ctx context.Context, orgID int64) ([]*Rule, error) {
+			return rules, nil
+		},
+		Producer: pr,
+		Delayer: &amqputils.Delayer{
+			Producer: pr,
+			Queue:    "delay",
+		},
+		Key: "queue",
+		Counter: func(ctx context.Context, rule string, decision Decision) {
+			decisions = append(decisions, testPolicyDecision{
+				rule:     rule,
+				decision: decision,
+			})
+		},
+	}
+	return p, &pubs, &decisions
+}
+
+func newTestPolicyDelivery(orgID int64) amqp.Delivery {
+	return amqp.Delivery{
+		Exchange:   "exchange",
+		RoutingKey: "key",
+		Headers: amqp.Table{
+			headerOrganizationID: orgID,
+			"type":               "test",
+		},
+		Body: []byte("test"),
+	}
+}
+
+func checkTestPolicyAck(tb testing.TB, err error) {
+	tb.Helper()
+	if err == nil {
+		tb.Fatal("no error")
+	}
+	if !errors.IsIgnored(err) {
+		tb.Fatal("not ignored")
+	}
+	ack := amqputils.GetErrorAcknowledger(err)
+	if ack != amqputils.Ack {
+		tb.Fatalf("unexpected acknowledger: got %v, want %v", ack, amqputils.Ack)
+	}
+}
+
+func TestPolicyNoMatch(t *testing.T) {
+	ctx := context.Background()
+	p, pubs, decisions := newTestPolicy(&Rule{
+		Name:       "skip",
+		RoutingKey: "other",
+		Action:     ActionSkip,
+	})
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(*pubs) != 0 {
+		t.Fatalf("unexpected publishings: %v", *pubs)
+	}
+	testutils.Compare(t, "unexpected decisions", *decisions, []testPolicyDecision{
+		{decision: DecisionAccept},
+	})
+}
+
+func TestPolicySkip(t *testing.T) {
+	ctx := context.Background()
+	p, _, decisions := newTestPolicy(&Rule{
+		Name:     "skip",
+		Exchange: "exchange",
+		Headers: amqp.Table{
+			"type": "test",
+		},
+		Action: ActionSkip,
+	})
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	checkTestPolicyAck(t, err)
+	testutils.Compare(t, "unexpected decisions", *decisions, []testPolicyDecision{
+		{rule: "skip", decision: DecisionSkip},
+	})
+}
+
+func TestPolicyRuleJSON(t *testing.T) {
+	ctx := context.Background()
+	var r Rule
+	err := json.Unmarshal([]byte(`{"Name":"skip","Headers":{"version":2},"Action":"skip"}`), &r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p, _, decisions := newTestPolicy(&r)
+	dlv := newTestPolicyDelivery(123)
+	dlv.Headers["version"] = int32(2)
+	err = p.Check(ctx, dlv)
+	checkTestPolicyAck(t, err)
+	dlv.Headers["version"] = int64(3)
+	err = p.Check(ctx, dlv)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected decisions", *decisions, []testPolicyDecision{
+		{rule: "skip", decision: DecisionSkip},
+		{decision: DecisionAccept},
+	})
+}
+
+func TestPolicyAccept(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy(
+		&Rule{
+			Name:   "accept",
+			Action: ActionAccept,
+		},
+		&Rule{
+			Name:   "skip",
+			Action: ActionSkip,
+		},
+	)
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestPolicyForward(t *testing.T) {
+	ctx := context.Background()
+	p, pubs, _ := newTestPolicy(&Rule{
+		Name:              "forward",
+		Action:            ActionForward,
+		ForwardExchange:   "forward_exchange",
+		ForwardRoutingKey: "forward_key",
+	})
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	checkTestPolicyAck(t, err)
+	if len(*pubs) != 1 {
+		t.Fatalf("unexpected publishings: %v", *pubs)
+	}
+	pub := (*pubs)[0]
+	if pub.exchange != "forward_exchange" || pub.key != "forward_key" || string(pub.msg.Body) != "test" {
+		t.Fatalf("unexpected publishing: %v", pub)
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	ctx := context.Background()
+	p, pubs, _ := newTestPolicy(&Rule{
+		Name:   "delay",
+		Action: ActionDelay,
+		Delay:  1 * time.Minute,
+	})
+	dlv := newTestPolicyDelivery(123)
+	err := p.Check(ctx, dlv)
+	checkTestPolicyAck(t, err)
+	if len(*pubs) != 1 {
+		t.Fatalf("unexpected publishings: %v", *pubs)
+	}
+	pub := (*pubs)[0]
+	if pub.exchange != "" || pub.key != "delay.60000" {
+		t.Fatalf("unexpected publishing: %v", pub)
+	}
+	// The message is delayed to the consumed queue, not to the original exchange.
+	if pub.msg.Headers["delay-exchange"] != "" || pub.msg.Headers["delay-routing-key"] != "queue" {
+		t.Fatalf("unexpected delayed destination: %v", pub.msg.Headers)
+	}
+	// The delayed message is not delayed again by the same rule.
+	dlv.Headers = pub.msg.Headers
+	err = p.Check(ctx, dlv)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestPolicyDelayErrorNoKey(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy(&Rule{
+		Name:   "delay",
+		Action: ActionDelay,
+		Delay:  1 * time.Minute,
+	})
+	p.Key = ""
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestPolicyDelayErrorNoDelayer(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy(&Rule{
+		Name:   "delay",
+		Action: ActionDelay,
+		Delay:  1 * time.Minute,
+	})
+	p.Delayer = nil
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestPolicyRateLimit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	p, pubs, decisions := newTestPolicy(&Rule{
+		Name:   "rate_limit",
+		Action: ActionRateLimit,
+		Rate:   1,
+		Burst:  2,
+	})
+	for i := 0; i < 2; i++ {
+		err := p.Check(ctx, newTestPolicyDelivery(123))
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	checkTestPolicyAck(t, err)
+	if len(*pubs) != 1 || (*pubs)[0].key != "delay.1000" {
+		t.Fatalf("unexpected publishings: %v", *pubs)
+	}
+	// The limit is per organization.
+	err = p.Check(ctx, newTestPolicyDelivery(456))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// The tokens are refilled, after the slot reserved by the delayed message.
+	timeutils.SetFixed(now.Add(2 * time.Second))
+	err = p.Check(ctx, newTestPolicyDelivery(123))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected decisions", *decisions, []testPolicyDecision{
+		{rule: "rate_limit", decision: DecisionAccept},
+		{rule: "rate_limit", decision: DecisionAccept},
+		{rule: "rate_limit", decision: DecisionRateLimited},
+		{rule: "rate_limit", decision: DecisionAccept},
+		{rule: "rate_limit", decision: DecisionAccept},
+	})
+}
+
+func TestPolicyRateLimitBurst(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	p, pubs, _ := newTestPolicy(&Rule{
+		Name:   "rate_limit",
+		Action: ActionRateLimit,
+		Rate:   1,
+	})
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		err = p.Check(ctx, newTestPolicyDelivery(123))
+		checkTestPolicyAck(t, err)
+	}
+	var untils []interface{}
+	for _, pub := range *pubs {
+		untils = append(untils, pub.msg.Headers["delay-until"])
+	}
+	// Each delayed message has its own slot.
+	testutils.Compare(t, "unexpected delays", untils, []interface{}{
+		now.Add(1 * time.Second).Format(time.RFC3339Nano),
+		now.Add(2 * time.Second).Format(time.RFC3339Nano),
+		now.Add(3 * time.Second).Format(time.RFC3339Nano),
+	})
+	// The delayed message is accepted when it is delivered again, without reserving another slot.
+	timeutils.SetFixed(now.Add(1 * time.Second))
+	dlv := newTestPolicyDelivery(123)
+	dlv.Headers = (*pubs)[0].msg.Headers
+	err = p.Check(ctx, dlv)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = p.Check(ctx, newTestPolicyDelivery(123))
+	checkTestPolicyAck(t, err)
+	if until, want := (*pubs)[3].msg.Headers["delay-until"], now.Add(4*time.Second).Format(time.RFC3339Nano); until != want {
+		t.Fatalf("unexpected delay: got %v, want %s", until, want)
+	}
+}
+
+func TestPolicyLoader(t *testing.T) {
+	ctx := context.Background()
+	var sourceCalled testutils.CallCounter
+	p := &Policy{
+		Source: func(ctx context.Context, orgID int64) ([]*Rule, error) {
+			sourceCalled.Call()
+			return nil, nil
+		},
+		Loader: &loadutils.Loader{
+			Cache: &loadutils.InMemoryCacheWrapper{
+				Cache: inmemorycache.New(),
+			},
+		},
+		Counter: func(ctx context.Context, rule string, decision Decision) {},
+	}
+	for i := 0; i < 3; i++ {
+		err := p.Check(ctx, newTestPolicyDelivery(123))
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	sourceCalled.AssertCount(t, 1)
+}
+
+func TestPolicyCounterDefault(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy(&Rule{
+		Name:   "test_counter_default",
+		Action: ActionSkip,
+	})
+	p.Counter = nil
+	getCount := func() int64 {
+		v, _ := expvarDecisions.Get("test_counter_default.skip").(*expvar.Int)
+		if v == nil {
+			return 0
+		}
+		return v.Value()
+	}
+	before := getCount()
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	checkTestPolicyAck(t, err)
+	if c := getCount() - before; c != 1 {
+		t.Fatalf("unexpected count: got %d, want 1", c)
+	}
+}
+
+func TestPolicyErrorHeaderMissing(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy()
+	err := p.Check(ctx, amqp.Delivery{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	ack := amqputils.GetErrorAcknowledger(err)
+	if ack != amqputils.NackDiscard {
+		t.Fatalf("unexpected acknowledger: got %v, want %v", ack, amqputils.NackDiscard)
+	}
+}
+
+func TestPolicyErrorSource(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestPolicy()
+	p.Source = func(ctx context.Context, orgID int64) ([]*Rule, error) {
+		return nil, errors.New("error")
+	}
+	err := p.Check(ctx, newTestPolicyDelivery(123))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
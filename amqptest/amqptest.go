@@ -2,6 +2,8 @@
 //
 // If AMQP is not available, the test is skipped.
 // It can be controlled with the AMQPTEST_UNAVAILABLE_SKIP environment variable.
+//
+// Broker is an in-memory fake, that allows to run tests without AMQP.
 package amqptest
 
 import (
@@ -0,0 +1,846 @@
+package amqptest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+const (
+	brokerVhost          = "/"
+	brokerExpireInterval = 10 * time.Millisecond
+	brokerReplyToPrefix  = amqputils.RPCReplyTo + "."
+	brokerStreamOffset   = "x-stream-offset"
+)
+
+// Broker is an in-memory AMQP broker.
+//
+// It is a fake for unit tests, that doesn't require a running RabbitMQ.
+// It mimics the RabbitMQ behavior for the most common features:
+//   - exchanges (direct, fanout, topic, headers) and exchange to exchange bindings
+//   - queues (durable, auto delete, exclusive) and bindings
+//   - publish, consume, get, ack, nack and reject
+//   - prefetch (Qos)
+//   - per-message and per-queue TTL ("x-message-ttl")
+//   - dead-lettering ("x-dead-letter-exchange" and "x-dead-letter-routing-key"), with the "x-death" header
+//   - publisher confirms and mandatory returns
+//   - direct reply-to
+//   - stream queues ("x-queue-type" = "stream"), with the "x-stream-offset" consume argument
+//
+// Errors are reported like RabbitMQ, with *amqp.Error, and the channel is closed.
+// Like the real client, errors caused by asynchronous methods (Publish, Ack, etc.) are only reported to NotifyClose.
+//
+// The message expiration uses timeutils.Now(), so it can be controlled with a fixed time (testutils initializes a fixed time).
+// Expired messages are checked periodically, and during each operation.
+//
+// The flags noWait, noLocal and immediate are ignored.
+type Broker struct {
+	mu sync.Mutex
+	// notifyMu guarantees that the notifications are sent in order.
+	notifyMu sync.Mutex
+	notifies []func()
+
+	exchanges map[string]*brokerExchange
+	queues    map[string]*brokerQueue
+	channels  map[*Channel]struct{}
+	counter   int64
+	closed    bool
+	stop      chan struct{}
+}
+
+// NewBroker returns a new Broker.
+//
+// It registers a cleanup function that closes the Broker at the end of the test.
+func NewBroker(tb testing.TB) *Broker {
+	tb.Helper()
+	b := &Broker{
+		exchanges: make(map[string]*brokerExchange),
+		queues:    make(map[string]*brokerQueue),
+		channels:  make(map[*Channel]struct{}),
+		stop:      make(chan struct{}),
+	}
+	for name, kind := range map[string]string{
+		"amq.direct":  amqp.ExchangeDirect,
+		"amq.fanout":  amqp.ExchangeFanout,
+		"amq.topic":   amqp.ExchangeTopic,
+		"amq.headers": amqp.ExchangeHeaders,
+		"amq.match":   amqp.ExchangeHeaders,
+	} {
+		b.exchanges[name] = &brokerExchange{
+			name:    name,
+			kind:    kind,
+			durable: true,
+		}
+	}
+	go b.runExpire()
+	tb.Cleanup(b.Close)
+	return b
+}
+
+// Channel opens a new Channel.
+//
+// It panics if the Broker is closed.
+func (b *Broker) Channel() *Channel {
+	b.lock()
+	defer b.unlock()
+	if b.closed {
+		panic("broker closed")
+	}
+	c := &Channel{
+		broker:    b,
+		unacked:   make(map[uint64]*brokerUnacked),
+		consumers: make(map[string]*brokerConsumer),
+	}
+	b.channels[c] = struct{}{}
+	return c
+}
+
+// ChannelGetter returns an amqputils.ChannelGetter that opens new channels.
+func (b *Broker) ChannelGetter() amqputils.ChannelGetter {
+	return func(ctx context.Context) (amqputils.Channel, error) {
+		b.mu.Lock()
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return nil, amqp.ErrClosed
+		}
+		return b.Channel(), nil
+	}
+}
+
+// QueueMessages returns the number of ready messages in a queue.
+//
+// It doesn't include the messages delivered and not yet acknowledged.
+// For a stream queue, it returns the number of messages in the stream.
+// It returns 0 if the queue doesn't exist.
+func (b *Broker) QueueMessages(name string) int {
+	b.lock()
+	defer b.unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return 0
+	}
+	return len(q.msgs)
+}
+
+// Close closes the Broker and all its channels.
+func (b *Broker) Close() {
+	b.lock()
+	defer b.unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.stop)
+	for c := range b.channels {
+		c.shutdown(nil)
+	}
+}
+
+// lock locks the Broker, and expires the messages.
+func (b *Broker) lock() {
+	b.mu.Lock()
+	b.dispatch()
+}
+
+// unlock dispatches the messages, unlocks the Broker, then sends the pending notifications.
+func (b *Broker) unlock() {
+	b.dispatch()
+	fs := b.notifies
+	b.notifies = nil
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	b.mu.Unlock()
+	for _, f := range fs {
+		f()
+	}
+}
+
+// notify registers a function that is called after the Broker is unlocked.
+// It allows to send to channels provided by the user without holding the lock.
+func (b *Broker) notify(f func()) {
+	b.notifies = append(b.notifies, f)
+}
+
+func (b *Broker) runExpire() {
+	t := time.NewTicker(brokerExpireInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.lock()
+			b.unlock()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *Broker) newName(prefix string) string {
+	b.counter++
+	return prefix + strconv.FormatInt(b.counter, 10)
+}
+
+func (b *Broker) getExchange(name string) (*brokerExchange, *amqp.Error) {
+	e, ok := b.exchanges[name]
+	if !ok {
+		return nil, newBrokerError(amqp.NotFound, "no exchange '%s' in vhost '%s'", name, brokerVhost)
+	}
+	return e, nil
+}
+
+func (b *Broker) getQueue(name string) (*brokerQueue, *amqp.Error) {
+	q, ok := b.queues[name]
+	if !ok {
+		return nil, newBrokerError(amqp.NotFound, "no queue '%s' in vhost '%s'", name, brokerVhost)
+	}
+	return q, nil
+}
+
+func (b *Broker) checkQueueOwner(q *brokerQueue, c *Channel) *amqp.Error {
+	if q.owner != nil && q.owner != c {
+		return newBrokerError(amqp.ResourceLocked, "cannot obtain exclusive access to locked queue '%s' in vhost '%s'", q.name, brokerVhost)
+	}
+	return nil
+}
+
+func (b *Broker) deleteQueue(q *brokerQueue) {
+	delete(b.queues, q.name)
+	q.deleted = true
+	for _, cs := range q.consumers {
+		delete(cs.channel.consumers, cs.tag)
+		cs.cancel()
+	}
+	q.consumers = nil
+	for _, e := range b.exchanges {
+		e.removeBindings(q.name, false)
+	}
+}
+
+func (b *Broker) deleteExchange(e *brokerExchange) {
+	delete(b.exchanges, e.name)
+	for _, oe := range b.exchanges {
+		oe.removeBindings(e.name, true)
+	}
+}
+
+// publish routes a message to the queues.
+// It returns false if the message was not routed.
+func (b *Broker) publish(exchange string, m *brokerMessage) (bool, *amqp.Error) {
+	var qs []*brokerQueue
+	if exchange == "" {
+		q, ok := b.queues[m.key]
+		if ok {
+			qs = append(qs, q)
+		}
+	} else {
+		e, aerr := b.getExchange(exchange)
+		if aerr != nil {
+			return false, aerr
+		}
+		visited := make(map[string]bool)
+		qs = b.route(e, m.key, m.pbl.Headers, visited, qs)
+	}
+	now := timeutils.Now()
+	for _, q := range qs {
+		q.enqueue(m.clone(), now)
+	}
+	return len(qs) > 0, nil
+}
+
+func (b *Broker) route(e *brokerExchange, key string, headers amqp.Table, visited map[string]bool, qs []*brokerQueue) []*brokerQueue {
+	if visited[e.name] {
+		return qs
+	}
+	visited[e.name] = true
+	for _, bd := range e.bindings {
+		if !e.match(bd, key, headers) {
+			continue
+		}
+		if bd.toExchange {
+			de, ok := b.exchanges[bd.destination]
+			if ok {
+				qs = b.route(de, key, headers, visited, qs)
+			}
+			continue
+		}
+		q, ok := b.queues[bd.destination]
+		if ok && !containsBrokerQueue(qs, q) {
+			qs = append(qs, q)
+		}
+	}
+	return qs
+}
+
+func containsBrokerQueue(qs []*brokerQueue, q *brokerQueue) bool {
+	for _, v := range qs {
+		if v == q {
+			return true
+		}
+	}
+	return false
+}
+
+// deadLetter publishes a message to the dead letter exchange of the queue, if it is defined.
+func (b *Broker) deadLetter(q *brokerQueue, m *brokerMessage, reason string) {
+	if q.isStream() {
+		return
+	}
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := m.key
+	dlk, ok := q.args["x-dead-letter-routing-key"].(string)
+	if ok {
+		key = dlk
+	}
+	dm := m.clone()
+	dm.pbl.Headers = addBrokerDeath(dm.pbl.Headers, q.name, reason, m, timeutils.Now())
+	if dm.pbl.Expiration != "" {
+		// RabbitMQ removes the expiration, in order to not expire the message again.
+		dm.pbl.Expiration = ""
+	}
+	dm.exchange = dlx
+	dm.key = key
+	dm.redelivered = false
+	// If the dead letter exchange doesn't exist, the message is dropped.
+	_, _ = b.publish(dlx, dm)
+}
+
+func addBrokerDeath(headers amqp.Table, queue, reason string, m *brokerMessage, now time.Time) amqp.Table {
+	h := make(amqp.Table, len(headers)+4)
+	for k, v := range headers {
+		h[k] = v
+	}
+	deaths, _ := h["x-death"].([]interface{})
+	newDeaths := make([]interface{}, 0, len(deaths)+1)
+	death := amqp.Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        queue,
+		"time":         now,
+		"exchange":     m.exchange,
+		"routing-keys": []interface{}{m.key},
+	}
+	if m.pbl.Expiration != "" {
+		death["original-expiration"] = m.pbl.Expiration
+	}
+	for _, d := range deaths {
+		dt, ok := d.(amqp.Table)
+		if ok && dt["queue"] == queue && dt["reason"] == reason {
+			// RabbitMQ increments the count of the existing entry, and moves it to the beginning.
+			count, _ := dt["count"].(int64)
+			death["count"] = count + 1
+			continue
+		}
+		newDeaths = append(newDeaths, d)
+	}
+	h["x-death"] = append([]interface{}{death}, newDeaths...)
+	if _, ok := h["x-first-death-queue"]; !ok {
+		h["x-first-death-queue"] = queue
+		h["x-first-death-reason"] = reason
+		h["x-first-death-exchange"] = m.exchange
+	}
+	return h
+}
+
+// dispatch expires messages and delivers messages to consumers.
+func (b *Broker) dispatch() {
+	now := timeutils.Now()
+	for changed := true; changed; {
+		changed = false
+		for _, q := range b.sortedQueues() {
+			if b.dispatchQueue(q, now) {
+				changed = true
+			}
+		}
+	}
+}
+
+func (b *Broker) sortedQueues() []*brokerQueue {
+	qs := make([]*brokerQueue, 0, len(b.queues))
+	for _, q := range b.queues {
+		qs = append(qs, q)
+	}
+	sort.Slice(qs, func(i, j int) bool {
+		return qs[i].name < qs[j].name
+	})
+	return qs
+}
+
+func (b *Broker) dispatchQueue(q *brokerQueue, now time.Time) (changed bool) {
+	if q.isStream() {
+		return q.dispatchStream()
+	}
+	for len(q.msgs) > 0 {
+		m := q.msgs[0]
+		if !m.expireAt.IsZero() && now.After(m.expireAt) {
+			b.expireQueue(q)
+			changed = true
+			continue
+		}
+		cs := q.nextConsumer()
+		if cs != nil {
+			q.msgs = q.msgs[1:]
+			cs.deliver(q, m)
+			changed = true
+			continue
+		}
+		if !m.expireAt.IsZero() && !now.Before(m.expireAt) {
+			// Like RabbitMQ, a message with a TTL of 0 expires if it can't be delivered immediately.
+			b.expireQueue(q)
+			changed = true
+			continue
+		}
+		break
+	}
+	return changed
+}
+
+// expireQueue dead-letters the message at the head of the queue.
+// Like RabbitMQ, only the head of the queue is expired.
+func (b *Broker) expireQueue(q *brokerQueue) {
+	m := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	b.deadLetter(q, m, "expired")
+}
+
+type brokerExchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       amqp.Table
+	bindings   []*brokerBinding
+}
+
+func (e *brokerExchange) checkEquivalent(kind string, durable, autoDelete, internal bool, args amqp.Table) *amqp.Error {
+	if kind != e.kind {
+		return newBrokerErrorInequivalent("exchange", e.name, "type", kind, e.kind)
+	}
+	if durable != e.durable {
+		return newBrokerErrorInequivalent("exchange", e.name, "durable", durable, e.durable)
+	}
+	if autoDelete != e.autoDelete {
+		return newBrokerErrorInequivalent("exchange", e.name, "auto_delete", autoDelete, e.autoDelete)
+	}
+	if internal != e.internal {
+		return newBrokerErrorInequivalent("exchange", e.name, "internal", internal, e.internal)
+	}
+	return checkBrokerArgsEquivalent("exchange", e.name, args, e.args)
+}
+
+func (e *brokerExchange) addBinding(bd *brokerBinding) {
+	for _, v := range e.bindings {
+		if v.destination == bd.destination && v.toExchange == bd.toExchange && v.key == bd.key && equalBrokerArgs(v.args, bd.args) {
+			return
+		}
+	}
+	e.bindings = append(e.bindings, bd)
+}
+
+func (e *brokerExchange) removeBindings(destination string, toExchange bool) {
+	bds := e.bindings[:0]
+	for _, bd := range e.bindings {
+		if bd.destination != destination || bd.toExchange != toExchange {
+			bds = append(bds, bd)
+		}
+	}
+	e.bindings = bds
+}
+
+func (e *brokerExchange) match(bd *brokerBinding, key string, headers amqp.Table) bool {
+	switch e.kind {
+	case amqp.ExchangeDirect:
+		return bd.key == key
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return matchBrokerTopic(strings.Split(bd.key, "."), strings.Split(key, "."))
+	case amqp.ExchangeHeaders:
+		return matchBrokerHeaders(bd.args, headers)
+	}
+	return false
+}
+
+func matchBrokerTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchBrokerTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchBrokerTopic(pattern[1:], words[1:])
+	}
+	return len(words) > 0 && pattern[0] == words[0] && matchBrokerTopic(pattern[1:], words[1:])
+}
+
+func matchBrokerHeaders(args, headers amqp.Table) bool {
+	matchAny := args["x-match"] == "any"
+	count := 0
+	matched := 0
+	for k, v := range args {
+		if strings.HasPrefix(k, "x-") {
+			continue
+		}
+		count++
+		hv, ok := headers[k]
+		if ok && fmt.Sprint(hv) == fmt.Sprint(v) {
+			matched++
+		}
+	}
+	if matchAny {
+		return matched > 0
+	}
+	return matched == count
+}
+
+type brokerBinding struct {
+	destination string
+	toExchange  bool
+	key         string
+	args        amqp.Table
+}
+
+type brokerQueue struct {
+	name       string
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	args       amqp.Table
+	owner      *Channel
+	msgs       []*brokerMessage
+	consumers  []*brokerConsumer
+	next       int
+	deleted    bool
+}
+
+func (q *brokerQueue) checkEquivalent(durable, autoDelete, exclusive bool, args amqp.Table) *amqp.Error {
+	if durable != q.durable {
+		return newBrokerErrorInequivalent("queue", q.name, "durable", durable, q.durable)
+	}
+	if autoDelete != q.autoDelete {
+		return newBrokerErrorInequivalent("queue", q.name, "auto_delete", autoDelete, q.autoDelete)
+	}
+	if exclusive != q.exclusive {
+		return newBrokerErrorInequivalent("queue", q.name, "exclusive", exclusive, q.exclusive)
+	}
+	return checkBrokerArgsEquivalent("queue", q.name, args, q.args)
+}
+
+func (q *brokerQueue) enqueue(m *brokerMessage, now time.Time) {
+	m.expireAt = time.Time{}
+	if q.isStream() {
+		// The messages of a stream are never removed, and the offset is the position in the stream.
+		m.published = now
+		m.pbl.Headers = copyBrokerTable(m.pbl.Headers)
+		if m.pbl.Headers == nil {
+			m.pbl.Headers = make(amqp.Table, 1)
+		}
+		m.pbl.Headers[brokerStreamOffset] = int64(len(q.msgs))
+		q.msgs = append(q.msgs, m)
+		return
+	}
+	ttl, ok := getBrokerTTL(m.pbl.Expiration)
+	if ok {
+		m.expireAt = now.Add(ttl)
+	}
+	ttl, ok = getBrokerQueueTTL(q.args)
+	if ok {
+		exp := now.Add(ttl)
+		if m.expireAt.IsZero() || exp.Before(m.expireAt) {
+			m.expireAt = exp
+		}
+	}
+	q.msgs = append(q.msgs, m)
+}
+
+// requeue puts back messages at the beginning of the queue.
+func (q *brokerQueue) requeue(ms []*brokerMessage) {
+	if q.deleted || q.isStream() || len(ms) == 0 {
+		return
+	}
+	for _, m := range ms {
+		m.redelivered = true
+	}
+	q.msgs = append(append(make([]*brokerMessage, 0, len(ms)+len(q.msgs)), ms...), q.msgs...)
+}
+
+// nextConsumer returns the next consumer that can receive a message, with a round robin.
+func (q *brokerQueue) nextConsumer() *brokerConsumer {
+	for i := range q.consumers {
+		idx := (q.next + i) % len(q.consumers)
+		cs := q.consumers[idx]
+		if cs.ready() {
+			q.next = idx + 1
+			return cs
+		}
+	}
+	return nil
+}
+
+func (q *brokerQueue) removeConsumer(cs *brokerConsumer) {
+	for i, v := range q.consumers {
+		if v == cs {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *brokerQueue) isStream() bool {
+	return q.args["x-queue-type"] == "stream"
+}
+
+// dispatchStream delivers the messages of a stream to each consumer, from its own offset.
+func (q *brokerQueue) dispatchStream() (changed bool) {
+	for _, cs := range q.consumers {
+		for cs.ready() && cs.offset < len(q.msgs) {
+			cs.deliver(q, q.msgs[cs.offset])
+			cs.offset++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// streamOffset returns the position in the stream for a "x-stream-offset" consume argument.
+// The default value is "next".
+func (q *brokerQueue) streamOffset(v interface{}) (int, *amqp.Error) {
+	switch v := v.(type) {
+	case nil:
+		return len(q.msgs), nil
+	case string:
+		offset, ok := q.streamOffsetSpec(v)
+		if ok {
+			return offset, nil
+		}
+	case int:
+		return clampBrokerStreamOffset(int64(v), len(q.msgs)), nil
+	case int32:
+		return clampBrokerStreamOffset(int64(v), len(q.msgs)), nil
+	case int64:
+		return clampBrokerStreamOffset(v, len(q.msgs)), nil
+	case time.Time:
+		return sort.Search(len(q.msgs), func(i int) bool {
+			return !q.msgs[i].published.Before(v)
+		}), nil
+	}
+	return 0, newBrokerError(amqp.PreconditionFailed, "invalid argument '%s' for queue '%s' in vhost '%s': %v", brokerStreamOffset, q.name, brokerVhost, v)
+}
+
+// streamOffsetSpec returns the position in the stream for a named offset: "first", "last" or "next".
+func (q *brokerQueue) streamOffsetSpec(spec string) (int, bool) {
+	switch spec {
+	case "first":
+		return 0, true
+	case "last":
+		if len(q.msgs) == 0 {
+			return 0, true
+		}
+		return len(q.msgs) - 1, true
+	case "next":
+		return len(q.msgs), true
+	}
+	return 0, false
+}
+
+func clampBrokerStreamOffset(offset int64, n int) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > int64(n) {
+		return n
+	}
+	return int(offset)
+}
+
+func (q *brokerQueue) info() amqp.Queue {
+	return amqp.Queue{
+		Name:      q.name,
+		Messages:  len(q.msgs),
+		Consumers: len(q.consumers),
+	}
+}
+
+func getBrokerTTL(exp string) (time.Duration, bool) {
+	if exp == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func getBrokerQueueTTL(args amqp.Table) (time.Duration, bool) {
+	var ms int64
+	switch v := args["x-message-ttl"].(type) {
+	case int:
+		ms = int64(v)
+	case int16:
+		ms = int64(v)
+	case int32:
+		ms = int64(v)
+	case int64:
+		ms = v
+	default:
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+type brokerMessage struct {
+	exchange    string
+	key         string
+	pbl         amqp.Publishing
+	redelivered bool
+	expireAt    time.Time
+	published   time.Time
+}
+
+func (m *brokerMessage) clone() *brokerMessage {
+	nm := *m
+	if m.pbl.Headers != nil {
+		nm.pbl.Headers = make(amqp.Table, len(m.pbl.Headers))
+		for k, v := range m.pbl.Headers {
+			nm.pbl.Headers[k] = v
+		}
+	}
+	return &nm
+}
+
+func (m *brokerMessage) delivery(ack amqp.Acknowledger, tag uint64, consumerTag string) amqp.Delivery {
+	pbl := m.pbl
+	return amqp.Delivery{
+		Acknowledger:    ack,
+		Headers:         pbl.Headers,
+		ContentType:     pbl.ContentType,
+		ContentEncoding: pbl.ContentEncoding,
+		DeliveryMode:    pbl.DeliveryMode,
+		Priority:        pbl.Priority,
+		CorrelationId:   pbl.CorrelationId,
+		ReplyTo:         pbl.ReplyTo,
+		Expiration:      pbl.Expiration,
+		MessageId:       pbl.MessageId,
+		Timestamp:       pbl.Timestamp,
+		Type:            pbl.Type,
+		UserId:          pbl.UserId,
+		AppId:           pbl.AppId,
+		ConsumerTag:     consumerTag,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Body:            pbl.Body,
+	}
+}
+
+func (m *brokerMessage) ret(code uint16, text string) amqp.Return {
+	pbl := m.pbl
+	return amqp.Return{
+		ReplyCode:       code,
+		ReplyText:       text,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Headers:         pbl.Headers,
+		ContentType:     pbl.ContentType,
+		ContentEncoding: pbl.ContentEncoding,
+		DeliveryMode:    pbl.DeliveryMode,
+		Priority:        pbl.Priority,
+		CorrelationId:   pbl.CorrelationId,
+		ReplyTo:         pbl.ReplyTo,
+		Expiration:      pbl.Expiration,
+		MessageId:       pbl.MessageId,
+		Timestamp:       pbl.Timestamp,
+		Type:            pbl.Type,
+		UserId:          pbl.UserId,
+		AppId:           pbl.AppId,
+		Body:            pbl.Body,
+	}
+}
+
+func copyBrokerTable(t amqp.Table) amqp.Table {
+	if t == nil {
+		return nil
+	}
+	nt := make(amqp.Table, len(t))
+	for k, v := range t {
+		nt[k] = v
+	}
+	return nt
+}
+
+func checkBrokerArgsEquivalent(kind, name string, received, current amqp.Table) *amqp.Error {
+	keys := make([]string, 0, len(received)+len(current))
+	for k := range received {
+		keys = append(keys, k)
+	}
+	for k := range current {
+		if _, ok := received[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rv, rok := received[k]
+		cv, cok := current[k]
+		if rok && cok && fmt.Sprint(rv) == fmt.Sprint(cv) {
+			continue
+		}
+		r := "none"
+		if rok {
+			r = fmt.Sprintf("'%v'", rv)
+		}
+		c := "none"
+		if cok {
+			c = fmt.Sprintf("'%v'", cv)
+		}
+		return newBrokerError(amqp.PreconditionFailed, "inequivalent arg '%s' for %s '%s' in vhost '%s': received %s but current is %s", k, kind, name, brokerVhost, r, c)
+	}
+	return nil
+}
+
+func equalBrokerArgs(a, b amqp.Table) bool {
+	return checkBrokerArgsEquivalent("", "", a, b) == nil
+}
+
+func newBrokerErrorInequivalent(kind, name, field string, received, current interface{}) *amqp.Error {
+	return newBrokerError(amqp.PreconditionFailed, "inequivalent arg '%s' for %s '%s' in vhost '%s': received '%v' but current is '%v'", field, kind, name, brokerVhost, received, current)
+}
+
+var brokerErrorCodeTexts = map[int]string{
+	amqp.AccessRefused:      "ACCESS_REFUSED",
+	amqp.NotFound:           "NOT_FOUND",
+	amqp.ResourceLocked:     "RESOURCE_LOCKED",
+	amqp.PreconditionFailed: "PRECONDITION_FAILED",
+	amqp.CommandInvalid:     "COMMAND_INVALID",
+	amqp.NotAllowed:         "NOT_ALLOWED",
+}
+
+func newBrokerError(code int, format string, args ...interface{}) *amqp.Error {
+	return &amqp.Error{
+		Code:    code,
+		Reason:  brokerErrorCodeTexts[code] + " - " + fmt.Sprintf(format, args...),
+		Server:  true,
+		Recover: false,
+	}
+}
@@ -0,0 +1,426 @@
+package amqptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+func newTestBrokerQueue(t *testing.T, c *Channel, name string, args amqp.Table) {
+	t.Helper()
+	_, err := c.QueueDeclare(name, false, false, false, false, args)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func publishTestBroker(t *testing.T, c *Channel, exchange, key string, pbl amqp.Publishing) {
+	t.Helper()
+	err := c.Publish(exchange, key, false, false, pbl)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func getTestBroker(t *testing.T, c *Channel, queue string) (amqp.Delivery, bool) {
+	t.Helper()
+	dlv, ok, err := c.Get(queue, false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	return dlv, ok
+}
+
+func TestBrokerRouting(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		kind       string
+		bindingKey string
+		args       amqp.Table
+		key        string
+		headers    amqp.Table
+		expected   bool
+	}{
+		{name: "DirectMatch", kind: amqp.ExchangeDirect, bindingKey: "a", key: "a", expected: true},
+		{name: "DirectNoMatch", kind: amqp.ExchangeDirect, bindingKey: "a", key: "b", expected: false},
+		{name: "Fanout", kind: amqp.ExchangeFanout, bindingKey: "a", key: "b", expected: true},
+		{name: "TopicStar", kind: amqp.ExchangeTopic, bindingKey: "a.*.c", key: "a.b.c", expected: true},
+		{name: "TopicStarNoMatch", kind: amqp.ExchangeTopic, bindingKey: "a.*", key: "a.b.c", expected: false},
+		{name: "TopicHash", kind: amqp.ExchangeTopic, bindingKey: "a.#", key: "a.b.c", expected: true},
+		{name: "TopicHashEmpty", kind: amqp.ExchangeTopic, bindingKey: "a.#", key: "a", expected: true},
+		{name: "TopicHashMiddle", kind: amqp.ExchangeTopic, bindingKey: "a.#.d", key: "a.b.c.d", expected: true},
+		{name: "HeadersAll", kind: amqp.ExchangeHeaders, args: amqp.Table{"x-match": "all", "a": "1", "b": "2"}, headers: amqp.Table{"a": "1", "b": "2"}, expected: true},
+		{name: "HeadersAllNoMatch", kind: amqp.ExchangeHeaders, args: amqp.Table{"x-match": "all", "a": "1", "b": "2"}, headers: amqp.Table{"a": "1"}, expected: false},
+		{name: "HeadersAny", kind: amqp.ExchangeHeaders, args: amqp.Table{"x-match": "any", "a": "1", "b": "2"}, headers: amqp.Table{"a": "1"}, expected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroker(t)
+			c := b.Channel()
+			err := c.ExchangeDeclare("test", tc.kind, false, false, false, false, nil)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			newTestBrokerQueue(t, c, "test", nil)
+			err = c.QueueBind("test", tc.bindingKey, "test", false, tc.args)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			publishTestBroker(t, c, "test", tc.key, amqp.Publishing{
+				Headers: tc.headers,
+			})
+			_, ok := getTestBroker(t, c, "test")
+			if ok != tc.expected {
+				t.Fatalf("unexpected routed: got %t, want %t", ok, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBrokerExchangeBind(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	err := c.ExchangeDeclare("test", amqp.ExchangeFanout, false, false, false, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = c.ExchangeBind("test", "a", "amq.direct", false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	newTestBrokerQueue(t, c, "test", nil)
+	err = c.QueueBind("test", "", "test", false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	publishTestBroker(t, c, "amq.direct", "a", amqp.Publishing{})
+	dlv, ok := getTestBroker(t, c, "test")
+	if !ok {
+		t.Fatal("not ok")
+	}
+	if dlv.Exchange != "amq.direct" {
+		t.Fatalf("unexpected exchange: got %q, want %q", dlv.Exchange, "amq.direct")
+	}
+}
+
+func TestBrokerConsume(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", nil)
+	dlvs, err := c.Consume("test", "", false, false, false, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	publishTestBroker(t, c, "", "test", amqp.Publishing{
+		Body: []byte("test"),
+	})
+	dlv := <-dlvs
+	if string(dlv.Body) != "test" {
+		t.Fatalf("unexpected body: got %q, want %q", dlv.Body, "test")
+	}
+	err = dlv.Ack(false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = c.Close()
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	_, ok := <-dlvs
+	if ok {
+		t.Fatal("deliveries channel not closed")
+	}
+	if n := b.QueueMessages("test"); n != 0 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 0)
+	}
+}
+
+func TestBrokerPrefetch(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", nil)
+	err := c.Qos(1, 0, false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	dlvs, err := c.Consume("test", "", false, false, false, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		publishTestBroker(t, c, "", "test", amqp.Publishing{})
+	}
+	dlv := <-dlvs
+	if n := b.QueueMessages("test"); n != 1 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 1)
+	}
+	err = dlv.Ack(false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	<-dlvs
+	if n := b.QueueMessages("test"); n != 0 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 0)
+	}
+}
+
+func TestBrokerNackRequeue(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", nil)
+	publishTestBroker(t, c, "", "test", amqp.Publishing{Body: []byte("1")})
+	publishTestBroker(t, c, "", "test", amqp.Publishing{Body: []byte("2")})
+	getTestBroker(t, c, "test")
+	dlv, _ := getTestBroker(t, c, "test")
+	err := dlv.Nack(true, true)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for _, body := range []string{"1", "2"} {
+		dlv, ok := getTestBroker(t, c, "test")
+		if !ok {
+			t.Fatal("not ok")
+		}
+		if string(dlv.Body) != body {
+			t.Fatalf("unexpected body: got %q, want %q", dlv.Body, body)
+		}
+		if !dlv.Redelivered {
+			t.Fatal("not redelivered")
+		}
+	}
+}
+
+func TestBrokerCloseRequeue(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", nil)
+	publishTestBroker(t, c, "", "test", amqp.Publishing{})
+	getTestBroker(t, c, "test")
+	err := c.Close()
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n := b.QueueMessages("test"); n != 1 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 1)
+	}
+}
+
+func TestBrokerDeadLetterRejected(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "dead", nil)
+	newTestBrokerQueue(t, c, "test", amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "dead",
+	})
+	publishTestBroker(t, c, "", "test", amqp.Publishing{})
+	dlv, _ := getTestBroker(t, c, "test")
+	err := dlv.Reject(false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	dlv, ok := getTestBroker(t, c, "dead")
+	if !ok {
+		t.Fatal("not ok")
+	}
+	deaths, _ := dlv.Headers["x-death"].([]interface{})
+	if len(deaths) != 1 {
+		t.Fatalf("unexpected deaths length: got %d, want %d", len(deaths), 1)
+	}
+	death := deaths[0].(amqp.Table)
+	testutils.Compare(t, "unexpected death", death, amqp.Table{
+		"count":        int64(1),
+		"reason":       "rejected",
+		"queue":        "test",
+		"time":         death["time"],
+		"exchange":     "",
+		"routing-keys": []interface{}{"test"},
+	})
+}
+
+func TestBrokerExpiration(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitReal()
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "dead", nil)
+	newTestBrokerQueue(t, c, "test", amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "dead",
+		"x-message-ttl":             int64(1000),
+	})
+	publishTestBroker(t, c, "", "test", amqp.Publishing{})
+	publishTestBroker(t, c, "", "test", amqp.Publishing{Expiration: "100"})
+	timeutils.SetFixed(now.Add(500 * time.Millisecond))
+	if n := b.QueueMessages("test"); n != 2 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 2)
+	}
+	timeutils.SetFixed(now.Add(2 * time.Second))
+	if n := b.QueueMessages("test"); n != 0 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 0)
+	}
+	if n := b.QueueMessages("dead"); n != 2 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 2)
+	}
+	getTestBroker(t, c, "dead")
+	dlv, _ := getTestBroker(t, c, "dead")
+	if dlv.Expiration != "" {
+		t.Fatalf("unexpected expiration: got %q, want empty", dlv.Expiration)
+	}
+	deaths, _ := dlv.Headers["x-death"].([]interface{})
+	death := deaths[0].(amqp.Table)
+	if death["reason"] != "expired" {
+		t.Fatalf("unexpected reason: got %v, want %q", death["reason"], "expired")
+	}
+	if death["original-expiration"] != "100" {
+		t.Fatalf("unexpected original expiration: got %v, want %q", death["original-expiration"], "100")
+	}
+}
+
+func TestBrokerConfirmReturn(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	err := c.Confirm(false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	cfms := c.NotifyPublish(make(chan amqp.Confirmation, 1))
+	rets := c.NotifyReturn(make(chan amqp.Return, 1))
+	publishTestBroker(t, c, "", "missing", amqp.Publishing{})
+	cfm := <-cfms
+	if cfm.DeliveryTag != 1 || !cfm.Ack {
+		t.Fatalf("unexpected confirmation: %+v", cfm)
+	}
+	select {
+	case <-rets:
+		t.Fatal("unexpected return")
+	default:
+	}
+	err = c.Publish("", "missing", true, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	ret := <-rets
+	if ret.ReplyCode != amqp.NoRoute {
+		t.Fatalf("unexpected reply code: got %d, want %d", ret.ReplyCode, amqp.NoRoute)
+	}
+	cfm = <-cfms
+	if cfm.DeliveryTag != 2 {
+		t.Fatalf("unexpected delivery tag: got %d, want %d", cfm.DeliveryTag, 2)
+	}
+}
+
+func TestBrokerErrorNotFound(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	closes := c.NotifyClose(make(chan *amqp.Error, 1))
+	_, err := c.QueueDeclarePassive("missing", false, false, false, false, nil)
+	var aerr *amqp.Error
+	if !errors.As(err, &aerr) || aerr.Code != amqp.NotFound {
+		t.Fatalf("unexpected error: got %v, want code %d", err, amqp.NotFound)
+	}
+	if <-closes != aerr {
+		t.Fatal("unexpected close error")
+	}
+	_, err = c.QueueDeclare("test", false, false, false, false, nil)
+	if err != amqp.ErrClosed {
+		t.Fatalf("unexpected error: got %v, want %v", err, amqp.ErrClosed)
+	}
+}
+
+func TestBrokerErrorInequivalent(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", nil)
+	_, err := c.QueueDeclare("test", false, false, false, false, amqp.Table{
+		"x-message-ttl": int64(1000),
+	})
+	var aerr *amqp.Error
+	if !errors.As(err, &aerr) || aerr.Code != amqp.PreconditionFailed {
+		t.Fatalf("unexpected error: got %v, want code %d", err, amqp.PreconditionFailed)
+	}
+}
+
+func TestBrokerErrorUnknownDeliveryTag(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	closes := c.NotifyClose(make(chan *amqp.Error, 1))
+	err := c.Ack(1, false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	aerr := <-closes
+	if aerr == nil || aerr.Code != amqp.PreconditionFailed {
+		t.Fatalf("unexpected error: got %v, want code %d", aerr, amqp.PreconditionFailed)
+	}
+}
+
+func TestBrokerDirectReplyTo(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	dlvs, err := c.Consume(amqputils.RPCReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	newTestBrokerQueue(t, c, "test", nil)
+	publishTestBroker(t, c, "", "test", amqp.Publishing{
+		ReplyTo: amqputils.RPCReplyTo,
+	})
+	req, _ := getTestBroker(t, c, "test")
+	publishTestBroker(t, c, "", req.ReplyTo, amqp.Publishing{
+		Body: []byte("reply"),
+	})
+	dlv := <-dlvs
+	if string(dlv.Body) != "reply" {
+		t.Fatalf("unexpected body: got %q, want %q", dlv.Body, "reply")
+	}
+}
+
+func TestBrokerStream(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", amqp.Table{"x-queue-type": "stream"})
+	for _, body := range []string{"a", "b", "c"} {
+		publishTestBroker(t, c, "", "test", amqp.Publishing{Body: []byte(body)})
+	}
+	err := c.Qos(10, 0, false)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for _, tc := range []struct {
+		offset   interface{}
+		expected string
+	}{
+		{offset: "first", expected: "a"},
+		{offset: "last", expected: "c"},
+		{offset: int64(1), expected: "b"},
+	} {
+		ch, err := c.Consume("test", "", false, false, false, false, amqp.Table{"x-stream-offset": tc.offset})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		dlv := <-ch
+		if string(dlv.Body) != tc.expected {
+			t.Fatalf("offset %v: unexpected body: got %q, want %q", tc.offset, dlv.Body, tc.expected)
+		}
+	}
+	// The messages are not removed from the stream.
+	if n := b.QueueMessages("test"); n != 3 {
+		t.Fatalf("unexpected messages: got %d, want 3", n)
+	}
+}
+
+func TestBrokerStreamErrorPrefetch(t *testing.T) {
+	b := NewBroker(t)
+	c := b.Channel()
+	newTestBrokerQueue(t, c, "test", amqp.Table{"x-queue-type": "stream"})
+	_, err := c.Consume("test", "", false, false, false, false, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
@@ -0,0 +1,848 @@
+package amqptest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/streadway/amqp"
+)
+
+// Channel is a channel of a Broker.
+//
+// It implements amqputils.Channel and amqp.Acknowledger.
+type Channel struct {
+	broker *Broker
+
+	closed      bool
+	confirm     bool
+	publishTag  uint64
+	deliveryTag uint64
+	prefetch    int
+	unacked     map[uint64]*brokerUnacked
+	consumers   map[string]*brokerConsumer
+	replyTo     string
+
+	notifyCloses   []chan *amqp.Error
+	notifyPublishs []chan amqp.Confirmation
+	notifyReturns  []chan amqp.Return
+}
+
+var (
+	_ amqputils.Channel = (*Channel)(nil)
+	_ amqp.Acknowledger = (*Channel)(nil)
+)
+
+// Close implements amqputils.Channel.
+func (c *Channel) Close() error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.shutdown(nil)
+	return nil
+}
+
+// shutdown closes the channel.
+//
+// The unacknowledged messages are requeued, the consumers are canceled, and the exclusive queues are deleted.
+// The error (that can be nil) is sent to the NotifyClose channels, then all notification channels are closed.
+func (c *Channel) shutdown(aerr *amqp.Error) {
+	if c.closed {
+		return
+	}
+	b := c.broker
+	c.closed = true
+	delete(b.channels, c)
+	for _, cs := range c.consumers {
+		c.cancelConsumer(cs)
+	}
+	requeueBrokerUnacked(c.removeUnacked(c.unackedTags(func(uint64) bool {
+		return true
+	})))
+	for _, q := range b.queues {
+		if q.owner == c {
+			b.deleteQueue(q)
+		}
+	}
+	closes, publishs, returns := c.notifyCloses, c.notifyPublishs, c.notifyReturns
+	c.notifyCloses, c.notifyPublishs, c.notifyReturns = nil, nil, nil
+	b.notify(func() {
+		for _, ch := range closes {
+			if aerr != nil {
+				ch <- aerr
+			}
+			close(ch)
+		}
+		for _, ch := range publishs {
+			close(ch)
+		}
+		for _, ch := range returns {
+			close(ch)
+		}
+	})
+}
+
+// fail closes the channel with an error, and returns it.
+func (c *Channel) fail(aerr *amqp.Error) error {
+	c.shutdown(aerr)
+	return aerr
+}
+
+// NotifyClose implements amqputils.Channel.
+func (c *Channel) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.notifyCloses = append(c.notifyCloses, ch)
+	return ch
+}
+
+// NotifyPublish implements amqputils.Channel.
+//
+// Like the real client, the capacity of the channel must be at least as large as the number of outstanding publishings.
+func (c *Channel) NotifyPublish(ch chan amqp.Confirmation) chan amqp.Confirmation {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.notifyPublishs = append(c.notifyPublishs, ch)
+	return ch
+}
+
+// NotifyReturn implements amqputils.Channel.
+func (c *Channel) NotifyReturn(ch chan amqp.Return) chan amqp.Return {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.notifyReturns = append(c.notifyReturns, ch)
+	return ch
+}
+
+// Confirm implements amqputils.Channel.
+func (c *Channel) Confirm(noWait bool) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.confirm = true
+	return nil
+}
+
+// Qos implements amqputils.Channel.
+//
+// The prefetch count applies to the consumers created after the call.
+// The prefetch size and global flag are ignored.
+func (c *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.prefetch = prefetchCount
+	return nil
+}
+
+// Consume implements amqputils.Channel.
+func (c *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, aerr := c.getConsumeQueue(queue, autoAck)
+	if aerr != nil {
+		return nil, c.fail(aerr)
+	}
+	if consumer == "" {
+		consumer = b.newName("ctag-")
+	}
+	aerr = c.checkConsumer(q, consumer, exclusive)
+	if aerr != nil {
+		return nil, c.fail(aerr)
+	}
+	cs := newBrokerConsumer(c, q, consumer, autoAck, exclusive, c.prefetch)
+	if q.isStream() {
+		cs.offset, aerr = c.getStreamConsumeOffset(q, autoAck, args)
+		if aerr != nil {
+			return nil, c.fail(aerr)
+		}
+	}
+	c.consumers[consumer] = cs
+	q.consumers = append(q.consumers, cs)
+	go cs.run()
+	return cs.deliveries, nil
+}
+
+// getConsumeQueue returns the queue consumed by Consume.
+// The direct reply-to pseudo queue is created on the first call.
+func (c *Channel) getConsumeQueue(queue string, autoAck bool) (*brokerQueue, *amqp.Error) {
+	b := c.broker
+	if queue == amqputils.RPCReplyTo {
+		if !autoAck {
+			return nil, newBrokerError(amqp.PreconditionFailed, "reply consumer cannot acknowledge")
+		}
+		if c.replyTo == "" {
+			c.replyTo = b.newName(brokerReplyToPrefix)
+			b.queues[c.replyTo] = &brokerQueue{
+				name:       c.replyTo,
+				autoDelete: true,
+				exclusive:  true,
+				owner:      c,
+			}
+		}
+		queue = c.replyTo
+	}
+	q, aerr := b.getQueue(queue)
+	if aerr != nil {
+		return nil, aerr
+	}
+	aerr = b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return q, nil
+}
+
+// checkConsumer checks that a new consumer can be added to a queue.
+func (c *Channel) checkConsumer(q *brokerQueue, consumer string, exclusive bool) *amqp.Error {
+	if _, ok := c.consumers[consumer]; ok {
+		return newBrokerError(amqp.NotAllowed, "attempt to reuse consumer tag '%s'", consumer)
+	}
+	if exclusive && len(q.consumers) > 0 {
+		return newBrokerError(amqp.AccessRefused, "queue '%s' in vhost '%s' in exclusive use", q.name, brokerVhost)
+	}
+	for _, cs := range q.consumers {
+		if cs.exclusive {
+			return newBrokerError(amqp.AccessRefused, "queue '%s' in vhost '%s' in exclusive use", q.name, brokerVhost)
+		}
+	}
+	return nil
+}
+
+// getStreamConsumeOffset checks the arguments of a stream consumer, and returns its initial offset.
+func (c *Channel) getStreamConsumeOffset(q *brokerQueue, autoAck bool, args amqp.Table) (int, *amqp.Error) {
+	if autoAck {
+		return 0, newBrokerError(amqp.PreconditionFailed, "stream queue '%s' in vhost '%s' can only be consumed with manual acknowledgement", q.name, brokerVhost)
+	}
+	if c.prefetch <= 0 {
+		return 0, newBrokerError(amqp.PreconditionFailed, "consumer prefetch count is not set for stream queue '%s' in vhost '%s'", q.name, brokerVhost)
+	}
+	return q.streamOffset(args[brokerStreamOffset])
+}
+
+// Cancel implements amqputils.Channel.
+//
+// The deliveries channel returned by Consume is closed.
+func (c *Channel) Cancel(consumer string, noWait bool) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	cs, ok := c.consumers[consumer]
+	if !ok {
+		return nil
+	}
+	c.cancelConsumer(cs)
+	return nil
+}
+
+// cancelConsumer cancels a consumer.
+// The messages that were not yet received by the user are requeued.
+// If the queue is auto delete and has no more consumers, it is deleted.
+func (c *Channel) cancelConsumer(cs *brokerConsumer) {
+	b := c.broker
+	delete(c.consumers, cs.tag)
+	cs.queue.removeConsumer(cs)
+	pending := cs.cancel()
+	var ms []*brokerMessage
+	for _, d := range pending {
+		if !cs.autoAck {
+			delete(c.unacked, d.DeliveryTag)
+		}
+		ms = append(ms, d.msg)
+	}
+	cs.queue.requeue(ms)
+	if cs.queue.autoDelete && len(cs.queue.consumers) == 0 && !cs.queue.deleted {
+		b.deleteQueue(cs.queue)
+	}
+}
+
+// Get implements amqputils.Channel.
+func (c *Channel) Get(queue string, autoAck bool) (msg amqp.Delivery, ok bool, err error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+	q, aerr := b.getQueue(queue)
+	if aerr != nil {
+		return amqp.Delivery{}, false, c.fail(aerr)
+	}
+	aerr = b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return amqp.Delivery{}, false, c.fail(aerr)
+	}
+	if q.isStream() {
+		return amqp.Delivery{}, false, c.fail(newBrokerError(amqp.NotImplemented, "basic.get not supported by stream queue '%s' in vhost '%s'", q.name, brokerVhost))
+	}
+	if len(q.msgs) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	m := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	tag := c.newDeliveryTag(q, m, nil, autoAck)
+	dlv := m.delivery(c, tag, "")
+	dlv.MessageCount = uint32(len(q.msgs))
+	return dlv, true, nil
+}
+
+// Publish implements amqputils.Channel.
+func (c *Channel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if msg.ReplyTo == amqputils.RPCReplyTo {
+		if c.replyTo == "" {
+			_ = c.fail(newBrokerError(amqp.PreconditionFailed, "fast reply consumer does not exist"))
+			return nil
+		}
+		msg.ReplyTo = c.replyTo
+	}
+	aerr := c.checkPublishExchange(exchange)
+	if aerr != nil {
+		_ = c.fail(aerr)
+		return nil
+	}
+	m := &brokerMessage{
+		exchange: exchange,
+		key:      key,
+		pbl:      msg,
+	}
+	routed, aerr := b.publish(exchange, m)
+	if aerr != nil {
+		_ = c.fail(aerr)
+		return nil
+	}
+	if !routed && mandatory {
+		c.notifyReturn(m.ret(amqp.NoRoute, "NO_ROUTE"))
+	}
+	if c.confirm {
+		c.notifyConfirm()
+	}
+	return nil
+}
+
+// checkPublishExchange checks that a message can be published to an exchange.
+// The default exchange is always allowed.
+func (c *Channel) checkPublishExchange(exchange string) *amqp.Error {
+	if exchange == "" {
+		return nil
+	}
+	e, aerr := c.broker.getExchange(exchange)
+	if aerr != nil {
+		return aerr
+	}
+	if e.internal {
+		return newBrokerError(amqp.AccessRefused, "cannot publish to internal exchange '%s' in vhost '%s'", exchange, brokerVhost)
+	}
+	return nil
+}
+
+// notifyReturn sends a returned message to the return listeners.
+func (c *Channel) notifyReturn(ret amqp.Return) {
+	returns := c.notifyReturns
+	c.broker.notify(func() {
+		for _, ch := range returns {
+			ch <- ret
+		}
+	})
+}
+
+// notifyConfirm sends the confirmation of the last published message to the publish listeners.
+func (c *Channel) notifyConfirm() {
+	c.publishTag++
+	cfm := amqp.Confirmation{
+		DeliveryTag: c.publishTag,
+		Ack:         true,
+	}
+	publishs := c.notifyPublishs
+	c.broker.notify(func() {
+		for _, ch := range publishs {
+			ch <- cfm
+		}
+	})
+}
+
+// ExchangeDeclare implements amqputils.Channel.
+func (c *Channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if name == "" {
+		return c.fail(newBrokerError(amqp.AccessRefused, "operation not permitted on the default exchange"))
+	}
+	e, ok := b.exchanges[name]
+	if ok {
+		aerr := e.checkEquivalent(kind, durable, autoDelete, internal, args)
+		if aerr != nil {
+			return c.fail(aerr)
+		}
+		return nil
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+	default:
+		return c.fail(newBrokerError(amqp.CommandInvalid, "invalid exchange type '%s'", kind))
+	}
+	if strings.HasPrefix(name, "amq.") {
+		return c.fail(newBrokerError(amqp.AccessRefused, "exchange name '%s' contains reserved prefix 'amq.*'", name))
+	}
+	b.exchanges[name] = &brokerExchange{
+		name:       name,
+		kind:       kind,
+		durable:    durable,
+		autoDelete: autoDelete,
+		internal:   internal,
+		args:       copyBrokerTable(args),
+	}
+	return nil
+}
+
+// ExchangeDeclarePassive implements amqputils.Channel.
+func (c *Channel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if name == "" {
+		return nil
+	}
+	_, aerr := b.getExchange(name)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	return nil
+}
+
+// ExchangeBind implements amqputils.Channel.
+func (c *Channel) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if destination == "" || source == "" {
+		return c.fail(newBrokerError(amqp.AccessRefused, "operation not permitted on the default exchange"))
+	}
+	se, aerr := b.getExchange(source)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	_, aerr = b.getExchange(destination)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	se.addBinding(&brokerBinding{
+		destination: destination,
+		toExchange:  true,
+		key:         key,
+		args:        copyBrokerTable(args),
+	})
+	return nil
+}
+
+// ExchangeDelete implements amqputils.Channel.
+func (c *Channel) ExchangeDelete(name string, ifUnused, noWait bool) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return c.fail(newBrokerError(amqp.AccessRefused, "operation not permitted on exchange '%s' in vhost '%s'", name, brokerVhost))
+	}
+	e, ok := b.exchanges[name]
+	if !ok {
+		return nil
+	}
+	if ifUnused && len(e.bindings) > 0 {
+		return c.fail(newBrokerError(amqp.PreconditionFailed, "exchange '%s' in vhost '%s' in use", name, brokerVhost))
+	}
+	b.deleteExchange(e)
+	return nil
+}
+
+// QueueDeclare implements amqputils.Channel.
+//
+// If the name is empty, a name is generated.
+func (c *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q, ok := b.queues[name]
+	if ok {
+		aerr := b.checkQueueOwner(q, c)
+		if aerr != nil {
+			return amqp.Queue{}, c.fail(aerr)
+		}
+		aerr = q.checkEquivalent(durable, autoDelete, exclusive, args)
+		if aerr != nil {
+			return amqp.Queue{}, c.fail(aerr)
+		}
+		return q.info(), nil
+	}
+	if name == "" {
+		name = b.newName("amq.gen-")
+	} else if strings.HasPrefix(name, "amq.") {
+		return amqp.Queue{}, c.fail(newBrokerError(amqp.AccessRefused, "queue name '%s' contains reserved prefix 'amq.*'", name))
+	}
+	q = &brokerQueue{
+		name:       name,
+		durable:    durable,
+		autoDelete: autoDelete,
+		exclusive:  exclusive,
+		args:       copyBrokerTable(args),
+	}
+	if exclusive {
+		q.owner = c
+	}
+	b.queues[name] = q
+	return q.info(), nil
+}
+
+// QueueDeclarePassive implements amqputils.Channel.
+func (c *Channel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q, aerr := b.getQueue(name)
+	if aerr != nil {
+		return amqp.Queue{}, c.fail(aerr)
+	}
+	aerr = b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return amqp.Queue{}, c.fail(aerr)
+	}
+	return q.info(), nil
+}
+
+// QueueBind implements amqputils.Channel.
+func (c *Channel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if exchange == "" {
+		return c.fail(newBrokerError(amqp.AccessRefused, "operation not permitted on the default exchange"))
+	}
+	e, aerr := b.getExchange(exchange)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	q, aerr := b.getQueue(name)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	aerr = b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return c.fail(aerr)
+	}
+	e.addBinding(&brokerBinding{
+		destination: name,
+		key:         key,
+		args:        copyBrokerTable(args),
+	})
+	return nil
+}
+
+// QueueDelete implements amqputils.Channel.
+func (c *Channel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, nil
+	}
+	aerr := b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return 0, c.fail(aerr)
+	}
+	if ifUnused && len(q.consumers) > 0 {
+		return 0, c.fail(newBrokerError(amqp.PreconditionFailed, "queue '%s' in vhost '%s' in use", name, brokerVhost))
+	}
+	if ifEmpty && len(q.msgs) > 0 {
+		return 0, c.fail(newBrokerError(amqp.PreconditionFailed, "queue '%s' in vhost '%s' not empty", name, brokerVhost))
+	}
+	n := len(q.msgs)
+	b.deleteQueue(q)
+	return n, nil
+}
+
+// QueuePurge implements amqputils.Channel.
+func (c *Channel) QueuePurge(name string, noWait bool) (int, error) {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return 0, amqp.ErrClosed
+	}
+	q, aerr := b.getQueue(name)
+	if aerr != nil {
+		return 0, c.fail(aerr)
+	}
+	aerr = b.checkQueueOwner(q, c)
+	if aerr != nil {
+		return 0, c.fail(aerr)
+	}
+	n := len(q.msgs)
+	q.msgs = nil
+	return n, nil
+}
+
+// Ack implements amqp.Acknowledger.
+func (c *Channel) Ack(tag uint64, multiple bool) error {
+	return c.acknowledge(tag, multiple, func([]*brokerUnacked) {})
+}
+
+// Nack implements amqp.Acknowledger.
+func (c *Channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return c.reject(tag, multiple, requeue)
+}
+
+// Reject implements amqp.Acknowledger.
+func (c *Channel) Reject(tag uint64, requeue bool) error {
+	return c.reject(tag, false, requeue)
+}
+
+func (c *Channel) reject(tag uint64, multiple bool, requeue bool) error {
+	return c.acknowledge(tag, multiple, func(uas []*brokerUnacked) {
+		if requeue {
+			requeueBrokerUnacked(uas)
+			return
+		}
+		for _, ua := range uas {
+			c.broker.deadLetter(ua.queue, ua.msg, "rejected")
+		}
+	})
+}
+
+// acknowledge removes the acknowledged messages, and calls a function with them.
+//
+// An unknown delivery tag closes the channel.
+func (c *Channel) acknowledge(tag uint64, multiple bool, f func([]*brokerUnacked)) error {
+	b := c.broker
+	b.lock()
+	defer b.unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	var tags []uint64
+	if multiple {
+		tags = c.unackedTags(func(t uint64) bool {
+			return tag == 0 || t <= tag
+		})
+	} else if _, ok := c.unacked[tag]; ok {
+		tags = []uint64{tag}
+	}
+	if len(tags) == 0 && !(multiple && tag == 0) {
+		_ = c.fail(newBrokerError(amqp.PreconditionFailed, "unknown delivery tag %d", tag))
+		return nil
+	}
+	f(c.removeUnacked(tags))
+	return nil
+}
+
+func (c *Channel) newDeliveryTag(q *brokerQueue, m *brokerMessage, cs *brokerConsumer, autoAck bool) uint64 {
+	c.deliveryTag++
+	tag := c.deliveryTag
+	if !autoAck {
+		c.unacked[tag] = &brokerUnacked{
+			queue:    q,
+			msg:      m,
+			consumer: cs,
+		}
+		if cs != nil {
+			cs.unacked++
+		}
+	}
+	return tag
+}
+
+func (c *Channel) removeUnacked(tags []uint64) []*brokerUnacked {
+	uas := make([]*brokerUnacked, len(tags))
+	for i, tag := range tags {
+		ua := c.unacked[tag]
+		delete(c.unacked, tag)
+		if ua.consumer != nil {
+			ua.consumer.unacked--
+		}
+		uas[i] = ua
+	}
+	return uas
+}
+
+func (c *Channel) unackedTags(f func(uint64) bool) []uint64 {
+	var tags []uint64
+	for t := range c.unacked {
+		if f(t) {
+			tags = append(tags, t)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i] < tags[j]
+	})
+	return tags
+}
+
+type brokerUnacked struct {
+	queue    *brokerQueue
+	msg      *brokerMessage
+	consumer *brokerConsumer
+}
+
+// requeueBrokerUnacked requeues messages, and keeps their original order in each queue.
+func requeueBrokerUnacked(uas []*brokerUnacked) {
+	var qs []*brokerQueue
+	msgs := make(map[*brokerQueue][]*brokerMessage)
+	for _, ua := range uas {
+		if _, ok := msgs[ua.queue]; !ok {
+			qs = append(qs, ua.queue)
+		}
+		msgs[ua.queue] = append(msgs[ua.queue], ua.msg)
+	}
+	for _, q := range qs {
+		q.requeue(msgs[q])
+	}
+}
+
+type brokerConsumer struct {
+	channel   *Channel
+	queue     *brokerQueue
+	tag       string
+	autoAck   bool
+	exclusive bool
+	prefetch  int
+	unacked   int
+	// offset is the position of the next message to deliver in a stream queue.
+	offset int
+
+	cond       *sync.Cond
+	pending    []*brokerDelivery
+	canceled   bool
+	done       chan struct{}
+	deliveries chan amqp.Delivery
+}
+
+type brokerDelivery struct {
+	amqp.Delivery
+	msg *brokerMessage
+}
+
+func newBrokerConsumer(c *Channel, q *brokerQueue, tag string, autoAck, exclusive bool, prefetch int) *brokerConsumer {
+	return &brokerConsumer{
+		channel:    c,
+		queue:      q,
+		tag:        tag,
+		autoAck:    autoAck,
+		exclusive:  exclusive,
+		prefetch:   prefetch,
+		cond:       sync.NewCond(&c.broker.mu),
+		done:       make(chan struct{}),
+		deliveries: make(chan amqp.Delivery),
+	}
+}
+
+// ready returns true if the consumer can receive a message, according to the prefetch count.
+func (cs *brokerConsumer) ready() bool {
+	return cs.autoAck || cs.prefetch <= 0 || cs.unacked < cs.prefetch
+}
+
+func (cs *brokerConsumer) deliver(q *brokerQueue, m *brokerMessage) {
+	tag := cs.channel.newDeliveryTag(q, m, cs, cs.autoAck)
+	cs.pending = append(cs.pending, &brokerDelivery{
+		Delivery: m.delivery(cs.channel, tag, cs.tag),
+		msg:      m,
+	})
+	cs.cond.Signal()
+}
+
+// cancel stops the consumer, and returns the messages that were not received by the user.
+func (cs *brokerConsumer) cancel() []*brokerDelivery {
+	if cs.canceled {
+		return nil
+	}
+	cs.canceled = true
+	close(cs.done)
+	cs.cond.Signal()
+	pending := cs.pending
+	cs.pending = nil
+	return pending
+}
+
+// run sends the messages to the deliveries channel, until the consumer is canceled.
+func (cs *brokerConsumer) run() {
+	defer close(cs.deliveries)
+	mu := cs.cond.L
+	for {
+		mu.Lock()
+		for len(cs.pending) == 0 && !cs.canceled {
+			cs.cond.Wait()
+		}
+		if cs.canceled {
+			mu.Unlock()
+			return
+		}
+		d := cs.pending[0]
+		cs.pending = cs.pending[1:]
+		mu.Unlock()
+		select {
+		case cs.deliveries <- d.Delivery:
+		case <-cs.done:
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package amqputils
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
@@ -13,9 +14,18 @@ import (
 )
 
 // BatchConsumer consume messages in batch.
+//
+// The Processor acknowledges the messages.
+// The acknowledgements (ack, nack with or without requeue, reject) are counted in Metrics.
 type BatchConsumer struct {
 	Accumulator func(context.Context, <-chan amqp.Delivery) ([]amqp.Delivery, error)
 	Processor   BatchConsumerProcessor
+	// Queue is the name of the consumed queue.
+	// It is optional, and only used as metric label.
+	Queue string
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
 }
 
 // Consume consumes messages.
@@ -43,9 +53,99 @@ func (c *BatchConsumer) process(dlvs []amqp.Delivery) (err error) {
 	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
 	opentracing_ext.SpanKindConsumer.Set(span)
 	setTraceSpanTag(span, "deliveries.count", len(dlvs))
+	labels := MetricLabels{
+		Queue: c.Queue,
+	}
+	m := getMetrics(c.Metrics)
+	m.Counter(MetricDeliveriesReceived, labels, int64(len(dlvs)))
+	m.Histogram(MetricBatchSize, labels, float64(len(dlvs)))
+	start := timeutils.Now()
+	defer func() {
+		observeDuration(m, MetricProcessingDuration, labels, start, timeutils.Now())
+	}()
+	wrapBatchAcknowledgers(dlvs, m, labels)
 	return c.Processor(ctx, dlvs)
 }
 
+// wrapBatchAcknowledgers wraps the Acknowledger of the messages, in order to count the acknowledgements.
+func wrapBatchAcknowledgers(dlvs []amqp.Delivery, m Metrics, labels MetricLabels) {
+	as := make(map[amqp.Acknowledger]*batchAcknowledger)
+	for i, dlv := range dlvs {
+		if dlv.Acknowledger == nil {
+			continue
+		}
+		a, ok := as[dlv.Acknowledger]
+		if !ok {
+			a = &batchAcknowledger{
+				Acknowledger: dlv.Acknowledger,
+				metrics:      m,
+				labels:       labels,
+				pending:      make(map[uint64]struct{}),
+			}
+			as[dlv.Acknowledger] = a
+		}
+		a.pending[dlv.DeliveryTag] = struct{}{}
+		dlvs[i].Acknowledger = a
+	}
+}
+
+// batchAcknowledger is an amqp.Acknowledger that counts the acknowledgements of the messages of a batch.
+type batchAcknowledger struct {
+	amqp.Acknowledger
+	metrics Metrics
+	labels  MetricLabels
+
+	mu      sync.Mutex
+	pending map[uint64]struct{}
+}
+
+func (a *batchAcknowledger) Ack(tag uint64, multiple bool) error {
+	err := a.Acknowledger.Ack(tag, multiple)
+	if err == nil {
+		a.count(Ack, tag, multiple)
+	}
+	return err
+}
+
+func (a *batchAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	err := a.Acknowledger.Nack(tag, multiple, requeue)
+	if err == nil {
+		a.count(getNackAcknowledger(requeue), tag, multiple)
+	}
+	return err
+}
+
+func (a *batchAcknowledger) Reject(tag uint64, requeue bool) error {
+	err := a.Acknowledger.Reject(tag, requeue)
+	if err == nil {
+		a.count(getNackAcknowledger(requeue), tag, false)
+	}
+	return err
+}
+
+// count counts the pending messages that are acknowledged by a tag.
+func (a *batchAcknowledger) count(ack Acknowledger, tag uint64, multiple bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var n int64
+	for t := range a.pending {
+		if t == tag || (multiple && t < tag) {
+			delete(a.pending, t)
+			n++
+		}
+	}
+	if n > 0 {
+		countAcknowledger(a.metrics, ack, a.labels, n)
+	}
+}
+
+func getNackAcknowledger(requeue bool) Acknowledger {
+	if requeue {
+		return NackRequeue
+	}
+	return NackDiscard
+}
+
 // BatchConsumerProcessor represents a processor for BatchConsumer.
 type BatchConsumerProcessor func(context.Context, []amqp.Delivery) error
 
@@ -65,8 +165,9 @@ func RunBatchConsumers(ctx context.Context, cg ChannelGetter, tp Topology, queue
 	c := &BatchConsumer{
 		Accumulator: a.Accumulate,
 		Processor:   pr,
+		Queue:       queue,
 	}
-	start := func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+	start := func(ctx context.Context, chn Channel) (<-chan amqp.Delivery, error) {
 		err := tp.Init(ctx, chn)
 		if err != nil {
 			return nil, errors.Wrap(err, "topology")
@@ -2,21 +2,55 @@ package amqputils
 
 import (
 	"context"
+	"time"
 
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/siddhant2408/golang-libraries/ctxsync"
 	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
 	"github.com/siddhant2408/golang-libraries/tracingutils"
 	"github.com/streadway/amqp"
 )
 
+// Channel represents an AMQP channel.
+//
+// It is implemented by *amqp.Channel.
+// It allows to use a fake implementation in tests, see amqptest.Broker.
+type Channel interface {
+	Close() error
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	Confirm(noWait bool) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Get(queue string, autoAck bool) (msg amqp.Delivery, ok bool, err error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error
+	ExchangeDelete(name string, ifUnused, noWait bool) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+	QueuePurge(name string, noWait bool) (int, error)
+}
+
+var _ Channel = (*amqp.Channel)(nil)
+
 // ChannelGetter returns a new Channel.
-type ChannelGetter func(context.Context) (*amqp.Channel, error)
+type ChannelGetter func(context.Context) (Channel, error)
 
 // NewChannelGetterConnection returns a ChannelGetter for a Connection.
 func NewChannelGetterConnection(conn *amqp.Connection) ChannelGetter {
-	return func(ctx context.Context) (chn *amqp.Channel, err error) {
-		return connectionChannel(ctx, conn)
+	return func(ctx context.Context) (Channel, error) {
+		chn, err := connectionChannel(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		return chn, nil
 	}
 }
 
@@ -32,51 +66,281 @@ func connectionChannel(ctx context.Context, conn *amqp.Connection) (chn *amqp.Ch
 }
 
 // ChannelPool is a pool of channel.
+//
+// The zero value is unlimited.
 type ChannelPool struct {
 	Channel ChannelGetter
+	// MaxIdle is the maximum number of idle channels in the pool.
+	// The channels that are put in a full pool are closed.
+	// If it is less than or equal to 0, there is no limit.
+	MaxIdle int
+	// MaxOpen is the maximum number of open channels (idle or checked out).
+	// If the limit is reached, Get blocks until a channel is put or discarded.
+	// If it is less than or equal to 0, there is no limit.
+	MaxOpen int
+	// IdleTimeout is the maximum duration a channel can stay idle in the pool, before it is closed.
+	// If it is less than or equal to 0, the idle channels are never closed.
+	IdleTimeout time.Duration
+	// CloseTimeout is the maximum duration Close waits for the checked out channels.
+	// The default value is 30 seconds.
+	CloseTimeout time.Duration
+	// Name identifies the pool in the metrics.
+	// It is optional.
+	Name string
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
+
+	mu      ctxsync.Mutex
+	entries map[Channel]*channelPoolEntry
+	idle    []*channelPoolEntry
+	opening int
+	closing bool
+	// released is closed and replaced when a channel is put or discarded, in order to wake up the waiters.
+	released chan struct{}
+	stats    ChannelPoolStats
+}
+
+type channelPoolEntry struct {
+	chn     Channel
+	closeCh chan *amqp.Error
+	since   time.Time
+	// closed is set when the close notification was received by Close.
+	closed bool
+}
 
-	mu   ctxsync.Mutex
-	chns []*amqp.Channel
+// isClosed returns true if the channel was closed, by the broker or the connection.
+func (e *channelPoolEntry) isClosed() bool {
+	if e.closed {
+		return true
+	}
+	select {
+	case <-e.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// ChannelPoolStats contains the statistics of a ChannelPool.
+type ChannelPoolStats struct {
+	// Hits is the number of times an idle channel was reused.
+	Hits int64
+	// Misses is the number of times a new channel was opened.
+	Misses int64
+	// Evictions is the number of idle channels that were closed, because they were closed by the broker, or idle for too long.
+	Evictions int64
+	// Open is the number of open channels (idle or checked out).
+	Open int
+	// Idle is the number of idle channels.
+	Idle int
+	// Waiting is the number of Get calls waiting for a channel.
+	Waiting int
 }
 
 // Get returns a channel from the pool.
 // It opens a new one if the pool is empty.
+//
+// The idle channels that were closed since they were put in the pool are discarded.
 // Warning: it is not guaranteed that the returned channel is open.
-func (cp *ChannelPool) Get(ctx context.Context) (chn *amqp.Channel, err error) {
+//
+// If MaxOpen is reached, it blocks until a channel is available, or the context is canceled.
+// The returned channel must be given back with Put or Discard.
+func (cp *ChannelPool) Get(ctx context.Context) (chn Channel, err error) {
 	_, spanFinish := startTraceChildSpan(&ctx, "channel_pool.get", &err)
 	defer spanFinish()
 	err = tracingutils.TraceSyncLockerCtx(ctx, &cp.mu)
 	if err != nil {
 		return nil, errors.Wrap(err, "lock")
 	}
-	defer cp.mu.Unlock()
-	l := len(cp.chns)
-	if l == 0 {
-		chn, err = cp.Channel(ctx)
+	for {
+		if cp.closing {
+			cp.mu.Unlock()
+			return nil, errors.New("pool closing")
+		}
+		cp.evict()
+		l := len(cp.idle)
+		if l > 0 {
+			e := cp.idle[l-1]
+			cp.idle[l-1] = nil
+			cp.idle = cp.idle[:l-1]
+			cp.stats.Hits++
+			cp.reportMetrics()
+			cp.mu.Unlock()
+			return e.chn, nil
+		}
+		if cp.MaxOpen <= 0 || cp.open() < cp.MaxOpen {
+			cp.opening++
+			cp.stats.Misses++
+			cp.reportMetrics()
+			cp.mu.Unlock()
+			return cp.openChannel(ctx)
+		}
+		err = cp.wait(ctx)
 		if err != nil {
-			return nil, errors.Wrap(err, "open channel")
+			return nil, errors.Wrap(err, "wait")
 		}
-		return chn, nil
 	}
-	i := l - 1
-	chn = cp.chns[i]
-	cp.chns[i] = nil
-	cp.chns = cp.chns[:i]
+}
+
+// wait waits until a channel is released.
+// It must be called with the lock held, and returns with the lock held, unless an error is returned.
+func (cp *ChannelPool) wait(ctx context.Context) (err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "channel_pool.wait", &err)
+	defer spanFinish()
+	released := cp.getReleased()
+	cp.stats.Waiting++
+	cp.reportMetrics()
+	cp.mu.Unlock()
+	select {
+	case <-released:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "")
+	}
+	cp.mu.Lock()
+	cp.stats.Waiting--
+	cp.reportMetrics()
+	if err != nil {
+		cp.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (cp *ChannelPool) openChannel(ctx context.Context) (Channel, error) {
+	chn, err := cp.Channel(ctx)
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	defer cp.reportMetrics()
+	cp.opening--
+	if err != nil {
+		cp.release()
+		return nil, errors.Wrap(err, "open channel")
+	}
+	cp.register(chn)
 	return chn, nil
 }
 
+func (cp *ChannelPool) register(chn Channel) *channelPoolEntry {
+	if cp.entries == nil {
+		cp.entries = make(map[Channel]*channelPoolEntry)
+	}
+	e := &channelPoolEntry{
+		chn:     chn,
+		closeCh: chn.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+	cp.entries[chn] = e
+	return e
+}
+
 // Put puts a channel to the pool.
-func (cp *ChannelPool) Put(chn *amqp.Channel) {
+//
+// The channel is closed if it was closed by the broker, if the pool is full (MaxIdle), or if the pool is closing.
+func (cp *ChannelPool) Put(chn Channel) {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
-	cp.chns = append(cp.chns, chn)
+	defer cp.reportMetrics()
+	defer cp.release()
+	e, ok := cp.entries[chn]
+	if !ok {
+		// The channel was not opened by the pool.
+		e = cp.register(chn)
+	}
+	if e.isClosed() || cp.closing || (cp.MaxIdle > 0 && len(cp.idle) >= cp.MaxIdle) {
+		cp.remove(e)
+		return
+	}
+	e.since = timeutils.Now()
+	cp.idle = append(cp.idle, e)
+}
+
+// Discard closes a channel that was returned by Get, instead of putting it back in the pool.
+func (cp *ChannelPool) Discard(chn Channel) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	defer cp.reportMetrics()
+	defer cp.release()
+	e, ok := cp.entries[chn]
+	if !ok {
+		_ = chn.Close()
+		return
+	}
+	cp.remove(e)
+}
+
+// remove closes a channel and removes it from the pool.
+func (cp *ChannelPool) remove(e *channelPoolEntry) {
+	delete(cp.entries, e.chn)
+	_ = e.chn.Close()
+}
+
+// evict closes the idle channels that were closed by the broker or that are idle for too long.
+func (cp *ChannelPool) evict() {
+	var deadline time.Time
+	if cp.IdleTimeout > 0 {
+		deadline = timeutils.Now().Add(-cp.IdleTimeout)
+	}
+	idle := cp.idle[:0]
+	for _, e := range cp.idle {
+		if e.isClosed() || (!deadline.IsZero() && e.since.Before(deadline)) {
+			cp.remove(e)
+			cp.stats.Evictions++
+			continue
+		}
+		idle = append(idle, e)
+	}
+	for i := len(idle); i < len(cp.idle); i++ {
+		cp.idle[i] = nil
+	}
+	cp.idle = idle
+}
+
+// release wakes up the waiters.
+func (cp *ChannelPool) release() {
+	if cp.released != nil {
+		close(cp.released)
+		cp.released = nil
+	}
+}
+
+func (cp *ChannelPool) getReleased() <-chan struct{} {
+	if cp.released == nil {
+		cp.released = make(chan struct{})
+	}
+	return cp.released
+}
+
+func (cp *ChannelPool) open() int {
+	return len(cp.entries) + cp.opening
+}
+
+// reportMetrics reports the gauges of the pool.
+// It must be called with the lock held.
+func (cp *ChannelPool) reportMetrics() {
+	m := getMetrics(cp.Metrics)
+	labels := MetricLabels{
+		Name: cp.Name,
+	}
+	m.Gauge(MetricChannelPoolOpen, labels, float64(cp.open()))
+	m.Gauge(MetricChannelPoolIdle, labels, float64(len(cp.idle)))
+	m.Gauge(MetricChannelPoolWaiting, labels, float64(cp.stats.Waiting))
+}
+
+// Stats returns the statistics of the pool.
+func (cp *ChannelPool) Stats() ChannelPoolStats {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	st := cp.stats
+	st.Open = cp.open()
+	st.Idle = len(cp.idle)
+	return st
 }
 
 // Run runs a given function with a channel from the pool.
 //
 // When the given function returns, the channel is recycled to the pool, unless a *amqp.Error is returned.
 // For other types of error, the channel is recycled to the pool.
-func (cp *ChannelPool) Run(ctx context.Context, f func(context.Context, *amqp.Channel) error) (err error) {
+func (cp *ChannelPool) Run(ctx context.Context, f func(context.Context, Channel) error) (err error) {
 	_, spanFinish := startTraceChildSpan(&ctx, "channel_pool.run", &err)
 	defer spanFinish()
 	ch, err := cp.Get(ctx)
@@ -87,7 +351,7 @@ func (cp *ChannelPool) Run(ctx context.Context, f func(context.Context, *amqp.Ch
 	if err != nil {
 		errc := errors.UnwrapAll(err)
 		if _, ok := errc.(*amqp.Error); ok {
-			_ = ch.Close()
+			cp.Discard(ch)
 		} else {
 			cp.Put(ch)
 		}
@@ -99,17 +363,101 @@ func (cp *ChannelPool) Run(ctx context.Context, f func(context.Context, *amqp.Ch
 
 // Close closes the pool and all channels.
 //
+// It waits until the checked out channels are put back, discarded or closed by the broker, at most CloseTimeout.
+// If the timeout expires, the idle channels are closed anyway, and an error is returned.
+// The Get calls return an error during the closing.
+//
 // It is OK to reuse the ChannelPool after this call.
 func (cp *ChannelPool) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cp.getCloseTimeout())
+	defer cancel()
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
-	var firstErr error
-	for _, chn := range cp.chns {
-		err := chn.Close()
+	cp.closing = true
+	defer func() {
+		cp.closing = false
+	}()
+	firstErr := cp.waitCheckedOut(ctx)
+	if firstErr != nil {
+		firstErr = errors.Wrap(firstErr, "wait checked out channels")
+	}
+	for _, e := range cp.idle {
+		delete(cp.entries, e.chn)
+		err := e.chn.Close()
 		if err != nil && firstErr == nil {
 			firstErr = errors.Wrap(err, "close channel")
 		}
 	}
-	cp.chns = nil
+	cp.idle = nil
+	cp.reportMetrics()
 	return firstErr
 }
+
+// waitCheckedOut waits until the checked out channels are put back, discarded or closed.
+// It must be called with the lock held, and returns with the lock held.
+func (cp *ChannelPool) waitCheckedOut(ctx context.Context) error {
+	for {
+		es := cp.checkedOut()
+		if len(es) == 0 && cp.opening == 0 {
+			return nil
+		}
+		released := cp.getReleased()
+		done := make(chan struct{})
+		for _, e := range es {
+			go cp.watchClose(e, done)
+		}
+		cp.mu.Unlock()
+		var err error
+		select {
+		case <-released:
+		case <-ctx.Done():
+			err = errors.Wrap(ctx.Err(), "")
+		}
+		close(done)
+		cp.mu.Lock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkedOut returns the checked out channels.
+// The checked out channels that were closed are removed from the pool.
+// It must be called with the lock held.
+func (cp *ChannelPool) checkedOut() []*channelPoolEntry {
+	idle := make(map[*channelPoolEntry]bool, len(cp.idle))
+	for _, e := range cp.idle {
+		idle[e] = true
+	}
+	var es []*channelPoolEntry
+	for _, e := range cp.entries {
+		if idle[e] {
+			continue
+		}
+		if e.isClosed() {
+			cp.remove(e)
+			continue
+		}
+		es = append(es, e)
+	}
+	return es
+}
+
+// watchClose wakes up Close when a checked out channel is closed, until done is closed.
+func (cp *ChannelPool) watchClose(e *channelPoolEntry, done <-chan struct{}) {
+	select {
+	case <-e.closeCh:
+		cp.mu.Lock()
+		defer cp.mu.Unlock()
+		e.closed = true
+		cp.release()
+	case <-done:
+	}
+}
+
+func (cp *ChannelPool) getCloseTimeout() time.Duration {
+	if cp.CloseTimeout > 0 {
+		return cp.CloseTimeout
+	}
+	return 30 * time.Second
+}
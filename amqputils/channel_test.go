@@ -3,11 +3,13 @@ package amqputils_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/siddhant2408/golang-libraries/amqptest"
 	"github.com/siddhant2408/golang-libraries/amqputils"
 	"github.com/siddhant2408/golang-libraries/errors"
 	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
 	"github.com/streadway/amqp"
 )
 
@@ -44,7 +46,7 @@ func TestChannelPoolErrorLock(t *testing.T) {
 func TestChannelPoolErrorOpen(t *testing.T) {
 	ctx := context.Background()
 	cp := &amqputils.ChannelPool{
-		Channel: func(ctx context.Context) (*amqp.Channel, error) {
+		Channel: func(ctx context.Context) (amqputils.Channel, error) {
 			return nil, errors.New("error")
 		},
 	}
@@ -60,7 +62,7 @@ func TestChannelPoolRun(t *testing.T) {
 	cp := &amqputils.ChannelPool{
 		Channel: amqputils.NewChannelGetterConnection(conn),
 	}
-	err := cp.Run(ctx, func(ctx context.Context, ch *amqp.Channel) error {
+	err := cp.Run(ctx, func(ctx context.Context, ch amqputils.Channel) error {
 		return nil
 	})
 	if err != nil {
@@ -75,11 +77,11 @@ func TestChannelPoolRun(t *testing.T) {
 func TestChannelPoolRunErrorGetChannel(t *testing.T) {
 	ctx := context.Background()
 	cp := &amqputils.ChannelPool{
-		Channel: func(ctx context.Context) (*amqp.Channel, error) {
+		Channel: func(ctx context.Context) (amqputils.Channel, error) {
 			return nil, errors.New("error")
 		},
 	}
-	err := cp.Run(ctx, func(ctx context.Context, ch *amqp.Channel) error {
+	err := cp.Run(ctx, func(ctx context.Context, ch amqputils.Channel) error {
 		t.Fatal("should not be called")
 		return nil
 	})
@@ -98,7 +100,7 @@ func TestChannelPoolRunErrorRecycle(t *testing.T) {
 	cp := &amqputils.ChannelPool{
 		Channel: amqputils.NewChannelGetterConnection(conn),
 	}
-	err := cp.Run(ctx, func(ctx context.Context, ch *amqp.Channel) error {
+	err := cp.Run(ctx, func(ctx context.Context, ch amqputils.Channel) error {
 		return errors.New("error")
 	})
 	if err == nil {
@@ -116,7 +118,7 @@ func TestChannelPoolRunErrorDiscard(t *testing.T) {
 	cp := &amqputils.ChannelPool{
 		Channel: amqputils.NewChannelGetterConnection(conn),
 	}
-	err := cp.Run(ctx, func(ctx context.Context, ch *amqp.Channel) error {
+	err := cp.Run(ctx, func(ctx context.Context, ch amqputils.Channel) error {
 		return amqp.ErrClosed
 	})
 	if err == nil {
@@ -145,3 +147,203 @@ func TestChannelPoolErrorClose(t *testing.T) {
 		t.Fatal("no error")
 	}
 }
+
+func TestChannelPoolMaxOpen(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+		MaxOpen: 1,
+	}
+	defer cp.Close() //nolint:errcheck
+	chn, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = cp.Get(ctxTimeout)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cp.Put(chn)
+	}()
+	chn2, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if chn2 != chn {
+		t.Fatal("channel not reused")
+	}
+	cp.Put(chn2)
+	st := cp.Stats()
+	testutils.Compare(t, "unexpected stats", st, amqputils.ChannelPoolStats{
+		Hits:   1,
+		Misses: 1,
+		Open:   1,
+		Idle:   1,
+	})
+}
+
+func TestChannelPoolMaxIdle(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+		MaxIdle: 1,
+	}
+	defer cp.Close() //nolint:errcheck
+	var chns []amqputils.Channel
+	for i := 0; i < 2; i++ {
+		chn, err := cp.Get(ctx)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		chns = append(chns, chn)
+	}
+	for _, chn := range chns {
+		cp.Put(chn)
+	}
+	st := cp.Stats()
+	if st.Open != 1 || st.Idle != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestChannelPoolEvictClosed(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+	}
+	defer cp.Close() //nolint:errcheck
+	chn, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	cp.Put(chn)
+	// The broker closes the channel.
+	_, err = chn.QueueDeclarePassive("unknown", false, false, false, false, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	chn2, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if chn2 == chn {
+		t.Fatal("closed channel reused")
+	}
+	st := cp.Stats()
+	if st.Evictions != 1 || st.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	cp.Put(chn2)
+}
+
+func TestChannelPoolEvictIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel:     b.ChannelGetter(),
+		IdleTimeout: 1 * time.Minute,
+	}
+	defer cp.Close() //nolint:errcheck
+	chn, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	cp.Put(chn)
+	timeutils.SetFixed(now.Add(2 * time.Minute))
+	chn, err = cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	cp.Put(chn)
+	st := cp.Stats()
+	if st.Evictions != 1 || st.Hits != 0 || st.Open != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestChannelPoolCloseWait(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+	}
+	chn, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	closed := make(chan error)
+	go func() {
+		closed <- cp.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closed with a checked out channel")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cp.Put(chn)
+	err = <-closed
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if st := cp.Stats(); st.Open != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestChannelPoolCloseClosedByBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+	}
+	chn, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	closed := make(chan error)
+	go func() {
+		closed <- cp.Close()
+	}()
+	_, err = chn.QueueDeclarePassive("missing", false, false, false, false, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	err = <-closed
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if st := cp.Stats(); st.Open != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	cp.Put(chn)
+	if st := cp.Stats(); st.Open != 0 || st.Idle != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestChannelPoolCloseErrorTimeout(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cp := &amqputils.ChannelPool{
+		Channel:      b.ChannelGetter(),
+		CloseTimeout: 10 * time.Millisecond,
+	}
+	_, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = cp.Close()
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
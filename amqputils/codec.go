@@ -0,0 +1,347 @@
+package amqputils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+)
+
+// Content types and encodings supported by default.
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeOctetStream = "application/octet-stream"
+	ContentEncodingGzip    = "gzip"
+)
+
+// SchemaVersionHeader is the header containing the schema version (int64) of the message body.
+const SchemaVersionHeader = "schema-version"
+
+// Codec marshals and unmarshals values for a content type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Encoding encodes and decodes bytes for a content encoding.
+type Encoding interface {
+	Encode([]byte) ([]byte, error)
+	Decode([]byte) ([]byte, error)
+}
+
+// JSONCodec is a Codec for JSON.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "JSON marshal")
+	}
+	return b, nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return errors.Wrap(err, "JSON unmarshal")
+	}
+	return nil
+}
+
+// RawCodec is a Codec for raw bytes.
+//
+// It marshals []byte and string values, and unmarshals to *[]byte and *string values.
+var RawCodec Codec = rawCodec{}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errors.Newf("raw marshal: unsupported type %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = copyBytes(data)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return errors.Newf("raw unmarshal: unsupported type %T", v)
+}
+
+// GzipEncoding is an Encoding for gzip.
+var GzipEncoding Encoding = gzipEncoding{}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Encode(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip write")
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip close")
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipEncoding) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip reader")
+	}
+	defer r.Close() //nolint:errcheck
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip read")
+	}
+	return b, nil
+}
+
+// Codecs is a registry of Codec and Encoding, keyed by content type and content encoding.
+//
+// It allows to encode/decode the body of a message according to its ContentType and ContentEncoding fields.
+type Codecs struct {
+	ContentTypes     map[string]Codec
+	ContentEncodings map[string]Encoding
+	// DefaultContentType is used if the message doesn't define a content type.
+	DefaultContentType string
+}
+
+// NewCodecs returns a new Codecs with the default Codec and Encoding registered.
+//
+// The default content type is JSON.
+func NewCodecs() *Codecs {
+	return &Codecs{
+		ContentTypes: map[string]Codec{
+			ContentTypeJSON:        JSONCodec,
+			ContentTypeOctetStream: RawCodec,
+		},
+		ContentEncodings: map[string]Encoding{
+			ContentEncodingGzip: GzipEncoding,
+		},
+		DefaultContentType: ContentTypeJSON,
+	}
+}
+
+// Encode encodes a value to the body of a Publishing.
+//
+// It uses the ContentType and ContentEncoding fields of the Publishing.
+// If the ContentType is empty, it is set to DefaultContentType.
+func (cs *Codecs) Encode(pbl *amqp.Publishing, v interface{}) error {
+	if pbl.ContentType == "" {
+		pbl.ContentType = cs.DefaultContentType
+	}
+	c, err := cs.getCodec(pbl.ContentType)
+	if err != nil {
+		return err
+	}
+	b, err := c.Marshal(v)
+	if err != nil {
+		err = wrapErrorValue(err, "content_type", pbl.ContentType)
+		return errors.Wrap(err, "marshal")
+	}
+	if pbl.ContentEncoding != "" {
+		e, err := cs.getEncoding(pbl.ContentEncoding)
+		if err != nil {
+			return err
+		}
+		b, err = e.Encode(b)
+		if err != nil {
+			err = wrapErrorValue(err, "content_encoding", pbl.ContentEncoding)
+			return errors.Wrap(err, "encode")
+		}
+	}
+	pbl.Body = b
+	return nil
+}
+
+// Decode decodes the body of a Delivery to a value.
+//
+// It uses the ContentType and ContentEncoding fields of the Delivery.
+// If the ContentType is empty, DefaultContentType is used.
+//
+// The returned error is not temporary, so the Consumer discards the message.
+func (cs *Codecs) Decode(dlv amqp.Delivery, v interface{}) error {
+	err := cs.decode(dlv, v)
+	if err != nil {
+		err = errors.WithTemporary(err, false)
+		err = wrapErrorValueBody(err, dlv.Body)
+		return err
+	}
+	return nil
+}
+
+func (cs *Codecs) decode(dlv amqp.Delivery, v interface{}) error {
+	b := dlv.Body
+	if dlv.ContentEncoding != "" {
+		e, err := cs.getEncoding(dlv.ContentEncoding)
+		if err != nil {
+			return err
+		}
+		b, err = e.Decode(b)
+		if err != nil {
+			err = wrapErrorValue(err, "content_encoding", dlv.ContentEncoding)
+			return errors.Wrap(err, "decode")
+		}
+	}
+	ct := dlv.ContentType
+	if ct == "" {
+		ct = cs.DefaultContentType
+	}
+	c, err := cs.getCodec(ct)
+	if err != nil {
+		return err
+	}
+	err = c.Unmarshal(b, v)
+	if err != nil {
+		err = wrapErrorValue(err, "content_type", ct)
+		return errors.Wrap(err, "unmarshal")
+	}
+	return nil
+}
+
+func (cs *Codecs) getCodec(ct string) (Codec, error) {
+	// Ignore the parameters, e.g. "application/json; charset=utf-8".
+	mt := strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
+	c, ok := cs.ContentTypes[mt]
+	if !ok {
+		return nil, errors.Newf("unsupported content type: %q", ct)
+	}
+	return c, nil
+}
+
+func (cs *Codecs) getEncoding(ce string) (Encoding, error) {
+	e, ok := cs.ContentEncodings[ce]
+	if !ok {
+		return nil, errors.Newf("unsupported content encoding: %q", ce)
+	}
+	return e, nil
+}
+
+// SetSchemaVersion sets the "schema-version" header.
+func SetSchemaVersion(pbl *amqp.Publishing, version int64) {
+	if pbl.Headers == nil {
+		pbl.Headers = make(amqp.Table)
+	}
+	pbl.Headers[SchemaVersionHeader] = version
+}
+
+// GetSchemaVersion returns the value of the "schema-version" header.
+//
+// All the integer types are accepted, because the publishers that are not written in Go can send a smaller type (e.g. int32 or int16).
+// If the header is not defined or doesn't have an integer type, the "ok" boolean value is false.
+func GetSchemaVersion(dlv amqp.Delivery) (version int64, ok bool) {
+	return getHeaderInt64(dlv.Headers[SchemaVersionHeader])
+}
+
+// getHeaderInt64 converts an integer header value to int64.
+func getHeaderInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// CodecProducer encodes values and produces them.
+type CodecProducer struct {
+	Producer        Producer
+	Codecs          *Codecs
+	ContentType     string
+	ContentEncoding string
+	// SchemaVersion is set to the "schema-version" header if it is not 0.
+	SchemaVersion int64
+}
+
+// Produce encodes a value and produces it.
+//
+// The Publishing is used as a template, its Body field is overwritten.
+// Its ContentType and ContentEncoding fields are defined with the CodecProducer values if they are empty.
+func (p *CodecProducer) Produce(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing, v interface{}) (err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "codec_producer", &err)
+	defer spanFinish()
+	if msg.ContentType == "" {
+		msg.ContentType = p.ContentType
+	}
+	if msg.ContentEncoding == "" {
+		msg.ContentEncoding = p.ContentEncoding
+	}
+	if p.SchemaVersion != 0 {
+		msg.Headers = copyTable(msg.Headers)
+		SetSchemaVersion(&msg, p.SchemaVersion)
+	}
+	err = p.Codecs.Encode(&msg, v)
+	if err != nil {
+		err = wrapErrorValue(err, "value_type", fmt.Sprintf("%T", v))
+		return errors.Wrap(err, "encode")
+	}
+	return p.Producer(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// CodecProcessor decodes the body of a delivery and calls a processor with the decoded value.
+type CodecProcessor struct {
+	Codecs *Codecs
+	// New returns a new pointer to a value in which the body is decoded.
+	// The delivery is provided in order to allow to select the type by schema version.
+	New func(amqp.Delivery) (interface{}, error)
+	// Processor processes the delivery with the decoded value.
+	Processor func(context.Context, amqp.Delivery, interface{}) error
+}
+
+// Process implements ConsumerProcessor.
+//
+// Decoding errors are not temporary, so the Consumer discards the message.
+func (p *CodecProcessor) Process(ctx context.Context, dlv amqp.Delivery) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "codec_processor", &err)
+	defer spanFinish()
+	if dlv.ContentType != "" {
+		setTraceSpanTag(span, "content_type", dlv.ContentType)
+	}
+	if dlv.ContentEncoding != "" {
+		setTraceSpanTag(span, "content_encoding", dlv.ContentEncoding)
+	}
+	v, err := p.New(dlv)
+	if err != nil {
+		err = errors.WithTemporary(err, false)
+		return errors.Wrap(err, "new")
+	}
+	err = p.Codecs.Decode(dlv, v)
+	if err != nil {
+		return errors.Wrap(err, "decode")
+	}
+	return p.Processor(ctx, dlv, v)
+}
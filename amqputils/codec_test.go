@@ -0,0 +1,273 @@
+package amqputils_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+type testCodecValue struct {
+	Foo string `json:"foo"`
+}
+
+func TestCodecs(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	for _, tc := range []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		value           interface{}
+		newTarget       func() interface{}
+	}{
+		{
+			name:  "Default",
+			value: &testCodecValue{Foo: "bar"},
+			newTarget: func() interface{} {
+				return new(testCodecValue)
+			},
+		},
+		{
+			name:            "JSONGzip",
+			contentType:     amqputils.ContentTypeJSON,
+			contentEncoding: amqputils.ContentEncodingGzip,
+			value:           &testCodecValue{Foo: "bar"},
+			newTarget: func() interface{} {
+				return new(testCodecValue)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pbl := amqp.Publishing{
+				ContentType:     tc.contentType,
+				ContentEncoding: tc.contentEncoding,
+			}
+			err := cs.Encode(&pbl, tc.value)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			dlv := amqp.Delivery{
+				ContentType:     pbl.ContentType,
+				ContentEncoding: pbl.ContentEncoding,
+				Body:            pbl.Body,
+			}
+			v := tc.newTarget()
+			err = cs.Decode(dlv, v)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			testutils.Compare(t, "unexpected value", v, tc.value)
+		})
+	}
+}
+
+func TestCodecsRaw(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	pbl := amqp.Publishing{
+		ContentType: amqputils.ContentTypeOctetStream,
+	}
+	err := cs.Encode(&pbl, "test")
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	dlv := amqp.Delivery{
+		ContentType: pbl.ContentType,
+		Body:        pbl.Body,
+	}
+	var v string
+	err = cs.Decode(dlv, &v)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if v != "test" {
+		t.Fatalf("unexpected value: got %q, want %q", v, "test")
+	}
+}
+
+func TestCodecsContentTypeParameters(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	dlv := amqp.Delivery{
+		ContentType: "application/json; charset=utf-8",
+		Body:        []byte(`{"foo":"bar"}`),
+	}
+	v := new(testCodecValue)
+	err := cs.Decode(dlv, v)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected value", v, &testCodecValue{Foo: "bar"})
+}
+
+func TestCodecsEncodeErrorContentType(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	pbl := amqp.Publishing{
+		ContentType: "invalid",
+	}
+	err := cs.Encode(&pbl, "test")
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestCodecsEncodeErrorContentEncoding(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	pbl := amqp.Publishing{
+		ContentEncoding: "invalid",
+	}
+	err := cs.Encode(&pbl, "test")
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestCodecsDecodeErrorNotTemporary(t *testing.T) {
+	cs := amqputils.NewCodecs()
+	for _, tc := range []struct {
+		name string
+		dlv  amqp.Delivery
+	}{
+		{
+			name: "ContentType",
+			dlv: amqp.Delivery{
+				ContentType: "invalid",
+			},
+		},
+		{
+			name: "ContentEncoding",
+			dlv: amqp.Delivery{
+				ContentEncoding: "invalid",
+			},
+		},
+		{
+			name: "Gzip",
+			dlv: amqp.Delivery{
+				ContentEncoding: amqputils.ContentEncodingGzip,
+				Body:            []byte("invalid"),
+			},
+		},
+		{
+			name: "JSON",
+			dlv: amqp.Delivery{
+				Body: []byte("invalid"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := cs.Decode(tc.dlv, new(testCodecValue))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if errors.IsTemporary(err) {
+				t.Fatal("temporary")
+			}
+		})
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	pbl := amqp.Publishing{}
+	amqputils.SetSchemaVersion(&pbl, 2)
+	dlv := amqp.Delivery{
+		Headers: pbl.Headers,
+	}
+	v, ok := amqputils.GetSchemaVersion(dlv)
+	if !ok {
+		t.Fatal("not ok")
+	}
+	if v != 2 {
+		t.Fatalf("unexpected version: got %d, want %d", v, 2)
+	}
+}
+
+func TestSchemaVersionIntegerTypes(t *testing.T) {
+	for _, v := range []interface{}{int64(2), int32(2), int16(2), int8(2), int(2), uint32(2), uint16(2), uint8(2)} {
+		dlv := amqp.Delivery{
+			Headers: amqp.Table{
+				amqputils.SchemaVersionHeader: v,
+			},
+		}
+		version, ok := amqputils.GetSchemaVersion(dlv)
+		if !ok {
+			t.Fatalf("%T: not ok", v)
+		}
+		if version != 2 {
+			t.Fatalf("%T: unexpected version: got %d, want %d", v, version, 2)
+		}
+	}
+	_, ok := amqputils.GetSchemaVersion(amqp.Delivery{
+		Headers: amqp.Table{
+			amqputils.SchemaVersionHeader: "2",
+		},
+	})
+	if ok {
+		t.Fatal("string: ok")
+	}
+}
+
+func TestCodecProducerProcessor(t *testing.T) {
+	ctx := context.Background()
+	cs := amqputils.NewCodecs()
+	var dlv amqp.Delivery
+	p := &amqputils.CodecProducer{
+		Producer: func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			dlv = amqp.Delivery{
+				Headers:         msg.Headers,
+				ContentType:     msg.ContentType,
+				ContentEncoding: msg.ContentEncoding,
+				Body:            msg.Body,
+			}
+			return nil
+		},
+		Codecs:          cs,
+		ContentEncoding: amqputils.ContentEncodingGzip,
+		SchemaVersion:   3,
+	}
+	value := &testCodecValue{Foo: "bar"}
+	err := p.Produce(ctx, "exchange", "key", false, false, amqp.Publishing{}, value)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	var prCalled testutils.CallCounter
+	pr := &amqputils.CodecProcessor{
+		Codecs: cs,
+		New: func(dlv amqp.Delivery) (interface{}, error) {
+			v, _ := amqputils.GetSchemaVersion(dlv)
+			if v != 3 {
+				t.Fatalf("unexpected version: got %d, want %d", v, 3)
+			}
+			return new(testCodecValue), nil
+		},
+		Processor: func(ctx context.Context, dlv amqp.Delivery, v interface{}) error {
+			prCalled.Call()
+			testutils.Compare(t, "unexpected value", v, value)
+			return nil
+		},
+	}
+	err = pr.Process(ctx, dlv)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	prCalled.AssertCalled(t)
+}
+
+func TestCodecProcessorErrorDecode(t *testing.T) {
+	ctx := context.Background()
+	pr := &amqputils.CodecProcessor{
+		Codecs: amqputils.NewCodecs(),
+		New: func(dlv amqp.Delivery) (interface{}, error) {
+			return new(testCodecValue), nil
+		},
+	}
+	err := pr.Process(ctx, amqp.Delivery{
+		Body: []byte("invalid"),
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if errors.IsTemporary(err) {
+		t.Fatal("temporary")
+	}
+}
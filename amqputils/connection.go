@@ -12,15 +12,22 @@ import (
 // ConnectionManager manages a amqp.Connection.
 type ConnectionManager struct {
 	Dial func(context.Context) (*amqp.Connection, error)
+	// Blocked is optional.
+	// If it is defined, it is notified when the managed amqp.Connection is blocked or unblocked.
+	Blocked *ConnectionBlocked
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
 
-	mu   ctxsync.Mutex
-	conn *amqp.Connection
+	mu     ctxsync.Mutex
+	conn   *amqp.Connection
+	dialed bool
 }
 
 // Channel opens a new amqp.Channel on the managed amqp.Connection.
 //
 // If an error occurs during the amqp.Channel opening, the ConnectionManager is closed.
-func (m *ConnectionManager) Channel(ctx context.Context) (chn *amqp.Channel, err error) {
+func (m *ConnectionManager) Channel(ctx context.Context) (_ Channel, err error) {
 	_, spanFinish := startTraceChildSpan(&ctx, "connection_manager.channel", &err)
 	defer spanFinish()
 	err = tracingutils.TraceSyncLockerCtx(ctx, &m.mu)
@@ -32,7 +39,7 @@ func (m *ConnectionManager) Channel(ctx context.Context) (chn *amqp.Channel, err
 	if err != nil {
 		return nil, errors.Wrap(err, "get connection")
 	}
-	chn, err = connectionChannel(ctx, conn)
+	chn, err := connectionChannel(ctx, conn)
 	if err != nil {
 		_ = m.close()
 		return nil, errors.Wrap(err, "open channel")
@@ -48,6 +55,13 @@ func (m *ConnectionManager) get(ctx context.Context) (conn *amqp.Connection, err
 	if err != nil {
 		return nil, errors.Wrap(err, "dial")
 	}
+	if m.Blocked != nil {
+		m.Blocked.Notify(conn)
+	}
+	if m.dialed {
+		getMetrics(m.Metrics).Counter(MetricReconnects, MetricLabels{}, 1)
+	}
+	m.dialed = true
 	m.conn = conn
 	return conn, nil
 }
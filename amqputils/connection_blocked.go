@@ -0,0 +1,131 @@
+package amqputils
+
+import (
+	"context"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+)
+
+// BlockedPolicy defines the behavior of a producer when the connection is blocked.
+type BlockedPolicy int
+
+// BlockedPolicy values.
+const (
+	// BlockedPolicyWait waits until the connection is unblocked, or until the context is canceled.
+	BlockedPolicyWait BlockedPolicy = iota
+	// BlockedPolicyFail returns a temporary error immediately.
+	BlockedPolicyFail
+)
+
+// ConnectionBlocked tracks the blocked state of a connection.
+//
+// The broker blocks the connections that publish messages if a resource alarm (memory or disk) is raised.
+// See https://www.rabbitmq.com/connection-blocked.html .
+//
+// The zero value is unblocked.
+type ConnectionBlocked struct {
+	mu        sync.Mutex
+	blk       amqp.Blocking
+	unblocked chan struct{}
+	gen       uint64
+}
+
+// Notify registers the ConnectionBlocked to the notifications of a connection.
+//
+// It starts a goroutine that runs until the connection is closed.
+// When the connection is closed, the state is reset to unblocked.
+//
+// Only the last registered connection updates the state.
+// The notifications of a previous connection (e.g. after a reconnection) are ignored.
+func (b *ConnectionBlocked) Notify(conn *amqp.Connection) {
+	b.mu.Lock()
+	b.gen++
+	gen := b.gen
+	b.mu.Unlock()
+	ch := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+	go func() {
+		for blk := range ch {
+			b.setGeneration(gen, blk)
+		}
+		b.setGeneration(gen, amqp.Blocking{})
+	}()
+}
+
+// Set sets the blocked state.
+func (b *ConnectionBlocked) Set(blk amqp.Blocking) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(blk)
+}
+
+// setGeneration sets the blocked state if gen is the generation of the last registered connection.
+func (b *ConnectionBlocked) setGeneration(gen uint64, blk amqp.Blocking) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.gen {
+		return
+	}
+	b.set(blk)
+}
+
+func (b *ConnectionBlocked) set(blk amqp.Blocking) {
+	if blk.Active == b.blk.Active {
+		b.blk = blk
+		return
+	}
+	b.blk = blk
+	if blk.Active {
+		b.unblocked = make(chan struct{})
+	} else {
+		close(b.unblocked)
+		b.unblocked = nil
+	}
+}
+
+// Get returns the blocked state.
+func (b *ConnectionBlocked) Get() amqp.Blocking {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.blk
+}
+
+// Wait waits until the connection is unblocked.
+//
+// It returns immediately if the connection is not blocked.
+func (b *ConnectionBlocked) Wait(ctx context.Context) (err error) {
+	b.mu.Lock()
+	blk, unblocked := b.blk, b.unblocked
+	b.mu.Unlock()
+	if !blk.Active {
+		return nil
+	}
+	span, spanFinish := startTraceChildSpan(&ctx, "connection_blocked.wait", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "blocked.reason", blk.Reason)
+	select {
+	case <-unblocked:
+		return nil
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "")
+		err = wrapErrorValue(err, "blocked.reason", blk.Reason)
+		return err
+	}
+}
+
+// check checks the blocked state according to a policy.
+func (b *ConnectionBlocked) check(ctx context.Context, policy BlockedPolicy) error {
+	switch policy {
+	case BlockedPolicyFail:
+		blk := b.Get()
+		if !blk.Active {
+			return nil
+		}
+		err := errors.New("connection blocked")
+		err = wrapErrorValue(err, "blocked.reason", blk.Reason)
+		return errors.WithTemporary(err, true)
+	default:
+		return b.Wait(ctx)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/siddhant2408/golang-libraries/ctxutils"
 	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
 	"github.com/siddhant2408/golang-libraries/tracingutils"
 	"github.com/streadway/amqp"
 )
@@ -24,6 +25,12 @@ type Consumer struct {
 	Processor ConsumerProcessor
 	// Error is called if the processor returns an error.
 	Error func(context.Context, error)
+	// Queue is the name of the consumed queue.
+	// It is optional, and only used as metric label.
+	Queue string
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
 }
 
 // Consume consumes a channel of messages.
@@ -55,20 +62,31 @@ func (c *Consumer) consumeDlv(dlv amqp.Delivery) (err error) {
 	span, spanFinish := startTraceRootSpan(&ctx, "consumer", &err)
 	defer spanFinish()
 	c.updateTracingSpan(span, dlv)
+	labels := c.getMetricLabels(dlv)
+	getMetrics(c.Metrics).Counter(MetricDeliveriesReceived, labels, 1)
+	start := timeutils.Now()
 	err = c.process(ctx, dlv)
+	observeDuration(c.Metrics, MetricProcessingDuration, labels, start, timeutils.Now())
 	if err != nil && !errors.IsIgnored(err) {
 		err = c.wrapProcessError(err, dlv)
 		tracingutils.SetSpanError(span, err)
 		err = errors.Wrap(err, "AMQP consumer")
 		c.Error(ctx, err)
 	}
-	err = c.acknowledge(ctx, dlv, err)
+	err = c.acknowledge(ctx, dlv, err, labels)
 	if err != nil {
 		return errors.Wrap(err, "acknowledge")
 	}
 	return nil
 }
 
+func (c *Consumer) getMetricLabels(dlv amqp.Delivery) MetricLabels {
+	return MetricLabels{
+		Queue:    c.Queue,
+		Exchange: dlv.Exchange,
+	}
+}
+
 func (c *Consumer) updateTracingSpan(span opentracing.Span, dlv amqp.Delivery) {
 	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
 	opentracing_ext.SpanKindConsumer.Set(span)
@@ -112,7 +130,7 @@ func (c *Consumer) wrapProcessError(err error, dlv amqp.Delivery) error {
 	return err
 }
 
-func (c *Consumer) acknowledge(ctx context.Context, dlv amqp.Delivery, myerr error) (err error) {
+func (c *Consumer) acknowledge(ctx context.Context, dlv amqp.Delivery, myerr error, labels MetricLabels) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "consumer.acknowledge", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
@@ -120,7 +138,29 @@ func (c *Consumer) acknowledge(ctx context.Context, dlv amqp.Delivery, myerr err
 	opentracing_ext.SpanKindConsumer.Set(span)
 	a := c.getAcknowledger(myerr)
 	setTraceSpanTag(span, "acknowledger", a.String())
-	return a.Acknowledge(dlv)
+	err = a.Acknowledge(dlv)
+	if err != nil {
+		return err
+	}
+	countAcknowledger(c.Metrics, a, labels, 1)
+	return nil
+}
+
+// countAcknowledger increments the counter of an Acknowledger.
+// The custom Acknowledgers are not counted.
+func countAcknowledger(m Metrics, a Acknowledger, labels MetricLabels, delta int64) {
+	var name string
+	switch a {
+	case Ack:
+		name = MetricDeliveriesAcked
+	case NackRequeue:
+		name = MetricDeliveriesRequeued
+	case NackDiscard:
+		name = MetricDeliveriesDiscarded
+	default:
+		return
+	}
+	getMetrics(m).Counter(name, labels, delta)
 }
 
 func (c *Consumer) getAcknowledger(err error) Acknowledger {
@@ -142,6 +182,7 @@ func RunConsumer(ctx context.Context, cg ChannelGetter, tp Topology, queue strin
 	c := &Consumer{
 		Processor: pr,
 		Error:     errFunc,
+		Queue:     queue,
 	}
 	start := NewReaderStartConsumer(tp, queue, prefetch)
 	r := &Reader{
@@ -159,6 +200,7 @@ func RunConsumers(ctx context.Context, cg ChannelGetter, tp Topology, queue stri
 	c := &Consumer{
 		Processor: pr,
 		Error:     errFunc,
+		Queue:     queue,
 	}
 	start := NewReaderStartConsumer(tp, queue, prefetch)
 	r := &Reader{
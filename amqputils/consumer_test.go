@@ -421,3 +421,96 @@ func newTestQueue(ctx context.Context, tb testing.TB, cg amqputils.ChannelGetter
 	}
 	return name
 }
+
+func TestRunConsumerBroker(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	cg := b.ChannelGetter()
+	queue := newTestQueue(ctx, t, cg)
+	chn := b.Channel()
+	err := chn.Publish("", queue, false, false, amqp.Publishing{
+		Body: []byte("test"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name:       queue,
+				AutoDelete: true,
+			},
+		},
+	}
+	var pCalled testutils.CallCounter
+	p := func(ctx context.Context, dlv amqp.Delivery) error {
+		pCalled.Call()
+		if string(dlv.Body) != "test" {
+			t.Errorf("unexpected body: got %q, want %q", dlv.Body, "test")
+		}
+		cancel()
+		return nil
+	}
+	errFunc := func(ctx context.Context, err error) {
+		testutils.ErrorErr(t, err)
+	}
+	amqputils.RunConsumer(ctx, cg, tp, queue, p, 1, errFunc)
+	pCalled.AssertCalled(t)
+}
+
+func TestRunConsumerRetryBroker(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	cg := b.ChannelGetter()
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name: "test",
+			},
+			{
+				Name: "test_delay",
+				Arguments: amqp.Table{
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": "test",
+				},
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, cg, tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	pr := &amqputils.SimpleProducer{
+		Channel: cg,
+		Confirm: true,
+	}
+	defer pr.Close() //nolint:errcheck
+	err = pr.Produce(ctx, "", "test", false, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := &amqputils.Retryer{
+		Producer: pr.Produce,
+		Max:      2,
+		Key:      "test_delay",
+	}
+	var attempts int
+	p := func(ctx context.Context, dlv amqp.Delivery) error {
+		attempts++
+		err := r.Retry(ctx, dlv)
+		if amqputils.GetErrorAcknowledger(err) == amqputils.NackDiscard {
+			cancel()
+		}
+		return err
+	}
+	// The last attempt returns an error.
+	errFunc := func(ctx context.Context, err error) {}
+	amqputils.RunConsumer(ctx, cg, tp, "test", p, 1, errFunc)
+	if attempts != 3 {
+		t.Fatalf("unexpected attempts: got %d, want %d", attempts, 3)
+	}
+}
@@ -0,0 +1,227 @@
+package amqputils
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+const (
+	delayHeaderUntil       = "delay-until"
+	delayHeaderExchange    = "delay-exchange"
+	delayHeaderRoutingKey  = "delay-routing-key"
+	delayHeaderExpiration  = "delay-expiration"
+	delayHeaderUntilLayout = time.RFC3339Nano
+
+	// delayPluginHeader is the header used by the delayed message exchange plugin.
+	delayPluginHeader = "x-delay"
+	// delayPluginExchangeType is the exchange type of the delayed message exchange plugin.
+	delayPluginExchangeType = "x-delayed-message"
+	// delayMax is the maximum TTL or delay supported by RabbitMQ (2^32-1 milliseconds).
+	delayMax = (1<<32 - 1) * time.Millisecond
+)
+
+// DefaultDelayBuckets is the default value for Delayer.Buckets.
+var DefaultDelayBuckets = []time.Duration{
+	1 * time.Second,
+	10 * time.Second,
+	1 * time.Minute,
+	10 * time.Minute,
+	1 * time.Hour,
+	24 * time.Hour,
+}
+
+// Delayer allows to produce messages that are delivered at a given time.
+//
+// The messages are held in the broker until the delivery time:
+//   - by default, in holding queues ("buckets") with a TTL, that dead-letter the messages to the ready queue
+//   - if Plugin is enabled, in an exchange of the delayed message exchange plugin, that routes the messages to the ready queue
+//
+// The ready queue must be consumed with Process (see RunDelayer()).
+// It produces the message to its final exchange and routing key if the delivery time is reached.
+// Otherwise it holds the message again for the remaining duration ("hop").
+// It allows to handle delays that are longer than the largest bucket or the maximum TTL.
+//
+// The topology must be initialized, see Topology().
+type Delayer struct {
+	Producer Producer
+	// Queue is the name of the ready queue.
+	// It is also the prefix of the bucket queues, and the name of the plugin exchange.
+	Queue string
+	// Buckets are the holding durations, sorted in ascending order.
+	// The largest bucket that is less than or equal to the remaining duration is selected.
+	// If the remaining duration is less than the smallest bucket, the smallest bucket is selected with a shorter message expiration.
+	// The default value is DefaultDelayBuckets.
+	Buckets []time.Duration
+	// Plugin enables the delayed message exchange plugin, instead of the buckets.
+	// See https://github.com/rabbitmq/rabbitmq-delayed-message-exchange .
+	Plugin bool
+}
+
+// Topology returns the topology used by the Delayer.
+func (d *Delayer) Topology() Topology {
+	ready := QueueConfig{
+		Name:    d.Queue,
+		Durable: true,
+	}
+	if d.Plugin {
+		ready.Bindings = []QueueBinding{
+			{
+				Exchange:   d.Queue,
+				RoutingKey: d.Queue,
+			},
+		}
+		return Topology{
+			Exchanges: []ExchangeConfig{
+				{
+					Name:    d.Queue,
+					Type:    delayPluginExchangeType,
+					Durable: true,
+					Arguments: amqp.Table{
+						"x-delayed-type": amqp.ExchangeDirect,
+					},
+				},
+			},
+			Queues: []QueueConfig{ready},
+		}
+	}
+	tp := Topology{
+		Queues: []QueueConfig{ready},
+	}
+	for _, b := range d.getBuckets() {
+		tp.Queues = append(tp.Queues, QueueConfig{
+			Name:    d.getBucketQueue(b),
+			Durable: true,
+			Arguments: amqp.Table{
+				"x-message-ttl":             int64(b / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": d.Queue,
+			},
+		})
+	}
+	return tp
+}
+
+// ProduceAt produces a message that is delivered at the given time to the exchange and routing key.
+//
+// If the time is in the past, the message is produced immediately.
+func (d *Delayer) ProduceAt(ctx context.Context, t time.Time, exchange, key string, msg amqp.Publishing) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "delayer.produce_at", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "delay.until", t.Format(delayHeaderUntilLayout))
+	msg.Headers = copyTable(msg.Headers)
+	msg.Headers[delayHeaderUntil] = t.Format(delayHeaderUntilLayout)
+	msg.Headers[delayHeaderExchange] = exchange
+	msg.Headers[delayHeaderRoutingKey] = key
+	if msg.Expiration != "" {
+		// The expiration is used by the buckets, so it is restored when the message is delivered.
+		msg.Headers[delayHeaderExpiration] = msg.Expiration
+		msg.Expiration = ""
+	}
+	err = d.produce(ctx, t, msg)
+	if err != nil {
+		return wrapErrorProducer(err, exchange, key, msg)
+	}
+	return nil
+}
+
+// Process processes a message from the ready queue.
+//
+// It implements ConsumerProcessor.
+func (d *Delayer) Process(ctx context.Context, dlv amqp.Delivery) (err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "delayer.process", &err)
+	defer spanFinish()
+	untilStr, _ := dlv.Headers[delayHeaderUntil].(string)
+	t, err := time.Parse(delayHeaderUntilLayout, untilStr)
+	if err != nil {
+		return errors.Wrap(err, "parse delay until header")
+	}
+	err = d.produce(ctx, t, deliveryToPublishing(dlv))
+	if err != nil {
+		// The message must not be discarded.
+		return errors.WithTemporary(err, true)
+	}
+	return nil
+}
+
+// produce holds the message until the given time, or produces it to its final destination.
+func (d *Delayer) produce(ctx context.Context, t time.Time, msg amqp.Publishing) error {
+	dur := timeutils.Until(t)
+	if dur <= 0 {
+		return d.produceReady(ctx, msg)
+	}
+	if dur > delayMax {
+		dur = delayMax
+	}
+	var exchange, key string
+	if d.Plugin {
+		exchange = d.Queue
+		key = d.Queue
+		msg.Headers[delayPluginHeader] = getDelayMilliseconds(dur)
+	} else {
+		b := d.getBucket(dur)
+		if dur > b {
+			dur = b
+		}
+		key = d.getBucketQueue(b)
+		msg.Expiration = strconv.FormatInt(getDelayMilliseconds(dur), 10)
+	}
+	err := d.Producer(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return errors.Wrap(err, "produce delay")
+	}
+	return nil
+}
+
+// produceReady produces the message to its final destination, without the delay headers.
+func (d *Delayer) produceReady(ctx context.Context, msg amqp.Publishing) error {
+	exchange, _ := msg.Headers[delayHeaderExchange].(string)
+	key, _ := msg.Headers[delayHeaderRoutingKey].(string)
+	msg.Expiration, _ = msg.Headers[delayHeaderExpiration].(string)
+	for _, h := range []string{delayHeaderUntil, delayHeaderExchange, delayHeaderRoutingKey, delayHeaderExpiration, delayPluginHeader} {
+		delete(msg.Headers, h)
+	}
+	err := d.Producer(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		err = wrapErrorProducer(err, exchange, key, msg)
+		return errors.Wrap(err, "produce ready")
+	}
+	return nil
+}
+
+func (d *Delayer) getBuckets() []time.Duration {
+	if len(d.Buckets) > 0 {
+		return d.Buckets
+	}
+	return DefaultDelayBuckets
+}
+
+func (d *Delayer) getBucket(dur time.Duration) time.Duration {
+	bs := d.getBuckets()
+	b := bs[0]
+	for _, v := range bs[1:] {
+		if v > dur {
+			break
+		}
+		b = v
+	}
+	return b
+}
+
+func (d *Delayer) getBucketQueue(b time.Duration) string {
+	return d.Queue + "." + strconv.FormatInt(int64(b/time.Millisecond), 10)
+}
+
+// getDelayMilliseconds returns the number of milliseconds, rounded up.
+func getDelayMilliseconds(dur time.Duration) int64 {
+	return int64((dur + time.Millisecond - 1) / time.Millisecond)
+}
+
+// RunDelayer runs a consumer on the ready queue of a Delayer.
+func RunDelayer(ctx context.Context, cg ChannelGetter, d *Delayer, prefetch int, errFunc func(context.Context, error)) {
+	RunConsumer(ctx, cg, d.Topology(), d.Queue, d.Process, prefetch, errFunc)
+}
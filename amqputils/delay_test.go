@@ -0,0 +1,184 @@
+package amqputils_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqptest"
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/streadway/amqp"
+)
+
+type testDelayPublish struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+func newTestDelayer(plugin bool) (*amqputils.Delayer, *[]testDelayPublish) {
+	var pubs []testDelayPublish
+	d := &amqputils.Delayer{
+		Producer: func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			pubs = append(pubs, testDelayPublish{
+				exchange: exchange,
+				key:      key,
+				msg:      msg,
+			})
+			return nil
+		},
+		Queue: "delay",
+		Buckets: []time.Duration{
+			1 * time.Second,
+			10 * time.Second,
+		},
+		Plugin: plugin,
+	}
+	return d, &pubs
+}
+
+// runTestDelayer simulates the broker: it advances the fake clock by the hold duration, and delivers the message to the ready queue.
+func runTestDelayer(ctx context.Context, tb testing.TB, d *amqputils.Delayer, pubs *[]testDelayPublish, plugin bool) (testDelayPublish, []string) {
+	tb.Helper()
+	var hops []string
+	for i := 0; i < 100; i++ {
+		p := (*pubs)[len(*pubs)-1]
+		held := p.exchange == "delay" || (p.exchange == "" && strings.HasPrefix(p.key, "delay."))
+		if !held {
+			return p, hops
+		}
+		var dur time.Duration
+		if plugin {
+			dur = time.Duration(p.msg.Headers["x-delay"].(int64)) * time.Millisecond
+		} else {
+			ms, err := strconv.ParseInt(p.msg.Expiration, 10, 64)
+			if err != nil {
+				testutils.FatalErr(tb, err)
+			}
+			dur = time.Duration(ms) * time.Millisecond
+		}
+		hops = append(hops, p.key+"/"+dur.String())
+		timeutils.SetFixed(timeutils.Now().Add(dur))
+		err := d.Process(ctx, amqp.Delivery{
+			Headers: p.msg.Headers,
+			Body:    p.msg.Body,
+		})
+		if err != nil {
+			testutils.FatalErr(tb, err)
+		}
+	}
+	tb.Fatal("too many hops")
+	return testDelayPublish{}, nil
+}
+
+func TestDelayerBuckets(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	d, pubs := newTestDelayer(false)
+	until := now.Add(21*time.Second + 500*time.Millisecond)
+	err := d.ProduceAt(ctx, until, "exchange", "key", amqp.Publishing{
+		Headers: amqp.Table{
+			"foo": "bar",
+		},
+		Expiration: "60000",
+		Body:       []byte("test"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p, hops := runTestDelayer(ctx, t, d, pubs, false)
+	testutils.Compare(t, "unexpected hops", hops, []string{
+		"delay.10000/10s",
+		"delay.10000/10s",
+		"delay.1000/1s",
+		"delay.1000/500ms",
+	})
+	if !timeutils.Now().Equal(until) {
+		t.Fatalf("unexpected delivery time: got %s, want %s", timeutils.Now(), until)
+	}
+	if p.exchange != "exchange" || p.key != "key" {
+		t.Fatalf("unexpected destination: got %q %q", p.exchange, p.key)
+	}
+	testutils.Compare(t, "unexpected headers", p.msg.Headers, amqp.Table{
+		"foo": "bar",
+	})
+	if p.msg.Expiration != "60000" {
+		t.Fatalf("unexpected expiration: got %q, want %q", p.msg.Expiration, "60000")
+	}
+}
+
+func TestDelayerPlugin(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	d, pubs := newTestDelayer(true)
+	// Longer than the maximum delay of the plugin.
+	until := now.Add(60 * 24 * time.Hour)
+	err := d.ProduceAt(ctx, until, "exchange", "key", amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p, hops := runTestDelayer(ctx, t, d, pubs, true)
+	if len(hops) != 2 {
+		t.Fatalf("unexpected hops: got %v, want 2", hops)
+	}
+	if !timeutils.Now().Equal(until) {
+		t.Fatalf("unexpected delivery time: got %s, want %s", timeutils.Now(), until)
+	}
+	if p.exchange != "exchange" || p.key != "key" {
+		t.Fatalf("unexpected destination: got %q %q", p.exchange, p.key)
+	}
+	if _, ok := p.msg.Headers["x-delay"]; ok {
+		t.Fatal("x-delay header not removed")
+	}
+}
+
+func TestDelayerPast(t *testing.T) {
+	ctx := context.Background()
+	d, pubs := newTestDelayer(false)
+	err := d.ProduceAt(ctx, timeutils.Now().Add(-1*time.Second), "exchange", "key", amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(*pubs) != 1 || (*pubs)[0].key != "key" {
+		t.Fatalf("unexpected publishings: %v", *pubs)
+	}
+}
+
+func TestDelayerProcessErrorHeader(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestDelayer(false)
+	err := d.Process(ctx, amqp.Delivery{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestDelayerTopologyBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	d, _ := newTestDelayer(false)
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), d.Topology())
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	// A message that expires in a bucket is dead-lettered to the ready queue.
+	err = chn.Publish("", "delay.1000", false, false, amqp.Publishing{
+		Expiration: "0",
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n := b.QueueMessages("delay"); n != 1 {
+		t.Fatalf("unexpected ready messages: got %d, want 1", n)
+	}
+}
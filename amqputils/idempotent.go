@@ -0,0 +1,122 @@
+package amqputils
+
+import (
+	"context"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/dedup"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+)
+
+// DeliveryKeyMessageID is a DeliveryKey that returns the MessageId.
+func DeliveryKeyMessageID(dlv amqp.Delivery) string {
+	return dlv.MessageId
+}
+
+const idempotentProcessorWaitInterval = 100 * time.Millisecond
+
+// IdempotentProcessor is a ConsumerProcessor that skips the duplicate messages.
+//
+// It marks the key of the message as in-flight before processing it, and as done after a successful processing.
+// If the processing fails, the in-flight mark is removed, so the message can be processed again.
+// If the done mark can't be stored after a successful processing, the message is acknowledged and the error is returned.
+// The duplicates of a processed message are skipped and acknowledged.
+//
+// The messages without key are always processed.
+type IdempotentProcessor struct {
+	Processor ConsumerProcessor
+	Store     dedup.Store
+	// Key returns the deduplication key of a message.
+	// The default value is DeliveryKeyMessageID.
+	Key DeliveryKey
+	// InFlightWait is the maximum duration to wait for a message with the same key that is being processed.
+	// If it is 0 or if the duration is exceeded, a temporary error is returned, and the message is requeued.
+	InFlightWait time.Duration
+}
+
+// Process implements ConsumerProcessor.
+func (p *IdempotentProcessor) Process(ctx context.Context, dlv amqp.Delivery) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "idempotent_processor", &err)
+	defer spanFinish()
+	key := p.getKey(dlv)
+	if key == "" {
+		return p.Processor(ctx, dlv)
+	}
+	setTraceSpanTag(span, "idempotent.key", key)
+	st, err := p.start(ctx, key)
+	if err != nil {
+		err = wrapErrorValue(err, "idempotent.key", key)
+		return errors.Wrap(err, "start")
+	}
+	setTraceSpanTag(span, "idempotent.state", st.String())
+	switch st {
+	case dedup.StateDone:
+		return nil
+	case dedup.StateInFlight:
+		err = errors.New("message with the same key is being processed")
+		err = wrapErrorValue(err, "idempotent.key", key)
+		return errors.WithTemporary(err, true)
+	}
+	return p.process(ctx, dlv, key)
+}
+
+func (p *IdempotentProcessor) getKey(dlv amqp.Delivery) string {
+	if p.Key != nil {
+		return p.Key(dlv)
+	}
+	return DeliveryKeyMessageID(dlv)
+}
+
+// start marks the key as in-flight.
+// If the key is already in-flight, it waits until the key is done or canceled, or until InFlightWait is exceeded.
+func (p *IdempotentProcessor) start(ctx context.Context, key string) (dedup.State, error) {
+	var deadline <-chan time.Time
+	if p.InFlightWait > 0 {
+		tm := time.NewTimer(p.InFlightWait)
+		defer tm.Stop()
+		deadline = tm.C
+	}
+	for {
+		st, err := p.Store.Start(ctx, key)
+		if err != nil {
+			return 0, errors.Wrap(err, "store")
+		}
+		if st != dedup.StateInFlight || deadline == nil {
+			return st, nil
+		}
+		tm := time.NewTimer(idempotentProcessorWaitInterval)
+		select {
+		case <-tm.C:
+		case <-deadline:
+			tm.Stop()
+			return st, nil
+		case <-ctx.Done():
+			tm.Stop()
+			return 0, errors.Wrap(ctx.Err(), "")
+		}
+	}
+}
+
+func (p *IdempotentProcessor) process(ctx context.Context, dlv amqp.Delivery, key string) error {
+	err := p.Processor(ctx, dlv)
+	if err != nil {
+		cerr := p.Store.Cancel(ctx, key)
+		if cerr != nil {
+			// The in-flight mark can't be removed, so the message must be requeued and processed later.
+			cerr = wrapErrorValue(cerr, "idempotent.key", key)
+			cerr = errors.WithTemporary(cerr, true)
+			return errors.Wrapf(cerr, "cancel (processor error: %v)", err)
+		}
+		return err
+	}
+	err = p.Store.Done(ctx, key)
+	if err != nil {
+		// The message was processed successfully, so it must not be requeued.
+		// Otherwise it would be redelivered while the key is still in-flight, until the mark expires.
+		err = wrapErrorValue(err, "idempotent.key", key)
+		err = ErrorWithAcknowledger(err, Ack)
+		return errors.Wrap(err, "done")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package amqputils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/dedup"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/inmemorycache"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestIdempotentProcessor(t *testing.T) {
+	ctx := context.Background()
+	var pCalled testutils.CallCounter
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			pCalled.Call()
+			return nil
+		},
+		Store: dedup.NewMemoryStore(inmemorycache.MaxSize(10)),
+	}
+	dlv := amqp.Delivery{
+		MessageId: "test",
+	}
+	for i := 0; i < 2; i++ {
+		err := p.Process(ctx, dlv)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	pCalled.AssertCount(t, 1)
+}
+
+func TestIdempotentProcessorNoKey(t *testing.T) {
+	ctx := context.Background()
+	var pCalled testutils.CallCounter
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			pCalled.Call()
+			return nil
+		},
+		Store: dedup.NewMemoryStore(),
+	}
+	for i := 0; i < 2; i++ {
+		err := p.Process(ctx, amqp.Delivery{})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	pCalled.AssertCount(t, 2)
+}
+
+func TestIdempotentProcessorCustomKey(t *testing.T) {
+	ctx := context.Background()
+	var pCalled testutils.CallCounter
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			pCalled.Call()
+			return nil
+		},
+		Store: dedup.NewMemoryStore(),
+		Key:   amqputils.NewDeliveryKeyHeader("id"),
+	}
+	for _, id := range []string{"a", "b", "a"} {
+		err := p.Process(ctx, amqp.Delivery{
+			Headers: amqp.Table{
+				"id": id,
+			},
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	pCalled.AssertCount(t, 2)
+}
+
+func TestIdempotentProcessorErrorProcessor(t *testing.T) {
+	ctx := context.Background()
+	var pCalled testutils.CallCounter
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			pCalled.Call()
+			return errors.New("error")
+		},
+		Store: dedup.NewMemoryStore(),
+	}
+	dlv := amqp.Delivery{
+		MessageId: "test",
+	}
+	for i := 0; i < 2; i++ {
+		err := p.Process(ctx, dlv)
+		if err == nil {
+			t.Fatal("no error")
+		}
+	}
+	// The key is not marked as done, so the message is processed again.
+	pCalled.AssertCount(t, 2)
+}
+
+func TestIdempotentProcessorErrorDone(t *testing.T) {
+	ctx := context.Background()
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			return nil
+		},
+		Store: &testDedupStoreErrorDone{
+			Store: dedup.NewMemoryStore(),
+		},
+	}
+	err := p.Process(ctx, amqp.Delivery{
+		MessageId: "test",
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	// The message was processed, so it is acknowledged instead of being requeued.
+	ack := amqputils.GetErrorAcknowledger(err)
+	if ack != amqputils.Ack {
+		t.Fatalf("unexpected acknowledger: got %v, want %v", ack, amqputils.Ack)
+	}
+}
+
+type testDedupStoreErrorDone struct {
+	dedup.Store
+}
+
+func (s *testDedupStoreErrorDone) Done(ctx context.Context, key string) error {
+	return errors.New("error")
+}
+
+func TestIdempotentProcessorInFlight(t *testing.T) {
+	ctx := context.Background()
+	s := dedup.NewMemoryStore()
+	_, err := s.Start(ctx, "test")
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			t.Fatal("should not be called")
+			return nil
+		},
+		Store: s,
+	}
+	err = p.Process(ctx, amqp.Delivery{
+		MessageId: "test",
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !errors.IsTemporary(err) {
+		t.Fatal("not temporary")
+	}
+}
+
+func TestIdempotentProcessorInFlightWait(t *testing.T) {
+	ctx := context.Background()
+	s := dedup.NewMemoryStore()
+	_, err := s.Start(ctx, "test")
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = s.Done(ctx, "test")
+	}()
+	p := &amqputils.IdempotentProcessor{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			t.Error("should not be called")
+			return nil
+		},
+		Store:        s,
+		InFlightWait: 10 * time.Second,
+	}
+	err = p.Process(ctx, amqp.Delivery{
+		MessageId: "test",
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
@@ -0,0 +1,175 @@
+package amqputils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/goroutine"
+	"github.com/streadway/amqp"
+)
+
+// KeyConsumer runs multiple consumers, and preserves the order of the messages with the same key.
+//
+// It reads messages from a single channel, and dispatches them to the consumers by hashing the key.
+// The messages with the same key are always processed sequentially by the same consumer.
+// The messages with different keys are processed concurrently.
+//
+// Each consumer has a bounded queue.
+// If a queue is full, the dispatch is blocked until the consumer processes a message.
+//
+// The messages are acknowledged individually by the consumer (e.g. Consumer), so the order of acknowledgement doesn't matter.
+type KeyConsumer struct {
+	// Count is the number of consumers.
+	// The default value is 1.
+	Count int
+	// QueueSize is the size of the queue of each consumer.
+	// The default value is 1.
+	QueueSize int
+	Key       DeliveryKey
+	Consume   func(context.Context, <-chan amqp.Delivery) error
+}
+
+// KeyConsume consumes messages with multiple consumers.
+//
+// It blocks until:
+//   - The context is canceled
+//   - The channel is closed
+//   - A consumer returns an error
+//
+// The messages that are waiting in the queues are not acknowledged, they are requeued when the AMQP channel is closed.
+func (kc *KeyConsumer) KeyConsume(ctx context.Context, ch <-chan amqp.Delivery) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var errMu sync.Mutex
+	var consumeErr error
+	wg := new(sync.WaitGroup)
+	chs := make([]chan amqp.Delivery, kc.getCount())
+	for i := range chs {
+		wch := make(chan amqp.Delivery, kc.getQueueSize())
+		chs[i] = wch
+		goroutine.WaitGroup(wg, func() {
+			err := kc.Consume(ctx, wch)
+			if err != nil {
+				errMu.Lock()
+				if consumeErr == nil {
+					consumeErr = err
+				}
+				errMu.Unlock()
+				cancel()
+			}
+		})
+	}
+	err := kc.dispatch(ctx, ch, chs)
+	cancel()
+	wg.Wait()
+	if consumeErr != nil {
+		return errors.Wrap(consumeErr, "consume")
+	}
+	return err
+}
+
+func (kc *KeyConsumer) dispatch(ctx context.Context, ch <-chan amqp.Delivery, chs []chan amqp.Delivery) error {
+	for {
+		select {
+		case dlv, ok := <-ch:
+			if !ok {
+				return errors.New("channel closed unexpectedly")
+			}
+			wch := chs[getKeyConsumerIndex(kc.Key(dlv), len(chs))]
+			select {
+			case wch <- dlv:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (kc *KeyConsumer) getCount() int {
+	if kc.Count > 0 {
+		return kc.Count
+	}
+	return 1
+}
+
+func (kc *KeyConsumer) getQueueSize() int {
+	if kc.QueueSize > 0 {
+		return kc.QueueSize
+	}
+	return 1
+}
+
+func getKeyConsumerIndex(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// DeliveryKey returns the key of a message.
+type DeliveryKey func(amqp.Delivery) string
+
+// NewDeliveryKeyHeader returns a DeliveryKey that returns the value of a header.
+//
+// If the header is not defined, the key is empty.
+func NewDeliveryKeyHeader(name string) DeliveryKey {
+	return func(dlv amqp.Delivery) string {
+		v, ok := dlv.Headers[name]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// NewDeliveryKeyJSONBody returns a DeliveryKey that returns the value of a field in a JSON object body.
+//
+// If the body is not a JSON object, or if the field is not defined, the key is empty.
+// If the value is a string, it is unquoted, otherwise the raw JSON value is returned.
+func NewDeliveryKeyJSONBody(field string) DeliveryKey {
+	return func(dlv amqp.Delivery) string {
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(dlv.Body, &m)
+		if err != nil {
+			return ""
+		}
+		raw, ok := m[field]
+		if !ok {
+			return ""
+		}
+		var s string
+		err = json.Unmarshal(raw, &s)
+		if err == nil {
+			return s
+		}
+		return string(raw)
+	}
+}
+
+// RunKeyConsumer runs consumers on a queue, and preserves the order of the messages with the same key.
+//
+// All consumers share the same AMQP channel and prefetch.
+func RunKeyConsumer(ctx context.Context, cg ChannelGetter, tp Topology, queue string, pr ConsumerProcessor, key DeliveryKey, count int, prefetch int, errFunc func(context.Context, error)) {
+	c := &Consumer{
+		Processor: pr,
+		Error:     errFunc,
+		Queue:     queue,
+	}
+	kc := &KeyConsumer{
+		Count:   count,
+		Key:     key,
+		Consume: c.Consume,
+	}
+	start := NewReaderStartConsumer(tp, queue, prefetch)
+	r := &Reader{
+		Channel: cg,
+		Start:   start,
+		Consume: kc.KeyConsume,
+	}
+	RunReader(ctx, r, errFunc)
+}
@@ -0,0 +1,287 @@
+package amqputils_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/amqptest"
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestKeyConsumer(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	const keyCount = 10
+	const msgCount = 100
+	r := &testKeyConsumerRecorder{
+		expected: keyCount * msgCount,
+		done:     cancel,
+		received: make(map[string][]int),
+	}
+	kc := &amqputils.KeyConsumer{
+		Count:     4,
+		QueueSize: 2,
+		Key:       amqputils.NewDeliveryKeyHeader("key"),
+		Consume:   r.consume,
+	}
+	ch := newTestKeyConsumerDeliveries(keyCount, msgCount)
+	err := kc.KeyConsume(ctx, ch)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(r.received) != keyCount {
+		t.Fatalf("unexpected keys: got %d, want %d", len(r.received), keyCount)
+	}
+	for key, is := range r.received {
+		checkKeyConsumerOrder(t, key, is)
+	}
+}
+
+// testKeyConsumerRecorder records the received messages by key, and calls done when all the expected messages are received.
+type testKeyConsumerRecorder struct {
+	expected int
+	done     func()
+
+	mu       sync.Mutex
+	received map[string][]int
+	total    int
+}
+
+func (r *testKeyConsumerRecorder) consume(ctx context.Context, ch <-chan amqp.Delivery) error {
+	for {
+		select {
+		case dlv := <-ch:
+			r.record(dlv)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *testKeyConsumerRecorder) record(dlv amqp.Delivery) {
+	key := dlv.Headers["key"].(string)
+	i, _ := strconv.Atoi(string(dlv.Body))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received[key] = append(r.received[key], i)
+	r.total++
+	if r.total == r.expected {
+		r.done()
+	}
+}
+
+// newTestKeyConsumerDeliveries returns a channel containing msgCount messages for each key, interleaved.
+func newTestKeyConsumerDeliveries(keyCount, msgCount int) <-chan amqp.Delivery {
+	ch := make(chan amqp.Delivery, keyCount*msgCount)
+	for i := 0; i < msgCount; i++ {
+		for k := 0; k < keyCount; k++ {
+			ch <- amqp.Delivery{
+				Headers: amqp.Table{
+					"key": strconv.Itoa(k),
+				},
+				Body: []byte(strconv.Itoa(i)),
+			}
+		}
+	}
+	return ch
+}
+
+func checkKeyConsumerOrder(t *testing.T, key string, is []int) {
+	t.Helper()
+	for i, v := range is {
+		if v != i {
+			t.Fatalf("unexpected order for key %q: got %d, want %d", key, v, i)
+		}
+	}
+}
+
+func TestKeyConsumerErrorConsume(t *testing.T) {
+	ctx := context.Background()
+	c := func(ctx context.Context, ch <-chan amqp.Delivery) error {
+		return errors.New("error")
+	}
+	kc := &amqputils.KeyConsumer{
+		Count:   4,
+		Key:     amqputils.NewDeliveryKeyHeader("key"),
+		Consume: c,
+	}
+	ch := make(chan amqp.Delivery)
+	err := kc.KeyConsume(ctx, ch)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestKeyConsumerErrorChannelClosed(t *testing.T) {
+	ctx := context.Background()
+	c := func(ctx context.Context, ch <-chan amqp.Delivery) error {
+		<-ctx.Done()
+		return nil
+	}
+	kc := &amqputils.KeyConsumer{
+		Count:   4,
+		Key:     amqputils.NewDeliveryKeyHeader("key"),
+		Consume: c,
+	}
+	ch := make(chan amqp.Delivery)
+	close(ch)
+	err := kc.KeyConsume(ctx, ch)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestKeyConsumerDefaultCount(t *testing.T) {
+	ctx := context.Background()
+	c := func(ctx context.Context, ch <-chan amqp.Delivery) error {
+		dlv := <-ch
+		if string(dlv.Body) != "test" {
+			t.Errorf("unexpected body: got %q, want %q", dlv.Body, "test")
+		}
+		return errors.New("error")
+	}
+	kc := &amqputils.KeyConsumer{
+		Key:     amqputils.NewDeliveryKeyHeader("key"),
+		Consume: c,
+	}
+	ch := make(chan amqp.Delivery, 1)
+	ch <- amqp.Delivery{Body: []byte("test")}
+	err := kc.KeyConsume(ctx, ch)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestNewDeliveryKeyHeader(t *testing.T) {
+	key := amqputils.NewDeliveryKeyHeader("key")
+	for _, tc := range []struct {
+		name     string
+		headers  amqp.Table
+		expected string
+	}{
+		{
+			name: "String",
+			headers: amqp.Table{
+				"key": "test",
+			},
+			expected: "test",
+		},
+		{
+			name: "Int",
+			headers: amqp.Table{
+				"key": int64(123),
+			},
+			expected: "123",
+		},
+		{
+			name:     "Missing",
+			expected: "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k := key(amqp.Delivery{
+				Headers: tc.headers,
+			})
+			if k != tc.expected {
+				t.Fatalf("unexpected key: got %q, want %q", k, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewDeliveryKeyJSONBody(t *testing.T) {
+	key := amqputils.NewDeliveryKeyJSONBody("id")
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "String",
+			body:     `{"id":"test"}`,
+			expected: "test",
+		},
+		{
+			name:     "Number",
+			body:     `{"id":123}`,
+			expected: "123",
+		},
+		{
+			name:     "Missing",
+			body:     `{}`,
+			expected: "",
+		},
+		{
+			name:     "Invalid",
+			body:     `invalid`,
+			expected: "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k := key(amqp.Delivery{
+				Body: []byte(tc.body),
+			})
+			if k != tc.expected {
+				t.Fatalf("unexpected key: got %q, want %q", k, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunKeyConsumerBroker(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	cg := b.ChannelGetter()
+	queue := newTestQueue(ctx, t, cg)
+	chn := b.Channel()
+	const msgCount = 20
+	for i := 0; i < msgCount; i++ {
+		err := chn.Publish("", queue, false, false, amqp.Publishing{
+			Headers: amqp.Table{
+				"key": strconv.Itoa(i % 2),
+			},
+			Body: []byte(strconv.Itoa(i)),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name:       queue,
+				AutoDelete: true,
+			},
+		},
+	}
+	var mu sync.Mutex
+	last := map[string]int{"0": -1, "1": -1}
+	count := 0
+	p := func(ctx context.Context, dlv amqp.Delivery) error {
+		key := dlv.Headers["key"].(string)
+		i, _ := strconv.Atoi(string(dlv.Body))
+		mu.Lock()
+		defer mu.Unlock()
+		if i <= last[key] {
+			t.Errorf("unexpected order for key %q: got %d after %d", key, i, last[key])
+		}
+		last[key] = i
+		count++
+		if count == msgCount {
+			cancel()
+		}
+		return nil
+	}
+	errFunc := func(ctx context.Context, err error) {
+		testutils.ErrorErr(t, err)
+	}
+	amqputils.RunKeyConsumer(ctx, cg, tp, queue, p, amqputils.NewDeliveryKeyHeader("key"), 2, 4, errFunc)
+}
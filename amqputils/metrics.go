@@ -0,0 +1,212 @@
+package amqputils
+
+import (
+	"expvar"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names.
+const (
+	// MetricDeliveriesReceived is a counter of the messages received by the consumers.
+	MetricDeliveriesReceived = "amqp_deliveries_received"
+	// MetricDeliveriesAcked is a counter of the messages acknowledged by the consumers.
+	MetricDeliveriesAcked = "amqp_deliveries_acked"
+	// MetricDeliveriesRequeued is a counter of the messages negatively acknowledged with requeue by the consumers.
+	MetricDeliveriesRequeued = "amqp_deliveries_requeued"
+	// MetricDeliveriesDiscarded is a counter of the messages negatively acknowledged without requeue by the consumers.
+	MetricDeliveriesDiscarded = "amqp_deliveries_discarded"
+	// MetricDeliveriesRetried is a counter of the messages retried by Retryer.
+	MetricDeliveriesRetried = "amqp_deliveries_retried"
+	// MetricProcessingDuration is a histogram of the processing duration (in seconds) of the consumers.
+	MetricProcessingDuration = "amqp_processing_duration_seconds"
+	// MetricBatchSize is a histogram of the batch sizes of BatchConsumer.
+	MetricBatchSize = "amqp_batch_size"
+	// MetricPublishDuration is a histogram of the publish duration (in seconds) of SimpleProducer, including the confirmation.
+	MetricPublishDuration = "amqp_publish_duration_seconds"
+	// MetricPublishConfirmFailures is a counter of the negative confirmations and returned messages of SimpleProducer.
+	MetricPublishConfirmFailures = "amqp_publish_confirm_failures"
+	// MetricReconnects is a counter of the reconnections of ConnectionManager.
+	MetricReconnects = "amqp_reconnects"
+	// MetricChannelPoolOpen is a gauge of the open channels of ChannelPool.
+	MetricChannelPoolOpen = "amqp_channel_pool_open"
+	// MetricChannelPoolIdle is a gauge of the idle channels of ChannelPool.
+	MetricChannelPoolIdle = "amqp_channel_pool_idle"
+	// MetricChannelPoolWaiting is a gauge of the Get calls waiting for a channel in ChannelPool.
+	MetricChannelPoolWaiting = "amqp_channel_pool_waiting"
+)
+
+// MetricLabels are the labels of a metric.
+//
+// The empty values are not significant.
+type MetricLabels struct {
+	Queue    string
+	Exchange string
+	// Name identifies a component that is not related to a queue or exchange (e.g. a ChannelPool).
+	Name string
+}
+
+// Metrics records metrics.
+//
+// It must be safe for concurrent use.
+type Metrics interface {
+	// Counter increments a counter.
+	Counter(name string, labels MetricLabels, delta int64)
+	// Histogram records a value in a histogram.
+	Histogram(name string, labels MetricLabels, value float64)
+	// Gauge sets the value of a gauge.
+	Gauge(name string, labels MetricLabels, value float64)
+}
+
+// DefaultMetrics is the default Metrics.
+//
+// It is used if the Metrics field of a component is not defined.
+// The metrics are published in the "amqp" expvar.
+var DefaultMetrics Metrics = NewExpvarMetrics("amqp")
+
+func getMetrics(m Metrics) Metrics {
+	if m != nil {
+		return m
+	}
+	return DefaultMetrics
+}
+
+func observeDuration(m Metrics, name string, labels MetricLabels, start time.Time, end time.Time) {
+	getMetrics(m).Histogram(name, labels, end.Sub(start).Seconds())
+}
+
+// ExpvarMetrics is a Metrics that publishes the metrics in an expvar.Map.
+//
+// The keys have the format `name{label="value",...}`.
+// The counters are expvar.Int, the gauges are expvar.Float.
+// The histograms are summarized with count, sum, min and max.
+type ExpvarMetrics struct {
+	m  *expvar.Map
+	mu sync.Mutex
+}
+
+// NewExpvarMetrics returns a new ExpvarMetrics, published with the given name.
+//
+// If an expvar.Map is already published with this name, it is reused.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m, _ := expvar.Get(name).(*expvar.Map)
+	if m == nil {
+		m = expvar.NewMap(name)
+	}
+	return &ExpvarMetrics{
+		m: m,
+	}
+}
+
+// Counter implements Metrics.
+func (em *ExpvarMetrics) Counter(name string, labels MetricLabels, delta int64) {
+	em.m.Add(getMetricKey(name, labels), delta)
+}
+
+// Histogram implements Metrics.
+func (em *ExpvarMetrics) Histogram(name string, labels MetricLabels, value float64) {
+	k := getMetricKey(name, labels)
+	em.mu.Lock()
+	h, _ := em.m.Get(k).(*expvarHistogram)
+	if h == nil {
+		h = new(expvarHistogram)
+		em.m.Set(k, h)
+	}
+	em.mu.Unlock()
+	h.observe(value)
+}
+
+// Gauge implements Metrics.
+func (em *ExpvarMetrics) Gauge(name string, labels MetricLabels, value float64) {
+	k := getMetricKey(name, labels)
+	em.mu.Lock()
+	g, _ := em.m.Get(k).(*expvar.Float)
+	if g == nil {
+		g = new(expvar.Float)
+		em.m.Set(k, g)
+	}
+	em.mu.Unlock()
+	g.Set(value)
+}
+
+// Get returns the expvar.Var of a metric, or nil if it doesn't exist.
+func (em *ExpvarMetrics) Get(name string, labels MetricLabels) expvar.Var {
+	return em.m.Get(getMetricKey(name, labels))
+}
+
+func getMetricKey(name string, labels MetricLabels) string {
+	var ls []string
+	for _, l := range []struct {
+		key   string
+		value string
+	}{
+		{"exchange", labels.Exchange},
+		{"name", labels.Name},
+		{"queue", labels.Queue},
+	} {
+		if l.value != "" {
+			ls = append(ls, l.key+"="+strconv.Quote(l.value))
+		}
+	}
+	if len(ls) == 0 {
+		return name
+	}
+	sort.Strings(ls)
+	return name + "{" + strings.Join(ls, ",") + "}"
+}
+
+type expvarHistogram struct {
+	mu    sync.Mutex
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (h *expvarHistogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// String implements expvar.Var.
+func (h *expvarHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var b strings.Builder
+	b.WriteString(`{"count":`)
+	b.WriteString(strconv.FormatInt(h.count, 10))
+	for _, f := range []struct {
+		key   string
+		value float64
+	}{
+		{"sum", h.sum},
+		{"min", h.min},
+		{"max", h.max},
+	} {
+		b.WriteString(`,"`)
+		b.WriteString(f.key)
+		b.WriteString(`":`)
+		b.WriteString(formatExpvarFloat(f.value))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func formatExpvarFloat(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,248 @@
+package amqputils_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqptest"
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+type testMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]int64
+	histograms map[string]int
+	gauges     map[string]float64
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		counters:   make(map[string]int64),
+		histograms: make(map[string]int),
+		gauges:     make(map[string]float64),
+	}
+}
+
+func (m *testMetrics) Counter(name string, labels amqputils.MetricLabels, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name+"/"+labels.Queue+"/"+labels.Exchange+"/"+labels.Name] += delta
+}
+
+func (m *testMetrics) Histogram(name string, labels amqputils.MetricLabels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.histograms[name+"/"+labels.Queue+"/"+labels.Exchange+"/"+labels.Name]++
+}
+
+func (m *testMetrics) Gauge(name string, labels amqputils.MetricLabels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[name+"/"+labels.Queue+"/"+labels.Exchange+"/"+labels.Name] = value
+}
+
+func TestConsumerMetricsBroker(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name: "test",
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	for _, body := range []string{"ack", "discard", "requeue"} {
+		err = chn.Publish("", "test", false, false, amqp.Publishing{
+			Body: []byte(body),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	m := newTestMetrics()
+	count := 0
+	requeued := false
+	c := &amqputils.Consumer{
+		Processor: func(ctx context.Context, dlv amqp.Delivery) error {
+			count++
+			if count == 4 {
+				cancel()
+			}
+			switch string(dlv.Body) {
+			case "discard":
+				return errors.WithTemporary(errors.New("error"), false)
+			case "requeue":
+				if !requeued {
+					requeued = true
+					return errors.WithTemporary(errors.New("error"), true)
+				}
+			}
+			return nil
+		},
+		Error:   func(ctx context.Context, err error) {},
+		Queue:   "test",
+		Metrics: m,
+	}
+	r := &amqputils.Reader{
+		Channel: b.ChannelGetter(),
+		Start:   amqputils.NewReaderStartConsumer(tp, "test", 10),
+		Consume: c.Consume,
+	}
+	err = r.Read(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected counters", m.counters, map[string]int64{
+		amqputils.MetricDeliveriesReceived + "/test//":  4,
+		amqputils.MetricDeliveriesAcked + "/test//":     2,
+		amqputils.MetricDeliveriesDiscarded + "/test//": 1,
+		amqputils.MetricDeliveriesRequeued + "/test//":  1,
+	})
+	testutils.Compare(t, "unexpected histograms", m.histograms, map[string]int{
+		amqputils.MetricProcessingDuration + "/test//": 4,
+	})
+}
+
+func TestBatchConsumerMetricsBroker(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name: "test",
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	for i := 0; i < 4; i++ {
+		err = chn.Publish("", "test", false, false, amqp.Publishing{
+			Body: []byte("test"),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	m := newTestMetrics()
+	a := &amqputils.Accumulator{
+		Size:  4,
+		Delay: 1 * time.Second,
+	}
+	c := &amqputils.BatchConsumer{
+		Accumulator: a.Accumulate,
+		Processor: func(ctx context.Context, dlvs []amqp.Delivery) error {
+			defer cancel()
+			for _, f := range []func() error{
+				func() error { return dlvs[1].Ack(true) },
+				func() error { return dlvs[2].Nack(false, false) },
+				func() error { return dlvs[3].Reject(true) },
+			} {
+				err := f()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Queue:   "test",
+		Metrics: m,
+	}
+	r := &amqputils.Reader{
+		Channel: b.ChannelGetter(),
+		Start:   amqputils.NewReaderStartConsumer(tp, "test", 10),
+		Consume: c.Consume,
+	}
+	err = r.Read(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected counters", m.counters, map[string]int64{
+		amqputils.MetricDeliveriesReceived + "/test//":  4,
+		amqputils.MetricDeliveriesAcked + "/test//":     2,
+		amqputils.MetricDeliveriesDiscarded + "/test//": 1,
+		amqputils.MetricDeliveriesRequeued + "/test//":  1,
+	})
+}
+
+func TestChannelPoolMetricsBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	m := newTestMetrics()
+	cp := &amqputils.ChannelPool{
+		Channel: b.ChannelGetter(),
+		Name:    "test",
+		Metrics: m,
+	}
+	defer cp.Close() //nolint:errcheck
+	chn1, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn2, err := cp.Get(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	cp.Put(chn1)
+	testutils.Compare(t, "unexpected gauges", m.gauges, map[string]float64{
+		amqputils.MetricChannelPoolOpen + "///test":    2,
+		amqputils.MetricChannelPoolIdle + "///test":    1,
+		amqputils.MetricChannelPoolWaiting + "///test": 0,
+	})
+	cp.Put(chn2)
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	name := fmt.Sprintf("amqputils_test_metrics_%d", rand.Int63())
+	m := amqputils.NewExpvarMetrics(name)
+	labels := amqputils.MetricLabels{
+		Queue:    "queue",
+		Exchange: "exchange",
+	}
+	m.Counter("counter", labels, 1)
+	m.Counter("counter", labels, 2)
+	m.Gauge("gauge", labels, 1.5)
+	m.Histogram("histogram", labels, 1)
+	m.Histogram("histogram", labels, 3)
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"counter", "3"},
+		{"gauge", "1.5"},
+		{"histogram", `{"count":2,"sum":4,"min":1,"max":3}`},
+	} {
+		v := m.Get(tc.name, labels)
+		if v == nil {
+			t.Fatalf("%s: not found", tc.name)
+		}
+		if v.String() != tc.expected {
+			t.Fatalf("%s: unexpected value: got %s, want %s", tc.name, v.String(), tc.expected)
+		}
+	}
+	// The same expvar is reused.
+	m = amqputils.NewExpvarMetrics(name)
+	if m.Get("counter", labels) == nil {
+		t.Fatal("not reused")
+	}
+}
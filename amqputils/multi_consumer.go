@@ -50,6 +50,7 @@ func RunMultiConsumer(ctx context.Context, cg ChannelGetter, tp Topology, queue
 	c := &Consumer{
 		Processor: pr,
 		Error:     errFunc,
+		Queue:     queue,
 	}
 	mc := &MultiConsumer{
 		Count:   count,
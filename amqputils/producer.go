@@ -10,6 +10,7 @@ import (
 	"github.com/siddhant2408/golang-libraries/ctxsync"
 	"github.com/siddhant2408/golang-libraries/ctxutils"
 	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
 	"github.com/siddhant2408/golang-libraries/tracingutils"
 	"github.com/streadway/amqp"
 )
@@ -20,13 +21,23 @@ type Producer func(ctx context.Context, exchange, key string, mandatory, immedia
 // SimpleProducer is a simple producer.
 //
 // It support confirmation optionally.
+// If confirmation is enabled, the mandatory messages returned by the broker are reported as errors, see GetErrorReturn().
+// If confirmation is disabled, the returned messages are not observed, and a mandatory publishing that is not routed succeeds silently.
+//
+// If Blocked is defined, the producer observes the blocked state of the connection, according to BlockedPolicy.
 type SimpleProducer struct {
-	Channel ChannelGetter
-	Confirm bool
+	Channel       ChannelGetter
+	Confirm       bool
+	Blocked       *ConnectionBlocked
+	BlockedPolicy BlockedPolicy
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
 
 	mu    ctxsync.Mutex
-	chn   *amqp.Channel
+	chn   Channel
 	cfmCh <-chan amqp.Confirmation
+	retCh <-chan amqp.Return
 }
 
 // Produce implements Producer.
@@ -53,14 +64,33 @@ func (p *SimpleProducer) Produce(ctx context.Context, exchange, key string, mand
 		setTraceSpanTag(span, "headers", fmt.Sprint(msg.Headers))
 	}
 	setTraceSpanTagBody(span, msg.Body)
+	err = p.checkBlocked(ctx)
+	if err != nil {
+		return wrapErrorProducer(err, exchange, key, msg)
+	}
+	start := timeutils.Now()
 	err = p.produce(ctx, exchange, key, mandatory, immediate, msg)
+	observeDuration(p.Metrics, MetricPublishDuration, MetricLabels{Exchange: exchange}, start, timeutils.Now())
 	if err != nil {
-		_ = p.close()
+		if GetErrorReturn(err) == nil {
+			_ = p.close()
+		}
 		return wrapErrorProducer(err, exchange, key, msg)
 	}
 	return nil
 }
 
+func (p *SimpleProducer) checkBlocked(ctx context.Context) error {
+	if p.Blocked == nil {
+		return nil
+	}
+	err := p.Blocked.check(ctx, p.BlockedPolicy)
+	if err != nil {
+		return errors.Wrap(err, "blocked")
+	}
+	return nil
+}
+
 func (p *SimpleProducer) produce(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
 	chn, err := p.getChannel(ctx)
 	if err != nil {
@@ -72,12 +102,15 @@ func (p *SimpleProducer) produce(ctx context.Context, exchange, key string, mand
 	}
 	err = p.confirm(ctx)
 	if err != nil {
+		if ctx.Err() == nil {
+			getMetrics(p.Metrics).Counter(MetricPublishConfirmFailures, MetricLabels{Exchange: exchange}, 1)
+		}
 		return errors.Wrap(err, "confirm")
 	}
 	return nil
 }
 
-func (p *SimpleProducer) publish(ctx context.Context, chn *amqp.Channel, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (err error) {
+func (p *SimpleProducer) publish(ctx context.Context, chn Channel, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "simple_producer.publish", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
@@ -107,13 +140,19 @@ func (p *SimpleProducer) confirm(ctx context.Context) (err error) {
 		if !cfm.Ack {
 			return errors.New("negative confirmation")
 		}
+		// The broker sends the returned message before the confirmation.
+		select {
+		case ret := <-p.retCh:
+			return newReturnError(ret)
+		default:
+		}
 		return nil
 	case <-ctx.Done():
 		return errors.Wrap(ctx.Err(), "")
 	}
 }
 
-func (p *SimpleProducer) getChannel(ctx context.Context) (*amqp.Channel, error) {
+func (p *SimpleProducer) getChannel(ctx context.Context) (Channel, error) {
 	if p.chn != nil {
 		return p.chn, nil
 	}
@@ -121,26 +160,30 @@ func (p *SimpleProducer) getChannel(ctx context.Context) (*amqp.Channel, error)
 	if err != nil {
 		return nil, errors.Wrap(err, "open channel")
 	}
-	cfmCh, err := p.initConfirm(chn)
+	cfmCh, retCh, err := p.initConfirm(chn)
 	if err != nil {
 		return nil, errors.Wrap(err, "confirm")
 	}
 	p.chn = chn
 	p.cfmCh = cfmCh
+	p.retCh = retCh
 	return p.chn, nil
 }
 
-func (p *SimpleProducer) initConfirm(chn *amqp.Channel) (<-chan amqp.Confirmation, error) {
+func (p *SimpleProducer) initConfirm(chn Channel) (<-chan amqp.Confirmation, <-chan amqp.Return, error) {
 	if !p.Confirm {
-		return nil, nil
+		return nil, nil, nil
 	}
 	err := chn.Confirm(false)
 	if err != nil {
-		return nil, errors.Wrap(err, "set mode")
+		return nil, nil, errors.Wrap(err, "set mode")
 	}
 	cfmCh := make(chan amqp.Confirmation, 1)
 	chn.NotifyPublish(cfmCh)
-	return cfmCh, nil
+	// There is at most 1 returned message per publishing, and it is always read after the confirmation.
+	retCh := make(chan amqp.Return, 1)
+	chn.NotifyReturn(retCh)
+	return cfmCh, retCh, nil
 }
 
 // Close closes the SimpleProducer.
@@ -156,6 +199,7 @@ func (p *SimpleProducer) close() error {
 	chn := p.chn
 	p.chn = nil
 	p.cfmCh = nil
+	p.retCh = nil
 	if chn != nil {
 		err := chn.Close()
 		if err != nil {
@@ -364,6 +408,44 @@ func Reproduce(ctx context.Context, p Producer, exchange, key string, mandatory,
 	return p(ctx, exchange, key, mandatory, immediate, pbl)
 }
 
+func newReturnError(ret amqp.Return) error {
+	return &returnError{
+		err: errors.New("message returned"),
+		ret: ret,
+	}
+}
+
+type returnError struct {
+	err error
+	ret amqp.Return
+}
+
+func (err *returnError) AMQPReturn() *amqp.Return {
+	return &err.ret
+}
+
+func (err *returnError) WriteErrorMessage(w errors.Writer, verbose bool) bool {
+	_, _ = fmt.Fprintf(w, "AMQP return %d %s", err.ret.ReplyCode, err.ret.ReplyText)
+	return true
+}
+
+func (err *returnError) Error() string                 { return errors.Error(err) }
+func (err *returnError) Format(s fmt.State, verb rune) { errors.Format(err, s, verb) }
+func (err *returnError) Unwrap() error                 { return err.err }
+
+// GetErrorReturn returns the returned message associated to the error.
+//
+// It allows to know the reply code and text of a mandatory message that could not be routed.
+// If the error is not caused by a returned message, it returns nil.
+func GetErrorReturn(err error) *amqp.Return {
+	var werr *returnError
+	ok := errors.As(err, &werr)
+	if ok {
+		return werr.AMQPReturn()
+	}
+	return nil
+}
+
 func wrapErrorProducer(err error, exchange string, key string, msg amqp.Publishing) error {
 	if exchange != "" {
 		err = wrapErrorValue(err, "exchange", exchange)
@@ -65,7 +65,7 @@ func TestSimpleProducerErrorLock(t *testing.T) {
 
 func TestSimpleProducerErrorOpenChannel(t *testing.T) {
 	p := &amqputils.SimpleProducer{
-		Channel: func(context.Context) (*amqp.Channel, error) {
+		Channel: func(context.Context) (amqputils.Channel, error) {
 			return nil, errors.New("error")
 		},
 	}
@@ -303,3 +303,124 @@ func TestReproduce(t *testing.T) {
 	}
 	pCalled.AssertCalled(t)
 }
+
+func TestSimpleProducerConfirmBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	chn := b.Channel()
+	_, err := chn.QueueDeclare("test", false, false, false, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p := &amqputils.SimpleProducer{
+		Channel: b.ChannelGetter(),
+		Confirm: true,
+	}
+	defer p.Close() //nolint:errcheck
+	for i := 0; i < 2; i++ {
+		err = p.Produce(ctx, "", "test", false, false, amqp.Publishing{})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	if n := b.QueueMessages("test"); n != 2 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 2)
+	}
+}
+
+func TestSimpleProducerReturn(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	p := &amqputils.SimpleProducer{
+		Channel: b.ChannelGetter(),
+		Confirm: true,
+	}
+	defer p.Close() //nolint:errcheck
+	err := p.Produce(ctx, "amq.direct", "test", true, false, amqp.Publishing{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	ret := amqputils.GetErrorReturn(err)
+	if ret == nil {
+		t.Fatal("no return")
+	}
+	if ret.ReplyCode != amqp.NoRoute {
+		t.Fatalf("unexpected reply code: got %d, want %d", ret.ReplyCode, amqp.NoRoute)
+	}
+	err = p.Produce(ctx, "amq.direct", "test", false, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestSimpleProducerBlockedWait(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	blk := new(amqputils.ConnectionBlocked)
+	blk.Set(amqp.Blocking{
+		Active: true,
+		Reason: "low on memory",
+	})
+	p := &amqputils.SimpleProducer{
+		Channel: b.ChannelGetter(),
+		Blocked: blk,
+	}
+	defer p.Close() //nolint:errcheck
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		blk.Set(amqp.Blocking{})
+	}()
+	err := p.Produce(ctx, "amq.direct", "test", false, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestSimpleProducerBlockedWaitErrorContext(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	b := amqptest.NewBroker(t)
+	blk := new(amqputils.ConnectionBlocked)
+	blk.Set(amqp.Blocking{
+		Active: true,
+		Reason: "low on memory",
+	})
+	p := &amqputils.SimpleProducer{
+		Channel: b.ChannelGetter(),
+		Blocked: blk,
+	}
+	defer p.Close() //nolint:errcheck
+	err := p.Produce(ctx, "amq.direct", "test", false, false, amqp.Publishing{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestSimpleProducerBlockedFail(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	blk := new(amqputils.ConnectionBlocked)
+	blk.Set(amqp.Blocking{
+		Active: true,
+		Reason: "low on memory",
+	})
+	p := &amqputils.SimpleProducer{
+		Channel:       b.ChannelGetter(),
+		Blocked:       blk,
+		BlockedPolicy: amqputils.BlockedPolicyFail,
+	}
+	defer p.Close() //nolint:errcheck
+	err := p.Produce(ctx, "amq.direct", "test", false, false, amqp.Publishing{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !errors.IsTemporary(err) {
+		t.Fatal("not temporary")
+	}
+	blk.Set(amqp.Blocking{})
+	err = p.Produce(ctx, "amq.direct", "test", false, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
@@ -35,12 +35,12 @@ func (r *Reader) Read(ctx context.Context) error {
 	return nil
 }
 
-// ReaderStart starts to consume messages on an amqp.Channel.
-type ReaderStart func(context.Context, *amqp.Channel) (<-chan amqp.Delivery, error)
+// ReaderStart starts to consume messages on a Channel.
+type ReaderStart func(context.Context, Channel) (<-chan amqp.Delivery, error)
 
 // NewReaderStartQueue returns a new ReaderStart for a queue.
 func NewReaderStartQueue(queue string) ReaderStart {
-	return func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+	return func(ctx context.Context, chn Channel) (<-chan amqp.Delivery, error) {
 		ch, err := chn.Consume(queue, "", false, false, false, false, nil)
 		if err != nil {
 			return nil, errors.Wrap(err, "consume")
@@ -54,7 +54,7 @@ func NewReaderStartQueue(queue string) ReaderStart {
 // It initializes the topology + the prefetch.
 func NewReaderStartConsumer(tp Topology, queue string, prefetch int) ReaderStart {
 	startQueue := NewReaderStartQueue(queue)
-	return func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+	return func(ctx context.Context, chn Channel) (<-chan amqp.Delivery, error) {
 		err := tp.Init(ctx, chn)
 		if err != nil {
 			return nil, errors.Wrap(err, "topology")
@@ -18,7 +18,7 @@ func TestReader(t *testing.T) {
 	conn := amqptest.NewConnection(t, testVhost)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, nil
 		},
 		Consume: func(ctx context.Context, chn <-chan amqp.Delivery) error {
@@ -35,7 +35,7 @@ func TestReader(t *testing.T) {
 func TestReaderErrorChannel(t *testing.T) {
 	ctx := context.Background()
 	r := &amqputils.Reader{
-		Channel: func(ctx context.Context) (*amqp.Channel, error) {
+		Channel: func(ctx context.Context) (amqputils.Channel, error) {
 			return nil, errors.New("error")
 		},
 	}
@@ -50,7 +50,7 @@ func TestReaderErrorConsume(t *testing.T) {
 	conn := amqptest.NewConnection(t, testVhost)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, errors.New("error")
 		},
 	}
@@ -65,7 +65,7 @@ func TestReaderErrorProcess(t *testing.T) {
 	conn := amqptest.NewConnection(t, testVhost)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, nil
 		},
 		Consume: func(ctx context.Context, chn <-chan amqp.Delivery) error {
@@ -104,7 +104,7 @@ func TestRunReader(t *testing.T) {
 	conn := amqptest.NewConnection(t, testVhost)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, nil
 		},
 		Consume: func(ctx context.Context, chn <-chan amqp.Delivery) error {
@@ -121,7 +121,7 @@ func TestRunReaderError(t *testing.T) {
 	conn := amqptest.NewConnection(t, testVhost)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, nil
 		},
 		Consume: func(ctx context.Context, chn <-chan amqp.Delivery) error {
@@ -142,7 +142,7 @@ func TestRunReaders(t *testing.T) {
 	wg.Add(count)
 	r := &amqputils.Reader{
 		Channel: amqputils.NewChannelGetterConnection(conn),
-		Start: func(ctx context.Context, chn *amqp.Channel) (<-chan amqp.Delivery, error) {
+		Start: func(ctx context.Context, chn amqputils.Channel) (<-chan amqp.Delivery, error) {
 			return nil, nil
 		},
 		Consume: func(ctx context.Context, chn <-chan amqp.Delivery) error {
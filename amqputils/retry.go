@@ -21,6 +21,9 @@ type Retryer struct {
 	Delay    time.Duration
 	Exchange string
 	Key      string
+	// Metrics is optional.
+	// The default value is DefaultMetrics.
+	Metrics Metrics
 }
 
 // Retry retries a message.
@@ -59,6 +62,9 @@ func (r *Retryer) Retry(ctx context.Context, dlv amqp.Delivery) error {
 	if err != nil {
 		return errors.Wrap(err, "produce")
 	}
+	getMetrics(r.Metrics).Counter(MetricDeliveriesRetried, MetricLabels{
+		Exchange: dlv.Exchange,
+	}, 1)
 	err = errors.New("retry")
 	err = errors.Ignore(err)
 	err = ErrorWithAcknowledger(err, Ack)
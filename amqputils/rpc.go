@@ -0,0 +1,313 @@
+package amqputils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+
+	opentracing_ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/siddhant2408/golang-libraries/ctxsync"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+	"github.com/streadway/amqp"
+)
+
+// RPCReplyTo is the RabbitMQ direct reply-to pseudo-queue.
+//
+// See https://www.rabbitmq.com/direct-reply-to.html .
+const RPCReplyTo = "amq.rabbitmq.reply-to"
+
+const (
+	rpcHeaderError          = "rpc-error"
+	rpcHeaderErrorTemporary = "rpc-error-temporary"
+)
+
+// RPCClient is a request/reply client.
+//
+// It uses the RabbitMQ direct reply-to pseudo-queue.
+// All the calls share the same AMQP channel, and can run concurrently.
+// If the channel is closed, the pending calls return an error, and a new channel is opened by the next call.
+type RPCClient struct {
+	Channel ChannelGetter
+
+	mu  ctxsync.Mutex
+	chn Channel
+
+	callsMu sync.Mutex
+	calls   map[string]*rpcCall
+}
+
+type rpcCall struct {
+	chn   Channel
+	reply chan amqp.Delivery
+}
+
+// Call sends a request and waits for the reply.
+//
+// The CorrelationId and ReplyTo fields of the Publishing are overwritten.
+// If the context has a deadline, it is used as the message expiration.
+//
+// If the server replied with an error, it is returned with the reply.
+func (c *RPCClient) Call(ctx context.Context, exchange, key string, msg amqp.Publishing) (_ amqp.Delivery, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "rpc_client", &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.AppTypeRPC)
+	opentracing_ext.SpanKindRPCClient.Set(span)
+	if exchange != "" {
+		setTraceSpanTag(span, "exchange", exchange)
+	}
+	if key != "" {
+		setTraceSpanTag(span, "routing_key", key)
+	}
+	setTraceSpanTagBody(span, msg.Body)
+	dlv, err := c.call(ctx, exchange, key, msg)
+	if err != nil {
+		return dlv, wrapErrorProducer(err, exchange, key, msg)
+	}
+	return dlv, nil
+}
+
+func (c *RPCClient) call(ctx context.Context, exchange, key string, msg amqp.Publishing) (amqp.Delivery, error) {
+	id, err := newRPCCorrelationID()
+	if err != nil {
+		return amqp.Delivery{}, errors.Wrap(err, "correlation ID")
+	}
+	msg.CorrelationId = id
+	msg.ReplyTo = RPCReplyTo
+	dl, ok := ctx.Deadline()
+	if ok {
+		exp := timeutils.Until(dl).Milliseconds()
+		if exp <= 0 {
+			return amqp.Delivery{}, errors.Wrap(context.DeadlineExceeded, "")
+		}
+		msg.Expiration = strconv.FormatInt(exp, 10)
+	}
+	reply := make(chan amqp.Delivery, 1)
+	defer c.removeCall(id)
+	err = c.publish(ctx, exchange, key, msg, id, reply)
+	if err != nil {
+		return amqp.Delivery{}, errors.Wrap(err, "publish")
+	}
+	dlv, err := c.wait(ctx, reply)
+	if err != nil {
+		err = wrapErrorValue(err, "correlation_id", id)
+		return dlv, errors.Wrap(err, "wait")
+	}
+	return dlv, nil
+}
+
+func (c *RPCClient) publish(ctx context.Context, exchange, key string, msg amqp.Publishing, id string, reply chan amqp.Delivery) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "rpc_client.publish", &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageProducer)
+	opentracing_ext.SpanKindProducer.Set(span)
+	err = tracingutils.TraceSyncLockerCtx(ctx, &c.mu)
+	if err != nil {
+		return errors.Wrap(err, "lock")
+	}
+	defer c.mu.Unlock()
+	chn, err := c.getChannel(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get channel")
+	}
+	// The call must be registered before the publication, because the reply can be received before Publish() returns.
+	c.addCall(id, &rpcCall{
+		chn:   chn,
+		reply: reply,
+	})
+	err = chn.Publish(exchange, key, false, false, msg)
+	if err != nil {
+		_ = c.close()
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+func (c *RPCClient) wait(ctx context.Context, reply <-chan amqp.Delivery) (amqp.Delivery, error) {
+	select {
+	case dlv, ok := <-reply:
+		if !ok {
+			return amqp.Delivery{}, errors.New("channel closed")
+		}
+		err := GetRPCReplyError(dlv)
+		if err != nil {
+			return dlv, errors.Wrap(err, "reply")
+		}
+		return dlv, nil
+	case <-ctx.Done():
+		return amqp.Delivery{}, errors.Wrap(ctx.Err(), "")
+	}
+}
+
+func (c *RPCClient) getChannel(ctx context.Context) (Channel, error) {
+	if c.chn != nil {
+		return c.chn, nil
+	}
+	chn, err := c.Channel(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "open channel")
+	}
+	// The direct reply-to pseudo-queue must be consumed in no-ack mode, on the same channel used to publish.
+	dlvs, err := chn.Consume(RPCReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		_ = chn.Close()
+		return nil, errors.Wrap(err, "consume")
+	}
+	c.chn = chn
+	go c.dispatch(chn, dlvs)
+	return chn, nil
+}
+
+func (c *RPCClient) dispatch(chn Channel, dlvs <-chan amqp.Delivery) {
+	for dlv := range dlvs {
+		c.callsMu.Lock()
+		call, ok := c.calls[dlv.CorrelationId]
+		if ok {
+			delete(c.calls, dlv.CorrelationId)
+			call.reply <- dlv // The channel is buffered, and receives a single reply.
+		}
+		c.callsMu.Unlock()
+	}
+	c.mu.Lock()
+	if c.chn == chn {
+		c.chn = nil
+	}
+	c.mu.Unlock()
+	// The replies for the pending calls on this channel are lost.
+	c.callsMu.Lock()
+	for id, call := range c.calls {
+		if call.chn == chn {
+			delete(c.calls, id)
+			close(call.reply)
+		}
+	}
+	c.callsMu.Unlock()
+}
+
+func (c *RPCClient) addCall(id string, call *rpcCall) {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]*rpcCall)
+	}
+	c.calls[id] = call
+}
+
+func (c *RPCClient) removeCall(id string) {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+	delete(c.calls, id)
+}
+
+// Close closes the RPCClient.
+//
+// It closes the underlying channel.
+// It is OK to reuse the RPCClient after this call.
+func (c *RPCClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.close()
+}
+
+func (c *RPCClient) close() error {
+	chn := c.chn
+	c.chn = nil
+	if chn != nil {
+		err := chn.Close()
+		if err != nil {
+			return errors.Wrap(err, "close channel")
+		}
+	}
+	return nil
+}
+
+const rpcCorrelationIDByteCount = 16
+
+func newRPCCorrelationID() (string, error) {
+	var buf [rpcCorrelationIDByteCount]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// RPCHandler handles a request and returns the reply.
+type RPCHandler func(context.Context, amqp.Delivery) (amqp.Publishing, error)
+
+// RPCServer replies to requests sent by RPCClient.
+type RPCServer struct {
+	Handler  RPCHandler
+	Producer Producer
+}
+
+// Process implements ConsumerProcessor.
+//
+// It calls the handler and produces the reply to the queue defined by the ReplyTo field of the request.
+// If the handler returns an error, it is encoded in the reply headers, and returned with the Ack acknowledger.
+// If the request doesn't have a ReplyTo field, a not temporary error is returned.
+func (s *RPCServer) Process(ctx context.Context, dlv amqp.Delivery) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "rpc_server", &err)
+	defer spanFinish()
+	tracingutils.SetSpanType(span, tracingutils.AppTypeRPC)
+	opentracing_ext.SpanKindRPCServer.Set(span)
+	if dlv.CorrelationId != "" {
+		setTraceSpanTag(span, "correlation_id", dlv.CorrelationId)
+	}
+	if dlv.ReplyTo == "" {
+		err = errors.New("missing reply to")
+		err = errors.WithTemporary(err, false)
+		return err
+	}
+	pbl, hErr := s.handle(ctx, dlv)
+	if hErr != nil {
+		pbl = newRPCErrorPublishing(hErr)
+	}
+	pbl.CorrelationId = dlv.CorrelationId
+	err = s.Producer(ctx, "", dlv.ReplyTo, false, false, pbl)
+	if err != nil {
+		return errors.Wrap(err, "reply")
+	}
+	if hErr != nil {
+		// The error has been sent to the client, so the request must not be processed again.
+		err = ErrorWithAcknowledger(hErr, Ack)
+		return errors.Wrap(err, "handler")
+	}
+	return nil
+}
+
+func (s *RPCServer) handle(ctx context.Context, dlv amqp.Delivery) (_ amqp.Publishing, err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "rpc_server.handle", &err)
+	defer spanFinish()
+	return s.Handler(ctx, dlv)
+}
+
+func newRPCErrorPublishing(err error) amqp.Publishing {
+	return amqp.Publishing{
+		Headers: amqp.Table{
+			rpcHeaderError:          err.Error(),
+			rpcHeaderErrorTemporary: errors.IsTemporary(err),
+		},
+	}
+}
+
+// GetRPCReplyError returns the error encoded in the headers of a reply.
+//
+// It returns nil if the reply doesn't contain an error.
+func GetRPCReplyError(dlv amqp.Delivery) error {
+	msg, ok := dlv.Headers[rpcHeaderError].(string)
+	if !ok {
+		return nil
+	}
+	err := errors.New(msg)
+	tmp, ok := dlv.Headers[rpcHeaderErrorTemporary].(bool)
+	if ok {
+		err = errors.WithTemporary(err, tmp)
+	}
+	return errors.Wrap(err, "RPC server")
+}
@@ -0,0 +1,240 @@
+package amqputils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/amqptest"
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestRPC(t *testing.T) {
+	ctx := context.Background()
+	cm := amqptest.NewConnectionManager(t, testVhost)
+	queue := "test_rpc"
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name:       queue,
+				AutoDelete: true,
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, cm.Channel, tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p := &amqputils.SimpleProducer{
+		Channel: cm.Channel,
+	}
+	defer p.Close() //nolint:errcheck
+	s := &amqputils.RPCServer{
+		Handler: func(ctx context.Context, dlv amqp.Delivery) (amqp.Publishing, error) {
+			return amqp.Publishing{
+				Body: append([]byte("reply "), dlv.Body...),
+			}, nil
+		},
+		Producer: p.Produce,
+	}
+	srvCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go amqputils.RunConsumer(srvCtx, cm.Channel, tp, queue, s.Process, 1, func(ctx context.Context, err error) {
+		testutils.ErrorErr(t, err)
+	})
+	c := &amqputils.RPCClient{
+		Channel: cm.Channel,
+	}
+	defer c.Close() //nolint:errcheck
+	callCtx, callCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer callCancel()
+	dlv, err := c.Call(callCtx, "", queue, amqp.Publishing{
+		Body: []byte("test"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(dlv.Body) != "reply test" {
+		t.Fatalf("unexpected body: got %q, want %q", dlv.Body, "reply test")
+	}
+}
+
+func TestRPCClientErrorGetChannel(t *testing.T) {
+	ctx := context.Background()
+	c := &amqputils.RPCClient{
+		Channel: func(ctx context.Context) (amqputils.Channel, error) {
+			return nil, errors.New("error")
+		},
+	}
+	_, err := c.Call(ctx, "", "test", amqp.Publishing{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestRPCClientErrorDeadlineExceeded(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(-1*time.Second))
+	defer cancel()
+	c := &amqputils.RPCClient{}
+	_, err := c.Call(ctx, "", "test", amqp.Publishing{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRPCServer(t *testing.T) {
+	ctx := context.Background()
+	var pCalled testutils.CallCounter
+	s := &amqputils.RPCServer{
+		Handler: func(ctx context.Context, dlv amqp.Delivery) (amqp.Publishing, error) {
+			return amqp.Publishing{
+				Body: []byte("reply"),
+			}, nil
+		},
+		Producer: func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			pCalled.Call()
+			if key != "reply_to" {
+				t.Fatalf("unexpected key: got %q, want %q", key, "reply_to")
+			}
+			if msg.CorrelationId != "id" {
+				t.Fatalf("unexpected correlation ID: got %q, want %q", msg.CorrelationId, "id")
+			}
+			return nil
+		},
+	}
+	err := s.Process(ctx, amqp.Delivery{
+		CorrelationId: "id",
+		ReplyTo:       "reply_to",
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	pCalled.AssertCalled(t)
+}
+
+func TestRPCServerErrorHandler(t *testing.T) {
+	ctx := context.Background()
+	var pbl amqp.Publishing
+	s := &amqputils.RPCServer{
+		Handler: func(ctx context.Context, dlv amqp.Delivery) (amqp.Publishing, error) {
+			err := errors.New("error")
+			err = errors.WithTemporary(err, false)
+			return amqp.Publishing{}, err
+		},
+		Producer: func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			pbl = msg
+			return nil
+		},
+	}
+	err := s.Process(ctx, amqp.Delivery{
+		CorrelationId: "id",
+		ReplyTo:       "reply_to",
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	ack := amqputils.GetErrorAcknowledger(err)
+	if ack != amqputils.Ack {
+		t.Fatalf("unexpected acknowledger: got %v, want %v", ack, amqputils.Ack)
+	}
+	replyErr := amqputils.GetRPCReplyError(amqp.Delivery{
+		Headers: pbl.Headers,
+	})
+	if replyErr == nil {
+		t.Fatal("no reply error")
+	}
+	if errors.IsTemporary(replyErr) {
+		t.Fatal("temporary")
+	}
+}
+
+func TestRPCServerErrorMissingReplyTo(t *testing.T) {
+	ctx := context.Background()
+	s := &amqputils.RPCServer{}
+	err := s.Process(ctx, amqp.Delivery{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if errors.IsTemporary(err) {
+		t.Fatal("temporary")
+	}
+}
+
+func TestRPCServerErrorProducer(t *testing.T) {
+	ctx := context.Background()
+	s := &amqputils.RPCServer{
+		Handler: func(ctx context.Context, dlv amqp.Delivery) (amqp.Publishing, error) {
+			return amqp.Publishing{}, nil
+		},
+		Producer: func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			return errors.New("error")
+		},
+	}
+	err := s.Process(ctx, amqp.Delivery{
+		ReplyTo: "reply_to",
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestGetRPCReplyErrorNil(t *testing.T) {
+	err := amqputils.GetRPCReplyError(amqp.Delivery{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestRPCBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	cg := b.ChannelGetter()
+	queue := "test_rpc"
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name: queue,
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, cg, tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	p := &amqputils.SimpleProducer{
+		Channel: cg,
+	}
+	defer p.Close() //nolint:errcheck
+	s := &amqputils.RPCServer{
+		Handler: func(ctx context.Context, dlv amqp.Delivery) (amqp.Publishing, error) {
+			return amqp.Publishing{
+				Body: append([]byte("reply "), dlv.Body...),
+			}, nil
+		},
+		Producer: p.Produce,
+	}
+	srvCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go amqputils.RunConsumer(srvCtx, cg, tp, queue, s.Process, 1, func(ctx context.Context, err error) {
+		testutils.ErrorErr(t, err)
+	})
+	c := &amqputils.RPCClient{
+		Channel: cg,
+	}
+	defer c.Close() //nolint:errcheck
+	callCtx, callCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer callCancel()
+	dlv, err := c.Call(callCtx, "", queue, amqp.Publishing{
+		Body: []byte("test"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(dlv.Body) != "reply test" {
+		t.Fatalf("unexpected body: got %q, want %q", dlv.Body, "reply test")
+	}
+}
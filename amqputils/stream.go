@@ -0,0 +1,219 @@
+package amqputils
+
+import (
+	"context"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+)
+
+// Stream offset specifications, for StreamConsumer.Offset.
+const (
+	StreamOffsetFirst = "first"
+	StreamOffsetLast  = "last"
+	StreamOffsetNext  = "next"
+)
+
+const streamOffsetArgument = "x-stream-offset"
+
+// StreamOffsetStore stores the offset of the last processed message of stream consumers.
+type StreamOffsetStore interface {
+	// Load returns the stored offset.
+	// ok is false if there is no stored offset.
+	Load(ctx context.Context, name string) (offset int64, ok bool, err error)
+	// Save stores an offset.
+	Save(ctx context.Context, name string, offset int64) error
+}
+
+// StreamConsumer consumes a stream queue, and resumes from the last processed message.
+//
+// It is used with Reader and Consumer:
+//   - Start is a ReaderStart, it consumes from the offset following the stored offset
+//   - Processor wraps a ConsumerProcessor, it stores the offset after each message
+//
+// If the processing returns a temporary error, the offset is not stored, and the Consumer is stopped.
+// The Reader restarts, and the message is delivered again.
+// Other errors are handled like with a classic queue, except that the messages are not removed from the stream.
+//
+// See RunStreamConsumer().
+type StreamConsumer struct {
+	Topology Topology
+	Queue    string
+	Prefetch int
+	Store    StreamOffsetStore
+	// Name identifies the consumer in the Store.
+	// The default value is Queue.
+	Name string
+	// Offset is used if there is no stored offset.
+	// It is StreamOffsetFirst, StreamOffsetLast, StreamOffsetNext, an int64 offset or a time.Time timestamp.
+	// The default value is StreamOffsetNext.
+	Offset interface{}
+}
+
+// Start implements ReaderStart.
+//
+// It initializes the topology + the prefetch.
+// The prefetch is required by the broker for stream queues.
+func (sc *StreamConsumer) Start(ctx context.Context, chn Channel) (<-chan amqp.Delivery, error) {
+	err := sc.Topology.Init(ctx, chn)
+	if err != nil {
+		return nil, errors.Wrap(err, "topology")
+	}
+	err = chn.Qos(sc.Prefetch, 0, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "set QOS")
+	}
+	offset, err := sc.getOffset(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "offset")
+	}
+	ch, err := chn.Consume(sc.Queue, "", false, false, false, false, amqp.Table{
+		streamOffsetArgument: offset,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "consume")
+	}
+	return ch, nil
+}
+
+func (sc *StreamConsumer) getOffset(ctx context.Context) (interface{}, error) {
+	offset, ok, err := sc.load(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "load")
+	}
+	if ok {
+		return offset + 1, nil
+	}
+	if sc.Offset != nil {
+		return sc.Offset, nil
+	}
+	return StreamOffsetNext, nil
+}
+
+func (sc *StreamConsumer) load(ctx context.Context) (offset int64, ok bool, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "stream_consumer.load", &err)
+	defer spanFinish()
+	name := sc.getName()
+	setTraceSpanTag(span, "stream.name", name)
+	offset, ok, err = sc.Store.Load(ctx, name)
+	if err != nil {
+		err = wrapErrorValue(err, "stream.name", name)
+		return 0, false, err
+	}
+	return offset, ok, nil
+}
+
+func (sc *StreamConsumer) save(ctx context.Context, offset int64) (err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "stream_consumer.save", &err)
+	defer spanFinish()
+	name := sc.getName()
+	setTraceSpanTag(span, "stream.name", name)
+	setTraceSpanTag(span, "stream.offset", offset)
+	err = sc.Store.Save(ctx, name, offset)
+	if err != nil {
+		err = wrapErrorValue(err, "stream.name", name)
+		err = wrapErrorValue(err, "stream.offset", offset)
+		return err
+	}
+	return nil
+}
+
+func (sc *StreamConsumer) getName() string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	return sc.Queue
+}
+
+// Processor returns a ConsumerProcessor that stores the offset of the processed messages.
+func (sc *StreamConsumer) Processor(pr ConsumerProcessor) ConsumerProcessor {
+	return func(ctx context.Context, dlv amqp.Delivery) error {
+		offset, ok := GetStreamOffset(dlv)
+		if !ok {
+			return errors.New("missing stream offset")
+		}
+		err := pr(ctx, dlv)
+		if err != nil && GetErrorAcknowledger(err) == nil && errors.IsTemporary(err) {
+			return ErrorWithAcknowledger(err, streamRestart)
+		}
+		serr := sc.save(ctx, offset)
+		if serr != nil {
+			serr = errors.Wrap(serr, "save offset")
+			if err != nil {
+				serr = errors.Wrapf(serr, "processor error: %v", err)
+			}
+			return ErrorWithAcknowledger(serr, streamRestart)
+		}
+		return err
+	}
+}
+
+// streamRestart is an Acknowledger that stops the Consumer.
+// The Reader restarts from the stored offset, so the message is delivered again.
+const streamRestart = streamRestartAcknowledger("stream restart")
+
+type streamRestartAcknowledger string
+
+func (a streamRestartAcknowledger) Acknowledge(msg Delivery) error {
+	return errors.New(string(a))
+}
+
+func (a streamRestartAcknowledger) String() string {
+	return string(a)
+}
+
+// GetStreamOffset returns the offset of a message delivered from a stream queue.
+func GetStreamOffset(dlv amqp.Delivery) (int64, bool) {
+	switch v := dlv.Headers[streamOffsetArgument].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// MemoryStreamOffsetStore is an in-memory StreamOffsetStore.
+//
+// It is only useful for tests, or if the consumer can restart from StreamConsumer.Offset after the process is restarted.
+type MemoryStreamOffsetStore struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// Load implements StreamOffsetStore.
+func (s *MemoryStreamOffsetStore) Load(ctx context.Context, name string) (offset int64, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok = s.offsets[name]
+	return offset, ok, nil
+}
+
+// Save implements StreamOffsetStore.
+func (s *MemoryStreamOffsetStore) Save(ctx context.Context, name string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offsets == nil {
+		s.offsets = make(map[string]int64)
+	}
+	s.offsets[name] = offset
+	return nil
+}
+
+// RunStreamConsumer runs a single consumer on a stream queue.
+func RunStreamConsumer(ctx context.Context, cg ChannelGetter, sc *StreamConsumer, pr ConsumerProcessor, errFunc func(context.Context, error)) {
+	c := &Consumer{
+		Processor: sc.Processor(pr),
+		Error:     errFunc,
+		Queue:     sc.Queue,
+	}
+	r := &Reader{
+		Channel: cg,
+		Start:   sc.Start,
+		Consume: c.Consume,
+	}
+	RunReader(ctx, r, errFunc)
+}
@@ -0,0 +1,181 @@
+package amqputils_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/amqptest"
+	"github.com/siddhant2408/golang-libraries/amqputils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+var testStreamTopology = amqputils.Topology{
+	Queues: []amqputils.QueueConfig{
+		{
+			Name:    "test",
+			Type:    amqputils.QueueTypeStream,
+			Durable: true,
+			Stream: &amqputils.StreamQueueConfig{
+				MaxAge: "7D",
+			},
+		},
+	},
+}
+
+func publishTestStream(ctx context.Context, tb testing.TB, b *amqptest.Broker, start, count int) {
+	tb.Helper()
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), testStreamTopology)
+	if err != nil {
+		testutils.FatalErr(tb, err)
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	for i := start; i < start+count; i++ {
+		err := chn.Publish("", "test", false, false, amqp.Publishing{
+			Body: []byte(fmt.Sprint(i)),
+		})
+		if err != nil {
+			testutils.FatalErr(tb, err)
+		}
+	}
+}
+
+func runTestStreamConsumer(ctx context.Context, tb testing.TB, b *amqptest.Broker, sc *amqputils.StreamConsumer, count int, pr amqputils.ConsumerProcessor) []string {
+	tb.Helper()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var bodies []string
+	p := func(ctx context.Context, dlv amqp.Delivery) error {
+		err := pr(ctx, dlv)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, string(dlv.Body))
+		if len(bodies) == count {
+			cancel()
+		}
+		return nil
+	}
+	errFunc := func(ctx context.Context, err error) {}
+	amqputils.RunStreamConsumer(ctx, b.ChannelGetter(), sc, p, errFunc)
+	return bodies
+}
+
+func TestRunStreamConsumerBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	publishTestStream(ctx, t, b, 0, 3)
+	store := new(amqputils.MemoryStreamOffsetStore)
+	sc := &amqputils.StreamConsumer{
+		Topology: testStreamTopology,
+		Queue:    "test",
+		Prefetch: 10,
+		Store:    store,
+		Offset:   amqputils.StreamOffsetFirst,
+	}
+	pr := func(ctx context.Context, dlv amqp.Delivery) error {
+		return nil
+	}
+	bodies := runTestStreamConsumer(ctx, t, b, sc, 3, pr)
+	testutils.Compare(t, "unexpected bodies", bodies, []string{"0", "1", "2"})
+	offset, ok, err := store.Load(ctx, "test")
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !ok || offset != 2 {
+		t.Fatalf("unexpected offset: got %d (%t), want 2", offset, ok)
+	}
+	// The consumer resumes from the stored offset.
+	publishTestStream(ctx, t, b, 3, 2)
+	bodies = runTestStreamConsumer(ctx, t, b, sc, 2, pr)
+	testutils.Compare(t, "unexpected bodies", bodies, []string{"3", "4"})
+}
+
+func TestRunStreamConsumerBrokerTemporaryError(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	publishTestStream(ctx, t, b, 0, 3)
+	sc := &amqputils.StreamConsumer{
+		Topology: testStreamTopology,
+		Queue:    "test",
+		Prefetch: 10,
+		Store:    new(amqputils.MemoryStreamOffsetStore),
+		Offset:   amqputils.StreamOffsetFirst,
+	}
+	failed := false
+	pr := func(ctx context.Context, dlv amqp.Delivery) error {
+		if string(dlv.Body) == "1" && !failed {
+			failed = true
+			return errors.WithTemporary(errors.New("error"), true)
+		}
+		return nil
+	}
+	bodies := runTestStreamConsumer(ctx, t, b, sc, 3, pr)
+	testutils.Compare(t, "unexpected bodies", bodies, []string{"0", "1", "2"})
+}
+
+func TestStreamConsumerStartOffset(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	publishTestStream(ctx, t, b, 0, 3)
+	sc := &amqputils.StreamConsumer{
+		Topology: testStreamTopology,
+		Queue:    "test",
+		Prefetch: 10,
+		Store:    new(amqputils.MemoryStreamOffsetStore),
+		Offset:   int64(1),
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	ch, err := sc.Start(ctx, chn)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	dlv := <-ch
+	offset, ok := amqputils.GetStreamOffset(dlv)
+	if !ok || offset != 1 {
+		t.Fatalf("unexpected offset: got %d (%t), want 1", offset, ok)
+	}
+	if string(dlv.Body) != "1" {
+		t.Fatalf("unexpected body: got %q, want %q", dlv.Body, "1")
+	}
+}
+
+func TestInitTopologyQuorumBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	tp := amqputils.Topology{
+		Queues: []amqputils.QueueConfig{
+			{
+				Name:    "test",
+				Type:    amqputils.QueueTypeQuorum,
+				Durable: true,
+				Arguments: amqp.Table{
+					"x-overflow": "reject-publish",
+				},
+				Quorum: &amqputils.QuorumQueueConfig{
+					DeliveryLimit:      5,
+					DeadLetterStrategy: amqputils.DeadLetterStrategyAtLeastOnce,
+				},
+			},
+		},
+	}
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), tp)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn := b.Channel()
+	defer chn.Close() //nolint:errcheck
+	_, err = chn.QueueDeclare("test", true, false, false, false, amqp.Table{
+		"x-queue-type":           "quorum",
+		"x-overflow":             "reject-publish",
+		"x-delivery-limit":       int64(5),
+		"x-dead-letter-strategy": "at-least-once",
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
@@ -21,12 +21,12 @@ func InitTopology(ctx context.Context, cg ChannelGetter, tp Topology) error {
 
 // Topology represents an exchanges+queues topology.
 type Topology struct {
-	Exchanges []ExchangeConfig
-	Queues    []QueueConfig
+	Exchanges []ExchangeConfig `json:"exchanges,omitempty" yaml:"exchanges,omitempty"`
+	Queues    []QueueConfig    `json:"queues,omitempty" yaml:"queues,omitempty"`
 }
 
 // Init initializes the topology.
-func (tp Topology) Init(ctx context.Context, chn *amqp.Channel) error {
+func (tp Topology) Init(ctx context.Context, chn Channel) error {
 	for _, ec := range tp.Exchanges {
 		err := ec.init(ctx, chn)
 		if err != nil {
@@ -48,16 +48,16 @@ func (tp Topology) Init(ctx context.Context, chn *amqp.Channel) error {
 
 // ExchangeConfig represents an exchange config.
 type ExchangeConfig struct {
-	Name       string
-	Type       string
-	Durable    bool
-	AutoDelete bool
-	Internal   bool
-	Arguments  amqp.Table
-	Bindings   []ExchangeBinding
+	Name       string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Type       string            `json:"type,omitempty" yaml:"type,omitempty"`
+	Durable    bool              `json:"durable,omitempty" yaml:"durable,omitempty"`
+	AutoDelete bool              `json:"auto_delete,omitempty" yaml:"auto_delete,omitempty"`
+	Internal   bool              `json:"internal,omitempty" yaml:"internal,omitempty"`
+	Arguments  amqp.Table        `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Bindings   []ExchangeBinding `json:"bindings,omitempty" yaml:"bindings,omitempty"`
 }
 
-func (ec ExchangeConfig) init(ctx context.Context, chn *amqp.Channel) error {
+func (ec ExchangeConfig) init(ctx context.Context, chn Channel) error {
 	err := ec.declare(ctx, chn)
 	if err != nil {
 		return errors.Wrap(err, "declare")
@@ -71,7 +71,7 @@ func (ec ExchangeConfig) init(ctx context.Context, chn *amqp.Channel) error {
 	return nil
 }
 
-func (ec ExchangeConfig) declare(ctx context.Context, chn *amqp.Channel) (err error) {
+func (ec ExchangeConfig) declare(ctx context.Context, chn Channel) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "exchange_declare", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
@@ -87,12 +87,12 @@ func (ec ExchangeConfig) declare(ctx context.Context, chn *amqp.Channel) (err er
 
 // ExchangeBinding represents an exchange binding.
 type ExchangeBinding struct {
-	RoutingKey string
-	Source     string
-	Arguments  amqp.Table
+	RoutingKey string     `json:"routing_key,omitempty" yaml:"routing_key,omitempty"`
+	Source     string     `json:"source,omitempty" yaml:"source,omitempty"`
+	Arguments  amqp.Table `json:"arguments,omitempty" yaml:"arguments,omitempty"`
 }
 
-func (eb ExchangeBinding) bind(ctx context.Context, chn *amqp.Channel, name string) (err error) {
+func (eb ExchangeBinding) bind(ctx context.Context, chn Channel, name string) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "exchange_bind", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
@@ -116,16 +116,106 @@ func (eb ExchangeBinding) bind(ctx context.Context, chn *amqp.Channel, name stri
 }
 
 // QueueConfig represents a queue config.
+//
+// Type, Quorum and Stream are converted to arguments, and override the values defined in Arguments.
+// Quorum and stream queues must be durable, and can't be exclusive or auto delete.
 type QueueConfig struct {
-	Name       string
-	Durable    bool
-	AutoDelete bool
-	Exclusive  bool
-	Arguments  amqp.Table
-	Bindings   []QueueBinding
+	Name       string             `json:"name,omitempty" yaml:"name,omitempty"`
+	Type       string             `json:"type,omitempty" yaml:"type,omitempty"`
+	Durable    bool               `json:"durable,omitempty" yaml:"durable,omitempty"`
+	AutoDelete bool               `json:"auto_delete,omitempty" yaml:"auto_delete,omitempty"`
+	Exclusive  bool               `json:"exclusive,omitempty" yaml:"exclusive,omitempty"`
+	Arguments  amqp.Table         `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Quorum     *QuorumQueueConfig `json:"quorum,omitempty" yaml:"quorum,omitempty"`
+	Stream     *StreamQueueConfig `json:"stream,omitempty" yaml:"stream,omitempty"`
+	Bindings   []QueueBinding     `json:"bindings,omitempty" yaml:"bindings,omitempty"`
 }
 
-func (qc QueueConfig) init(ctx context.Context, chn *amqp.Channel) error {
+// Queue types, for QueueConfig.Type.
+const (
+	QueueTypeClassic = "classic"
+	QueueTypeQuorum  = "quorum"
+	QueueTypeStream  = "stream"
+)
+
+// QuorumQueueConfig represents the options of a quorum queue.
+//
+// See https://www.rabbitmq.com/quorum-queues.html .
+type QuorumQueueConfig struct {
+	// DeliveryLimit is the maximum number of deliveries of a message, before it is dead-lettered or dropped.
+	DeliveryLimit int64 `json:"delivery_limit,omitempty" yaml:"delivery_limit,omitempty"`
+	// DeadLetterStrategy is DeadLetterStrategyAtMostOnce (default) or DeadLetterStrategyAtLeastOnce.
+	// DeadLetterStrategyAtLeastOnce requires the argument "x-overflow" = "reject-publish".
+	DeadLetterStrategy string `json:"dead_letter_strategy,omitempty" yaml:"dead_letter_strategy,omitempty"`
+	InitialGroupSize   int64  `json:"initial_group_size,omitempty" yaml:"initial_group_size,omitempty"`
+}
+
+// Dead letter strategies, for QuorumQueueConfig.DeadLetterStrategy.
+const (
+	DeadLetterStrategyAtMostOnce  = "at-most-once"
+	DeadLetterStrategyAtLeastOnce = "at-least-once"
+)
+
+func (c *QuorumQueueConfig) setArguments(args amqp.Table) {
+	if c.DeliveryLimit > 0 {
+		args["x-delivery-limit"] = c.DeliveryLimit
+	}
+	if c.DeadLetterStrategy != "" {
+		args["x-dead-letter-strategy"] = c.DeadLetterStrategy
+	}
+	if c.InitialGroupSize > 0 {
+		args["x-quorum-initial-group-size"] = c.InitialGroupSize
+	}
+}
+
+// StreamQueueConfig represents the options of a stream queue.
+//
+// See https://www.rabbitmq.com/streams.html .
+type StreamQueueConfig struct {
+	// MaxAge is the retention of the messages, e.g. "7D", "12h".
+	MaxAge              string `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	MaxLengthBytes      int64  `json:"max_length_bytes,omitempty" yaml:"max_length_bytes,omitempty"`
+	MaxSegmentSizeBytes int64  `json:"max_segment_size_bytes,omitempty" yaml:"max_segment_size_bytes,omitempty"`
+	InitialClusterSize  int64  `json:"initial_cluster_size,omitempty" yaml:"initial_cluster_size,omitempty"`
+}
+
+func (c *StreamQueueConfig) setArguments(args amqp.Table) {
+	if c.MaxAge != "" {
+		args["x-max-age"] = c.MaxAge
+	}
+	if c.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = c.MaxLengthBytes
+	}
+	if c.MaxSegmentSizeBytes > 0 {
+		args["x-stream-max-segment-size-bytes"] = c.MaxSegmentSizeBytes
+	}
+	if c.InitialClusterSize > 0 {
+		args["x-initial-cluster-size"] = c.InitialClusterSize
+	}
+}
+
+// arguments returns the arguments of the queue declaration.
+func (qc QueueConfig) arguments() amqp.Table {
+	if qc.Type == "" && qc.Quorum == nil && qc.Stream == nil {
+		return qc.Arguments
+	}
+	args := make(amqp.Table, len(qc.Arguments)+4)
+	for k, v := range qc.Arguments {
+		args[k] = v
+	}
+	if qc.Type != "" {
+		args["x-queue-type"] = qc.Type
+	}
+	if qc.Quorum != nil {
+		qc.Quorum.setArguments(args)
+	}
+	if qc.Stream != nil {
+		qc.Stream.setArguments(args)
+	}
+	return args
+}
+
+func (qc QueueConfig) init(ctx context.Context, chn Channel) error {
 	err := qc.declare(ctx, chn)
 	if err != nil {
 		return errors.Wrap(err, "declare")
@@ -139,14 +229,14 @@ func (qc QueueConfig) init(ctx context.Context, chn *amqp.Channel) error {
 	return nil
 }
 
-func (qc QueueConfig) declare(ctx context.Context, chn *amqp.Channel) (err error) {
+func (qc QueueConfig) declare(ctx context.Context, chn Channel) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "queue_declare", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
 	tracingutils.SetSpanType(span, tracingutils.AppTypeRPC)
 	opentracing_ext.SpanKindRPCClient.Set(span)
 	setTraceSpanTag(span, "queue", qc.Name)
-	_, err = chn.QueueDeclare(qc.Name, qc.Durable, qc.AutoDelete, qc.Exclusive, false, qc.Arguments)
+	_, err = chn.QueueDeclare(qc.Name, qc.Durable, qc.AutoDelete, qc.Exclusive, false, qc.arguments())
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
@@ -155,12 +245,12 @@ func (qc QueueConfig) declare(ctx context.Context, chn *amqp.Channel) (err error
 
 // QueueBinding represents a queue binding.
 type QueueBinding struct {
-	RoutingKey string
-	Exchange   string
-	Arguments  amqp.Table
+	RoutingKey string     `json:"routing_key,omitempty" yaml:"routing_key,omitempty"`
+	Exchange   string     `json:"exchange,omitempty" yaml:"exchange,omitempty"`
+	Arguments  amqp.Table `json:"arguments,omitempty" yaml:"arguments,omitempty"`
 }
 
-func (qb QueueBinding) bind(ctx context.Context, chn *amqp.Channel, name string) (err error) {
+func (qb QueueBinding) bind(ctx context.Context, chn Channel, name string) (err error) {
 	span, spanFinish := startTraceChildSpan(&ctx, "queue_bind", &err)
 	defer spanFinish()
 	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
@@ -0,0 +1,143 @@
+package amqputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/streadway/amqp"
+	"gopkg.in/yaml.v2"
+)
+
+// UnmarshalTopologyJSON returns a Topology from a JSON description.
+//
+// Integer numbers in arguments are converted to int64, other numbers to float64.
+func UnmarshalTopologyJSON(data []byte) (Topology, error) {
+	var tp Topology
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&tp)
+	if err != nil {
+		return Topology{}, errors.Wrap(err, "JSON decode")
+	}
+	err = tp.normalizeArguments()
+	if err != nil {
+		return Topology{}, errors.Wrap(err, "normalize arguments")
+	}
+	return tp, nil
+}
+
+// UnmarshalTopologyYAML returns a Topology from a YAML description.
+//
+// Integer numbers in arguments are converted to int64.
+func UnmarshalTopologyYAML(data []byte) (Topology, error) {
+	var tp Topology
+	err := yaml.UnmarshalStrict(data, &tp)
+	if err != nil {
+		return Topology{}, errors.Wrap(err, "YAML unmarshal")
+	}
+	err = tp.normalizeArguments()
+	if err != nil {
+		return Topology{}, errors.Wrap(err, "normalize arguments")
+	}
+	return tp, nil
+}
+
+// normalizeArguments converts the decoded argument values to types supported by AMQP.
+func (tp Topology) normalizeArguments() (err error) {
+	for _, ec := range tp.Exchanges {
+		err = normalizeTopologyArguments(ec.Arguments)
+		if err != nil {
+			err = wrapErrorValue(err, "exchange", ec.Name)
+			return errors.Wrap(err, "exchange")
+		}
+		for _, eb := range ec.Bindings {
+			err = normalizeTopologyArguments(eb.Arguments)
+			if err != nil {
+				err = wrapErrorValue(err, "exchange", ec.Name)
+				err = wrapErrorValue(err, "source", eb.Source)
+				return errors.Wrap(err, "exchange binding")
+			}
+		}
+	}
+	for _, qc := range tp.Queues {
+		err = normalizeTopologyArguments(qc.Arguments)
+		if err != nil {
+			err = wrapErrorValue(err, "queue", qc.Name)
+			return errors.Wrap(err, "queue")
+		}
+		for _, qb := range qc.Bindings {
+			err = normalizeTopologyArguments(qb.Arguments)
+			if err != nil {
+				err = wrapErrorValue(err, "queue", qc.Name)
+				err = wrapErrorValue(err, "exchange", qb.Exchange)
+				return errors.Wrap(err, "queue binding")
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeTopologyArguments(args amqp.Table) error {
+	for k, v := range args {
+		nv, err := normalizeTopologyValue(v)
+		if err != nil {
+			return errors.Wrapf(err, "argument %q", k)
+		}
+		args[k] = nv
+	}
+	return nil
+}
+
+func normalizeTopologyValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return normalizeTopologyNumber(v)
+	case int:
+		return int64(v), nil
+	case map[string]interface{}:
+		return normalizeTopologyTable(amqp.Table(v))
+	case map[interface{}]interface{}:
+		t := make(amqp.Table, len(v))
+		for k, vv := range v {
+			t[fmt.Sprint(k)] = vv
+		}
+		return normalizeTopologyTable(t)
+	case []interface{}:
+		return normalizeTopologyArray(v)
+	}
+	return v, nil
+}
+
+func normalizeTopologyNumber(v json.Number) (interface{}, error) {
+	i, err := v.Int64()
+	if err == nil {
+		return i, nil
+	}
+	f, err := v.Float64()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse number")
+	}
+	return f, nil
+}
+
+func normalizeTopologyTable(t amqp.Table) (interface{}, error) {
+	err := normalizeTopologyArguments(t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func normalizeTopologyArray(v []interface{}) (interface{}, error) {
+	for i, vv := range v {
+		nv, err := normalizeTopologyValue(vv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "index %d", i)
+		}
+		v[i] = nv
+	}
+	return v, nil
+}
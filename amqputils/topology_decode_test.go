@@ -0,0 +1,104 @@
+package amqputils
+
+import (
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+var testTopologyDecodeExpected = Topology{
+	Exchanges: []ExchangeConfig{
+		{
+			Name:    "X_1",
+			Type:    amqp.ExchangeHeaders,
+			Durable: true,
+		},
+	},
+	Queues: []QueueConfig{
+		{
+			Name:    "Q_1",
+			Durable: true,
+			Arguments: amqp.Table{
+				"x-message-ttl": int64(60000),
+				"x-queue-type":  "quorum",
+			},
+			Bindings: []QueueBinding{
+				{
+					Exchange:   "X_1",
+					RoutingKey: "key",
+					Arguments: amqp.Table{
+						"x-match": "any",
+						"nested": amqp.Table{
+							"a": int64(1),
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestUnmarshalTopologyJSON(t *testing.T) {
+	data := []byte(`{
+	"exchanges": [
+		{"name": "X_1", "type": "headers", "durable": true}
+	],
+	"queues": [
+		{
+			"name": "Q_1",
+			"durable": true,
+			"arguments": {"x-message-ttl": 60000, "x-queue-type": "quorum"},
+			"bindings": [
+				{"exchange": "X_1", "routing_key": "key", "arguments": {"x-match": "any", "nested": {"a": 1}}}
+			]
+		}
+	]
+}`)
+	tp, err := UnmarshalTopologyJSON(data)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected topology", tp, testTopologyDecodeExpected)
+}
+
+func TestUnmarshalTopologyJSONError(t *testing.T) {
+	_, err := UnmarshalTopologyJSON([]byte(`{"invalid": true}`))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestUnmarshalTopologyYAML(t *testing.T) {
+	data := []byte(`
+exchanges:
+  - name: X_1
+    type: headers
+    durable: true
+queues:
+  - name: Q_1
+    durable: true
+    arguments:
+      x-message-ttl: 60000
+      x-queue-type: quorum
+    bindings:
+      - exchange: X_1
+        routing_key: key
+        arguments:
+          x-match: any
+          nested:
+            a: 1
+`)
+	tp, err := UnmarshalTopologyYAML(data)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected topology", tp, testTopologyDecodeExpected)
+}
+
+func TestUnmarshalTopologyYAMLError(t *testing.T) {
+	_, err := UnmarshalTopologyYAML([]byte(`invalid: true`))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
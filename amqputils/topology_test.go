@@ -53,7 +53,7 @@ func TestInitTopology(t *testing.T) {
 }
 
 func TestInitTopologyErrorGetChannel(t *testing.T) {
-	cg := func(context.Context) (*amqp.Channel, error) {
+	cg := func(context.Context) (amqputils.Channel, error) {
 		return nil, errors.New("error")
 	}
 	err := amqputils.InitTopology(context.Background(), cg, amqputils.Topology{})
@@ -157,3 +157,162 @@ func TestInitTopologyErrorQueueBind(t *testing.T) {
 		testutils.FatalErr(t, err)
 	}
 }
+
+func TestTopologyVerify(t *testing.T) {
+	ctx := context.Background()
+	cm := amqptest.NewConnectionManager(t, testVhost)
+	tp := amqputils.Topology{
+		Exchanges: []amqputils.ExchangeConfig{
+			{
+				Name:       "X_verify",
+				Type:       amqp.ExchangeFanout,
+				AutoDelete: true,
+			},
+		},
+		Queues: []amqputils.QueueConfig{
+			{
+				Name:       "Q_verify",
+				AutoDelete: true,
+				Arguments: amqp.Table{
+					"x-message-ttl": int64(1000),
+				},
+				Bindings: []amqputils.QueueBinding{
+					{
+						Exchange: "X_verify",
+					},
+				},
+			},
+		},
+	}
+	chn, err := cm.Channel(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	defer chn.Close() //nolint:errcheck
+	err = tp.Init(ctx, chn)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	diff, err := tp.Verify(ctx, cm.Channel, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !diff.IsEmpty() {
+		t.Fatalf("not empty: %+v", diff)
+	}
+	tp.Queues[0].Arguments["x-message-ttl"] = int64(2000)
+	tp.Queues = append(tp.Queues, amqputils.QueueConfig{
+		Name: "Q_verify_missing",
+	})
+	diff, err = tp.Verify(ctx, cm.Channel, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected missing queues", diff.MissingQueues, []string{"Q_verify_missing"})
+	if len(diff.Mismatches) != 1 {
+		t.Fatalf("unexpected mismatches count: got %d, want %d", len(diff.Mismatches), 1)
+	}
+	if diff.Mismatches[0].Field != "x-message-ttl" {
+		t.Fatalf("unexpected mismatch field: got %q, want %q", diff.Mismatches[0].Field, "x-message-ttl")
+	}
+}
+
+func TestTopologyVerifyResourceLocked(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	owner := b.Channel()
+	_, err := owner.QueueDeclare("Q_locked", false, false, true, false, nil)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for _, tc := range []struct {
+		name      string
+		exclusive bool
+		locked    []string
+		field     string
+	}{
+		{
+			name:      "Exclusive",
+			exclusive: true,
+			locked:    []string{"Q_locked"},
+		},
+		{
+			name:  "NotExclusive",
+			field: "exclusive",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tp := amqputils.Topology{
+				Queues: []amqputils.QueueConfig{
+					{
+						Name:      "Q_locked",
+						Exclusive: tc.exclusive,
+					},
+				},
+			}
+			diff, err := tp.Verify(ctx, b.ChannelGetter(), nil)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			testutils.Compare(t, "unexpected locked queues", diff.LockedQueues, tc.locked)
+			checkTopologyVerifyMismatchField(t, diff, tc.field)
+		})
+	}
+}
+
+func checkTopologyVerifyMismatchField(tb testing.TB, diff *amqputils.TopologyDiff, field string) {
+	tb.Helper()
+	if field == "" {
+		if len(diff.Mismatches) != 0 {
+			tb.Fatalf("unexpected mismatches: %v", diff.Mismatches)
+		}
+		return
+	}
+	if len(diff.Mismatches) != 1 || diff.Mismatches[0].Field != field {
+		tb.Fatalf("unexpected mismatches: got %v, want field %q", diff.Mismatches, field)
+	}
+}
+
+func TestInitTopologyBroker(t *testing.T) {
+	ctx := context.Background()
+	b := amqptest.NewBroker(t)
+	err := amqputils.InitTopology(ctx, b.ChannelGetter(), amqputils.Topology{
+		Exchanges: []amqputils.ExchangeConfig{
+			{
+				Name: "X_1",
+				Type: amqp.ExchangeFanout,
+			},
+			{
+				Name: "X_2",
+				Type: amqp.ExchangeTopic,
+				Bindings: []amqputils.ExchangeBinding{
+					{
+						Source: "X_1",
+					},
+				},
+			},
+		},
+		Queues: []amqputils.QueueConfig{
+			{
+				Name: "Q_1",
+				Bindings: []amqputils.QueueBinding{
+					{
+						Exchange:   "X_2",
+						RoutingKey: "a.#",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	chn := b.Channel()
+	err = chn.Publish("X_1", "a.b", false, false, amqp.Publishing{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if n := b.QueueMessages("Q_1"); n != 1 {
+		t.Fatalf("unexpected messages: got %d, want %d", n, 1)
+	}
+}
@@ -0,0 +1,358 @@
+package amqputils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	rabbithole "github.com/michaelklishin/rabbit-hole/v2"
+	opentracing_ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+	"github.com/streadway/amqp"
+)
+
+// TopologyDiff represents the differences between a Topology and the broker.
+type TopologyDiff struct {
+	MissingExchanges []string
+	MissingQueues    []string
+	MissingBindings  []TopologyBinding
+	Mismatches       []TopologyMismatch
+	// LockedQueues contains the exclusive queues that are owned by another connection.
+	// Their parameters can't be compared, but they are configured as exclusive, so they are not differences.
+	LockedQueues []string
+}
+
+// IsEmpty returns true if there is no difference.
+func (d *TopologyDiff) IsEmpty() bool {
+	return len(d.MissingExchanges) == 0 && len(d.MissingQueues) == 0 && len(d.MissingBindings) == 0 && len(d.Mismatches) == 0
+}
+
+// TopologyMismatch represents an exchange or queue that exists with different parameters.
+type TopologyMismatch struct {
+	// Kind is either "exchange" or "queue".
+	Kind string
+	Name string
+	// Field is the inequivalent field or argument, if it could be determined.
+	Field string
+	// Message is the message returned by the broker.
+	Message string
+}
+
+// TopologyBinding represents a binding.
+type TopologyBinding struct {
+	Source string
+	// Destination is the name of the queue or exchange.
+	Destination string
+	// DestinationType is either "queue" or "exchange".
+	DestinationType string
+	RoutingKey      string
+	Arguments       amqp.Table
+}
+
+// TopologyInspector inspects the broker.
+//
+// It is used by Topology.Verify() to check things that can't be checked with AMQP, such as bindings.
+type TopologyInspector interface {
+	ListBindings(ctx context.Context) ([]TopologyBinding, error)
+}
+
+// Verify verifies that the topology exists in the broker, without creating anything.
+//
+// It uses passive declarations to check that exchanges and queues exist.
+// The existing exchanges and queues are redeclared with the configured parameters, which is a no-op if they are equivalent.
+// Otherwise the broker refuses it with PRECONDITION_FAILED, and the inequivalent field is reported as a mismatch.
+// An exclusive queue owned by another connection is refused with RESOURCE_LOCKED.
+// It is reported in LockedQueues if it is configured as exclusive, or as a mismatch of the "exclusive" field otherwise.
+//
+// Bindings can't be checked with AMQP, so they are only checked if a TopologyInspector is provided (it can be nil).
+//
+// A failed declaration closes the channel, so a ChannelGetter is required in order to open new channels.
+func (tp Topology) Verify(ctx context.Context, cg ChannelGetter, ins TopologyInspector) (_ *TopologyDiff, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "topology.verify", &err)
+	defer spanFinish()
+	v := &topologyVerifier{
+		channel: cg,
+		diff:    new(TopologyDiff),
+	}
+	defer v.close()
+	for _, ec := range tp.Exchanges {
+		err = v.exchange(ctx, ec)
+		if err != nil {
+			err = wrapErrorValue(err, "exchange", ec.Name)
+			return nil, errors.Wrap(err, "exchange")
+		}
+	}
+	for _, qc := range tp.Queues {
+		err = v.queue(ctx, qc)
+		if err != nil {
+			err = wrapErrorValue(err, "queue", qc.Name)
+			return nil, errors.Wrap(err, "queue")
+		}
+	}
+	if ins != nil {
+		err = v.bindings(ctx, tp, ins)
+		if err != nil {
+			return nil, errors.Wrap(err, "bindings")
+		}
+	}
+	setTraceSpanTag(span, "diff.empty", v.diff.IsEmpty())
+	return v.diff, nil
+}
+
+type topologyVerifier struct {
+	channel ChannelGetter
+	chn     Channel
+	diff    *TopologyDiff
+}
+
+func (v *topologyVerifier) exchange(ctx context.Context, ec ExchangeConfig) error {
+	found, err := v.check(ctx, "exchange_declare_passive", func(chn Channel) error {
+		return chn.ExchangeDeclarePassive(ec.Name, ec.Type, ec.Durable, ec.AutoDelete, ec.Internal, false, ec.Arguments)
+	})
+	if err != nil {
+		return errors.Wrap(err, "passive declare")
+	}
+	if !found {
+		v.diff.MissingExchanges = append(v.diff.MissingExchanges, ec.Name)
+		return nil
+	}
+	locked, err := v.equivalent(ctx, "exchange", ec.Name, func(chn Channel) error {
+		return chn.ExchangeDeclare(ec.Name, ec.Type, ec.Durable, ec.AutoDelete, ec.Internal, false, ec.Arguments)
+	})
+	if err != nil {
+		return errors.Wrap(err, "declare")
+	}
+	if locked {
+		return errors.New("declare: resource locked")
+	}
+	return nil
+}
+
+func (v *topologyVerifier) queue(ctx context.Context, qc QueueConfig) error {
+	found, err := v.check(ctx, "queue_declare_passive", func(chn Channel) error {
+		_, err := chn.QueueDeclarePassive(qc.Name, qc.Durable, qc.AutoDelete, qc.Exclusive, false, qc.arguments())
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "passive declare")
+	}
+	if !found {
+		v.diff.MissingQueues = append(v.diff.MissingQueues, qc.Name)
+		return nil
+	}
+	locked, err := v.equivalent(ctx, "queue", qc.Name, func(chn Channel) error {
+		_, err := chn.QueueDeclare(qc.Name, qc.Durable, qc.AutoDelete, qc.Exclusive, false, qc.arguments())
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "declare")
+	}
+	if !locked {
+		return nil
+	}
+	if qc.Exclusive {
+		v.diff.LockedQueues = append(v.diff.LockedQueues, qc.Name)
+		return nil
+	}
+	v.diff.Mismatches = append(v.diff.Mismatches, TopologyMismatch{
+		Kind:    "queue",
+		Name:    qc.Name,
+		Field:   "exclusive",
+		Message: "queue is exclusive to another connection",
+	})
+	return nil
+}
+
+// check runs a passive declaration.
+// It returns false if the broker returns NOT_FOUND.
+func (v *topologyVerifier) check(ctx context.Context, op string, f func(Channel) error) (bool, error) {
+	aerr, err := v.run(ctx, op, f)
+	if err != nil {
+		return false, err
+	}
+	if aerr == nil {
+		return true, nil
+	}
+	switch aerr.Code {
+	case amqp.NotFound:
+		return false, nil
+	case amqp.ResourceLocked:
+		// The queue exists, but it is exclusive to another connection.
+		// The declaration reports it as a mismatch.
+		return true, nil
+	}
+	return false, errors.Wrap(aerr, "")
+}
+
+// equivalent runs a declaration.
+// It adds a mismatch if the broker returns PRECONDITION_FAILED.
+// It returns true if the broker returns RESOURCE_LOCKED, because the declared resource is owned by another connection.
+func (v *topologyVerifier) equivalent(ctx context.Context, kind string, name string, f func(Channel) error) (locked bool, err error) {
+	aerr, err := v.run(ctx, kind+"_declare", f)
+	if err != nil {
+		return false, err
+	}
+	if aerr == nil {
+		return false, nil
+	}
+	switch aerr.Code {
+	case amqp.PreconditionFailed:
+	case amqp.ResourceLocked:
+		return true, nil
+	default:
+		return false, errors.Wrap(aerr, "")
+	}
+	v.diff.Mismatches = append(v.diff.Mismatches, TopologyMismatch{
+		Kind:    kind,
+		Name:    name,
+		Field:   getTopologyMismatchField(aerr.Reason),
+		Message: aerr.Reason,
+	})
+	return false, nil
+}
+
+// run runs a function with a channel.
+// If the function returns an *amqp.Error, the channel is closed by the broker, so it is discarded, and the error is returned as the first value.
+func (v *topologyVerifier) run(ctx context.Context, op string, f func(Channel) error) (_ *amqp.Error, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, op, &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.AppTypeRPC)
+	opentracing_ext.SpanKindRPCClient.Set(span)
+	if v.chn == nil {
+		v.chn, err = v.channel(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "get channel")
+		}
+	}
+	err = f(v.chn)
+	if err == nil {
+		return nil, nil
+	}
+	v.close()
+	var aerr *amqp.Error
+	if errors.As(err, &aerr) {
+		setTraceSpanTag(span, "error.code", aerr.Code)
+		return aerr, nil
+	}
+	return nil, errors.Wrap(err, "")
+}
+
+func (v *topologyVerifier) close() {
+	if v.chn != nil {
+		_ = v.chn.Close()
+		v.chn = nil
+	}
+}
+
+func (v *topologyVerifier) bindings(ctx context.Context, tp Topology, ins TopologyInspector) (err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "topology.verify.bindings", &err)
+	defer spanFinish()
+	bs, err := ins.ListBindings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "list")
+	}
+	for _, b := range tp.bindings() {
+		if !containsTopologyBinding(bs, b) {
+			v.diff.MissingBindings = append(v.diff.MissingBindings, b)
+		}
+	}
+	return nil
+}
+
+func (tp Topology) bindings() []TopologyBinding {
+	var bs []TopologyBinding
+	for _, ec := range tp.Exchanges {
+		for _, eb := range ec.Bindings {
+			bs = append(bs, TopologyBinding{
+				Source:          eb.Source,
+				Destination:     ec.Name,
+				DestinationType: "exchange",
+				RoutingKey:      eb.RoutingKey,
+				Arguments:       eb.Arguments,
+			})
+		}
+	}
+	for _, qc := range tp.Queues {
+		for _, qb := range qc.Bindings {
+			bs = append(bs, TopologyBinding{
+				Source:          qb.Exchange,
+				Destination:     qc.Name,
+				DestinationType: "queue",
+				RoutingKey:      qb.RoutingKey,
+				Arguments:       qb.Arguments,
+			})
+		}
+	}
+	return bs
+}
+
+func containsTopologyBinding(bs []TopologyBinding, b TopologyBinding) bool {
+	for _, v := range bs {
+		if v.Source == b.Source && v.Destination == b.Destination && v.DestinationType == b.DestinationType && v.RoutingKey == b.RoutingKey && equalTopologyArguments(v.Arguments, b.Arguments) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalTopologyArguments compares arguments.
+// The values are compared by their string representation, because the number types are not preserved by the management API.
+func equalTopologyArguments(a, b amqp.Table) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, va := range a {
+		vb, ok := b[k]
+		if !ok {
+			return false
+		}
+		if fmt.Sprint(va) != fmt.Sprint(vb) {
+			return false
+		}
+	}
+	return true
+}
+
+var topologyMismatchFieldRegexp = regexp.MustCompile(`inequivalent arg '([^']+)'`)
+
+func getTopologyMismatchField(reason string) string {
+	m := topologyMismatchFieldRegexp.FindStringSubmatch(reason)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// RabbitHoleTopologyInspector is a TopologyInspector that uses the RabbitMQ management API.
+type RabbitHoleTopologyInspector struct {
+	Client *rabbithole.Client
+	Vhost  string
+}
+
+// ListBindings implements TopologyInspector.
+func (ins *RabbitHoleTopologyInspector) ListBindings(ctx context.Context) (_ []TopologyBinding, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "rabbithole_topology_inspector.list_bindings", &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.SpanTypeHTTP)
+	opentracing_ext.SpanKindRPCClient.Set(span)
+	setTraceSpanTag(span, "vhost", ins.Vhost)
+	bis, err := ins.Client.ListBindingsIn(ins.Vhost)
+	if err != nil {
+		err = wrapErrorValue(err, "vhost", ins.Vhost)
+		return nil, errors.Wrap(err, "")
+	}
+	bs := make([]TopologyBinding, len(bis))
+	for i, bi := range bis {
+		bs[i] = TopologyBinding{
+			Source:          bi.Source,
+			Destination:     bi.Destination,
+			DestinationType: bi.DestinationType,
+			RoutingKey:      bi.RoutingKey,
+			Arguments:       amqp.Table(bi.Arguments),
+		}
+	}
+	return bs, nil
+}
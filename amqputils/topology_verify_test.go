@@ -0,0 +1,127 @@
+package amqputils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/streadway/amqp"
+)
+
+func TestTopologyVerifierBindings(t *testing.T) {
+	ctx := context.Background()
+	tp := Topology{
+		Exchanges: []ExchangeConfig{
+			{
+				Name: "X_1",
+				Bindings: []ExchangeBinding{
+					{
+						Source: "X_2",
+					},
+				},
+			},
+		},
+		Queues: []QueueConfig{
+			{
+				Name: "Q_1",
+				Bindings: []QueueBinding{
+					{
+						Exchange:   "X_1",
+						RoutingKey: "a",
+						Arguments: amqp.Table{
+							"x-match": "all",
+							"foo":     int64(1),
+						},
+					},
+					{
+						Exchange:   "X_1",
+						RoutingKey: "b",
+					},
+				},
+			},
+		},
+	}
+	ins := testTopologyInspector(func(ctx context.Context) ([]TopologyBinding, error) {
+		return []TopologyBinding{
+			{
+				Source:          "X_2",
+				Destination:     "X_1",
+				DestinationType: "exchange",
+			},
+			{
+				Source:          "X_1",
+				Destination:     "Q_1",
+				DestinationType: "queue",
+				RoutingKey:      "a",
+				Arguments: amqp.Table{
+					"x-match": "all",
+					"foo":     float64(1),
+				},
+			},
+		}, nil
+	})
+	v := &topologyVerifier{
+		diff: new(TopologyDiff),
+	}
+	err := v.bindings(ctx, tp, ins)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected diff", v.diff, &TopologyDiff{
+		MissingBindings: []TopologyBinding{
+			{
+				Source:          "X_1",
+				Destination:     "Q_1",
+				DestinationType: "queue",
+				RoutingKey:      "b",
+			},
+		},
+	})
+}
+
+func TestTopologyVerifierBindingsError(t *testing.T) {
+	ctx := context.Background()
+	ins := testTopologyInspector(func(ctx context.Context) ([]TopologyBinding, error) {
+		return nil, errors.New("error")
+	})
+	v := &topologyVerifier{
+		diff: new(TopologyDiff),
+	}
+	err := v.bindings(ctx, Topology{}, ins)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestTopologyVerifyErrorGetChannel(t *testing.T) {
+	ctx := context.Background()
+	tp := Topology{
+		Queues: []QueueConfig{
+			{
+				Name: "Q_1",
+			},
+		},
+	}
+	cg := func(ctx context.Context) (Channel, error) {
+		return nil, errors.New("error")
+	}
+	_, err := tp.Verify(ctx, cg, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestGetTopologyMismatchField(t *testing.T) {
+	reason := "PRECONDITION_FAILED - inequivalent arg 'x-message-ttl' for queue 'Q' in vhost 'test': received the value '2000' of type 'signedint' but current is the value '1000' of type 'signedint'"
+	f := getTopologyMismatchField(reason)
+	if f != "x-message-ttl" {
+		t.Fatalf("unexpected field: got %q, want %q", f, "x-message-ttl")
+	}
+}
+
+type testTopologyInspector func(ctx context.Context) ([]TopologyBinding, error)
+
+func (f testTopologyInspector) ListBindings(ctx context.Context) ([]TopologyBinding, error) {
+	return f(ctx)
+}
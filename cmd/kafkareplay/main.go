@@ -0,0 +1,227 @@
+// Command kafkareplay resets the offsets of Kafka consumer groups, and copies or dumps a range of messages.
+//
+// Usage:
+//
+//	kafkareplay reset -brokers localhost:9092 -group group -topic topic -to 2020-01-01T00:00:00Z
+//	kafkareplay copy -brokers localhost:9092 -topic topic -from earliest -to latest -dst other -key key
+//	kafkareplay dump -brokers localhost:9092 -topic topic -from 0:10,1:20 -header name=value > messages.jsonl
+//
+// A position is "earliest", "latest", a RFC3339 time, or explicit offsets by partition (e.g. "0:10,1:20").
+// The -dry-run flag doesn't commit offsets and doesn't produce messages.
+// The flags -topic, -group and -to (reset), and -dst (copy) are required.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkautils"
+	"github.com/siddhant2408/golang-libraries/mainutils"
+)
+
+func main() {
+	mainutils.Run(func(ctx context.Context) error {
+		return run(ctx, os.Args[1:], os.Stdout)
+	})
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("missing command: reset, copy or dump")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "reset":
+		return runReset(ctx, args, out)
+	case "copy":
+		return runCopy(ctx, args, out)
+	case "dump":
+		return runDump(ctx, args, out)
+	}
+	return errors.Newf("unknown command %q", cmd)
+}
+
+type flags struct {
+	fs      *flag.FlagSet
+	brokers string
+	dryRun  bool
+}
+
+func newFlags(name string) *flags {
+	f := &flags{
+		fs: flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+	f.fs.StringVar(&f.brokers, "brokers", "localhost:9092", "Kafka brokers, comma separated")
+	f.fs.BoolVar(&f.dryRun, "dry-run", false, "don't commit offsets and don't produce messages")
+	return f
+}
+
+func (f *flags) parse(args []string) error {
+	err := f.fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err, "parse flags")
+	}
+	return nil
+}
+
+// checkRequired returns an error if a required flag is not defined.
+// It is called before connecting to the brokers.
+func (f *flags) checkRequired(names ...string) error {
+	for _, name := range names {
+		if f.fs.Lookup(name).Value.String() == "" {
+			return errors.Newf("missing flag -%s", name)
+		}
+	}
+	return nil
+}
+
+func (f *flags) newConfig() *kafkautils.Config {
+	return &kafkautils.Config{
+		Brokers: strings.Split(f.brokers, ","),
+	}
+}
+
+func (f *flags) newReplay() *kafkautils.Replay {
+	r := f.newConfig().NewReplay()
+	r.DryRun = f.dryRun
+	return r
+}
+
+func runReset(ctx context.Context, args []string, out io.Writer) error {
+	f := newFlags("reset")
+	group := f.fs.String("group", "", "consumer group")
+	topic := f.fs.String("topic", "", "topic")
+	to := f.fs.String("to", "", "position")
+	err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	err = f.checkRequired("group", "topic", "to")
+	if err != nil {
+		return err
+	}
+	pos, err := kafkautils.ParseReplayPosition(*to)
+	if err != nil {
+		return errors.Wrap(err, "to")
+	}
+	changes, err := f.newReplay().ResetOffsets(ctx, *group, *topic, pos)
+	if err != nil {
+		return errors.Wrap(err, "reset")
+	}
+	for _, c := range changes {
+		_, _ = fmt.Fprintf(out, "partition %d: %d -> %d\n", c.Partition, c.From, c.To)
+	}
+	return nil
+}
+
+type rangeFlags struct {
+	*flags
+	topic  string
+	from   string
+	to     string
+	key    string
+	header string
+}
+
+func newRangeFlags(name string) *rangeFlags {
+	f := &rangeFlags{
+		flags: newFlags(name),
+	}
+	f.fs.StringVar(&f.topic, "topic", "", "topic")
+	f.fs.StringVar(&f.from, "from", "earliest", "first position (inclusive)")
+	f.fs.StringVar(&f.to, "to", "latest", "last position (exclusive)")
+	f.fs.StringVar(&f.key, "key", "", "filter by key")
+	f.fs.StringVar(&f.header, "header", "", "filter by header, name=value")
+	return f
+}
+
+func (f *rangeFlags) getRange() (kafkautils.ReplayRange, error) {
+	rg := kafkautils.ReplayRange{
+		Topic: f.topic,
+	}
+	err := f.checkRequired("topic")
+	if err != nil {
+		return rg, err
+	}
+	rg.Start, err = kafkautils.ParseReplayPosition(f.from)
+	if err != nil {
+		return rg, errors.Wrap(err, "from")
+	}
+	rg.End, err = kafkautils.ParseReplayPosition(f.to)
+	if err != nil {
+		return rg, errors.Wrap(err, "to")
+	}
+	var filters []func(kafka.Message) bool
+	if f.key != "" {
+		filters = append(filters, kafkautils.ReplayKeyFilter([]byte(f.key)))
+	}
+	if f.header != "" {
+		i := strings.IndexByte(f.header, '=')
+		if i < 0 {
+			return rg, errors.Newf("header: invalid filter %q", f.header)
+		}
+		filters = append(filters, kafkautils.ReplayHeaderFilter(f.header[:i], []byte(f.header[i+1:])))
+	}
+	if len(filters) > 0 {
+		rg.Filter = func(msg kafka.Message) bool {
+			for _, ft := range filters {
+				if !ft(msg) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return rg, nil
+}
+
+func runCopy(ctx context.Context, args []string, out io.Writer) error {
+	f := newRangeFlags("copy")
+	dst := f.fs.String("dst", "", "destination topic")
+	err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	err = f.checkRequired("dst")
+	if err != nil {
+		return err
+	}
+	rg, err := f.getRange()
+	if err != nil {
+		return err
+	}
+	w := f.newConfig().NewWriter()
+	defer w.Close() //nolint:errcheck
+	p := &kafkautils.SimpleProducer{
+		Writer: w.WriteMessages,
+	}
+	rp, err := f.newReplay().Copy(ctx, rg, *dst, p.Produce)
+	if err != nil {
+		return errors.Wrap(err, "copy")
+	}
+	_, _ = fmt.Fprintf(out, "read: %d, copied: %d\n", rp.Read, rp.Matched)
+	return nil
+}
+
+func runDump(ctx context.Context, args []string, out io.Writer) error {
+	f := newRangeFlags("dump")
+	err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	rg, err := f.getRange()
+	if err != nil {
+		return err
+	}
+	_, err = f.newReplay().Dump(ctx, rg, out)
+	if err != nil {
+		return errors.Wrap(err, "dump")
+	}
+	return nil
+}
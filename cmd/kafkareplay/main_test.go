@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestRunError(t *testing.T) {
+	ctx := context.Background()
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"reset", "-invalid"},
+		{"reset", "-to", "invalid"},
+		{"copy", "-from", "invalid"},
+		{"dump", "-to", "invalid"},
+		{"dump", "-topic", "test", "-header", "invalid"},
+		{"reset", "-group", "group", "-topic", "test"},
+		{"reset", "-topic", "test", "-to", "earliest"},
+		{"reset", "-group", "group", "-to", "earliest"},
+		{"copy", "-topic", "test"},
+		{"copy", "-dst", "dst"},
+		{"dump"},
+	} {
+		err := run(ctx, args, io.Discard)
+		if err == nil {
+			t.Fatalf("no error for %q", args)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package dedup provides stores for the deduplication of messages.
+//
+// A key is marked as in-flight before the processing of a message, and as done after a successful processing.
+// The in-flight mark is set atomically, so only one consumer can process a key.
+package dedup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/inmemorycache"
+)
+
+// State is the state of a key in a Store.
+type State int
+
+// State values.
+const (
+	// StateNew means that the key was unknown, and is now marked as in-flight.
+	StateNew State = iota
+	// StateInFlight means that the key is being processed.
+	StateInFlight
+	// StateDone means that the key was processed successfully.
+	StateDone
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateInFlight:
+		return "in_flight"
+	case StateDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// Store stores the state of the processed messages.
+type Store interface {
+	// Start atomically marks a key as in-flight if it is unknown, and returns its previous state.
+	Start(ctx context.Context, key string) (State, error)
+	// Done marks a key as done.
+	Done(ctx context.Context, key string) error
+	// Cancel removes the in-flight mark, so the key can be processed again.
+	Cancel(ctx context.Context, key string) error
+}
+
+// MemoryStore is an in-memory Store.
+//
+// It is only useful if all the consumers are running in the same process.
+type MemoryStore struct {
+	mu    sync.Mutex
+	cache inmemorycache.Cache
+}
+
+// NewMemoryStore returns a new MemoryStore.
+//
+// The options are passed to inmemorycache.New(), e.g. inmemorycache.MaxSize() creates a LRU cache.
+// It is not necessary to use inmemorycache.Concurrent(), because the store is already synchronized.
+func NewMemoryStore(opts ...inmemorycache.Option) *MemoryStore {
+	return &MemoryStore{
+		cache: inmemorycache.New(opts...),
+	}
+}
+
+// Start implements Store.
+func (s *MemoryStore) Start(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.cache.Get(key)
+	if ok {
+		return v.(State), nil
+	}
+	s.cache.Set(key, StateInFlight)
+	return StateNew, nil
+}
+
+// Done implements Store.
+func (s *MemoryStore) Done(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Set(key, StateDone)
+	return nil
+}
+
+// Cancel implements Store.
+func (s *MemoryStore) Cancel(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Remove(key)
+	return nil
+}
@@ -0,0 +1,64 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for _, tc := range []struct {
+		name     string
+		f        func(ctx context.Context, key string) error
+		expected State
+	}{
+		{
+			name:     "New",
+			expected: StateNew,
+		},
+		{
+			name:     "InFlight",
+			expected: StateInFlight,
+		},
+		{
+			name:     "Cancel",
+			f:        s.Cancel,
+			expected: StateNew,
+		},
+		{
+			name:     "Done",
+			f:        s.Done,
+			expected: StateDone,
+		},
+	} {
+		if tc.f != nil {
+			err := tc.f(ctx, "key")
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+		}
+		st, err := s.Start(ctx, "key")
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		if st != tc.expected {
+			t.Fatalf("%s: unexpected state: got %v, want %v", tc.name, st, tc.expected)
+		}
+	}
+}
+
+func TestStateString(t *testing.T) {
+	for st, expected := range map[State]string{
+		StateNew:      "new",
+		StateInFlight: "in_flight",
+		StateDone:     "done",
+		State(-1):     "unknown",
+	} {
+		if s := st.String(); s != expected {
+			t.Fatalf("unexpected string: got %q, want %q", s, expected)
+		}
+	}
+}
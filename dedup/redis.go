@@ -0,0 +1,106 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+const (
+	redisStoreValueInFlight = "in_flight"
+	redisStoreValueDone     = "done"
+
+	defaultRedisStoreInFlightTTL = 1 * time.Minute
+	defaultRedisStoreTTL         = 24 * time.Hour
+)
+
+// RedisStore is a Store that uses Redis.
+//
+// The in-flight mark is set with SET NX, so only one consumer can process a key.
+// It expires after InFlightTTL, so a key is not blocked forever if a consumer crashes.
+// The done mark expires after TTL.
+type RedisStore struct {
+	Client interface {
+		SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+		Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+		Get(ctx context.Context, key string) *redis.StringCmd
+		Del(ctx context.Context, keys ...string) *redis.IntCmd
+	}
+	// Prefix is added to the keys.
+	Prefix string
+	// InFlightTTL is the expiration of the in-flight mark.
+	// It should be greater than the maximum processing duration.
+	// Default: 1m.
+	InFlightTTL time.Duration
+	// TTL is the expiration of the done mark.
+	// Default: 24h.
+	TTL time.Duration
+}
+
+// Start implements Store.
+func (s *RedisStore) Start(ctx context.Context, key string) (_ State, err error) {
+	span, spanFinish := startTraceSpan(&ctx, "redis_store.start", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "key", key)
+	rkey := s.Prefix + key
+	ok, err := s.Client.SetNX(ctx, rkey, redisStoreValueInFlight, s.getInFlightTTL()).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "Redis SETNX")
+	}
+	if ok {
+		return StateNew, nil
+	}
+	v, err := s.Client.Get(ctx, rkey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			// The key has expired or has been canceled in the meantime.
+			// It is considered as in-flight, the caller can try again.
+			return StateInFlight, nil
+		}
+		return 0, errors.Wrap(err, "Redis GET")
+	}
+	if v == redisStoreValueDone {
+		return StateDone, nil
+	}
+	return StateInFlight, nil
+}
+
+// Done implements Store.
+func (s *RedisStore) Done(ctx context.Context, key string) (err error) {
+	span, spanFinish := startTraceSpan(&ctx, "redis_store.done", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "key", key)
+	err = s.Client.Set(ctx, s.Prefix+key, redisStoreValueDone, s.getTTL()).Err()
+	if err != nil {
+		return errors.Wrap(err, "Redis SET")
+	}
+	return nil
+}
+
+// Cancel implements Store.
+func (s *RedisStore) Cancel(ctx context.Context, key string) (err error) {
+	span, spanFinish := startTraceSpan(&ctx, "redis_store.cancel", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "key", key)
+	err = s.Client.Del(ctx, s.Prefix+key).Err()
+	if err != nil {
+		return errors.Wrap(err, "Redis DEL")
+	}
+	return nil
+}
+
+func (s *RedisStore) getInFlightTTL() time.Duration {
+	if s.InFlightTTL > 0 {
+		return s.InFlightTTL
+	}
+	return defaultRedisStoreInFlightTTL
+}
+
+func (s *RedisStore) getTTL() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return defaultRedisStoreTTL
+}
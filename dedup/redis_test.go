@@ -0,0 +1,58 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/redistest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	s := &RedisStore{
+		Client:      redistest.NewClient(t),
+		Prefix:      "test:",
+		InFlightTTL: 1 * time.Minute,
+		TTL:         1 * time.Minute,
+	}
+	for _, tc := range []struct {
+		name     string
+		f        func(ctx context.Context, key string) error
+		expected State
+	}{
+		{
+			name:     "New",
+			expected: StateNew,
+		},
+		{
+			name:     "InFlight",
+			expected: StateInFlight,
+		},
+		{
+			name:     "Cancel",
+			f:        s.Cancel,
+			expected: StateNew,
+		},
+		{
+			name:     "Done",
+			f:        s.Done,
+			expected: StateDone,
+		},
+	} {
+		if tc.f != nil {
+			err := tc.f(ctx, "key")
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+		}
+		st, err := s.Start(ctx, "key")
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		if st != tc.expected {
+			t.Fatalf("%s: unexpected state: got %v, want %v", tc.name, st, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,806 @@
+package kafkatest
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+// Broker is an in-memory Kafka broker.
+//
+// It is a fake for unit tests, that doesn't require a running Kafka.
+// It mimics the Kafka behavior for the most common features:
+//   - topics with multiple partitions
+//   - writers with a kafka.Balancer (partitioner), keys and headers
+//   - readers for a single partition, or in a consumer group
+//   - consumer group offsets, with commit semantics
+//   - consumer group partition assignment, with rebalance when a reader joins or leaves the group
+//   - blocking fetch, with context cancellation
+//   - the kafka.Client methods Metadata, OffsetFetch, ListOffsets, CreateTopics, CreatePartitions and DescribeConfigs
+//   - consumer group offsets reset, with CommitOffsets
+//
+// The messages written without time use timeutils.Now().
+// The retention is infinite.
+type Broker struct {
+	mu      sync.Mutex
+	topics  map[string]*brokerTopic
+	groups  map[string]*brokerGroup
+	changed chan struct{}
+	closed  bool
+}
+
+type brokerTopic struct {
+	partitions        [][]kafka.Message
+	replicationFactor int
+	configs           map[string]string
+}
+
+func newBrokerTopic(partitions int) *brokerTopic {
+	return &brokerTopic{
+		partitions:        make([][]kafka.Message, partitions),
+		replicationFactor: 1,
+		configs:           make(map[string]string),
+	}
+}
+
+type brokerPartitionKey struct {
+	topic     string
+	partition int
+}
+
+type brokerGroup struct {
+	offsets    map[brokerPartitionKey]int64
+	members    []*Reader
+	generation int64
+}
+
+// NewBroker returns a new Broker.
+//
+// It registers a cleanup function that closes the Broker at the end of the test.
+func NewBroker(tb testing.TB) *Broker {
+	tb.Helper()
+	b := &Broker{
+		topics:  make(map[string]*brokerTopic),
+		groups:  make(map[string]*brokerGroup),
+		changed: make(chan struct{}),
+	}
+	tb.Cleanup(b.Close)
+	return b
+}
+
+// CreateTopic creates a topic.
+//
+// It returns kafka.TopicAlreadyExists if the topic already exists.
+func (b *Broker) CreateTopic(name string, partitions int) error {
+	if partitions <= 0 {
+		return errors.Newf("invalid partitions count %d", partitions)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[name]; ok {
+		return errors.Wrap(kafka.TopicAlreadyExists, name)
+	}
+	b.topics[name] = newBrokerTopic(partitions)
+	b.notify()
+	return nil
+}
+
+// Topic creates a new test topic, and returns its name.
+//
+// It is the in-memory equivalent of the Topic function.
+func (b *Broker) Topic(tb testing.TB, name string, partitions int) string {
+	tb.Helper()
+	err := b.CreateTopic(name, partitions)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return name
+}
+
+// Partitions returns the number of partitions of a topic, or 0 if it doesn't exist.
+func (b *Broker) Partitions(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return 0
+	}
+	return len(t.partitions)
+}
+
+// Messages returns the messages of a topic partition.
+func (b *Broker) Messages(topic string, partition int) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok || partition < 0 || partition >= len(t.partitions) {
+		return nil
+	}
+	msgs := make([]kafka.Message, len(t.partitions[partition]))
+	for i, msg := range t.partitions[partition] {
+		msgs[i] = copyMessage(msg)
+	}
+	return msgs
+}
+
+// TopicMessages returns the messages of all the partitions of a topic, sorted by partition and offset.
+func (b *Broker) TopicMessages(topic string) []kafka.Message {
+	var msgs []kafka.Message
+	for p := 0; p < b.Partitions(topic); p++ {
+		msgs = append(msgs, b.Messages(topic, p)...)
+	}
+	return msgs
+}
+
+// CommittedOffset returns the committed offset of a consumer group for a topic partition.
+//
+// The committed offset is the offset of the next message to read.
+// It returns -1 if there is no committed offset.
+func (b *Broker) CommittedOffset(group string, topic string, partition int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[group]
+	if !ok {
+		return -1
+	}
+	off, ok := g.offsets[brokerPartitionKey{topic: topic, partition: partition}]
+	if !ok {
+		return -1
+	}
+	return off
+}
+
+// Close closes the Broker.
+//
+// The blocked fetches return io.EOF.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.notify()
+}
+
+// notify wakes up the blocked fetches.
+// The lock must be held.
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Broker) write(topic string, balancer kafka.Balancer, autoCreate bool, msg kafka.Message) error {
+	if b.closed {
+		return io.ErrClosedPipe
+	}
+	t, ok := b.topics[topic]
+	if !ok {
+		if !autoCreate {
+			return errors.Wrap(kafka.UnknownTopicOrPartition, topic)
+		}
+		t = newBrokerTopic(1)
+		b.topics[topic] = t
+	}
+	partitions := make([]int, len(t.partitions))
+	for i := range partitions {
+		partitions[i] = i
+	}
+	p := balancer.Balance(msg, partitions...)
+	if p < 0 || p >= len(t.partitions) {
+		return errors.Wrapf(kafka.UnknownTopicOrPartition, "%s/%d", topic, p)
+	}
+	msg = copyMessage(msg)
+	msg.Topic = topic
+	msg.Partition = p
+	msg.Offset = int64(len(t.partitions[p]))
+	if msg.Time.IsZero() {
+		msg.Time = timeutils.Now()
+	}
+	t.partitions[p] = append(t.partitions[p], msg)
+	return nil
+}
+
+// Writer writes messages to a Broker.
+//
+// Its method WriteMessages can be used as kafkautils.SimpleProducer.Writer.
+type Writer struct {
+	Broker *Broker
+	// Topic is the topic of the messages.
+	// If it is empty, the Topic field of the messages is used.
+	Topic string
+	// Balancer selects the partition of the messages.
+	// Default: &kafka.RoundRobin{}, like kafka.Writer.
+	Balancer kafka.Balancer
+	// AllowAutoTopicCreation creates the unknown topics, with 1 partition.
+	AllowAutoTopicCreation bool
+
+	// defaultBalancer is protected by the Broker lock.
+	defaultBalancer kafka.Balancer
+}
+
+// WriteMessages writes messages.
+//
+// Like kafka.Writer, it returns an error if the Topic is defined in both the Writer and the message, or in none of them.
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	err := ctx.Err()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	for i, msg := range msgs {
+		if w.Topic != "" && msg.Topic != "" {
+			return errors.Newf("message %d: topic is defined in the writer and the message", i)
+		}
+		if w.Topic == "" && msg.Topic == "" {
+			return errors.Newf("message %d: topic is not defined", i)
+		}
+	}
+	b := w.Broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	balancer := w.getBalancer()
+	for i, msg := range msgs {
+		topic := w.Topic
+		if topic == "" {
+			topic = msg.Topic
+		}
+		err = b.write(topic, balancer, w.AllowAutoTopicCreation, msg)
+		if err != nil {
+			b.notify()
+			return errors.Wrapf(err, "message %d", i)
+		}
+	}
+	b.notify()
+	return nil
+}
+
+func (w *Writer) getBalancer() kafka.Balancer {
+	if w.Balancer != nil {
+		return w.Balancer
+	}
+	if w.defaultBalancer == nil {
+		w.defaultBalancer = &kafka.RoundRobin{}
+	}
+	return w.defaultBalancer
+}
+
+// Reader reads messages from a Broker.
+//
+// It implements kafkautils.FetchCommitter.
+type Reader struct {
+	broker *Broker
+	cfg    kafka.ReaderConfig
+
+	// The following fields are protected by the Broker lock.
+	closed     bool
+	generation int64
+	partitions int
+	assigned   []brokerPartitionKey
+	positions  map[brokerPartitionKey]int64
+	next       int
+}
+
+// NewReader returns a new Reader.
+//
+// The supported fields of the config are Topic, GroupID, Partition and StartOffset.
+// If GroupID is defined, the partitions of the topic are assigned to the readers of the group.
+// Otherwise, the reader reads a single partition.
+//
+// The topic must exist when the messages are fetched.
+func (b *Broker) NewReader(cfg kafka.ReaderConfig) *Reader {
+	r := &Reader{
+		broker:     b,
+		cfg:        cfg,
+		generation: -1,
+		positions:  make(map[brokerPartitionKey]int64),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cfg.GroupID != "" {
+		g := b.getGroup(cfg.GroupID)
+		g.members = append(g.members, r)
+		g.generation++
+		b.notify()
+	}
+	return r
+}
+
+func (b *Broker) getGroup(name string) *brokerGroup {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &brokerGroup{
+			offsets: make(map[brokerPartitionKey]int64),
+		}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// FetchMessage fetches a message, without committing it.
+//
+// It blocks until a message is available, or the context is canceled.
+func (r *Reader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	b := r.broker
+	for {
+		b.mu.Lock()
+		if r.closed || b.closed {
+			b.mu.Unlock()
+			return kafka.Message{}, io.EOF
+		}
+		msg, ok, err := r.fetch()
+		changed := b.changed
+		b.mu.Unlock()
+		if err != nil {
+			return kafka.Message{}, err
+		}
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// ReadMessage fetches a message, and commits it if GroupID is defined.
+func (r *Reader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	if r.cfg.GroupID != "" {
+		err = r.CommitMessages(ctx, msg)
+		if err != nil {
+			return kafka.Message{}, err
+		}
+	}
+	return msg, nil
+}
+
+// fetch returns the next message of the assigned partitions, in round-robin.
+// The lock must be held.
+func (r *Reader) fetch() (kafka.Message, bool, error) {
+	b := r.broker
+	t, ok := b.topics[r.cfg.Topic]
+	if !ok {
+		// The topic could be created later.
+		return kafka.Message{}, false, nil
+	}
+	err := r.assign(t)
+	if err != nil {
+		return kafka.Message{}, false, err
+	}
+	for i := range r.assigned {
+		k := r.assigned[(r.next+i)%len(r.assigned)]
+		msgs := t.partitions[k.partition]
+		pos, ok := r.positions[k]
+		if !ok {
+			pos = r.getStartOffset(k, len(msgs))
+			r.positions[k] = pos
+		}
+		if pos < int64(len(msgs)) {
+			r.positions[k] = pos + 1
+			r.next = (r.next + i + 1) % len(r.assigned)
+			return copyMessage(msgs[pos]), true, nil
+		}
+	}
+	return kafka.Message{}, false, nil
+}
+
+// assign updates the assigned partitions.
+// The lock must be held.
+func (r *Reader) assign(t *brokerTopic) error {
+	b := r.broker
+	if r.cfg.GroupID == "" {
+		if r.cfg.Partition < 0 || r.cfg.Partition >= len(t.partitions) {
+			return errors.Wrapf(kafka.UnknownTopicOrPartition, "%s/%d", r.cfg.Topic, r.cfg.Partition)
+		}
+		if r.assigned == nil {
+			r.assigned = []brokerPartitionKey{{topic: r.cfg.Topic, partition: r.cfg.Partition}}
+		}
+		return nil
+	}
+	g := b.getGroup(r.cfg.GroupID)
+	if r.generation == g.generation && r.partitions == len(t.partitions) {
+		return nil
+	}
+	// Rebalance: the partitions are assigned in round-robin to the members, by join order.
+	// The positions are reset, so the messages that were not committed are fetched again.
+	r.generation = g.generation
+	r.partitions = len(t.partitions)
+	r.assigned = nil
+	r.positions = make(map[brokerPartitionKey]int64)
+	r.next = 0
+	idx := 0
+	for i, m := range g.members {
+		if m == r {
+			idx = i
+		}
+	}
+	for p := idx; p < len(t.partitions); p += len(g.members) {
+		r.assigned = append(r.assigned, brokerPartitionKey{topic: r.cfg.Topic, partition: p})
+	}
+	return nil
+}
+
+// getStartOffset returns the offset where the reader starts to read a partition.
+// The lock must be held.
+func (r *Reader) getStartOffset(k brokerPartitionKey, size int) int64 {
+	if r.cfg.GroupID != "" {
+		off, ok := r.broker.getGroup(r.cfg.GroupID).offsets[k]
+		if ok {
+			return off
+		}
+	}
+	if r.cfg.StartOffset == kafka.LastOffset {
+		return int64(size)
+	}
+	return 0
+}
+
+// CommitMessages commits the messages.
+//
+// For each partition, the committed offset is the highest offset of the messages + 1.
+// It returns an error if GroupID is not defined.
+func (r *Reader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if r.cfg.GroupID == "" {
+		return errors.New("unavailable when GroupID is not set")
+	}
+	err := ctx.Err()
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	offsets := make(map[brokerPartitionKey]int64)
+	for _, msg := range msgs {
+		k := brokerPartitionKey{topic: msg.Topic, partition: msg.Partition}
+		off, ok := offsets[k]
+		if !ok || msg.Offset+1 > off {
+			offsets[k] = msg.Offset + 1
+		}
+	}
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.closed || b.closed {
+		return io.ErrClosedPipe
+	}
+	g := b.getGroup(r.cfg.GroupID)
+	for k, off := range offsets {
+		g.offsets[k] = off
+	}
+	return nil
+}
+
+// SetOffset sets the offset of the next message to read.
+//
+// It returns an error if GroupID is defined.
+func (r *Reader) SetOffset(offset int64) error {
+	if r.cfg.GroupID != "" {
+		return errors.New("unavailable when GroupID is set")
+	}
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.closed || b.closed {
+		return io.ErrClosedPipe
+	}
+	r.positions[brokerPartitionKey{topic: r.cfg.Topic, partition: r.cfg.Partition}] = offset
+	return nil
+}
+
+// Assigned returns the partitions currently assigned to the reader.
+//
+// It is updated when a message is fetched.
+func (r *Reader) Assigned() []int {
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ps := make([]int, len(r.assigned))
+	for i, k := range r.assigned {
+		ps[i] = k.partition
+	}
+	sort.Ints(ps)
+	return ps
+}
+
+// Close closes the Reader.
+//
+// If GroupID is defined, the reader leaves the group, and the partitions are assigned to the other readers.
+func (r *Reader) Close() error {
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.cfg.GroupID != "" {
+		g := b.getGroup(r.cfg.GroupID)
+		for i, m := range g.members {
+			if m == r {
+				g.members = append(g.members[:i], g.members[i+1:]...)
+				break
+			}
+		}
+		g.generation++
+	}
+	b.notify()
+	return nil
+}
+
+func copyMessage(msg kafka.Message) kafka.Message {
+	if msg.Key != nil {
+		msg.Key = append([]byte(nil), msg.Key...)
+	}
+	if msg.Value != nil {
+		msg.Value = append([]byte(nil), msg.Value...)
+	}
+	if msg.Headers != nil {
+		hs := make([]kafka.Header, len(msg.Headers))
+		for i, h := range msg.Headers {
+			hs[i] = kafka.Header{
+				Key:   h.Key,
+				Value: append([]byte(nil), h.Value...),
+			}
+		}
+		msg.Headers = hs
+	}
+	return msg
+}
+
+// Metadata implements the method of kafka.Client.
+//
+// Only the topic names, partition IDs, replicas and errors are defined.
+func (b *Broker) Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := req.Topics
+	if names == nil {
+		for name := range b.topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	resp := &kafka.MetadataResponse{}
+	for _, name := range names {
+		topic := kafka.Topic{
+			Name: name,
+		}
+		t, ok := b.topics[name]
+		if ok {
+			replicas := make([]kafka.Broker, t.replicationFactor)
+			for i := range replicas {
+				replicas[i] = kafka.Broker{
+					ID: i,
+				}
+			}
+			for p := range t.partitions {
+				topic.Partitions = append(topic.Partitions, kafka.Partition{
+					Topic:    name,
+					ID:       p,
+					Leader:   replicas[0],
+					Replicas: replicas,
+					Isr:      replicas,
+				})
+			}
+		} else {
+			topic.Error = kafka.UnknownTopicOrPartition
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, nil
+}
+
+// OffsetFetch implements the method of kafka.Client.
+//
+// The committed offset is -1 if the group has not committed.
+func (b *Broker) OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.getGroup(req.GroupID)
+	resp := &kafka.OffsetFetchResponse{
+		Topics: make(map[string][]kafka.OffsetFetchPartition, len(req.Topics)),
+	}
+	for topic, ps := range req.Topics {
+		for _, p := range ps {
+			off, ok := g.offsets[brokerPartitionKey{topic: topic, partition: p}]
+			if !ok {
+				off = -1
+			}
+			resp.Topics[topic] = append(resp.Topics[topic], kafka.OffsetFetchPartition{
+				Partition:       p,
+				CommittedOffset: off,
+			})
+		}
+	}
+	return resp, nil
+}
+
+// ListOffsets implements the method of kafka.Client.
+//
+// For a timestamp, the offset is the first message with a time greater than or equal to the timestamp, or -1.
+func (b *Broker) ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp := &kafka.ListOffsetsResponse{
+		Topics: make(map[string][]kafka.PartitionOffsets, len(req.Topics)),
+	}
+	for topic, reqs := range req.Topics {
+		t := b.topics[topic]
+		offsets := make(map[int]*kafka.PartitionOffsets)
+		var ps []int
+		for _, r := range reqs {
+			po, ok := offsets[r.Partition]
+			if !ok {
+				po = &kafka.PartitionOffsets{
+					Partition:   r.Partition,
+					FirstOffset: -1,
+					LastOffset:  -1,
+					Offsets:     make(map[int64]time.Time),
+				}
+				offsets[r.Partition] = po
+				ps = append(ps, r.Partition)
+			}
+			if t == nil || r.Partition < 0 || r.Partition >= len(t.partitions) {
+				po.Error = kafka.UnknownTopicOrPartition
+				continue
+			}
+			switch r.Timestamp {
+			case kafka.FirstOffset:
+				po.FirstOffset = 0
+			case kafka.LastOffset:
+				po.LastOffset = int64(len(t.partitions[r.Partition]))
+			default:
+				off, tm := getTimeOffset(t.partitions[r.Partition], r.Timestamp)
+				po.Offsets[off] = tm
+			}
+		}
+		for _, p := range ps {
+			resp.Topics[topic] = append(resp.Topics[topic], *offsets[p])
+		}
+	}
+	return resp, nil
+}
+
+func getTimeOffset(msgs []kafka.Message, timestamp int64) (int64, time.Time) {
+	for i, msg := range msgs {
+		if msg.Time.UnixNano()/int64(time.Millisecond) >= timestamp {
+			return int64(i), msg.Time
+		}
+	}
+	return -1, time.Time{}
+}
+
+// CommitOffsets commits the offsets of a consumer group, by topic and partition.
+//
+// The offsets are the offsets of the next messages to read.
+// The assigned readers of the group restart from the committed offsets.
+func (b *Broker) CommitOffsets(ctx context.Context, group string, offsets map[string]map[int]int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return io.ErrClosedPipe
+	}
+	g := b.getGroup(group)
+	for topic, ps := range offsets {
+		for p, off := range ps {
+			g.offsets[brokerPartitionKey{topic: topic, partition: p}] = off
+		}
+	}
+	g.generation++ // Like a rebalance, so the positions are reset.
+	b.notify()
+	return nil
+}
+
+// CreateTopics implements the method of kafka.Client.
+//
+// The default partitions count and replication factor are 1.
+func (b *Broker) CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp := &kafka.CreateTopicsResponse{
+		Errors: make(map[string]error, len(req.Topics)),
+	}
+	for _, tc := range req.Topics {
+		if _, ok := b.topics[tc.Topic]; ok {
+			resp.Errors[tc.Topic] = kafka.TopicAlreadyExists
+			continue
+		}
+		partitions := tc.NumPartitions
+		if partitions <= 0 {
+			partitions = 1
+		}
+		t := newBrokerTopic(partitions)
+		if tc.ReplicationFactor > 0 {
+			t.replicationFactor = tc.ReplicationFactor
+		}
+		for _, e := range tc.ConfigEntries {
+			t.configs[e.ConfigName] = e.ConfigValue
+		}
+		resp.Errors[tc.Topic] = nil
+		if !req.ValidateOnly {
+			b.topics[tc.Topic] = t
+		}
+	}
+	b.notify()
+	return resp, nil
+}
+
+// CreatePartitions implements the method of kafka.Client.
+//
+// The partitions assignments are ignored.
+func (b *Broker) CreatePartitions(ctx context.Context, req *kafka.CreatePartitionsRequest) (*kafka.CreatePartitionsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp := &kafka.CreatePartitionsResponse{
+		Errors: make(map[string]error, len(req.Topics)),
+	}
+	for _, tpc := range req.Topics {
+		t, ok := b.topics[tpc.Name]
+		if !ok {
+			resp.Errors[tpc.Name] = kafka.UnknownTopicOrPartition
+			continue
+		}
+		if int(tpc.Count) <= len(t.partitions) {
+			resp.Errors[tpc.Name] = kafka.InvalidPartitionNumber
+			continue
+		}
+		resp.Errors[tpc.Name] = nil
+		if !req.ValidateOnly {
+			t.partitions = append(t.partitions, make([][]kafka.Message, int(tpc.Count)-len(t.partitions))...)
+		}
+	}
+	b.notify()
+	return resp, nil
+}
+
+// DescribeConfigs implements the method of kafka.Client.
+//
+// Only the topic resources are supported, and only the configs defined at the creation are returned.
+func (b *Broker) DescribeConfigs(ctx context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp := &kafka.DescribeConfigsResponse{}
+	for _, r := range req.Resources {
+		res := kafka.DescribeConfigResponseResource{
+			ResourceType: int8(r.ResourceType),
+			ResourceName: r.ResourceName,
+		}
+		t, ok := b.topics[r.ResourceName]
+		switch {
+		case r.ResourceType != kafka.ResourceTypeTopic:
+			res.Error = kafka.InvalidRequest
+		case !ok:
+			res.Error = kafka.UnknownTopicOrPartition
+		default:
+			names := r.ConfigNames
+			if names == nil {
+				for name := range t.configs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+			}
+			for _, name := range names {
+				v, ok := t.configs[name]
+				if !ok {
+					continue
+				}
+				res.ConfigEntries = append(res.ConfigEntries, kafka.DescribeConfigResponseConfigEntry{
+					ConfigName:  name,
+					ConfigValue: v,
+				})
+			}
+		}
+		resp.Resources = append(resp.Resources, res)
+	}
+	return resp, nil
+}
@@ -0,0 +1,387 @@
+package kafkatest
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func TestBrokerWriteRead(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	w := &Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	err := w.WriteMessages(ctx, kafka.Message{
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Headers: []kafka.Header{
+			{
+				Key:   "header",
+				Value: []byte("header value"),
+			},
+		},
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic: topic,
+	})
+	defer r.Close() //nolint:errcheck
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	checkBrokerWriteReadMessage(t, msg, topic)
+	err = r.CommitMessages(ctx, msg)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func checkBrokerWriteReadMessage(tb testing.TB, msg kafka.Message, topic string) {
+	tb.Helper()
+	if msg.Topic != topic || msg.Partition != 0 || msg.Offset != 0 || string(msg.Key) != "key" || string(msg.Value) != "value" {
+		tb.Fatalf("unexpected message: %+v", msg)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "header" || string(msg.Headers[0].Value) != "header value" {
+		tb.Fatalf("unexpected headers: %v", msg.Headers)
+	}
+	if msg.Time.IsZero() {
+		tb.Fatal("zero time")
+	}
+}
+
+func TestBrokerWriterBalancer(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Hash", func(t *testing.T) {
+		b := NewBroker(t)
+		topic := b.Topic(t, "test", 4)
+		w := &Writer{
+			Broker:   b,
+			Topic:    topic,
+			Balancer: &kafka.Hash{},
+		}
+		writeBrokerMessages(ctx, t, w, 10, kafka.Message{
+			Key: []byte("key"),
+		})
+		partitions := getBrokerNonEmptyPartitions(b, topic, 4)
+		if len(partitions) != 1 {
+			t.Fatalf("messages with the same key are in several partitions: %v", partitions)
+		}
+		if len(b.TopicMessages(topic)) != 10 {
+			t.Fatalf("unexpected messages count: %d", len(b.TopicMessages(topic)))
+		}
+	})
+	t.Run("RoundRobin", func(t *testing.T) {
+		// The default balancer is round-robin.
+		b := NewBroker(t)
+		topic := b.Topic(t, "test", 4)
+		w := &Writer{
+			Broker: b,
+			Topic:  topic,
+		}
+		writeBrokerMessages(ctx, t, w, 4, kafka.Message{})
+		testutils.Compare(t, "unexpected partitions", getBrokerNonEmptyPartitions(b, topic, 4), []int{0, 1, 2, 3})
+	})
+}
+
+func writeBrokerMessages(ctx context.Context, tb testing.TB, w *Writer, n int, msg kafka.Message) {
+	tb.Helper()
+	for i := 0; i < n; i++ {
+		err := w.WriteMessages(ctx, msg)
+		if err != nil {
+			testutils.FatalErr(tb, err)
+		}
+	}
+}
+
+func getBrokerNonEmptyPartitions(b *Broker, topic string, partitions int) []int {
+	var res []int
+	for p := 0; p < partitions; p++ {
+		if len(b.Messages(topic, p)) > 0 {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func TestBrokerWriterErrors(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	w := &Writer{
+		Broker: b,
+	}
+	for _, tc := range []struct {
+		name  string
+		topic string
+		msg   kafka.Message
+	}{
+		{
+			name: "NoTopic",
+		},
+		{
+			name:  "BothTopics",
+			topic: "test",
+			msg: kafka.Message{
+				Topic: "test",
+			},
+		},
+		{
+			name: "UnknownTopic",
+			msg: kafka.Message{
+				Topic: "unknown",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w.Topic = tc.topic
+			err := w.WriteMessages(ctx, tc.msg)
+			if err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestBrokerWriterAutoCreate(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	w := &Writer{
+		Broker:                 b,
+		AllowAutoTopicCreation: true,
+	}
+	err := w.WriteMessages(ctx, kafka.Message{
+		Topic: "test",
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if b.Partitions("test") != 1 {
+		t.Fatalf("unexpected partitions: %d", b.Partitions("test"))
+	}
+}
+
+func TestBrokerConsumerGroup(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 2)
+	w := &Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	writeBrokerMessages(ctx, t, w, 4, kafka.Message{})
+	cfg := kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "group",
+	}
+	r1 := b.NewReader(cfg)
+	defer r1.Close() //nolint:errcheck
+	msg, err := r1.ReadMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if off := b.CommittedOffset("group", topic, msg.Partition); off != 1 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 1)
+	}
+	// A new reader joins the group: the partitions are shared.
+	r2 := b.NewReader(cfg)
+	defer r2.Close() //nolint:errcheck
+	msg1 := fetchBrokerMessage(ctx, t, r1)
+	msg2 := fetchBrokerMessage(ctx, t, r2)
+	testutils.Compare(t, "unexpected assigned partitions", [][]int{r1.Assigned(), r2.Assigned()}, [][]int{{0}, {1}})
+	// The first message of partition 0 was committed.
+	checkBrokerMessagePosition(t, msg1, 0, 1)
+	checkBrokerMessagePosition(t, msg2, 1, 0)
+	// The reader leaves the group: the partitions are assigned to the other reader.
+	err = r2.Close()
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// The uncommitted message is fetched again after the rebalance.
+	msg = fetchBrokerMessage(ctx, t, r1)
+	checkBrokerMessagePosition(t, msg, 0, 1)
+	testutils.Compare(t, "unexpected assigned partitions", r1.Assigned(), []int{0, 1})
+}
+
+func fetchBrokerMessage(ctx context.Context, tb testing.TB, r *Reader) kafka.Message {
+	tb.Helper()
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(tb, err)
+	}
+	return msg
+}
+
+func checkBrokerMessagePosition(tb testing.TB, msg kafka.Message, partition int, offset int64) {
+	tb.Helper()
+	if msg.Partition != partition || msg.Offset != offset {
+		tb.Fatalf("unexpected message position: got %d/%d, want %d/%d", msg.Partition, msg.Offset, partition, offset)
+	}
+}
+
+func TestBrokerFetchBlocking(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic: topic,
+	})
+	defer r.Close() //nolint:errcheck
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w := &Writer{
+			Broker: b,
+			Topic:  topic,
+		}
+		_ = w.WriteMessages(ctx, kafka.Message{
+			Value: []byte("value"),
+		})
+	}()
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(msg.Value) != "value" {
+		t.Fatalf("unexpected value: %q", msg.Value)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = r.FetchMessage(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBrokerFetchClosed(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic: topic,
+	})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = r.Close()
+	}()
+	_, err := r.FetchMessage(ctx)
+	if err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBrokerStartOffsetLast(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	w := &Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	err := w.WriteMessages(ctx, kafka.Message{Value: []byte("old")})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:       topic,
+		GroupID:     "group",
+		StartOffset: kafka.LastOffset,
+	})
+	defer r.Close() //nolint:errcheck
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = r.FetchMessage(ctx2)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = w.WriteMessages(ctx, kafka.Message{Value: []byte("new")})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(msg.Value) != "new" {
+		t.Fatalf("unexpected value: %q", msg.Value)
+	}
+}
+
+func TestBrokerReaderSetOffset(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	w := &Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		err := w.WriteMessages(ctx, kafka.Message{Value: []byte(v)})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic: topic,
+	})
+	defer r.Close() //nolint:errcheck
+	err := r.SetOffset(2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(msg.Value) != "c" {
+		t.Fatalf("unexpected value: %q", msg.Value)
+	}
+}
+
+func TestBrokerCommitOffsets(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	w := &Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	for _, v := range []string{"a", "b"} {
+		err := w.WriteMessages(ctx, kafka.Message{Value: []byte(v)})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "group",
+	})
+	defer r.Close() //nolint:errcheck
+	for i := 0; i < 2; i++ {
+		_, err := r.ReadMessage(ctx)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	err := b.CommitOffsets(ctx, "group", map[string]map[int]int64{topic: {0: 1}})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if off := b.CommittedOffset("group", topic, 0); off != 1 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 1)
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if string(msg.Value) != "b" {
+		t.Fatalf("unexpected value: %q", msg.Value)
+	}
+}
@@ -1,6 +1,8 @@
 // Package kafkatest provides Kafka test utilities.
 //
-// If Kafka is not available, the test is skipped.
+// Broker is an in-memory fake, that doesn't require a running Kafka.
+//
+// For the other utilities, if Kafka is not available, the test is skipped.
 // It can be controlled with the KAFKATEST_UNAVAILABLE_SKIP environment variable.
 package kafkatest
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/segmentio/kafka-go"
 	"github.com/siddhant2408/golang-libraries/ctxutils"
@@ -19,15 +20,23 @@ import (
 //  - commit the messages
 //
 // If the processor returns an error, the method Consume() returns.
+//
+// When the context is canceled, it stops fetching, and flushes the accumulated messages (processing and commit) within DrainTimeout.
 type BatchConsumer struct {
 	Accumulator func(context.Context, Fetcher) ([]kafka.Message, error)
 	Processor   BatchConsumerProcessor
+	// DrainTimeout is the maximum duration to flush the accumulated messages, after the context is canceled.
+	// The context given to the processor is canceled after it.
+	// Default: 30s.
+	DrainTimeout time.Duration
 }
 
 // Consume consumes batches of messages from a reader.
 func (c *BatchConsumer) Consume(ctx context.Context, r FetchCommitter) error {
+	drainCtx, drainCancel := newDrainContext(ctx, getDrainTimeout(c.DrainTimeout))
+	defer drainCancel()
 	for !ctxutils.IsDone(ctx) {
-		err := c.consume(ctx, r)
+		err := c.consume(ctx, drainCtx, r)
 		if err != nil {
 			return errors.Wrap(err, "batch consumer")
 		}
@@ -35,27 +44,25 @@ func (c *BatchConsumer) Consume(ctx context.Context, r FetchCommitter) error {
 	return nil
 }
 
-func (c *BatchConsumer) consume(ctx context.Context, r FetchCommitter) error {
+func (c *BatchConsumer) consume(ctx context.Context, drainCtx context.Context, r FetchCommitter) error {
+	// If ctx is canceled, the accumulator returns the accumulated messages, and they are flushed.
 	msgs, err := c.Accumulator(ctx, r.FetchMessage)
 	if err != nil {
 		return errors.Wrap(err, "accumulator")
 	}
-	if ctxutils.IsDone(ctx) {
-		return nil
-	}
 	if len(msgs) == 0 {
 		return nil
 	}
-	err = c.consumeMessages(r, msgs)
+	// The messages are not interrupted when ctx is canceled, but when drainCtx is canceled.
+	err = c.consumeMessages(drainCtx, r, msgs)
 	if err != nil {
 		return errors.Wrap(err, "messages")
 	}
 	return nil
 }
 
-func (c *BatchConsumer) consumeMessages(r FetchCommitter, msgs []kafka.Message) (err error) {
-	ctx := context.Background() // Don't want to be interrupted.
-	span, spanFinish := startTraceRootSpan(&ctx, "batch_consumer", &err)
+func (c *BatchConsumer) consumeMessages(ctx context.Context, r FetchCommitter, msgs []kafka.Message) (err error) {
+	span, spanFinish := startTraceRemoteChildSpan(&ctx, extractTraceContextMessages(msgs), "batch_consumer", &err)
 	defer spanFinish()
 	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
 	opentracing_ext.SpanKindConsumer.Set(span)
@@ -94,6 +101,17 @@ func (c *BatchConsumer) commitMessages(ctx context.Context, r FetchCommitter, ms
 	return nil
 }
 
+// extractTraceContextMessages returns the span context of the first message that has one.
+func extractTraceContextMessages(msgs []kafka.Message) opentracing.SpanContext {
+	for _, msg := range msgs {
+		sc := ExtractTraceContext(msg.Headers)
+		if sc != nil {
+			return sc
+		}
+	}
+	return nil
+}
+
 // BatchConsumerProcessor processes a batch of messages from a BatchConsumer.
 type BatchConsumerProcessor func(context.Context, []kafka.Message) error
 
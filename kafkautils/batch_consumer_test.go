@@ -3,6 +3,7 @@ package kafkautils
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/siddhant2408/golang-libraries/errors"
@@ -55,6 +56,62 @@ func TestBatchConsumerAccumulatorContextDone(t *testing.T) {
 	}
 }
 
+func TestBatchConsumerDrain(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	a := func(context.Context, Fetcher) ([]kafka.Message, error) {
+		cancel()
+		msgs := make([]kafka.Message, 5)
+		return msgs, nil
+	}
+	var prCalled testutils.CallCounter
+	pr := func(ctx context.Context, msgs []kafka.Message) error {
+		prCalled.Call()
+		return ctx.Err() // The accumulated messages are flushed.
+	}
+	var commitCalled testutils.CallCounter
+	r := &testFetchCommitter{
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			commitCalled.Call()
+			return ctx.Err()
+		},
+	}
+	c := &BatchConsumer{
+		Accumulator: a,
+		Processor:   pr,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	prCalled.AssertCount(t, 1)
+	commitCalled.AssertCount(t, 1)
+}
+
+func TestBatchConsumerDrainTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	a := func(context.Context, Fetcher) ([]kafka.Message, error) {
+		cancel()
+		msgs := make([]kafka.Message, 5)
+		return msgs, nil
+	}
+	pr := func(ctx context.Context, msgs []kafka.Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r := &testFetchCommitter{}
+	c := &BatchConsumer{
+		Accumulator:  a,
+		Processor:    pr,
+		DrainTimeout: 10 * time.Millisecond,
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
 func TestBatchConsumerAccumulatorNoMessage(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
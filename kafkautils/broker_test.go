@@ -0,0 +1,191 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+func newTestBrokerProducer(b *kafkatest.Broker) *SimpleProducer {
+	return &SimpleProducer{
+		Writer: (&kafkatest.Writer{
+			Broker:   b,
+			Balancer: &kafka.Hash{},
+		}).WriteMessages,
+	}
+}
+
+func TestBrokerConsumer(t *testing.T) {
+	ctx := context.Background()
+	b := kafkatest.NewBroker(t)
+	topic := b.Topic(t, "test", 2)
+	p := newTestBrokerProducer(b)
+	produceTestBrokerValues(ctx, t, p, topic, "a", "b", "error", "c")
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	processed := 0
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			processed++
+			if processed == 4 {
+				cancel()
+			}
+			if string(msg.Value) == "error" {
+				return errors.WithTemporary(errors.New("error"), false)
+			}
+			return nil
+		},
+		Discard: (&TopicProducer{
+			Producer: p.Produce,
+			Topic:    b.Topic(t, "discard", 1),
+		}).Produce,
+		Error: func(ctx context.Context, err error) {},
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	checkTestBrokerCommittedAll(t, b, "test", topic, 2)
+	discarded := b.TopicMessages("discard")
+	if len(discarded) != 1 || string(discarded[0].Value) != "error" {
+		t.Fatalf("unexpected discarded messages: %v", discarded)
+	}
+}
+
+// produceTestBrokerValues produces a message for each value, with the value as key.
+func produceTestBrokerValues(ctx context.Context, tb testing.TB, p *SimpleProducer, topic string, values ...string) {
+	tb.Helper()
+	for _, v := range values {
+		err := p.Produce(ctx, kafka.Message{
+			Topic: topic,
+			Key:   []byte(v),
+			Value: []byte(v),
+		})
+		if err != nil {
+			testutils.FatalErr(tb, err)
+		}
+	}
+}
+
+// checkTestBrokerCommittedAll checks that all the messages of the non-empty partitions are committed.
+func checkTestBrokerCommittedAll(tb testing.TB, b *kafkatest.Broker, group, topic string, partitions int) {
+	tb.Helper()
+	for partition := 0; partition < partitions; partition++ {
+		expected := int64(len(b.Messages(topic, partition)))
+		if off := b.CommittedOffset(group, topic, partition); expected > 0 && off != expected {
+			tb.Fatalf("partition %d: unexpected committed offset: got %d, want %d", partition, off, expected)
+		}
+	}
+}
+
+func TestBrokerBatchConsumer(t *testing.T) {
+	ctx := context.Background()
+	b := kafkatest.NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	p := newTestBrokerProducer(b)
+	msgs := make([]kafka.Message, 10)
+	for i := range msgs {
+		msgs[i] = kafka.Message{
+			Topic: topic,
+		}
+	}
+	err := p.Produce(ctx, msgs...)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var batches []int
+	a := &Accumulator{
+		Size:    4,
+		Timeout: 10 * time.Millisecond,
+	}
+	c := &BatchConsumer{
+		Accumulator: a.Accumulate,
+		Processor: func(ctx context.Context, msgs []kafka.Message) error {
+			batches = append(batches, len(msgs))
+			if len(batches) == 3 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	err = c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected batches", batches, []int{4, 4, 2})
+	if off := b.CommittedOffset("test", topic, 0); off != 10 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 10)
+	}
+}
+
+func TestBrokerWaitConsumer(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	b := kafkatest.NewBroker(t)
+	waitTopic := b.Topic(t, "wait", 1)
+	mainTopic := b.Topic(t, "main", 1)
+	p := newTestBrokerProducer(b)
+	wp := &WaitProducer{
+		Producer: (&TopicProducer{
+			Producer: p.Produce,
+			Topic:    waitTopic,
+		}).Produce,
+		Wait: -1 * time.Second, // Already expired, so it doesn't wait.
+	}
+	err := wp.Produce(ctx, kafka.Message{
+		Value: []byte("value"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   waitTopic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := &WaitConsumer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			defer cancel()
+			return (&TopicProducer{
+				Producer: p.Produce,
+				Topic:    mainTopic,
+			}).Produce(ctx, msgs...)
+		},
+	}
+	err = c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	mainMsgs := b.TopicMessages(mainTopic)
+	if len(mainMsgs) != 1 || string(mainMsgs[0].Value) != "value" {
+		t.Fatalf("unexpected messages: %v", mainMsgs)
+	}
+	if _, ok := GetHeader(mainMsgs[0].Headers, WaitUntilHeader); ok {
+		t.Fatal("wait until header is defined")
+	}
+	if off := b.CommittedOffset("test", waitTopic, 0); off != 1 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 1)
+	}
+}
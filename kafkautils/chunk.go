@@ -0,0 +1,480 @@
+package kafkautils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+// Chunk headers.
+const (
+	// ChunkIDHeader is the header containing the ID of the chunked message, shared by the chunks.
+	ChunkIDHeader = "chunk-id"
+	// ChunkIndexHeader is the header containing the index of the chunk (decimal, from 0).
+	ChunkIndexHeader = "chunk-index"
+	// ChunkCountHeader is the header containing the count of chunks (decimal).
+	ChunkCountHeader = "chunk-count"
+	// ChunkChecksumHeader is the header containing the SHA-256 checksum of the whole value (hex).
+	ChunkChecksumHeader = "chunk-checksum"
+)
+
+// ChunkProducer splits the large messages into chunks.
+//
+// If the value of a message is greater than ChunkSize, it is split into numbered chunks with a shared ID.
+// The chunks of a message are produced in order, in the same call to Producer.
+// They have the same key, and Producer must write the messages with the same key to the same partition.
+// NewChunkProducer checks it for a kafka.Writer.
+// If the message doesn't have a key, the chunk ID is used as key.
+// The headers of the message are copied to all chunks.
+//
+// The other messages are not modified.
+// The chunks are reassembled by ChunkReader.
+type ChunkProducer struct {
+	Producer Producer
+	// ChunkSize is the maximum size of the value of a chunk.
+	// It should be lower than the broker "message.max.bytes", minus the size of the key and headers.
+	// Default: 512KiB.
+	ChunkSize int
+}
+
+// NewChunkProducer returns a ChunkProducer that produces to a kafka.Writer with a SimpleProducer.
+//
+// It returns an error if the balancer of the writer is not based on the key (kafka.Hash, kafka.CRC32Balancer or kafka.Murmur2Balancer),
+// because the chunks of a message would be written to different partitions.
+// The default balancer (kafka.RoundRobin) is rejected.
+func NewChunkProducer(w *kafka.Writer, chunkSize int) (*ChunkProducer, error) {
+	if !isKeyBalancer(w.Balancer) {
+		return nil, errors.Newf("Kafka chunk producer: the balancer %T is not based on the key", w.Balancer)
+	}
+	sp := &SimpleProducer{
+		Writer: w.WriteMessages,
+	}
+	return &ChunkProducer{
+		Producer:  sp.Produce,
+		ChunkSize: chunkSize,
+	}, nil
+}
+
+func isKeyBalancer(b kafka.Balancer) bool {
+	switch b.(type) {
+	case *kafka.Hash, kafka.CRC32Balancer, *kafka.CRC32Balancer, kafka.Murmur2Balancer, *kafka.Murmur2Balancer:
+		return true
+	}
+	return false
+}
+
+// Produce splits and produces messages.
+func (p *ChunkProducer) Produce(ctx context.Context, msgs ...kafka.Message) error {
+	size := p.getChunkSize()
+	var chunks []kafka.Message
+	for _, msg := range msgs {
+		if len(msg.Value) <= size {
+			chunks = append(chunks, msg)
+			continue
+		}
+		cs, err := newChunks(msg, size)
+		if err != nil {
+			return errors.Wrap(err, "Kafka chunk producer")
+		}
+		chunks = append(chunks, cs...)
+	}
+	return p.Producer(ctx, chunks...)
+}
+
+func (p *ChunkProducer) getChunkSize() int {
+	if p.ChunkSize > 0 {
+		return p.ChunkSize
+	}
+	return 512 << 10
+}
+
+func newChunks(msg kafka.Message, size int) ([]kafka.Message, error) {
+	id, err := generateChunkID()
+	if err != nil {
+		return nil, errors.Wrap(err, "generate ID")
+	}
+	key := msg.Key
+	if len(key) == 0 {
+		key = []byte(id)
+	}
+	sum := sha256.Sum256(msg.Value)
+	checksum := []byte(hex.EncodeToString(sum[:]))
+	count := (len(msg.Value) + size - 1) / size
+	countBytes := []byte(strconv.Itoa(count))
+	chunks := make([]kafka.Message, count)
+	for i := range chunks {
+		end := (i + 1) * size
+		if end > len(msg.Value) {
+			end = len(msg.Value)
+		}
+		headers := make([]kafka.Header, len(msg.Headers), len(msg.Headers)+4)
+		copy(headers, msg.Headers)
+		headers = SetHeader(headers, ChunkIDHeader, []byte(id))
+		headers = SetHeader(headers, ChunkIndexHeader, []byte(strconv.Itoa(i)))
+		headers = SetHeader(headers, ChunkCountHeader, countBytes)
+		headers = SetHeader(headers, ChunkChecksumHeader, checksum)
+		chunks[i] = kafka.Message{
+			Topic:   msg.Topic,
+			Key:     key,
+			Value:   msg.Value[i*size : end],
+			Headers: headers,
+			Time:    msg.Time,
+		}
+	}
+	return chunks, nil
+}
+
+const chunkIDByteCount = 16
+
+func generateChunkID() (string, error) {
+	var buf [chunkIDByteCount]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", errors.Wrap(err, "")
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// Chunk discard reasons.
+const (
+	ChunkDiscardTimeout  = "timeout"
+	ChunkDiscardMemory   = "memory"
+	ChunkDiscardChecksum = "checksum"
+	ChunkDiscardInvalid  = "invalid"
+)
+
+// ChunkDiscardReport is the report of a discarded chunked message.
+type ChunkDiscardReport struct {
+	ID        string
+	Topic     string
+	Partition int
+	// FirstOffset is the offset of the first received chunk.
+	FirstOffset int64
+	// Received is the count of received chunks.
+	Received int
+	// Count is the expected count of chunks.
+	Count int
+	// Bytes is the size of the received chunks.
+	Bytes  int
+	Reason string
+}
+
+// ChunkReader reassembles the chunks produced by ChunkProducer.
+//
+// It wraps a FetchCommitter, and can be used by any consumer, e.g. Consumer.
+// FetchMessage returns the messages that are not chunked, and the reassembled messages.
+// A reassembled message has the topic, partition, key, headers (without the chunk headers) and time of the first chunk, and the offset of the last chunk.
+// Its key is nil if the original message didn't have a key.
+//
+// The chunks are buffered in memory until all the chunks of a message are received.
+// The committed offset of a partition never goes past a buffered chunk, so the chunks are fetched again after a restart.
+// Some messages can be delivered again, like after a rebalance.
+//
+// A chunked message is discarded and reported if:
+//   - its chunks are not received before Timeout (checked when a message is fetched)
+//   - the buffered chunks exceed MaxBytes (the oldest messages are discarded)
+//   - the checksum doesn't match
+//   - the chunk headers are invalid
+type ChunkReader struct {
+	FetchCommitter FetchCommitter
+	// MaxBytes is the maximum size of the buffered chunks.
+	// Default: 64MiB.
+	MaxBytes int
+	// Timeout is the maximum duration between the first chunk and the last chunk of a message.
+	// Default: 1m.
+	Timeout time.Duration
+	// Discard is called when a chunked message is discarded, by FetchMessage.
+	// It is optional.
+	Discard func(context.Context, ChunkDiscardReport)
+
+	mu sync.Mutex
+	// pending contains the chunked messages that are not complete, in order of first chunk.
+	pending []*chunkGroup
+	bytes   int
+	// committed contains the committed offsets, by partition.
+	committed map[chunkPartitionKey]int64
+	reports   []ChunkDiscardReport
+}
+
+type chunkPartitionKey struct {
+	topic     string
+	partition int
+}
+
+type chunkGroup struct {
+	id       string
+	first    kafka.Message
+	last     kafka.Message
+	count    int
+	checksum string
+	chunks   [][]byte
+	received int
+	bytes    int
+	start    time.Time
+}
+
+// FetchMessage implements FetchCommitter.
+func (r *ChunkReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		msg, err := r.FetchCommitter.FetchMessage(ctx)
+		if err != nil {
+			return kafka.Message{}, err
+		}
+		r.mu.Lock()
+		msg, ok := r.handleMessage(msg)
+		reports := r.reports
+		r.reports = nil
+		r.mu.Unlock()
+		if r.Discard != nil {
+			for _, rp := range reports {
+				r.Discard(ctx, rp)
+			}
+		}
+		if ok {
+			return msg, nil
+		}
+	}
+}
+
+// handleMessage returns the message if it is not chunked, or the reassembled message if it is complete.
+// The lock must be held.
+func (r *ChunkReader) handleMessage(msg kafka.Message) (kafka.Message, bool) {
+	r.discardTimeout()
+	id, ok := GetHeader(msg.Headers, ChunkIDHeader)
+	if !ok {
+		return msg, true
+	}
+	return r.addChunk(string(id), msg)
+}
+
+// CommitMessages implements FetchCommitter.
+//
+// For each partition, the committed offset is bounded by the first buffered chunk.
+func (r *ChunkReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	commits := r.getCommits(msgs)
+	if len(commits) == 0 {
+		return nil
+	}
+	err := r.FetchCommitter.CommitMessages(ctx, commits...)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range commits {
+		k := chunkPartitionKey{topic: m.Topic, partition: m.Partition}
+		if m.Offset+1 > r.committed[k] {
+			r.committed[k] = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+// getCommits returns the last message to commit for each partition.
+func (r *ChunkReader) getCommits(msgs []kafka.Message) []kafka.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offsets := make(map[chunkPartitionKey]kafka.Message)
+	for _, msg := range msgs {
+		k := chunkPartitionKey{topic: msg.Topic, partition: msg.Partition}
+		if m, ok := offsets[k]; !ok || msg.Offset > m.Offset {
+			offsets[k] = msg
+		}
+	}
+	r.boundCommitsByPending(offsets)
+	if r.committed == nil {
+		r.committed = make(map[chunkPartitionKey]int64)
+	}
+	commits := make([]kafka.Message, 0, len(offsets))
+	for k, m := range offsets {
+		c, ok := r.committed[k]
+		if m.Offset < 0 || (ok && m.Offset+1 <= c) {
+			continue
+		}
+		commits = append(commits, m)
+	}
+	return commits
+}
+
+// boundCommitsByPending lowers the commits, so the first chunk of each pending group is not committed.
+// The lock must be held.
+func (r *ChunkReader) boundCommitsByPending(offsets map[chunkPartitionKey]kafka.Message) {
+	for _, g := range r.pending {
+		k := chunkPartitionKey{topic: g.first.Topic, partition: g.first.Partition}
+		m, ok := offsets[k]
+		if ok && m.Offset >= g.first.Offset {
+			// The committed offset is the offset of the message + 1, so it is the offset of the first buffered chunk.
+			m.Offset = g.first.Offset - 1
+			offsets[k] = m
+		}
+	}
+}
+
+// addChunk buffers a chunk, and returns the reassembled message if it is complete.
+// The lock must be held.
+func (r *ChunkReader) addChunk(id string, msg kafka.Message) (kafka.Message, bool) {
+	index, count, err := getChunkIndexCount(msg)
+	if err != nil {
+		r.report(&chunkGroup{id: id, first: msg}, ChunkDiscardInvalid)
+		return kafka.Message{}, false
+	}
+	g := r.getGroup(id)
+	if g == nil {
+		checksum, _ := GetHeader(msg.Headers, ChunkChecksumHeader)
+		g = &chunkGroup{
+			id:       id,
+			first:    msg,
+			count:    count,
+			checksum: string(checksum),
+			chunks:   make([][]byte, count),
+			start:    timeutils.Now(),
+		}
+		r.pending = append(r.pending, g)
+	}
+	if count != g.count {
+		r.remove(g)
+		r.report(g, ChunkDiscardInvalid)
+		return kafka.Message{}, false
+	}
+	if g.chunks[index] == nil {
+		g.received++
+	} else {
+		// Received again, e.g. after a rebalance.
+		g.bytes -= len(g.chunks[index])
+		r.bytes -= len(g.chunks[index])
+	}
+	value := msg.Value
+	if value == nil {
+		value = []byte{}
+	}
+	g.chunks[index] = value
+	g.bytes += len(value)
+	r.bytes += len(value)
+	if msg.Offset >= g.last.Offset {
+		g.last = msg
+	}
+	r.discardMemory()
+	if g.received < g.count {
+		return kafka.Message{}, false
+	}
+	if r.getGroup(id) == nil {
+		return kafka.Message{}, false // Discarded.
+	}
+	r.remove(g)
+	value = bytes.Join(g.chunks, nil)
+	sum := sha256.Sum256(value)
+	if hex.EncodeToString(sum[:]) != g.checksum {
+		r.report(g, ChunkDiscardChecksum)
+		return kafka.Message{}, false
+	}
+	return g.newMessage(value), true
+}
+
+func getChunkIndexCount(msg kafka.Message) (index int, count int, err error) {
+	indexBytes, _ := GetHeader(msg.Headers, ChunkIndexHeader)
+	index, err = strconv.Atoi(string(indexBytes))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "index")
+	}
+	countBytes, _ := GetHeader(msg.Headers, ChunkCountHeader)
+	count, err = strconv.Atoi(string(countBytes))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "count")
+	}
+	if count <= 0 || index < 0 || index >= count {
+		return 0, 0, errors.Newf("invalid index %d or count %d", index, count)
+	}
+	return index, count, nil
+}
+
+func (g *chunkGroup) newMessage(value []byte) kafka.Message {
+	msg := g.first
+	msg.Offset = g.last.Offset
+	msg.Value = value
+	if string(msg.Key) == g.id {
+		msg.Key = nil
+	}
+	var headers []kafka.Header
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case ChunkIDHeader, ChunkIndexHeader, ChunkCountHeader, ChunkChecksumHeader:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	msg.Headers = headers
+	return msg
+}
+
+func (r *ChunkReader) getGroup(id string) *chunkGroup {
+	for _, g := range r.pending {
+		if g.id == id {
+			return g
+		}
+	}
+	return nil
+}
+
+func (r *ChunkReader) remove(g *chunkGroup) {
+	for i, pg := range r.pending {
+		if pg == g {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			r.bytes -= g.bytes
+			return
+		}
+	}
+}
+
+func (r *ChunkReader) discardTimeout() {
+	timeout := r.getTimeout()
+	for len(r.pending) > 0 && timeutils.Since(r.pending[0].start) > timeout {
+		g := r.pending[0]
+		r.remove(g)
+		r.report(g, ChunkDiscardTimeout)
+	}
+}
+
+func (r *ChunkReader) discardMemory() {
+	maxBytes := r.getMaxBytes()
+	for len(r.pending) > 0 && r.bytes > maxBytes {
+		g := r.pending[0]
+		r.remove(g)
+		r.report(g, ChunkDiscardMemory)
+	}
+}
+
+func (r *ChunkReader) report(g *chunkGroup, reason string) {
+	r.reports = append(r.reports, ChunkDiscardReport{
+		ID:          g.id,
+		Topic:       g.first.Topic,
+		Partition:   g.first.Partition,
+		FirstOffset: g.first.Offset,
+		Received:    g.received,
+		Count:       g.count,
+		Bytes:       g.bytes,
+		Reason:      reason,
+	})
+}
+
+func (r *ChunkReader) getMaxBytes() int {
+	if r.MaxBytes > 0 {
+		return r.MaxBytes
+	}
+	return 64 << 20
+}
+
+func (r *ChunkReader) getTimeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return 1 * time.Minute
+}
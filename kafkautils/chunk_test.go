@@ -0,0 +1,444 @@
+package kafkautils
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+func newTestChunks(t *testing.T, size int, msgs ...kafka.Message) []kafka.Message {
+	t.Helper()
+	var chunks []kafka.Message
+	p := &ChunkProducer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			chunks = append(chunks, msgs...)
+			return nil
+		},
+		ChunkSize: size,
+	}
+	err := p.Produce(context.Background(), msgs...)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for i := range chunks {
+		chunks[i].Offset = int64(i)
+	}
+	return chunks
+}
+
+func newTestChunkReader(msgs []kafka.Message, commit func(context.Context, ...kafka.Message) error) *ChunkReader {
+	return &ChunkReader{
+		FetchCommitter: &testFetchCommitter{
+			fetch: func(ctx context.Context) (kafka.Message, error) {
+				if len(msgs) == 0 {
+					return kafka.Message{}, errors.New("no message")
+				}
+				msg := msgs[0]
+				msgs = msgs[1:]
+				return msg, nil
+			},
+			commit: commit,
+		},
+	}
+}
+
+func TestChunkProducer(t *testing.T) {
+	chunks := newTestChunks(t, 4,
+		kafka.Message{
+			Value: []byte("abcd"),
+		},
+		kafka.Message{
+			Key:   []byte("key"),
+			Value: []byte("0123456789"),
+			Headers: []kafka.Header{
+				{
+					Key:   "foo",
+					Value: []byte("bar"),
+				},
+			},
+		},
+	)
+	if len(chunks) != 4 {
+		t.Fatalf("unexpected chunk count: got %d, want %d", len(chunks), 4)
+	}
+	if _, ok := GetHeader(chunks[0].Headers, ChunkIDHeader); ok {
+		t.Fatal("small message is chunked")
+	}
+	id, _ := GetHeader(chunks[1].Headers, ChunkIDHeader)
+	var values []string
+	for i, c := range chunks[1:] {
+		if !bytes.Equal(c.Key, []byte("key")) {
+			t.Fatalf("unexpected key: got %q, want %q", c.Key, "key")
+		}
+		v, _ := GetHeader(c.Headers, ChunkIDHeader)
+		if !bytes.Equal(v, id) {
+			t.Fatalf("chunk %d: unexpected ID: got %q, want %q", i, v, id)
+		}
+		v, _ = GetHeader(c.Headers, ChunkIndexHeader)
+		if string(v) != strconv.Itoa(i) {
+			t.Fatalf("chunk %d: unexpected index: got %q, want %q", i, v, strconv.Itoa(i))
+		}
+		v, _ = GetHeader(c.Headers, ChunkCountHeader)
+		if string(v) != "3" {
+			t.Fatalf("chunk %d: unexpected count: got %q, want %q", i, v, "3")
+		}
+		v, _ = GetHeader(c.Headers, "foo")
+		if string(v) != "bar" {
+			t.Fatalf("chunk %d: unexpected header: got %q, want %q", i, v, "bar")
+		}
+		values = append(values, string(c.Value))
+	}
+	testutils.Compare(t, "unexpected values", values, []string{"0123", "4567", "89"})
+}
+
+func TestChunkProducerNoKey(t *testing.T) {
+	chunks := newTestChunks(t, 4, kafka.Message{
+		Value: []byte("0123456789"),
+	})
+	id, _ := GetHeader(chunks[0].Headers, ChunkIDHeader)
+	for _, c := range chunks {
+		if !bytes.Equal(c.Key, id) {
+			t.Fatalf("unexpected key: got %q, want %q", c.Key, id)
+		}
+	}
+}
+
+func TestChunkProducerError(t *testing.T) {
+	p := &ChunkProducer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			return errors.New("error")
+		},
+	}
+	err := p.Produce(context.Background(), kafka.Message{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4,
+		kafka.Message{
+			Value: []byte("0123456789"),
+			Headers: []kafka.Header{
+				{
+					Key:   "foo",
+					Value: []byte("bar"),
+				},
+			},
+		},
+		kafka.Message{
+			Value: []byte("abc"),
+		},
+	)
+	// The small message is fetched before the last chunk.
+	chunks[2], chunks[3] = chunks[3], chunks[2]
+	for i := range chunks {
+		chunks[i].Offset = int64(i)
+	}
+	r := newTestChunkReader(chunks, nil)
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected message", msg, kafka.Message{
+		Value:  []byte("abc"),
+		Offset: 2,
+	})
+	msg, err = r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected message", msg, kafka.Message{
+		Value: []byte("0123456789"),
+		Headers: []kafka.Header{
+			{
+				Key:   "foo",
+				Value: []byte("bar"),
+			},
+		},
+		Offset: 3,
+	})
+}
+
+func TestChunkReaderDuplicate(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4, kafka.Message{
+		Value: []byte("0123456789"),
+	})
+	chunks = append(chunks[:2], chunks...)
+	r := newTestChunkReader(chunks, nil)
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !bytes.Equal(msg.Value, []byte("0123456789")) {
+		t.Fatalf("unexpected value: got %q, want %q", msg.Value, "0123456789")
+	}
+}
+
+func TestChunkReaderCommit(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4,
+		kafka.Message{
+			Value: []byte("0123456789"),
+		},
+	)
+	chunks = append(chunks[:2:2], append([]kafka.Message{{Value: []byte("small")}}, chunks[2:]...)...)
+	for i := range chunks {
+		chunks[i].Offset = int64(10 + i)
+	}
+	var committed []int64
+	r := newTestChunkReader(chunks, func(ctx context.Context, msgs ...kafka.Message) error {
+		for _, msg := range msgs {
+			committed = append(committed, msg.Offset)
+		}
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		err = r.CommitMessages(ctx, msg)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	// The small message is not committed, because the first chunk is buffered.
+	testutils.Compare(t, "unexpected committed offsets", committed, []int64{9, 13})
+}
+
+func TestChunkReaderDiscardTimeout(t *testing.T) {
+	ctx := context.Background()
+	defer timeutils.InitFixed()
+	chunks := newTestChunks(t, 4, kafka.Message{
+		Value: []byte("0123456789"),
+	})
+	chunks = append(chunks[:1], kafka.Message{Value: []byte("small"), Offset: 3})
+	r := newTestChunkReader(chunks, nil)
+	r.Timeout = 1 * time.Minute
+	var reports []ChunkDiscardReport
+	r.Discard = func(ctx context.Context, rp ChunkDiscardReport) {
+		reports = append(reports, rp)
+	}
+	fetch := r.FetchCommitter.FetchMessage
+	r.FetchCommitter = &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			msg, err := fetch(ctx)
+			if msg.Offset == 3 {
+				timeutils.SetFixed(timeutils.Now().Add(2 * time.Minute))
+			}
+			return msg, err
+		},
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if msg.Offset != 3 {
+		t.Fatalf("unexpected offset: got %d, want %d", msg.Offset, 3)
+	}
+	id, _ := GetHeader(chunks[0].Headers, ChunkIDHeader)
+	testutils.Compare(t, "unexpected reports", reports, []ChunkDiscardReport{
+		{
+			ID:       string(id),
+			Received: 1,
+			Count:    3,
+			Bytes:    4,
+			Reason:   ChunkDiscardTimeout,
+		},
+	})
+}
+
+func TestChunkReaderDiscardMemory(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4,
+		kafka.Message{
+			Value: []byte("0123456789"),
+		},
+		kafka.Message{
+			Value: []byte("abcdefgh"),
+		},
+	)
+	// The chunks of the 2 messages are interleaved.
+	chunks = []kafka.Message{chunks[0], chunks[3], chunks[1], chunks[4]}
+	r := newTestChunkReader(chunks, nil)
+	r.MaxBytes = 12
+	var reports []ChunkDiscardReport
+	r.Discard = func(ctx context.Context, rp ChunkDiscardReport) {
+		reports = append(reports, rp)
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !bytes.Equal(msg.Value, []byte("abcdefgh")) {
+		t.Fatalf("unexpected value: got %q, want %q", msg.Value, "abcdefgh")
+	}
+	if len(reports) != 1 || reports[0].Reason != ChunkDiscardMemory || reports[0].Received != 2 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+}
+
+func TestChunkReaderDiscardChecksum(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4, kafka.Message{
+		Value: []byte("0123456789"),
+	})
+	chunks[1].Value = []byte("xxxx")
+	chunks = append(chunks, kafka.Message{Value: []byte("small")})
+	r := newTestChunkReader(chunks, nil)
+	var reports []ChunkDiscardReport
+	r.Discard = func(ctx context.Context, rp ChunkDiscardReport) {
+		reports = append(reports, rp)
+	}
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !bytes.Equal(msg.Value, []byte("small")) {
+		t.Fatalf("unexpected value: got %q, want %q", msg.Value, "small")
+	}
+	if len(reports) != 1 || reports[0].Reason != ChunkDiscardChecksum || reports[0].Received != 3 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+}
+
+func TestChunkReaderDiscardInvalid(t *testing.T) {
+	ctx := context.Background()
+	chunks := newTestChunks(t, 4, kafka.Message{
+		Value: []byte("0123456789"),
+	})
+	chunks[0].Headers = SetHeader(chunks[0].Headers, ChunkIndexHeader, []byte("3"))
+	r := newTestChunkReader(chunks[:1], nil)
+	var reports []ChunkDiscardReport
+	r.Discard = func(ctx context.Context, rp ChunkDiscardReport) {
+		reports = append(reports, rp)
+	}
+	_, err := r.FetchMessage(ctx)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if len(reports) != 1 || reports[0].Reason != ChunkDiscardInvalid {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+}
+
+func TestNewChunkProducer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		balancer kafka.Balancer
+		ok       bool
+	}{
+		{
+			name:     "Hash",
+			balancer: &kafka.Hash{},
+			ok:       true,
+		},
+		{
+			name:     "Murmur2",
+			balancer: kafka.Murmur2Balancer{},
+			ok:       true,
+		},
+		{
+			name: "Default",
+		},
+		{
+			name:     "LeastBytes",
+			balancer: &kafka.LeastBytes{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewChunkProducer(&kafka.Writer{
+				Balancer: tc.balancer,
+			}, 100)
+			if !tc.ok {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			if p.ChunkSize != 100 {
+				t.Fatalf("unexpected chunk size: got %d, want %d", p.ChunkSize, 100)
+			}
+		})
+	}
+}
+
+func TestBrokerChunkConsumer(t *testing.T) {
+	ctx := context.Background()
+	b := kafkatest.NewBroker(t)
+	topic := b.Topic(t, "test", 2)
+	p := &ChunkProducer{
+		Producer:  newTestBrokerProducer(b).Produce,
+		ChunkSize: 100,
+	}
+	large := strings.Repeat("0123456789", 50)
+	for _, v := range []string{"a", large, "b"} {
+		err := p.Produce(ctx, kafka.Message{
+			Topic: topic,
+			Key:   []byte(v[:1]),
+			Value: []byte(v),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var processed []string
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			processed = append(processed, string(msg.Value))
+			if len(processed) == 3 {
+				cancel()
+			}
+			return nil
+		},
+		Error: func(ctx context.Context, err error) {
+			testutils.ErrorErr(t, err)
+		},
+	}
+	err := c.Consume(ctx, &ChunkReader{
+		FetchCommitter: r,
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// The order between the partitions is not deterministic.
+	sort.Strings(processed)
+	testutils.Compare(t, "unexpected processed messages", processed, []string{large, "a", "b"})
+	if committed := sumTestBrokerCommittedOffsets(b, "test", topic, 2); committed != 7 {
+		t.Fatalf("unexpected committed offsets sum: got %d, want %d", committed, 7)
+	}
+}
+
+func sumTestBrokerCommittedOffsets(b *kafkatest.Broker, group, topic string, partitions int) int64 {
+	sum := int64(0)
+	for partition := 0; partition < partitions; partition++ {
+		off := b.CommittedOffset(group, topic, partition)
+		if off > 0 {
+			sum += off
+		}
+	}
+	return sum
+}
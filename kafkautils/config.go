@@ -27,6 +27,16 @@ func (c *Config) NewWriter() *kafka.Writer {
 	}
 }
 
+// NewClient creates a new kafka.Client.
+//
+// The Transport field is always defined with the result of c.NewTransport().
+func (c *Config) NewClient() *kafka.Client {
+	return &kafka.Client{
+		Addr:      kafka.TCP(c.Brokers...),
+		Transport: c.NewTransport(),
+	}
+}
+
 // NewTransport creates a new kafka.Transport.
 func (c *Config) NewTransport() *kafka.Transport {
 	return &kafka.Transport{
@@ -3,7 +3,9 @@ package kafkautils
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/segmentio/kafka-go"
 	"github.com/siddhant2408/golang-libraries/ctxutils"
@@ -22,6 +24,8 @@ import (
 //  - defined by ConsumerErrorWithHandler()
 //  - ConsumerDiscard if the error is not temporary
 //  - ConsumerRetry otherwise
+//
+// When the context is canceled, it stops fetching, and finishes the in-flight message (processing and commit) within DrainTimeout.
 type Consumer struct {
 	// Processor processes messages.
 	Processor ConsumerProcessor
@@ -32,12 +36,18 @@ type Consumer struct {
 	Discard Producer
 	// Error is called if the processor returns an error.
 	Error func(context.Context, error)
+	// DrainTimeout is the maximum duration to finish the in-flight message, after the context is canceled.
+	// The context given to the processor is canceled after it.
+	// Default: 30s.
+	DrainTimeout time.Duration
 }
 
 // Consume consumes messages from a reader.
 func (c *Consumer) Consume(ctx context.Context, r FetchCommitter) error {
+	drainCtx, drainCancel := newDrainContext(ctx, getDrainTimeout(c.DrainTimeout))
+	defer drainCancel()
 	for !ctxutils.IsDone(ctx) {
-		err := c.consume(ctx, r)
+		err := c.consume(ctx, drainCtx, r)
 		if err != nil {
 			return errors.Wrap(err, "consumer")
 		}
@@ -45,7 +55,7 @@ func (c *Consumer) Consume(ctx context.Context, r FetchCommitter) error {
 	return nil
 }
 
-func (c *Consumer) consume(ctx context.Context, r FetchCommitter) error {
+func (c *Consumer) consume(ctx context.Context, drainCtx context.Context, r FetchCommitter) error {
 	msg, err := r.FetchMessage(ctx)
 	if err != nil {
 		if ctxutils.IsDone(ctx) {
@@ -53,21 +63,36 @@ func (c *Consumer) consume(ctx context.Context, r FetchCommitter) error {
 		}
 		return errors.Wrap(err, "fetch")
 	}
-	err = c.consumeMessage(r, msg)
+	// The message is not interrupted when ctx is canceled, but when drainCtx is canceled.
+	err = c.consumeMessage(drainCtx, r, msg)
 	if err != nil {
 		return errors.Wrap(err, "message")
 	}
 	return nil
 }
 
-func (c *Consumer) consumeMessage(r FetchCommitter, msg kafka.Message) (err error) {
-	ctx := context.Background() // Don't want to be interrupted.
-	span, spanFinish := startTraceRootSpan(&ctx, "consumer", &err)
+func (c *Consumer) consumeMessage(ctx context.Context, r FetchCommitter, msg kafka.Message) (err error) {
+	span, spanFinish := startTraceRemoteChildSpan(&ctx, ExtractTraceContext(msg.Headers), "consumer", &err)
 	defer spanFinish()
 	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
 	opentracing_ext.SpanKindConsumer.Set(span)
 	setTraceSpanTagsMessageConsumer(span, msg)
-	err = c.processMessage(ctx, msg)
+	err = c.handleMessage(ctx, span, msg)
+	if err != nil {
+		return err
+	}
+	err = c.commitMessage(ctx, r, msg)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+// handleMessage processes a message, and handles the processor error.
+//
+// It returns an error only if the error handler fails.
+func (c *Consumer) handleMessage(ctx context.Context, span opentracing.Span, msg kafka.Message) error {
+	err := c.processMessage(ctx, msg)
 	if err != nil {
 		var h ConsumerErrorHandler
 		h, err = c.getErrorHandler(err)
@@ -82,10 +107,6 @@ func (c *Consumer) consumeMessage(r FetchCommitter, msg kafka.Message) (err erro
 			return errors.Wrap(err, "handle processor error")
 		}
 	}
-	err = c.commitMessage(ctx, r, msg)
-	if err != nil {
-		return errors.Wrap(err, "commit")
-	}
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/siddhant2408/golang-libraries/errors"
@@ -36,6 +37,68 @@ func TestConsumer(t *testing.T) {
 	}
 }
 
+func TestConsumerDrain(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	fetch := func(context.Context) (kafka.Message, error) {
+		return kafka.Message{}, nil
+	}
+	pr := func(ctx context.Context, msg kafka.Message) error {
+		cancel()
+		return ctx.Err() // The in-flight message is not interrupted.
+	}
+	var commitCalled testutils.CallCounter
+	commit := func(ctx context.Context, msgs ...kafka.Message) error {
+		commitCalled.Call()
+		return ctx.Err()
+	}
+	r := &testFetchCommitter{
+		fetch:  fetch,
+		commit: commit,
+	}
+	c := &Consumer{
+		Processor: pr,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	commitCalled.AssertCount(t, 1)
+}
+
+func TestConsumerDrainTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	fetch := func(context.Context) (kafka.Message, error) {
+		return kafka.Message{}, nil
+	}
+	pr := func(ctx context.Context, msg kafka.Message) error {
+		cancel()
+		<-ctx.Done()
+		return ConsumerErrorWithHandler(ctx.Err(), ConsumerNoop)
+	}
+	commit := func(ctx context.Context, msgs ...kafka.Message) error {
+		return ctx.Err()
+	}
+	r := &testFetchCommitter{
+		fetch:  fetch,
+		commit: commit,
+	}
+	var errorCalled testutils.CallCounter
+	c := &Consumer{
+		Processor: pr,
+		Error: func(ctx context.Context, err error) {
+			errorCalled.Call()
+		},
+		DrainTimeout: 10 * time.Millisecond,
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	errorCalled.AssertCount(t, 1)
+}
+
 func TestConsumerFetchContextDone(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
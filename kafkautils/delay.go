@@ -0,0 +1,79 @@
+package kafkautils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/goroutine"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+// DelayBucket is a topic containing the messages with a delay lower than or equal to MaxDelay.
+type DelayBucket struct {
+	Topic    string
+	MaxDelay time.Duration
+}
+
+// DelayProducer produces delayed messages to bucket topics, selected by delay.
+//
+// The messages with a short delay are never stuck behind messages with a long delay, because they are in different topics.
+// The messages are consumed by a WaitConsumer for each bucket, see RunDelayConsumers().
+type DelayProducer struct {
+	// Producer produces the messages to the bucket topics.
+	// The Topic field of the messages is defined.
+	Producer Producer
+	// Buckets are the bucket topics, by increasing MaxDelay.
+	// If the delay is greater than all MaxDelay, the last bucket is used.
+	Buckets []DelayBucket
+}
+
+// Produce adds a "wait-until" header and produces the messages to the bucket topic of the delay.
+func (p *DelayProducer) Produce(ctx context.Context, delay time.Duration, msgs ...kafka.Message) error {
+	b, ok := p.getBucket(delay)
+	if !ok {
+		return errors.New("Kafka delay producer: no bucket")
+	}
+	setWaitUntilHeader(msgs, timeutils.Now().Add(delay))
+	for i := range msgs {
+		msgs[i].Topic = b.Topic
+	}
+	err := p.Producer(ctx, msgs...)
+	if err != nil {
+		return errors.Wrapf(err, "Kafka delay producer: bucket %q", b.Topic)
+	}
+	return nil
+}
+
+func (p *DelayProducer) getBucket(delay time.Duration) (DelayBucket, bool) {
+	if len(p.Buckets) == 0 {
+		return DelayBucket{}, false
+	}
+	for _, b := range p.Buckets {
+		if delay <= b.MaxDelay {
+			return b, true
+		}
+	}
+	return p.Buckets[len(p.Buckets)-1], true
+}
+
+// RunDelayConsumers runs a WaitConsumer for each bucket topic, that produces the messages to producer.
+//
+// The Topic field of readerCfg is replaced.
+// It blocks until the context is canceled.
+func RunDelayConsumers(ctx context.Context, readerCfg kafka.ReaderConfig, buckets []DelayBucket, producer Producer, errFunc func(context.Context, error)) {
+	c := &WaitConsumer{
+		Producer: producer,
+	}
+	wg := new(sync.WaitGroup)
+	for _, b := range buckets {
+		bReaderCfg := readerCfg
+		bReaderCfg.Topic = b.Topic
+		goroutine.WaitGroup(wg, func() {
+			ConsumeReader(ctx, bReaderCfg, c.Consume, errFunc)
+		})
+	}
+	wg.Wait()
+}
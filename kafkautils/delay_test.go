@@ -0,0 +1,250 @@
+package kafkautils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+var testDelayBuckets = []DelayBucket{
+	{
+		Topic:    "delay_1m",
+		MaxDelay: 1 * time.Minute,
+	},
+	{
+		Topic:    "delay_1h",
+		MaxDelay: 1 * time.Hour,
+	},
+}
+
+func TestDelayProducer(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		delay time.Duration
+		topic string
+	}{
+		{
+			delay: 10 * time.Second,
+			topic: "delay_1m",
+		},
+		{
+			delay: 1 * time.Minute,
+			topic: "delay_1m",
+		},
+		{
+			delay: 10 * time.Minute,
+			topic: "delay_1h",
+		},
+		{
+			delay: 10 * time.Hour,
+			topic: "delay_1h",
+		},
+	} {
+		var pCalled testutils.CallCounter
+		p := &DelayProducer{
+			Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+				pCalled.Call()
+				expected := []kafka.Message{
+					{
+						Topic: tc.topic,
+						Value: []byte("value"),
+						Headers: []kafka.Header{
+							{
+								Key:   WaitUntilHeader,
+								Value: []byte(timeutils.Now().Add(tc.delay).Format(waitUntilHeaderLayout)),
+							},
+						},
+					},
+				}
+				testutils.Compare(t, "unexpected messages", msgs, expected)
+				return nil
+			},
+			Buckets: testDelayBuckets,
+		}
+		err := p.Produce(ctx, tc.delay, kafka.Message{
+			Value: []byte("value"),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		pCalled.AssertCount(t, 1)
+	}
+}
+
+func TestDelayProducerErrorNoBucket(t *testing.T) {
+	ctx := context.Background()
+	p := &DelayProducer{}
+	err := p.Produce(ctx, 1*time.Minute, kafka.Message{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestDelayProducerErrorProducer(t *testing.T) {
+	ctx := context.Background()
+	p := &DelayProducer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			return errors.New("error")
+		},
+		Buckets: testDelayBuckets,
+	}
+	err := p.Produce(ctx, 1*time.Minute, kafka.Message{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestBrokerWaitConsumerPartitionPaused(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	b := kafkatest.NewBroker(t)
+	waitTopic := b.Topic(t, "wait", 2)
+	mainTopic := b.Topic(t, "main", 1)
+	p := newTestBrokerProducer(b)
+	w := &kafkatest.Writer{
+		Broker: b,
+		Topic:  waitTopic,
+	}
+	for _, wait := range []time.Duration{
+		1 * time.Hour,    // Partition 0, not due.
+		-1 * time.Second, // Partition 1, due.
+		-1 * time.Second, // Partition 0, due but after a message that is not due.
+		-1 * time.Second, // Partition 1, due.
+	} {
+		msg := kafka.Message{
+			Value: []byte(wait.String()),
+		}
+		msgs := []kafka.Message{msg}
+		setWaitUntilHeader(msgs, now.Add(wait))
+		err := w.WriteMessages(ctx, msgs...)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   waitTopic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	forwarded := 0
+	c := &WaitConsumer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			forwarded += len(msgs)
+			if forwarded == 2 {
+				defer cancel()
+			}
+			return (&TopicProducer{
+				Producer: p.Produce,
+				Topic:    mainTopic,
+			}).Produce(ctx, msgs...)
+		},
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	mainMsgs := b.TopicMessages(mainTopic)
+	if len(mainMsgs) != 2 {
+		t.Fatalf("unexpected messages: %v", mainMsgs)
+	}
+	if off := b.CommittedOffset("test", waitTopic, 0); off >= 0 {
+		t.Fatalf("unexpected committed offset for the paused partition: got %d", off)
+	}
+	if off := b.CommittedOffset("test", waitTopic, 1); off != 2 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 2)
+	}
+}
+
+func TestWaitConsumerMaxPending(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	msg := kafka.Message{
+		Headers: []kafka.Header{
+			{
+				Key:   WaitUntilHeader,
+				Value: []byte(timeutils.Now().Add(1 * time.Hour).Format(waitUntilHeaderLayout)),
+			},
+		},
+	}
+	fetched := 0
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			fetched++
+			return msg, nil
+		},
+	}
+	c := &WaitConsumer{
+		MaxPending: 5,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// One message is held because the partition is full, and the fetch goroutine might have fetched one more message, that is not received.
+	if fetched > 7 {
+		t.Fatalf("unexpected fetched count: got %d, want <= %d", fetched, 7)
+	}
+}
+
+func TestWaitConsumerMaxPendingPartition(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	notDue := []kafka.Header{
+		{
+			Key:   WaitUntilHeader,
+			Value: []byte(timeutils.Now().Add(1 * time.Hour).Format(waitUntilHeaderLayout)),
+		},
+	}
+	msgs := []kafka.Message{
+		{Partition: 0, Offset: 0, Headers: notDue},
+		{Partition: 0, Offset: 1, Headers: notDue},
+		{Partition: 1, Offset: 0},
+		{Partition: 1, Offset: 1},
+	}
+	var committed []string
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			if len(msgs) == 0 {
+				<-ctx.Done()
+				return kafka.Message{}, ctx.Err()
+			}
+			msg := msgs[0]
+			msgs = msgs[1:]
+			return msg, nil
+		},
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			for _, msg := range msgs {
+				committed = append(committed, fmt.Sprintf("%d/%d", msg.Partition, msg.Offset))
+			}
+			if len(committed) == 2 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	c := &WaitConsumer{
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			return nil
+		},
+		MaxPending: 2,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// The full partition 0 doesn't prevent the messages of partition 1 from being forwarded.
+	testutils.Compare(t, "unexpected committed messages", committed, []string{"1/0", "1/1"})
+}
@@ -0,0 +1,45 @@
+package kafkautils
+
+import (
+	"context"
+	"time"
+
+	"github.com/siddhant2408/golang-libraries/goroutine"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// newDrainContext returns a context for the drain phase of a consumer.
+//
+// It is not canceled when the parent context is canceled, but after the timeout that follows it.
+// It allows to finish the in-flight work (processing and commit) during a graceful shutdown.
+// The values of the parent context are not kept.
+// The cancel function must be called.
+func newDrainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.Background())
+	wait := goroutine.Go(func() {
+		select {
+		case <-drainCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-drainCtx.Done():
+		case <-t.C:
+			cancel()
+		}
+	})
+	return drainCtx, func() {
+		cancel()
+		wait()
+	}
+}
+
+func getDrainTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return defaultDrainTimeout
+}
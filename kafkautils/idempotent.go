@@ -0,0 +1,97 @@
+package kafkautils
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/dedup"
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+// IdempotentProcessor skips the messages that are already processed.
+//
+// The messages can be delivered again after a rebalance, or a crash between the processing and the commit.
+// The duplicates are skipped, and committed by the Consumer.
+//
+// The key of a message is:
+//   - the value of the KeyHeader header, if it is defined in the message
+//   - "<topic>/<partition>/<offset>" otherwise
+//
+// It is prefixed by "<Namespace>/", if Namespace is defined.
+//
+// The key is marked as in-flight before the processing, and as done after a successful processing.
+// If the processing fails, the in-flight mark is removed, so the message can be processed again.
+// A message that is being processed by another consumer (e.g. during a rebalance) is retried with a temporary error.
+type IdempotentProcessor struct {
+	// Store is shared with amqputils.IdempotentProcessor, e.g. dedup.RedisStore.
+	Store dedup.Store
+	// Namespace is the prefix of the keys, e.g. the consumer group.
+	// It is optional.
+	Namespace string
+	// KeyHeader is the header containing a user-defined idempotency key.
+	// It is optional.
+	KeyHeader string
+}
+
+// Wrap wraps a ConsumerProcessor.
+//
+// If the store fails to record a processed message, the error is associated to ConsumerNoop, so the message is not retried.
+func (p *IdempotentProcessor) Wrap(pr ConsumerProcessor) ConsumerProcessor {
+	return func(ctx context.Context, msg kafka.Message) (err error) {
+		span, spanFinish := startTraceChildSpan(&ctx, "idempotent_processor", &err)
+		defer spanFinish()
+		key := p.getKey(msg)
+		setTraceSpanTag(span, "key", key)
+		st, err := p.Store.Start(ctx, key)
+		if err != nil {
+			err = wrapErrorValue(err, "idempotency_key", key)
+			return errors.Wrap(err, "Kafka idempotent processor: start")
+		}
+		setTraceSpanTag(span, "state", st.String())
+		switch st {
+		case dedup.StateDone:
+			return nil
+		case dedup.StateInFlight:
+			err = errors.New("Kafka idempotent processor: message with the same key is being processed")
+			err = wrapErrorValue(err, "idempotency_key", key)
+			return errors.WithTemporary(err, true)
+		}
+		return p.process(ctx, pr, msg, key)
+	}
+}
+
+func (p *IdempotentProcessor) process(ctx context.Context, pr ConsumerProcessor, msg kafka.Message, key string) error {
+	err := pr(ctx, msg)
+	if err != nil {
+		cerr := p.Store.Cancel(ctx, key)
+		if cerr != nil {
+			// The in-flight mark expires, so the message can be retried later.
+			cerr = wrapErrorValue(cerr, "idempotency_key", key)
+			cerr = errors.WithTemporary(cerr, true)
+			return errors.Wrapf(cerr, "Kafka idempotent processor: cancel (processor error: %v)", err)
+		}
+		return err
+	}
+	err = p.Store.Done(ctx, key)
+	if err != nil {
+		err = wrapErrorValue(err, "idempotency_key", key)
+		err = errors.Wrap(err, "Kafka idempotent processor: done")
+		return ConsumerErrorWithHandler(err, ConsumerNoop)
+	}
+	return nil
+}
+
+func (p *IdempotentProcessor) getKey(msg kafka.Message) string {
+	key := msg.Topic + "/" + strconv.Itoa(msg.Partition) + "/" + strconv.FormatInt(msg.Offset, 10)
+	if p.KeyHeader != "" {
+		v, ok := GetHeader(msg.Headers, p.KeyHeader)
+		if ok {
+			key = string(v)
+		}
+	}
+	if p.Namespace != "" {
+		key = p.Namespace + "/" + key
+	}
+	return key
+}
@@ -0,0 +1,231 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/dedup"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func TestIdempotentProcessor(t *testing.T) {
+	ctx := context.Background()
+	p := &IdempotentProcessor{
+		Store:     dedup.NewMemoryStore(),
+		Namespace: "group",
+	}
+	var prCalled testutils.CallCounter
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		prCalled.Call()
+		return nil
+	})
+	msg := kafka.Message{
+		Topic:     "test",
+		Partition: 1,
+		Offset:    2,
+	}
+	for i := 0; i < 2; i++ {
+		err := pr(ctx, msg)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	prCalled.AssertCount(t, 1)
+	msg.Offset = 3
+	err := pr(ctx, msg)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	prCalled.AssertCount(t, 2)
+}
+
+func TestIdempotentProcessorKeyHeader(t *testing.T) {
+	ctx := context.Background()
+	p := &IdempotentProcessor{
+		Store:     dedup.NewMemoryStore(),
+		KeyHeader: "idempotency-key",
+	}
+	var prCalled testutils.CallCounter
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		prCalled.Call()
+		return nil
+	})
+	for offset := int64(0); offset < 2; offset++ {
+		err := pr(ctx, kafka.Message{
+			Offset: offset,
+			Headers: []kafka.Header{
+				{
+					Key:   "idempotency-key",
+					Value: []byte("key"),
+				},
+			},
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	prCalled.AssertCount(t, 1)
+}
+
+func TestIdempotentProcessorErrorProcessor(t *testing.T) {
+	ctx := context.Background()
+	p := &IdempotentProcessor{
+		Store: dedup.NewMemoryStore(),
+	}
+	var prCalled testutils.CallCounter
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		prCalled.Call()
+		return errors.New("error")
+	})
+	for i := 0; i < 2; i++ {
+		err := pr(ctx, kafka.Message{})
+		if err == nil {
+			t.Fatal("no error")
+		}
+	}
+	// The message is not recorded, so it is processed again.
+	prCalled.AssertCount(t, 2)
+}
+
+func TestIdempotentProcessorErrorStart(t *testing.T) {
+	ctx := context.Background()
+	p := &IdempotentProcessor{
+		Store: &testDedupStoreError{},
+	}
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		t.Fatal("processor called")
+		return nil
+	})
+	err := pr(ctx, kafka.Message{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestIdempotentProcessorErrorDone(t *testing.T) {
+	ctx := context.Background()
+	p := &IdempotentProcessor{
+		Store: &testDedupStoreError{
+			Store: dedup.NewMemoryStore(),
+		},
+	}
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		return nil
+	})
+	err := pr(ctx, kafka.Message{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if h := GetConsumerErrorHandler(err); h != ConsumerNoop {
+		t.Fatalf("unexpected handler: %v", h)
+	}
+}
+
+type testDedupStoreError struct {
+	dedup.Store
+}
+
+func (s *testDedupStoreError) Start(ctx context.Context, key string) (dedup.State, error) {
+	if s.Store != nil {
+		return s.Store.Start(ctx, key)
+	}
+	return 0, errors.New("error")
+}
+
+func (s *testDedupStoreError) Done(ctx context.Context, key string) error {
+	return errors.New("error")
+}
+
+func TestIdempotentProcessorInFlight(t *testing.T) {
+	ctx := context.Background()
+	s := dedup.NewMemoryStore()
+	p := &IdempotentProcessor{
+		Store: s,
+	}
+	msg := kafka.Message{
+		Topic: "test",
+	}
+	_, err := s.Start(ctx, p.getKey(msg))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	pr := p.Wrap(func(ctx context.Context, msg kafka.Message) error {
+		t.Fatal("processor called")
+		return nil
+	})
+	err = pr(ctx, msg)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !errors.IsTemporary(err) {
+		t.Fatal("not temporary")
+	}
+}
+
+func TestBrokerIdempotentConsumer(t *testing.T) {
+	ctx := context.Background()
+	b := kafkatest.NewBroker(t)
+	topic := b.Topic(t, "test", 1)
+	p := newTestBrokerProducer(b)
+	for _, v := range []string{"a", "b"} {
+		err := p.Produce(ctx, kafka.Message{
+			Topic: topic,
+			Value: []byte(v),
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	ip := &IdempotentProcessor{
+		Store:     dedup.NewMemoryStore(),
+		Namespace: "test",
+	}
+	var processed []string
+	consume := func(count int) {
+		r := b.NewReader(kafka.ReaderConfig{
+			Topic:   topic,
+			GroupID: "test",
+		})
+		defer r.Close() //nolint:errcheck
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		fetched := 0
+		c := &Consumer{
+			Processor: ip.Wrap(func(ctx context.Context, msg kafka.Message) error {
+				processed = append(processed, string(msg.Value))
+				return nil
+			}),
+			Error: func(ctx context.Context, err error) {
+				testutils.ErrorErr(t, err)
+			},
+		}
+		err := c.Consume(ctx, &testFetchCommitter{
+			fetch: func(ctx context.Context) (kafka.Message, error) {
+				if fetched == count {
+					cancel()
+					return kafka.Message{}, ctx.Err()
+				}
+				fetched++
+				return r.FetchMessage(ctx)
+			},
+			commit: r.CommitMessages,
+		})
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+	}
+	consume(1)
+	// Simulate a crash after the processing, before the commit.
+	err := b.CommitOffsets(ctx, "test", map[string]map[int]int64{topic: {0: 0}})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	consume(2)
+	testutils.Compare(t, "unexpected processed messages", processed, []string{"a", "b"})
+	if off := b.CommittedOffset("test", topic, 0); off != 2 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, 2)
+	}
+}
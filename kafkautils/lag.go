@@ -0,0 +1,339 @@
+package kafkautils
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/ctxutils"
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+// LagClient is the subset of *kafka.Client used by LagMonitor.
+type LagClient interface {
+	Metadata(context.Context, *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	OffsetFetch(context.Context, *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	ListOffsets(context.Context, *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error)
+}
+
+// LagGroup is a consumer group monitored by LagMonitor.
+type LagGroup struct {
+	Group  string
+	Topics []string
+}
+
+// GroupLag is the lag of a consumer group.
+type GroupLag struct {
+	Group      string
+	Partitions []PartitionLag
+	// Total is the sum of the lag of the partitions.
+	Total int64
+}
+
+// PartitionLag is the lag of a consumer group for a partition.
+type PartitionLag struct {
+	Topic     string
+	Partition int
+	// Committed is the committed offset of the group, or -1 if the group has never committed.
+	Committed int64
+	// Latest is the offset of the next message that will be written to the partition.
+	Latest int64
+	// Lag is the number of messages that are not committed.
+	// If the group has never committed, it is the number of messages in the partition.
+	Lag int64
+}
+
+// LagSink receives the lag of the consumer groups.
+type LagSink interface {
+	Lag(*GroupLag)
+}
+
+// LagSinkFunc is a LagSink function.
+type LagSinkFunc func(*GroupLag)
+
+// Lag implements LagSink.
+func (f LagSinkFunc) Lag(l *GroupLag) {
+	f(l)
+}
+
+// DefaultLagSink is the default LagSink.
+//
+// It is used if LagMonitor.Sink is not defined.
+// The lag is published in the "kafka_lag" expvar.
+var DefaultLagSink LagSink = NewExpvarLagSink("kafka_lag")
+
+// LagMonitor monitors the lag of consumer groups.
+//
+// It compares the committed offsets of the groups with the latest offsets of the partitions.
+type LagMonitor struct {
+	// Client is usually a *kafka.Client, see Config.NewClient().
+	Client LagClient
+	Groups []LagGroup
+	// Interval is the interval between the checks.
+	// Default: 10s.
+	Interval time.Duration
+	// Sink receives the lag.
+	// Default: DefaultLagSink.
+	Sink LagSink
+}
+
+// Run checks the lag of the groups periodically, and publishes it to the sink.
+//
+// It blocks until the context is canceled.
+func (m *LagMonitor) Run(ctx context.Context, errFunc func(context.Context, error)) {
+	ticker := time.NewTicker(m.getInterval())
+	defer ticker.Stop()
+	for !ctxutils.IsDone(ctx) {
+		for _, g := range m.Groups {
+			err := m.check(ctx, g)
+			if err != nil && !ctxutils.IsDone(ctx) {
+				errFunc(ctx, errors.Wrap(err, "Kafka lag monitor"))
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (m *LagMonitor) check(ctx context.Context, g LagGroup) error {
+	l, err := m.GetLag(ctx, g)
+	if err != nil {
+		return err
+	}
+	m.getSink().Lag(l)
+	return nil
+}
+
+// WaitForLag waits until the lag of a group is lower than or equal to max.
+//
+// The group must be defined in Groups.
+// The lag is checked with Interval, and published to the sink.
+// It can be used for graceful deploys and tests.
+func (m *LagMonitor) WaitForLag(ctx context.Context, group string, max int64) error {
+	g, ok := m.getGroup(group)
+	if !ok {
+		return errors.Newf("Kafka lag monitor: unknown group %q", group)
+	}
+	ticker := time.NewTicker(m.getInterval())
+	defer ticker.Stop()
+	for {
+		l, err := m.GetLag(ctx, g)
+		if err != nil {
+			return errors.Wrap(err, "Kafka lag monitor")
+		}
+		m.getSink().Lag(l)
+		if l.Total <= max {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "Kafka lag monitor: group %q: lag %d is greater than %d", group, l.Total, max)
+		}
+	}
+}
+
+func (m *LagMonitor) getGroup(group string) (LagGroup, bool) {
+	for _, g := range m.Groups {
+		if g.Group == group {
+			return g, true
+		}
+	}
+	return LagGroup{}, false
+}
+
+// GetLag returns the lag of a group.
+func (m *LagMonitor) GetLag(ctx context.Context, g LagGroup) (_ *GroupLag, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "lag_monitor", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "group", g.Group)
+	partitions, err := m.getPartitions(ctx, g.Topics)
+	if err != nil {
+		return nil, errors.Wrapf(err, "group %q: get partitions", g.Group)
+	}
+	committed, err := m.getCommittedOffsets(ctx, g.Group, partitions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "group %q: get committed offsets", g.Group)
+	}
+	offsets, err := m.getOffsets(ctx, partitions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "group %q: get offsets", g.Group)
+	}
+	l := &GroupLag{
+		Group: g.Group,
+	}
+	for _, topic := range g.Topics {
+		for _, p := range partitions[topic] {
+			k := lagPartitionKey{topic: topic, partition: p}
+			pl := PartitionLag{
+				Topic:     topic,
+				Partition: p,
+				Committed: -1,
+			}
+			off := offsets[k]
+			pl.Latest = off.LastOffset
+			c, ok := committed[k]
+			if ok && c >= 0 {
+				pl.Committed = c
+				pl.Lag = off.LastOffset - c
+			} else if off.FirstOffset >= 0 {
+				pl.Lag = off.LastOffset - off.FirstOffset
+			}
+			if pl.Lag < 0 {
+				pl.Lag = 0
+			}
+			l.Partitions = append(l.Partitions, pl)
+			l.Total += pl.Lag
+		}
+	}
+	setTraceSpanTag(span, "lag", l.Total)
+	return l, nil
+}
+
+type lagPartitionKey struct {
+	topic     string
+	partition int
+}
+
+func (m *LagMonitor) getPartitions(ctx context.Context, topics []string) (map[string][]int, error) {
+	resp, err := m.Client.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: topics,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "metadata")
+	}
+	partitions := make(map[string][]int, len(resp.Topics))
+	for _, t := range resp.Topics {
+		if t.Error != nil {
+			return nil, errors.Wrapf(t.Error, "topic %q", t.Name)
+		}
+		ps := make([]int, len(t.Partitions))
+		for i, p := range t.Partitions {
+			ps[i] = p.ID
+		}
+		sort.Ints(ps)
+		partitions[t.Name] = ps
+	}
+	return partitions, nil
+}
+
+func (m *LagMonitor) getCommittedOffsets(ctx context.Context, group string, partitions map[string][]int) (map[lagPartitionKey]int64, error) {
+	resp, err := m.Client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  partitions,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "offset fetch")
+	}
+	if resp.Error != nil {
+		return nil, errors.Wrap(resp.Error, "offset fetch")
+	}
+	committed := make(map[lagPartitionKey]int64)
+	for topic, ps := range resp.Topics {
+		for _, p := range ps {
+			if p.Error != nil {
+				return nil, errors.Wrapf(p.Error, "topic %q partition %d", topic, p.Partition)
+			}
+			committed[lagPartitionKey{topic: topic, partition: p.Partition}] = p.CommittedOffset
+		}
+	}
+	return committed, nil
+}
+
+func (m *LagMonitor) getOffsets(ctx context.Context, partitions map[string][]int) (map[lagPartitionKey]kafka.PartitionOffsets, error) {
+	req := &kafka.ListOffsetsRequest{
+		Topics: make(map[string][]kafka.OffsetRequest, len(partitions)),
+	}
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			req.Topics[topic] = append(req.Topics[topic], kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+		}
+	}
+	resp, err := m.Client.ListOffsets(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "list offsets")
+	}
+	offsets := make(map[lagPartitionKey]kafka.PartitionOffsets)
+	for topic, ps := range resp.Topics {
+		for _, p := range ps {
+			if p.Error != nil {
+				return nil, errors.Wrapf(p.Error, "topic %q partition %d", topic, p.Partition)
+			}
+			offsets[lagPartitionKey{topic: topic, partition: p.Partition}] = p
+		}
+	}
+	return offsets, nil
+}
+
+func (m *LagMonitor) getInterval() time.Duration {
+	if m.Interval > 0 {
+		return m.Interval
+	}
+	return 10 * time.Second
+}
+
+func (m *LagMonitor) getSink() LagSink {
+	if m.Sink != nil {
+		return m.Sink
+	}
+	return DefaultLagSink
+}
+
+// ExpvarLagSink is a LagSink that publishes the lag in an expvar.Map.
+//
+// The keys have the format `group/topic/partition` for the partitions, and `group` for the total.
+type ExpvarLagSink struct {
+	m  *expvar.Map
+	mu sync.Mutex
+}
+
+// NewExpvarLagSink returns a new ExpvarLagSink, published with the given name.
+//
+// If an expvar.Map is already published with this name, it is reused.
+func NewExpvarLagSink(name string) *ExpvarLagSink {
+	m, _ := expvar.Get(name).(*expvar.Map)
+	if m == nil {
+		m = expvar.NewMap(name)
+	}
+	return &ExpvarLagSink{
+		m: m,
+	}
+}
+
+// Lag implements LagSink.
+func (s *ExpvarLagSink) Lag(l *GroupLag) {
+	s.set(l.Group, l.Total)
+	for _, pl := range l.Partitions {
+		s.set(l.Group+"/"+pl.Topic+"/"+strconv.Itoa(pl.Partition), pl.Lag)
+	}
+}
+
+func (s *ExpvarLagSink) set(k string, v int64) {
+	s.mu.Lock()
+	i, _ := s.m.Get(k).(*expvar.Int)
+	if i == nil {
+		i = new(expvar.Int)
+		s.m.Set(k, i)
+	}
+	s.mu.Unlock()
+	i.Set(v)
+}
+
+// Get returns the lag of a group (if topic is empty) or a partition, or -1 if it is not published.
+func (s *ExpvarLagSink) Get(group string, topic string, partition int) int64 {
+	k := group
+	if topic != "" {
+		k += "/" + topic + "/" + strconv.Itoa(partition)
+	}
+	i, _ := s.m.Get(k).(*expvar.Int)
+	if i == nil {
+		return -1
+	}
+	return i.Value()
+}
@@ -0,0 +1,157 @@
+package kafkautils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func newTestLagMonitor(tb testing.TB) (*LagMonitor, *kafkatest.Broker, *ExpvarLagSink) {
+	tb.Helper()
+	ctx := context.Background()
+	b := kafkatest.NewBroker(tb)
+	topic := b.Topic(tb, "test", 2)
+	w := &kafkatest.Writer{
+		Broker: b,
+		Topic:  topic,
+	}
+	for i := 0; i < 6; i++ {
+		err := w.WriteMessages(ctx, kafka.Message{})
+		if err != nil {
+			testutils.FatalErr(tb, err)
+		}
+	}
+	sink := NewExpvarLagSink(fmt.Sprintf("kafkautils_test_lag_%d", rand.Int63()))
+	m := &LagMonitor{
+		Client: b,
+		Groups: []LagGroup{
+			{
+				Group:  "group",
+				Topics: []string{topic},
+			},
+		},
+		Interval: 1 * time.Millisecond,
+		Sink:     sink,
+	}
+	return m, b, sink
+}
+
+func TestLagMonitorGetLag(t *testing.T) {
+	ctx := context.Background()
+	m, b, _ := newTestLagMonitor(t)
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   "test",
+		GroupID: "group",
+	})
+	defer r.Close() //nolint:errcheck
+	_, err := r.ReadMessage(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	l, err := m.GetLag(ctx, m.Groups[0])
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected lag", l, &GroupLag{
+		Group: "group",
+		Partitions: []PartitionLag{
+			{
+				Topic:     "test",
+				Partition: 0,
+				Committed: 1,
+				Latest:    3,
+				Lag:       2,
+			},
+			{
+				Topic:     "test",
+				Partition: 1,
+				Committed: -1,
+				Latest:    3,
+				Lag:       3,
+			},
+		},
+		Total: 5,
+	})
+}
+
+func TestLagMonitorRun(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m, _, sink := newTestLagMonitor(t)
+	m.Sink = LagSinkFunc(func(l *GroupLag) {
+		sink.Lag(l)
+		cancel()
+	})
+	m.Run(ctx, func(ctx context.Context, err error) {
+		testutils.ErrorErr(t, err)
+	})
+	if v := sink.Get("group", "", 0); v != 6 {
+		t.Fatalf("unexpected total lag: got %d, want %d", v, 6)
+	}
+	if v := sink.Get("group", "test", 1); v != 3 {
+		t.Fatalf("unexpected partition lag: got %d, want %d", v, 3)
+	}
+	if v := sink.Get("other", "", 0); v != -1 {
+		t.Fatalf("unexpected lag: got %d, want %d", v, -1)
+	}
+}
+
+func TestLagMonitorRunError(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m, _, _ := newTestLagMonitor(t)
+	m.Groups[0].Topics = []string{"unknown"}
+	var errCalled testutils.CallCounter
+	m.Run(ctx, func(ctx context.Context, err error) {
+		errCalled.Call()
+		cancel()
+	})
+	errCalled.AssertCalled(t)
+}
+
+func TestLagMonitorWaitForLag(t *testing.T) {
+	ctx := context.Background()
+	m, b, _ := newTestLagMonitor(t)
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   "test",
+		GroupID: "group",
+	})
+	defer r.Close() //nolint:errcheck
+	go func() {
+		for i := 0; i < 6; i++ {
+			_, _ = r.ReadMessage(ctx)
+		}
+	}()
+	err := m.WaitForLag(ctx, "group", 0)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestLagMonitorWaitForLagContextDone(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	m, _, _ := newTestLagMonitor(t)
+	err := m.WaitForLag(ctx, "group", 0)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestLagMonitorWaitForLagUnknownGroup(t *testing.T) {
+	ctx := context.Background()
+	m, _, _ := newTestLagMonitor(t)
+	err := m.WaitForLag(ctx, "unknown", 0)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
@@ -0,0 +1,367 @@
+package kafkautils
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	opentracing_ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/ctxutils"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/goroutine"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+)
+
+// ParallelConsumer consumes messages from a Reader, and processes them concurrently.
+//
+// The steps are:
+//  - fetch a message without committing it
+//  - dispatch it to a worker, by partition (or by key inside a partition if KeyOrdering is enabled)
+//  - process it with Consumer (the processor and the error handlers)
+//  - periodically commit the highest offset of each partition, below which all messages are processed
+//
+// The messages of a partition (or with the same key inside a partition) are processed in order.
+//
+// When the context is canceled, it stops fetching, waits for the dispatched messages to be processed, and commits them, within Consumer.DrainTimeout.
+// If an error handler of Consumer fails or the commit fails, it stops in the same way and returns the error.
+// The failed message and the following messages of its partition are not committed.
+type ParallelConsumer struct {
+	// Consumer processes the messages.
+	// Its fields are used, but it doesn't fetch or commit the messages.
+	Consumer *Consumer
+	// Workers is the number of workers.
+	// Default: 10.
+	Workers int
+	// QueueSize is the number of messages that can be queued for each worker.
+	// Default: 10.
+	QueueSize int
+	// KeyOrdering dispatches the messages by key inside a partition, instead of by partition.
+	// It increases the concurrency if a topic has few partitions.
+	KeyOrdering bool
+	// CommitInterval is the interval between the commits.
+	// Default: 1s.
+	CommitInterval time.Duration
+	// CommitCount commits after this number of processed messages, without waiting for CommitInterval.
+	// Default: 100.
+	CommitCount int
+}
+
+// Consume consumes messages from a reader.
+func (c *ParallelConsumer) Consume(ctx context.Context, r FetchCommitter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pc := &parallelConsumerRun{
+		c:       c,
+		r:       r,
+		cancel:  cancel,
+		tracker: newOffsetTracker(),
+		commit:  make(chan struct{}, 1),
+	}
+	err := pc.run(ctx)
+	if err != nil {
+		return errors.Wrap(err, "parallel consumer")
+	}
+	return nil
+}
+
+func (c *ParallelConsumer) getWorkers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return 10
+}
+
+func (c *ParallelConsumer) getQueueSize() int {
+	if c.QueueSize > 0 {
+		return c.QueueSize
+	}
+	return 10
+}
+
+func (c *ParallelConsumer) getCommitInterval() time.Duration {
+	if c.CommitInterval > 0 {
+		return c.CommitInterval
+	}
+	return 1 * time.Second
+}
+
+func (c *ParallelConsumer) getCommitCount() int {
+	if c.CommitCount > 0 {
+		return c.CommitCount
+	}
+	return 100
+}
+
+type parallelConsumerRun struct {
+	c       *ParallelConsumer
+	r       FetchCommitter
+	cancel  context.CancelFunc
+	tracker *offsetTracker
+	commit  chan struct{}
+	// drainCtx is used to process and commit the messages.
+	// It is not canceled when the consumer context is canceled, but after Consumer.DrainTimeout.
+	drainCtx context.Context
+
+	mu  sync.Mutex
+	err error
+}
+
+func (pc *parallelConsumerRun) run(ctx context.Context) error {
+	drainCtx, drainCancel := newDrainContext(ctx, getDrainTimeout(pc.c.Consumer.DrainTimeout))
+	defer drainCancel()
+	pc.drainCtx = drainCtx
+	workers := make([]chan kafka.Message, pc.c.getWorkers())
+	wg := new(sync.WaitGroup)
+	for i := range workers {
+		ch := make(chan kafka.Message, pc.c.getQueueSize())
+		workers[i] = ch
+		goroutine.WaitGroup(wg, func() {
+			pc.runWorker(ch)
+		})
+	}
+	committerCtx, committerCancel := context.WithCancel(context.Background())
+	waitCommitter := goroutine.Go(func() {
+		pc.runCommitter(committerCtx)
+	})
+	err := pc.fetch(ctx, workers)
+	if err != nil {
+		pc.setError(errors.Wrap(err, "fetch"))
+	}
+	// Drain: the workers process the dispatched messages.
+	for _, ch := range workers {
+		close(ch)
+	}
+	wg.Wait()
+	committerCancel()
+	waitCommitter()
+	err = pc.commitMessages(drainCtx)
+	if err != nil {
+		pc.setError(errors.Wrap(err, "commit"))
+	}
+	return pc.getError()
+}
+
+func (pc *parallelConsumerRun) fetch(ctx context.Context, workers []chan kafka.Message) error {
+	for !ctxutils.IsDone(ctx) {
+		msg, err := pc.r.FetchMessage(ctx)
+		if err != nil {
+			if ctxutils.IsDone(ctx) {
+				return nil
+			}
+			return errors.Wrap(err, "")
+		}
+		pc.tracker.add(msg)
+		ch := workers[pc.getWorkerIndex(msg, len(workers))]
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+			// The message is not processed, so it is not committed.
+			return nil
+		}
+	}
+	return nil
+}
+
+func (pc *parallelConsumerRun) getWorkerIndex(msg kafka.Message, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(msg.Topic))
+	_, _ = h.Write([]byte(strconv.Itoa(msg.Partition)))
+	if pc.c.KeyOrdering {
+		_, _ = h.Write(msg.Key)
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+func (pc *parallelConsumerRun) runWorker(ch <-chan kafka.Message) {
+	for msg := range ch {
+		if pc.getError() != nil {
+			// Skip the remaining messages, they are not committed.
+			continue
+		}
+		err := pc.processMessage(msg)
+		if err != nil {
+			pc.setError(errors.Wrap(err, "process"))
+			continue
+		}
+		if pc.tracker.done(msg) >= pc.c.getCommitCount() {
+			select {
+			case pc.commit <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (pc *parallelConsumerRun) processMessage(msg kafka.Message) (err error) {
+	ctx := pc.drainCtx
+	span, spanFinish := startTraceRemoteChildSpan(&ctx, ExtractTraceContext(msg.Headers), "parallel_consumer", &err)
+	defer spanFinish()
+	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
+	opentracing_ext.SpanKindConsumer.Set(span)
+	setTraceSpanTagsMessageConsumer(span, msg)
+	return pc.c.Consumer.handleMessage(ctx, span, msg)
+}
+
+func (pc *parallelConsumerRun) runCommitter(ctx context.Context) {
+	ticker := time.NewTicker(pc.c.getCommitInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-pc.commit:
+		}
+		err := pc.commitMessages(pc.drainCtx)
+		if err != nil {
+			pc.setError(errors.Wrap(err, "commit"))
+			return
+		}
+	}
+}
+
+func (pc *parallelConsumerRun) commitMessages(ctx context.Context) (err error) {
+	msgs := pc.tracker.commitMessages()
+	if len(msgs) == 0 {
+		return nil
+	}
+	span, spanFinish := startTraceRootSpan(&ctx, "parallel_consumer.commit", &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
+	opentracing_ext.SpanKindConsumer.Set(span)
+	setTraceSpanTag(span, "messages.count", len(msgs))
+	err = pc.r.CommitMessages(ctx, msgs...)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+func (pc *parallelConsumerRun) setError(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err == nil {
+		pc.err = err
+		pc.cancel()
+	}
+}
+
+func (pc *parallelConsumerRun) getError() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.err
+}
+
+// offsetTracker tracks the processed offsets of the partitions.
+//
+// It is safe for concurrent use.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[offsetTrackerPartitionKey]*offsetTrackerPartition
+	doneCount  int
+}
+
+type offsetTrackerPartitionKey struct {
+	topic     string
+	partition int
+}
+
+type offsetTrackerPartition struct {
+	// pending contains the offsets that are not processed yet, or processed after an offset that is not processed yet, in fetch order.
+	pending []int64
+	done    map[int64]bool
+	// commit is the highest offset that can be committed, or -1.
+	commit int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[offsetTrackerPartitionKey]*offsetTrackerPartition),
+	}
+}
+
+// add adds a fetched message.
+func (t *offsetTracker) add(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := offsetTrackerPartitionKey{
+		topic:     msg.Topic,
+		partition: msg.Partition,
+	}
+	p := t.partitions[k]
+	if p == nil {
+		p = &offsetTrackerPartition{
+			done:   make(map[int64]bool),
+			commit: -1,
+		}
+		t.partitions[k] = p
+	}
+	p.pending = append(p.pending, msg.Offset)
+}
+
+// done marks a message as processed.
+// It returns the number of processed messages since the last commit.
+func (t *offsetTracker) done(msg kafka.Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[offsetTrackerPartitionKey{
+		topic:     msg.Topic,
+		partition: msg.Partition,
+	}]
+	if p == nil {
+		return t.doneCount
+	}
+	p.done[msg.Offset] = true
+	for len(p.pending) > 0 && p.done[p.pending[0]] {
+		delete(p.done, p.pending[0])
+		p.commit = p.pending[0]
+		p.pending = p.pending[1:]
+	}
+	t.doneCount++
+	return t.doneCount
+}
+
+// commitMessages returns the messages to commit (one per partition), and resets them.
+func (t *offsetTracker) commitMessages() []kafka.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var msgs []kafka.Message
+	for k, p := range t.partitions {
+		if p.commit < 0 {
+			continue
+		}
+		msgs = append(msgs, kafka.Message{
+			Topic:     k.topic,
+			Partition: k.partition,
+			Offset:    p.commit,
+		})
+		p.commit = -1
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Topic != msgs[j].Topic {
+			return msgs[i].Topic < msgs[j].Topic
+		}
+		return msgs[i].Partition < msgs[j].Partition
+	})
+	t.doneCount = 0
+	return msgs
+}
+
+// RunParallelConsumers runs parallel consumers.
+func RunParallelConsumers(ctx context.Context, readerCfg kafka.ReaderConfig, pr ConsumerProcessor, count int, workers int, retry Producer, discard Producer, errFunc func(context.Context, error)) {
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: pr,
+			Retry:     retry,
+			Discard:   discard,
+			Error:     errFunc,
+		},
+		Workers: workers,
+	}
+	ConsumeReaders(ctx, readerCfg, count, c.Consume, errFunc)
+}
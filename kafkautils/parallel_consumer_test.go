@@ -0,0 +1,288 @@
+package kafkautils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func newTestParallelConsumerFetchCommitter(cancel context.CancelFunc, partitions int, count int) (*testFetchCommitter, func() map[int]int64) {
+	var msgs []kafka.Message
+	for i := 0; i < count; i++ {
+		for p := 0; p < partitions; p++ {
+			msgs = append(msgs, kafka.Message{
+				Topic:     "test",
+				Partition: p,
+				Offset:    int64(i),
+			})
+		}
+	}
+	var mu sync.Mutex
+	committed := make(map[int]int64)
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			mu.Lock()
+			if len(msgs) > 0 {
+				msg := msgs[0]
+				msgs = msgs[1:]
+				mu.Unlock()
+				return msg, nil
+			}
+			mu.Unlock()
+			cancel()
+			<-ctx.Done()
+			return kafka.Message{}, ctx.Err()
+		},
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, msg := range msgs {
+				if msg.Offset <= committed[msg.Partition] && committed[msg.Partition] != 0 {
+					return errors.Newf("offset %d is lower than committed %d", msg.Offset, committed[msg.Partition])
+				}
+				committed[msg.Partition] = msg.Offset
+			}
+			return nil
+		},
+	}
+	getCommitted := func() map[int]int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return committed
+	}
+	return r, getCommitted
+}
+
+func TestParallelConsumer(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, getCommitted := newTestParallelConsumerFetchCommitter(cancel, 4, 50)
+	var mu sync.Mutex
+	processed := make(map[int][]int64)
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: func(ctx context.Context, msg kafka.Message) error {
+				time.Sleep(time.Duration(msg.Offset%3) * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				processed[msg.Partition] = append(processed[msg.Partition], msg.Offset)
+				return nil
+			},
+		},
+		Workers:     3,
+		CommitCount: 10,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	for p := 0; p < 4; p++ {
+		offs := processed[p]
+		if len(offs) != 50 {
+			t.Fatalf("partition %d: unexpected processed count: got %d, want %d", p, len(offs), 50)
+		}
+		for i, off := range offs {
+			if off != int64(i) {
+				t.Fatalf("partition %d: unexpected order: %v", p, offs)
+			}
+		}
+	}
+	testutils.Compare(t, "unexpected committed offsets", getCommitted(), map[int]int64{
+		0: 49,
+		1: 49,
+		2: 49,
+		3: 49,
+	})
+}
+
+func TestParallelConsumerKeyOrdering(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var msgs []kafka.Message
+	for i := 0; i < 100; i++ {
+		msgs = append(msgs, kafka.Message{
+			Topic:  "test",
+			Offset: int64(i),
+			Key:    []byte{byte(i % 5)},
+		})
+	}
+	var committed int64
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			if len(msgs) > 0 {
+				msg := msgs[0]
+				msgs = msgs[1:]
+				return msg, nil
+			}
+			cancel()
+			<-ctx.Done()
+			return kafka.Message{}, ctx.Err()
+		},
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			committed = msgs[0].Offset
+			return nil
+		},
+	}
+	var mu sync.Mutex
+	processed := make(map[byte]int64)
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: func(ctx context.Context, msg kafka.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				last, ok := processed[msg.Key[0]]
+				if ok && msg.Offset < last {
+					return errors.WithTemporary(errors.Newf("key %d: offset %d processed after %d", msg.Key[0], msg.Offset, last), false)
+				}
+				processed[msg.Key[0]] = msg.Offset
+				return nil
+			},
+			Error: func(ctx context.Context, err error) {
+				t.Error(err)
+			},
+		},
+		KeyOrdering: true,
+	}
+	err := c.Consume(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if committed != 99 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", committed, 99)
+	}
+}
+
+func TestParallelConsumerErrorHandler(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, getCommitted := newTestParallelConsumerFetchCommitter(cancel, 2, 10)
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: func(ctx context.Context, msg kafka.Message) error {
+				if msg.Partition == 1 && msg.Offset == 5 {
+					return errors.New("error")
+				}
+				return nil
+			},
+			Retry: func(ctx context.Context, msgs ...kafka.Message) error {
+				return errors.New("error")
+			},
+			Error: func(ctx context.Context, err error) {},
+		},
+		Workers: 2,
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	committed := getCommitted()
+	if off, ok := committed[1]; !ok || off != 4 {
+		t.Fatalf("unexpected committed offset for partition 1: got %d, want %d", off, 4)
+	}
+}
+
+func TestParallelConsumerErrorFetch(t *testing.T) {
+	ctx := context.Background()
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			return kafka.Message{}, errors.New("error")
+		},
+	}
+	c := &ParallelConsumer{
+		Consumer: &Consumer{},
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestParallelConsumerErrorCommit(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, _ := newTestParallelConsumerFetchCommitter(cancel, 1, 10)
+	r.commit = func(ctx context.Context, msgs ...kafka.Message) error {
+		return errors.New("error")
+	}
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: func(ctx context.Context, msg kafka.Message) error {
+				return nil
+			},
+		},
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestParallelConsumerDrainTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, _ := newTestParallelConsumerFetchCommitter(cancel, 1, 1)
+	r.commit = func(ctx context.Context, msgs ...kafka.Message) error {
+		return ctx.Err()
+	}
+	c := &ParallelConsumer{
+		Consumer: &Consumer{
+			Processor: func(ctx context.Context, msg kafka.Message) error {
+				<-ctx.Done()
+				return ConsumerErrorWithHandler(ctx.Err(), ConsumerNoop)
+			},
+			Error:        func(ctx context.Context, err error) {},
+			DrainTimeout: 10 * time.Millisecond,
+		},
+	}
+	err := c.Consume(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tr := newOffsetTracker()
+	msgs := make([]kafka.Message, 4)
+	for i := range msgs {
+		msgs[i] = kafka.Message{
+			Topic:  "test",
+			Offset: int64(10 + i),
+		}
+		tr.add(msgs[i])
+	}
+	tr.done(msgs[1])
+	tr.done(msgs[2])
+	if cms := tr.commitMessages(); len(cms) != 0 {
+		t.Fatalf("unexpected commit messages: %v", cms)
+	}
+	tr.done(msgs[0])
+	testutils.Compare(t, "unexpected commit messages", tr.commitMessages(), []kafka.Message{
+		{
+			Topic:  "test",
+			Offset: 12,
+		},
+	})
+	if cms := tr.commitMessages(); len(cms) != 0 {
+		t.Fatalf("unexpected commit messages: %v", cms)
+	}
+	count := tr.done(msgs[3])
+	if count != 1 {
+		t.Fatalf("unexpected done count: got %d, want %d", count, 1)
+	}
+	testutils.Compare(t, "unexpected commit messages", tr.commitMessages(), []kafka.Message{
+		{
+			Topic:  "test",
+			Offset: 13,
+		},
+	})
+}
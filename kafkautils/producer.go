@@ -14,6 +14,8 @@ import (
 type Producer func(context.Context, ...kafka.Message) error
 
 // SimpleProducer produce messages.
+//
+// It injects the span context into the headers of the messages, see InjectTraceHeaders().
 type SimpleProducer struct {
 	Writer func(context.Context, ...kafka.Message) error
 }
@@ -26,6 +28,7 @@ func (p *SimpleProducer) Produce(ctx context.Context, msgs ...kafka.Message) (er
 	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageProducer)
 	opentracing_ext.SpanKindProducer.Set(span)
 	setTraceSpanTag(span, "messages.count", len(msgs))
+	injectTraceHeadersMessages(ctx, msgs)
 	if len(msgs) == 1 {
 		setTraceSpanTagsMessage(span, msgs[0])
 	}
@@ -63,7 +66,17 @@ func (p *SimpleProducer) wrapWriteErrors(err error) error {
 	return err
 }
 
+// injectTraceHeadersMessages injects the span context of the current span into the headers of the messages.
+func injectTraceHeadersMessages(ctx context.Context, msgs []kafka.Message) {
+	for i, msg := range msgs {
+		msg.Headers = InjectTraceHeaders(ctx, msg.Headers)
+		msgs[i] = msg
+	}
+}
+
 // TopicProducer is a Producer that overwrites the `kafka.Message.Topic` field.
+//
+// It injects the span context of the current span into the headers of the messages.
 type TopicProducer struct {
 	Producer
 	Topic string
@@ -73,6 +86,7 @@ type TopicProducer struct {
 func (p *TopicProducer) Produce(ctx context.Context, msgs ...kafka.Message) (err error) {
 	for i, msg := range msgs {
 		msg.Topic = p.Topic
+		msg.Headers = InjectTraceHeaders(ctx, msg.Headers)
 		msgs[i] = msg
 	}
 	return p.Producer(ctx, msgs...)
@@ -10,6 +10,9 @@ import (
 )
 
 // ConsumeReader consumes a Reader.
+//
+// When the context is canceled, the consumer stops fetching and drains the in-flight messages (see Consumer.DrainTimeout), then the Reader is closed.
+// Closing the Reader leaves the consumer group, and flushes the pending commits.
 func ConsumeReader(ctx context.Context, cfg kafka.ReaderConfig, c ReaderConsumer, errFunc func(context.Context, error)) {
 	for !ctxutils.IsDone(ctx) {
 		func() {
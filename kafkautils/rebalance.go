@@ -0,0 +1,125 @@
+package kafkautils
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+// PartitionHooks calls hooks when partitions are assigned to or revoked from a reader.
+//
+// It allows the processors to initialize and flush per-partition state.
+// The partitions are grouped by topic.
+//
+// A kafka.Reader doesn't expose the rebalances, so they are not detected:
+// a partition is assigned when its first message is fetched (Assigned is called before the message is returned),
+// and all the assigned partitions are revoked when the consumer returns (e.g. after the drain phase of a graceful shutdown).
+// The state of a partition that is moved to another reader during a rebalance is kept until the consumer returns,
+// so the processors must not rely on it to be exclusive.
+type PartitionHooks struct {
+	// Assigned is called with the newly assigned partitions.
+	// It is optional.
+	Assigned func(ctx context.Context, partitions map[string][]int) error
+	// Revoked is called with the revoked partitions.
+	// It is optional.
+	Revoked func(ctx context.Context, partitions map[string][]int) error
+	// Timeout is the maximum duration of the call to Revoked, when the consumer returns.
+	// Default: 30s.
+	Timeout time.Duration
+}
+
+// Wrap wraps a ReaderConsumer.
+//
+// If a hook returns an error, the consumer returns it.
+func (h *PartitionHooks) Wrap(c ReaderConsumer) ReaderConsumer {
+	return func(ctx context.Context, r FetchCommitter) error {
+		hr := &partitionHooksReader{
+			FetchCommitter: r,
+			h:              h,
+			assigned:       make(map[partitionHooksKey]bool),
+		}
+		err := c(ctx, hr)
+		revokeCtx, cancel := context.WithTimeout(context.Background(), getDrainTimeout(h.Timeout)) // Don't want to be interrupted.
+		defer cancel()
+		revokeErr := hr.revokeAll(revokeCtx)
+		if err != nil {
+			return err
+		}
+		if revokeErr != nil {
+			return errors.Wrap(revokeErr, "partition hooks")
+		}
+		return nil
+	}
+}
+
+type partitionHooksKey struct {
+	topic     string
+	partition int
+}
+
+type partitionHooksReader struct {
+	FetchCommitter
+	h *PartitionHooks
+
+	mu       sync.Mutex
+	assigned map[partitionHooksKey]bool
+}
+
+func (r *partitionHooksReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := r.FetchCommitter.FetchMessage(ctx)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	if r.assign(msg) && r.h.Assigned != nil {
+		err = r.h.Assigned(ctx, map[string][]int{msg.Topic: {msg.Partition}})
+		if err != nil {
+			return kafka.Message{}, errors.Wrap(err, "partition hooks: assigned")
+		}
+	}
+	return msg, nil
+}
+
+// assign marks the partition of a message as assigned, and returns true if it is new.
+func (r *partitionHooksReader) assign(msg kafka.Message) bool {
+	k := partitionHooksKey{topic: msg.Topic, partition: msg.Partition}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.assigned[k] {
+		return false
+	}
+	r.assigned[k] = true
+	return true
+}
+
+func (r *partitionHooksReader) revokeAll(ctx context.Context) error {
+	r.mu.Lock()
+	keys := make([]partitionHooksKey, 0, len(r.assigned))
+	for k := range r.assigned {
+		keys = append(keys, k)
+	}
+	r.assigned = make(map[partitionHooksKey]bool)
+	r.mu.Unlock()
+	if len(keys) == 0 || r.h.Revoked == nil {
+		return nil
+	}
+	err := r.h.Revoked(ctx, groupPartitionHooksKeys(keys))
+	if err != nil {
+		return errors.Wrap(err, "revoked")
+	}
+	return nil
+}
+
+func groupPartitionHooksKeys(keys []partitionHooksKey) map[string][]int {
+	m := make(map[string][]int)
+	for _, k := range keys {
+		m[k.topic] = append(m[k.topic], k.partition)
+	}
+	for _, ps := range m {
+		sort.Ints(ps)
+	}
+	return m
+}
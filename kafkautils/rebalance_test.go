@@ -0,0 +1,193 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func TestPartitionHooks(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs := []kafka.Message{
+		{Topic: "test", Partition: 0, Offset: 0},
+		{Topic: "test", Partition: 1, Offset: 0},
+		{Topic: "test", Partition: 0, Offset: 1},
+	}
+	var committed []kafka.Message
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			if len(msgs) == 0 {
+				cancel()
+				return kafka.Message{}, ctx.Err()
+			}
+			msg := msgs[0]
+			msgs = msgs[1:]
+			return msg, nil
+		},
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			committed = append(committed, msgs...)
+			return nil
+		},
+	}
+	var assigned, revoked []map[string][]int
+	h := &PartitionHooks{
+		Assigned: func(ctx context.Context, partitions map[string][]int) error {
+			assigned = append(assigned, partitions)
+			return nil
+		},
+		Revoked: func(ctx context.Context, partitions map[string][]int) error {
+			revoked = append(revoked, partitions)
+			return nil
+		},
+	}
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			return nil
+		},
+	}
+	err := h.Wrap(c.Consume)(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected assigned partitions", assigned, []map[string][]int{
+		{"test": {0}},
+		{"test": {1}},
+	})
+	testutils.Compare(t, "unexpected revoked partitions", revoked, []map[string][]int{
+		{"test": {0, 1}},
+	})
+	if len(committed) != 3 {
+		t.Fatalf("unexpected committed count: got %d, want %d", len(committed), 3)
+	}
+}
+
+func TestPartitionHooksErrorAssigned(t *testing.T) {
+	ctx := context.Background()
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			return kafka.Message{Topic: "test"}, nil
+		},
+	}
+	var revokedCalled testutils.CallCounter
+	h := &PartitionHooks{
+		Assigned: func(ctx context.Context, partitions map[string][]int) error {
+			return errors.New("error")
+		},
+		Revoked: func(ctx context.Context, partitions map[string][]int) error {
+			revokedCalled.Call()
+			return nil
+		},
+	}
+	c := &Consumer{}
+	err := h.Wrap(c.Consume)(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	revokedCalled.AssertCount(t, 1)
+}
+
+func TestPartitionHooksErrorRevoked(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fetched := false
+	r := &testFetchCommitter{
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			if fetched {
+				cancel()
+				return kafka.Message{}, ctx.Err()
+			}
+			fetched = true
+			return kafka.Message{Topic: "test"}, nil
+		},
+		commit: func(ctx context.Context, msgs ...kafka.Message) error {
+			return nil
+		},
+	}
+	h := &PartitionHooks{
+		Revoked: func(ctx context.Context, partitions map[string][]int) error {
+			return errors.New("error")
+		},
+	}
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			return nil
+		},
+	}
+	err := h.Wrap(c.Consume)(ctx, r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestBrokerPartitionHooks(t *testing.T) {
+	ctx := context.Background()
+	b := kafkatest.NewBroker(t)
+	topic := b.Topic(t, "test", 2)
+	w := &kafkatest.Writer{
+		Broker: b,
+	}
+	// The messages are written in round-robin: partitions 0, 1, 0, 1.
+	err := w.WriteMessages(ctx,
+		kafka.Message{Topic: topic, Value: []byte("a")},
+		kafka.Message{Topic: topic, Value: []byte("b")},
+		kafka.Message{Topic: topic, Value: []byte("c")},
+		kafka.Message{Topic: topic, Value: []byte("d")},
+	)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := b.NewReader(kafka.ReaderConfig{
+		Topic:   topic,
+		GroupID: "test",
+	})
+	defer r.Close() //nolint:errcheck
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	assigned := make(map[int]int)
+	var revoked []map[string][]int
+	h := &PartitionHooks{
+		Assigned: func(ctx context.Context, partitions map[string][]int) error {
+			for _, p := range partitions[topic] {
+				assigned[p]++
+			}
+			return nil
+		},
+		Revoked: func(ctx context.Context, partitions map[string][]int) error {
+			revoked = append(revoked, partitions)
+			return nil
+		},
+	}
+	processed := 0
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			processed++
+			if processed == 4 {
+				cancel()
+			}
+			return nil
+		},
+		Error: func(ctx context.Context, err error) {
+			testutils.ErrorErr(t, err)
+		},
+	}
+	err = h.Wrap(c.Consume)(ctx, r)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected assigned partitions", assigned, map[int]int{0: 1, 1: 1})
+	testutils.Compare(t, "unexpected revoked partitions", revoked, []map[string][]int{
+		{topic: {0, 1}},
+	})
+	for _, p := range []int{0, 1} {
+		if off := b.CommittedOffset("test", topic, p); off != 2 {
+			t.Fatalf("unexpected committed offset for partition %d: got %d, want %d", p, off, 2)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package kafkautils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+// ReplayClient is the subset of *kafka.Client used by Replay.
+type ReplayClient interface {
+	Metadata(context.Context, *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	OffsetFetch(context.Context, *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	ListOffsets(context.Context, *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error)
+}
+
+// ReplayReader reads a single partition.
+//
+// It is implemented by *kafka.Reader.
+type ReplayReader interface {
+	SetOffset(int64) error
+	FetchMessage(context.Context) (kafka.Message, error)
+	Close() error
+}
+
+// ReplayOffsetsCommitter commits the offsets of a consumer group, by topic and partition.
+type ReplayOffsetsCommitter func(ctx context.Context, group string, offsets map[string]map[int]int64) error
+
+// Replay resets the offsets of consumer groups, and copies or dumps a range of messages.
+//
+// It allows to replay the messages of a topic, e.g. after a bug.
+type Replay struct {
+	// Client is usually a *kafka.Client, see Config.NewClient().
+	Client ReplayClient
+	// NewReader returns a new reader for a topic partition.
+	NewReader func(topic string, partition int) ReplayReader
+	// CommitOffsets commits the offsets of a consumer group.
+	CommitOffsets ReplayOffsetsCommitter
+	// DryRun doesn't commit offsets and doesn't produce messages.
+	// The reports contain the changes that would be applied.
+	DryRun bool
+	// IdleTimeout is the maximum duration of a fetch, when a partition is read.
+	// If it is exceeded, the reading of the partition stops.
+	// It is required for the topics with transactions: the offsets of the control records (and of the aborted messages) are never returned by the reader, so the end of the range might not be reached.
+	// Default: 10s.
+	IdleTimeout time.Duration
+}
+
+const defaultReplayIdleTimeout = 10 * time.Second
+
+// NewReplay returns a new Replay, with a client, readers and a committer created from c.
+func (c *Config) NewReplay() *Replay {
+	return &Replay{
+		Client: c.NewClient(),
+		NewReader: func(topic string, partition int) ReplayReader {
+			cfg := c.NewReaderConfig()
+			cfg.Topic = topic
+			cfg.Partition = partition
+			return kafka.NewReader(cfg)
+		},
+		CommitOffsets: c.CommitGroupOffsets,
+	}
+}
+
+// CommitGroupOffsets commits the offsets of a consumer group.
+//
+// It joins the group, so the group should not have active members.
+func (c *Config) CommitGroupOffsets(ctx context.Context, group string, offsets map[string]map[int]int64) error {
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	cg, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      group,
+		Brokers: c.Brokers,
+		Dialer:  c.NewDialer(),
+		Topics:  topics,
+	})
+	if err != nil {
+		return errors.Wrap(err, "new consumer group")
+	}
+	defer cg.Close() //nolint:errcheck
+	gen, err := cg.Next(ctx)
+	if err != nil {
+		return errors.Wrap(err, "join consumer group")
+	}
+	err = gen.CommitOffsets(offsets)
+	if err != nil {
+		return errors.Wrap(err, "commit offsets")
+	}
+	return nil
+}
+
+// ReplayPosition is a position in the partitions of a topic.
+//
+// Only one field should be defined.
+// The zero value is the latest position.
+type ReplayPosition struct {
+	// Earliest is the first offset of the partitions.
+	Earliest bool
+	// Time is the offset of the first message with a time greater than or equal to it.
+	// If there is no such message, it is the latest offset.
+	Time time.Time
+	// Offsets are explicit offsets, by partition.
+	// The other partitions are not modified (reset) or not read (copy and dump).
+	Offsets map[int]int64
+}
+
+// ParseReplayPosition parses a ReplayPosition.
+//
+// The supported formats are:
+//   - "earliest"
+//   - "latest"
+//   - a RFC3339 time, e.g. "2020-01-01T00:00:00Z"
+//   - explicit offsets by partition, e.g. "0:10,1:20"
+func ParseReplayPosition(s string) (ReplayPosition, error) {
+	switch s {
+	case "earliest":
+		return ReplayPosition{Earliest: true}, nil
+	case "latest", "":
+		return ReplayPosition{}, nil
+	}
+	tm, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return ReplayPosition{Time: tm}, nil
+	}
+	offsets := make(map[int]int64)
+	for _, po := range strings.Split(s, ",") {
+		i := strings.IndexByte(po, ':')
+		if i < 0 {
+			return ReplayPosition{}, errors.Newf("invalid position %q", s)
+		}
+		p, err := strconv.Atoi(po[:i])
+		if err != nil {
+			return ReplayPosition{}, errors.Wrapf(err, "invalid position %q: partition", s)
+		}
+		off, err := strconv.ParseInt(po[i+1:], 10, 64)
+		if err != nil {
+			return ReplayPosition{}, errors.Wrapf(err, "invalid position %q: offset", s)
+		}
+		offsets[p] = off
+	}
+	return ReplayPosition{Offsets: offsets}, nil
+}
+
+// ResolveOffsets returns the offsets of a position, by partition.
+//
+// The offsets are bounded by the first and latest offsets of the partitions.
+func (r *Replay) ResolveOffsets(ctx context.Context, topic string, pos ReplayPosition) (map[int]int64, error) {
+	partitions, err := r.getPartitions(ctx, topic)
+	if err != nil {
+		return nil, errors.Wrap(err, "get partitions")
+	}
+	req := &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{},
+	}
+	for _, p := range partitions {
+		req.Topics[topic] = append(req.Topics[topic], kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+		if !pos.Time.IsZero() {
+			req.Topics[topic] = append(req.Topics[topic], kafka.TimeOffsetOf(p, pos.Time))
+		}
+	}
+	resp, err := r.Client.ListOffsets(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "list offsets")
+	}
+	offsets := make(map[int]int64, len(partitions))
+	for _, po := range resp.Topics[topic] {
+		if po.Error != nil {
+			return nil, errors.Wrapf(po.Error, "list offsets: partition %d", po.Partition)
+		}
+		off, ok := getReplayPositionOffset(pos, po)
+		if !ok {
+			continue
+		}
+		offsets[po.Partition] = clampReplayOffset(off, po)
+	}
+	err = checkReplayPositionPartitions(pos, offsets)
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// clampReplayOffset bounds an offset by the first and latest offsets of a partition.
+func clampReplayOffset(off int64, po kafka.PartitionOffsets) int64 {
+	if off < po.FirstOffset {
+		return po.FirstOffset
+	}
+	if off > po.LastOffset {
+		return po.LastOffset
+	}
+	return off
+}
+
+// checkReplayPositionPartitions checks that the explicit offsets of a position are defined for existing partitions.
+func checkReplayPositionPartitions(pos ReplayPosition, offsets map[int]int64) error {
+	for p := range pos.Offsets {
+		if _, ok := offsets[p]; !ok {
+			return errors.Newf("unknown partition %d", p)
+		}
+	}
+	return nil
+}
+
+func getReplayPositionOffset(pos ReplayPosition, po kafka.PartitionOffsets) (int64, bool) {
+	switch {
+	case pos.Earliest:
+		return po.FirstOffset, true
+	case !pos.Time.IsZero():
+		for off := range po.Offsets {
+			if off >= 0 {
+				return off, true
+			}
+		}
+		return po.LastOffset, true
+	case pos.Offsets != nil:
+		off, ok := pos.Offsets[po.Partition]
+		return off, ok
+	}
+	return po.LastOffset, true
+}
+
+func (r *Replay) getPartitions(ctx context.Context, topic string) ([]int, error) {
+	resp, err := r.Client.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{topic},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "metadata")
+	}
+	for _, t := range resp.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, errors.Wrapf(t.Error, "topic %q", t.Name)
+		}
+		ps := make([]int, len(t.Partitions))
+		for i, p := range t.Partitions {
+			ps[i] = p.ID
+		}
+		sort.Ints(ps)
+		return ps, nil
+	}
+	return nil, errors.Wrapf(kafka.UnknownTopicOrPartition, "topic %q", topic)
+}
+
+// ReplayOffsetChange is a change of the committed offset of a partition.
+//
+// From is -1 if the group has not committed.
+type ReplayOffsetChange struct {
+	Partition int
+	From      int64
+	To        int64
+}
+
+// ResetOffsets resets the committed offsets of a consumer group for a topic.
+//
+// The group should not have active members.
+// In dry-run mode, the offsets are not committed.
+func (r *Replay) ResetOffsets(ctx context.Context, group string, topic string, pos ReplayPosition) (_ []ReplayOffsetChange, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "replay.reset_offsets", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "group", group)
+	setTraceSpanTag(span, "topic", topic)
+	setTraceSpanTag(span, "dry_run", r.DryRun)
+	offsets, err := r.ResolveOffsets(ctx, topic, pos)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka replay: reset offsets: resolve offsets")
+	}
+	committed, err := r.getCommittedOffsets(ctx, group, topic, offsets)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka replay: reset offsets: get committed offsets")
+	}
+	changes := make([]ReplayOffsetChange, 0, len(offsets))
+	for p, off := range offsets {
+		changes = append(changes, ReplayOffsetChange{
+			Partition: p,
+			From:      committed[p],
+			To:        off,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Partition < changes[j].Partition
+	})
+	if r.DryRun {
+		return changes, nil
+	}
+	err = r.CommitOffsets(ctx, group, map[string]map[int]int64{topic: offsets})
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka replay: reset offsets: commit offsets")
+	}
+	return changes, nil
+}
+
+func (r *Replay) getCommittedOffsets(ctx context.Context, group string, topic string, offsets map[int]int64) (map[int]int64, error) {
+	ps := make([]int, 0, len(offsets))
+	for p := range offsets {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	resp, err := r.Client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  map[string][]int{topic: ps},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "offset fetch")
+	}
+	if resp.Error != nil {
+		return nil, errors.Wrap(resp.Error, "offset fetch")
+	}
+	committed := make(map[int]int64, len(ps))
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, errors.Wrapf(p.Error, "partition %d", p.Partition)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+	return committed, nil
+}
+
+// ReplayRange is a range of messages of a topic.
+type ReplayRange struct {
+	Topic string
+	// Start is the first position (inclusive).
+	// It should be defined, because the zero value is the latest position.
+	Start ReplayPosition
+	// End is the last position (exclusive).
+	// The zero value is the latest position when the range is read.
+	End ReplayPosition
+	// Filter returns true if the message must be kept.
+	// It is optional, see ReplayKeyFilter() and ReplayHeaderFilter().
+	Filter func(kafka.Message) bool
+}
+
+// ReplayKeyFilter returns a filter that keeps the messages with a key.
+func ReplayKeyFilter(key []byte) func(kafka.Message) bool {
+	return func(msg kafka.Message) bool {
+		return bytes.Equal(msg.Key, key)
+	}
+}
+
+// ReplayHeaderFilter returns a filter that keeps the messages with a header value.
+func ReplayHeaderFilter(key string, value []byte) func(kafka.Message) bool {
+	return func(msg kafka.Message) bool {
+		v, ok := GetHeader(msg.Headers, key)
+		return ok && bytes.Equal(v, value)
+	}
+}
+
+// ReplayReport is the report of Replay.Copy() and Replay.Dump().
+type ReplayReport struct {
+	// Read is the count of read messages.
+	Read int
+	// Matched is the count of messages kept by the filter.
+	Matched int
+}
+
+// Copy copies a range of messages to another topic.
+//
+// The messages are copied with CopyMessage(), and produced with the Topic field defined to dst.
+// In dry-run mode, the messages are not produced.
+func (r *Replay) Copy(ctx context.Context, rg ReplayRange, dst string, producer Producer) (_ *ReplayReport, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "replay.copy", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "topic", rg.Topic)
+	setTraceSpanTag(span, "destination", dst)
+	setTraceSpanTag(span, "dry_run", r.DryRun)
+	rp, err := r.read(ctx, rg, func(msg kafka.Message) error {
+		if r.DryRun {
+			return nil
+		}
+		newMsg := CopyMessage(msg)
+		newMsg.Topic = dst
+		return producer(ctx, newMsg)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka replay: copy")
+	}
+	return rp, nil
+}
+
+// ReplayDumpMessage is a message written by Replay.Dump().
+//
+// The key, value and header values are encoded in base64 by encoding/json.
+type ReplayDumpMessage struct {
+	Topic     string             `json:"topic"`
+	Partition int                `json:"partition"`
+	Offset    int64              `json:"offset"`
+	Time      time.Time          `json:"time"`
+	Key       []byte             `json:"key"`
+	Value     []byte             `json:"value"`
+	Headers   []ReplayDumpHeader `json:"headers,omitempty"`
+}
+
+// ReplayDumpHeader is a header of ReplayDumpMessage.
+type ReplayDumpHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Dump writes a range of messages as JSON lines (ReplayDumpMessage).
+func (r *Replay) Dump(ctx context.Context, rg ReplayRange, w io.Writer) (_ *ReplayReport, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "replay.dump", &err)
+	defer spanFinish()
+	setTraceSpanTag(span, "topic", rg.Topic)
+	enc := json.NewEncoder(w)
+	rp, err := r.read(ctx, rg, func(msg kafka.Message) error {
+		dm := ReplayDumpMessage{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Time:      msg.Time,
+			Key:       msg.Key,
+			Value:     msg.Value,
+		}
+		for _, h := range msg.Headers {
+			dm.Headers = append(dm.Headers, ReplayDumpHeader(h))
+		}
+		err := enc.Encode(dm)
+		if err != nil {
+			return errors.Wrap(err, "encode")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka replay: dump")
+	}
+	return rp, nil
+}
+
+// read reads a range of messages, partition by partition, and calls f for the messages kept by the filter.
+func (r *Replay) read(ctx context.Context, rg ReplayRange, f func(kafka.Message) error) (*ReplayReport, error) {
+	starts, err := r.ResolveOffsets(ctx, rg.Topic, rg.Start)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve start offsets")
+	}
+	ends, err := r.ResolveOffsets(ctx, rg.Topic, rg.End)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve end offsets")
+	}
+	ps := make([]int, 0, len(starts))
+	for p := range starts {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	rp := new(ReplayReport)
+	for _, p := range ps {
+		end, ok := ends[p]
+		if !ok || starts[p] >= end {
+			continue
+		}
+		err = r.readPartition(ctx, rg, p, starts[p], end, rp, f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "partition %d", p)
+		}
+	}
+	return rp, nil
+}
+
+func (r *Replay) readPartition(ctx context.Context, rg ReplayRange, partition int, start int64, end int64, rp *ReplayReport, f func(kafka.Message) error) error {
+	rd := r.NewReader(rg.Topic, partition)
+	defer rd.Close() //nolint:errcheck
+	err := rd.SetOffset(start)
+	if err != nil {
+		return errors.Wrap(err, "set offset")
+	}
+	for {
+		msg, ok, err := r.fetch(ctx, rd)
+		if err != nil {
+			return errors.Wrap(err, "fetch")
+		}
+		if !ok || msg.Offset >= end {
+			return nil
+		}
+		rp.Read++
+		if rg.Filter == nil || rg.Filter(msg) {
+			rp.Matched++
+			err = f(msg)
+			if err != nil {
+				return errors.Wrapf(err, "offset %d", msg.Offset)
+			}
+		}
+		if msg.Offset+1 >= end {
+			return nil
+		}
+	}
+}
+
+// fetch fetches a message from a reader.
+// It returns false if no message is fetched before the idle timeout.
+func (r *Replay) fetch(ctx context.Context, rd ReplayReader) (kafka.Message, bool, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, r.getIdleTimeout())
+	defer cancel()
+	msg, err := rd.FetchMessage(fetchCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return kafka.Message{}, false, nil
+		}
+		return kafka.Message{}, false, errors.Wrap(err, "")
+	}
+	return msg, true, nil
+}
+
+func (r *Replay) getIdleTimeout() time.Duration {
+	if r.IdleTimeout > 0 {
+		return r.IdleTimeout
+	}
+	return defaultReplayIdleTimeout
+}
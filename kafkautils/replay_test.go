@@ -0,0 +1,327 @@
+package kafkautils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+var testReplayTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestReplay(tb testing.TB) (*Replay, *kafkatest.Broker) {
+	tb.Helper()
+	ctx := context.Background()
+	b := kafkatest.NewBroker(tb)
+	topic := b.Topic(tb, "test", 2)
+	w := &kafkatest.Writer{
+		Broker:   b,
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	}
+	// 3 messages per partition, 1 minute apart.
+	for i := 0; i < 3; i++ {
+		for _, key := range []string{"a", "b"} {
+			err := w.WriteMessages(ctx, kafka.Message{
+				Key:   []byte(key),
+				Value: []byte(key + string(rune('0'+i))),
+				Headers: []kafka.Header{
+					{
+						Key:   "key",
+						Value: []byte(key),
+					},
+				},
+				Time: testReplayTime.Add(time.Duration(i) * time.Minute),
+			})
+			if err != nil {
+				testutils.FatalErr(tb, err)
+			}
+		}
+	}
+	r := &Replay{
+		Client: b,
+		NewReader: func(topic string, partition int) ReplayReader {
+			return b.NewReader(kafka.ReaderConfig{
+				Topic:     topic,
+				Partition: partition,
+			})
+		},
+		CommitOffsets: b.CommitOffsets,
+	}
+	return r, b
+}
+
+func TestParseReplayPosition(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected ReplayPosition
+	}{
+		{
+			s:        "earliest",
+			expected: ReplayPosition{Earliest: true},
+		},
+		{
+			s:        "latest",
+			expected: ReplayPosition{},
+		},
+		{
+			s:        "2020-01-01T00:00:00Z",
+			expected: ReplayPosition{Time: testReplayTime},
+		},
+		{
+			s:        "0:10,1:20",
+			expected: ReplayPosition{Offsets: map[int]int64{0: 10, 1: 20}},
+		},
+	} {
+		pos, err := ParseReplayPosition(tc.s)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		testutils.Compare(t, "unexpected position", pos, tc.expected)
+	}
+}
+
+func TestParseReplayPositionError(t *testing.T) {
+	for _, s := range []string{"invalid", "a:1", "0:a"} {
+		_, err := ParseReplayPosition(s)
+		if err == nil {
+			t.Fatalf("no error for %q", s)
+		}
+	}
+}
+
+func TestReplayResolveOffsets(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestReplay(t)
+	for _, tc := range []struct {
+		name     string
+		pos      ReplayPosition
+		expected map[int]int64
+	}{
+		{
+			name:     "Earliest",
+			pos:      ReplayPosition{Earliest: true},
+			expected: map[int]int64{0: 0, 1: 0},
+		},
+		{
+			name:     "Latest",
+			pos:      ReplayPosition{},
+			expected: map[int]int64{0: 3, 1: 3},
+		},
+		{
+			name:     "Time",
+			pos:      ReplayPosition{Time: testReplayTime.Add(30 * time.Second)},
+			expected: map[int]int64{0: 1, 1: 1},
+		},
+		{
+			name:     "TimeAfterLast",
+			pos:      ReplayPosition{Time: testReplayTime.Add(1 * time.Hour)},
+			expected: map[int]int64{0: 3, 1: 3},
+		},
+		{
+			name:     "Offsets",
+			pos:      ReplayPosition{Offsets: map[int]int64{1: 2}},
+			expected: map[int]int64{1: 2},
+		},
+		{
+			name:     "OffsetsBounded",
+			pos:      ReplayPosition{Offsets: map[int]int64{0: -5, 1: 10}},
+			expected: map[int]int64{0: 0, 1: 3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			offsets, err := r.ResolveOffsets(ctx, "test", tc.pos)
+			if err != nil {
+				testutils.FatalErr(t, err)
+			}
+			testutils.Compare(t, "unexpected offsets", offsets, tc.expected)
+		})
+	}
+}
+
+func TestReplayResolveOffsetsErrorUnknownPartition(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestReplay(t)
+	_, err := r.ResolveOffsets(ctx, "test", ReplayPosition{Offsets: map[int]int64{2: 0}})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestReplayResolveOffsetsErrorUnknownTopic(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestReplay(t)
+	_, err := r.ResolveOffsets(ctx, "unknown", ReplayPosition{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestReplayResetOffsets(t *testing.T) {
+	ctx := context.Background()
+	r, b := newTestReplay(t)
+	err := b.CommitOffsets(ctx, "group", map[string]map[int]int64{"test": {0: 3}})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	changes, err := r.ResetOffsets(ctx, "group", "test", ReplayPosition{Time: testReplayTime.Add(1 * time.Minute)})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected changes", changes, []ReplayOffsetChange{
+		{
+			Partition: 0,
+			From:      3,
+			To:        1,
+		},
+		{
+			Partition: 1,
+			From:      -1,
+			To:        1,
+		},
+	})
+	for p := 0; p < 2; p++ {
+		if off := b.CommittedOffset("group", "test", p); off != 1 {
+			t.Fatalf("partition %d: unexpected committed offset: got %d, want %d", p, off, 1)
+		}
+	}
+}
+
+func TestReplayResetOffsetsDryRun(t *testing.T) {
+	ctx := context.Background()
+	r, b := newTestReplay(t)
+	r.DryRun = true
+	changes, err := r.ResetOffsets(ctx, "group", "test", ReplayPosition{Earliest: true})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+	if off := b.CommittedOffset("group", "test", 0); off != -1 {
+		t.Fatalf("unexpected committed offset: got %d, want %d", off, -1)
+	}
+}
+
+func TestReplayCopy(t *testing.T) {
+	ctx := context.Background()
+	r, b := newTestReplay(t)
+	dst := b.Topic(t, "dst", 1)
+	p := newTestBrokerProducer(b)
+	rp, err := r.Copy(ctx, ReplayRange{
+		Topic:  "test",
+		Start:  ReplayPosition{Time: testReplayTime.Add(1 * time.Minute)},
+		Filter: ReplayKeyFilter([]byte("a")),
+	}, dst, p.Produce)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected report", rp, &ReplayReport{
+		Read:    4,
+		Matched: 2,
+	})
+	var values []string
+	for _, msg := range b.TopicMessages(dst) {
+		values = append(values, string(msg.Value))
+	}
+	testutils.Compare(t, "unexpected values", values, []string{"a1", "a2"})
+}
+
+func TestReplayCopyDryRun(t *testing.T) {
+	ctx := context.Background()
+	r, b := newTestReplay(t)
+	r.DryRun = true
+	dst := b.Topic(t, "dst", 1)
+	p := newTestBrokerProducer(b)
+	rp, err := r.Copy(ctx, ReplayRange{
+		Topic: "test",
+		Start: ReplayPosition{Earliest: true},
+	}, dst, p.Produce)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if rp.Matched != 6 {
+		t.Fatalf("unexpected matched count: got %d, want %d", rp.Matched, 6)
+	}
+	if msgs := b.TopicMessages(dst); len(msgs) != 0 {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+}
+
+func TestReplayCopyIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	r, b := newTestReplay(t)
+	r.IdleTimeout = 10 * time.Millisecond
+	newReader := r.NewReader
+	r.NewReader = func(topic string, partition int) ReplayReader {
+		// The last message of each partition is hidden, like a transaction control record.
+		return &testReplayReaderHideLast{
+			ReplayReader: newReader(topic, partition),
+			last:         int64(len(b.Messages(topic, partition)) - 1),
+		}
+	}
+	dst := b.Topic(t, "dst", 1)
+	p := newTestBrokerProducer(b)
+	rp, err := r.Copy(ctx, ReplayRange{
+		Topic: "test",
+		Start: ReplayPosition{Earliest: true},
+	}, dst, p.Produce)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if rp.Read != 4 {
+		t.Fatalf("unexpected read count: got %d, want %d", rp.Read, 4)
+	}
+}
+
+type testReplayReaderHideLast struct {
+	ReplayReader
+	last int64
+}
+
+func (rd *testReplayReaderHideLast) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := rd.ReplayReader.FetchMessage(ctx)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	if msg.Offset == rd.last {
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	return msg, nil
+}
+
+func TestReplayDump(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestReplay(t)
+	buf := new(bytes.Buffer)
+	rp, err := r.Dump(ctx, ReplayRange{
+		Topic:  "test",
+		Start:  ReplayPosition{Offsets: map[int]int64{0: 0, 1: 0}},
+		End:    ReplayPosition{Offsets: map[int]int64{0: 1, 1: 2}},
+		Filter: ReplayHeaderFilter("key", []byte("b")),
+	}, buf)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected report", rp, &ReplayReport{
+		Read:    3,
+		Matched: 2,
+	})
+	dec := json.NewDecoder(buf)
+	var values []string
+	for dec.More() {
+		var dm ReplayDumpMessage
+		err = dec.Decode(&dm)
+		if err != nil {
+			testutils.FatalErr(t, err)
+		}
+		values = append(values, string(dm.Value))
+	}
+	testutils.Compare(t, "unexpected values", values, []string{"b0", "b1"})
+}
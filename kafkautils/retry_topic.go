@@ -0,0 +1,198 @@
+package kafkautils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/goroutine"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+// Retry headers.
+const (
+	// RetryAttemptHeader is the header containing the number of retry attempts (decimal).
+	RetryAttemptHeader = "retry-attempt"
+	// RetryOriginalTopicHeader is the header containing the topic of the message that failed first.
+	RetryOriginalTopicHeader = "retry-original-topic"
+	// RetryOriginalPartitionHeader is the header containing the partition of the message that failed first (decimal).
+	RetryOriginalPartitionHeader = "retry-original-partition"
+	// RetryOriginalOffsetHeader is the header containing the offset of the message that failed first (decimal).
+	RetryOriginalOffsetHeader = "retry-original-offset"
+	// RetryOriginalTimeHeader is the header containing the time of the message that failed first (RFC3339).
+	RetryOriginalTimeHeader = "retry-original-time"
+	// DeadLetterErrorHeader is the header containing the error message of a dead letter message.
+	DeadLetterErrorHeader = "dead-letter-error"
+	// DeadLetterErrorStackHeader is the header containing the error stack of a dead letter message.
+	DeadLetterErrorStackHeader = "dead-letter-error-stack"
+	// DeadLetterTimeHeader is the header containing the time when a message was sent to the dead letter topic (RFC3339).
+	DeadLetterTimeHeader = "dead-letter-time"
+)
+
+const retryTimeHeaderLayout = time.RFC3339Nano
+
+// RetryTopic is a retry topic.
+type RetryTopic struct {
+	Topic string
+	// Delay is the delay before the message is produced again to the main topic.
+	Delay time.Duration
+}
+
+// RetryConfig is the configuration of non-blocking retries.
+//
+// When a message fails with a temporary error, it is produced to the next retry topic with a "wait-until" header.
+// A WaitConsumer (see RunRetryConsumers) produces it again to the main topic after the delay.
+// The attempt count is stored in the "retry-attempt" header.
+// When all the retry topics are used, or if the error is not temporary, the message is produced to the dead letter topic.
+//
+// The dead letter messages have headers with the error, the original topic, partition, offset and time, and the time of the failure.
+type RetryConfig struct {
+	// Topic is the main topic.
+	Topic string
+	// Retries are the retry topics, by increasing delay.
+	Retries []RetryTopic
+	// DeadLetterTopic is the dead letter topic.
+	// If it is empty, the messages are discarded.
+	DeadLetterTopic string
+	// Producer produces the messages to the retry topics, dead letter topic and main topic.
+	// The Topic field of the messages is defined.
+	Producer Producer
+}
+
+// NewConsumer returns a new Consumer that handles the processor errors with the retry topics.
+//
+// The errors that already have a ConsumerErrorHandler (see ConsumerErrorWithHandler()) are not modified.
+func (cfg *RetryConfig) NewConsumer(pr ConsumerProcessor, errFunc func(context.Context, error)) *Consumer {
+	return &Consumer{
+		Processor: cfg.WrapProcessor(pr),
+		Error:     errFunc,
+	}
+}
+
+// WrapProcessor wraps a ConsumerProcessor, and associates the returned errors to the retry topics handler.
+func (cfg *RetryConfig) WrapProcessor(pr ConsumerProcessor) ConsumerProcessor {
+	h := &retryTopicsHandler{
+		cfg: cfg,
+	}
+	return func(ctx context.Context, msg kafka.Message) error {
+		err := pr(ctx, msg)
+		if err != nil && GetConsumerErrorHandler(err) == nil {
+			err = ConsumerErrorWithHandler(err, h)
+		}
+		return err
+	}
+}
+
+// RunRetryConsumers runs a WaitConsumer for each retry topic, that produces the messages to the main topic.
+//
+// The Topic field of readerCfg is replaced.
+// It blocks until the context is canceled.
+func (cfg *RetryConfig) RunRetryConsumers(ctx context.Context, readerCfg kafka.ReaderConfig, errFunc func(context.Context, error)) {
+	c := &WaitConsumer{
+		Producer: (&TopicProducer{
+			Producer: cfg.Producer,
+			Topic:    cfg.Topic,
+		}).Produce,
+	}
+	wg := new(sync.WaitGroup)
+	for _, rt := range cfg.Retries {
+		rtReaderCfg := readerCfg
+		rtReaderCfg.Topic = rt.Topic
+		goroutine.WaitGroup(wg, func() {
+			ConsumeReader(ctx, rtReaderCfg, c.Consume, errFunc)
+		})
+	}
+	wg.Wait()
+}
+
+type retryTopicsHandler struct {
+	cfg *RetryConfig
+}
+
+func (h *retryTopicsHandler) Handle(ctx context.Context, c *Consumer, msg kafka.Message, cErr error) (err error) {
+	_, spanFinish := startTraceChildSpan(&ctx, "consumer.error.retry_topics", &err)
+	defer spanFinish()
+	attempt := GetRetryAttempt(msg.Headers)
+	newMsg := CopyMessage(msg)
+	newMsg.Headers = DeleteHeader(newMsg.Headers, WaitUntilHeader)
+	newMsg.Headers = setRetryOriginalHeaders(newMsg.Headers, msg)
+	if errors.IsTemporary(cErr) && attempt < len(h.cfg.Retries) {
+		err = h.retry(ctx, newMsg, attempt)
+		if err != nil {
+			return errors.Wrap(err, "retry")
+		}
+		return nil
+	}
+	err = h.deadLetter(ctx, newMsg, cErr)
+	if err != nil {
+		return errors.Wrap(err, "dead letter")
+	}
+	return nil
+}
+
+func (h *retryTopicsHandler) retry(ctx context.Context, msg kafka.Message, attempt int) error {
+	rt := h.cfg.Retries[attempt]
+	msg.Topic = rt.Topic
+	msg.Headers = SetHeader(msg.Headers, RetryAttemptHeader, []byte(strconv.Itoa(attempt+1)))
+	p := &WaitProducer{
+		Producer: h.cfg.Producer,
+		Wait:     rt.Delay,
+	}
+	err := p.Produce(ctx, msg)
+	if err != nil {
+		return errors.Wrapf(err, "produce to %q", rt.Topic)
+	}
+	return nil
+}
+
+func (h *retryTopicsHandler) deadLetter(ctx context.Context, msg kafka.Message, cErr error) error {
+	if h.cfg.DeadLetterTopic == "" {
+		return nil
+	}
+	msg.Topic = h.cfg.DeadLetterTopic
+	msg.Headers = SetHeader(msg.Headers, DeadLetterErrorHeader, []byte(cErr.Error()))
+	msg.Headers = SetHeader(msg.Headers, DeadLetterErrorStackHeader, []byte(fmt.Sprintf("%+v", cErr)))
+	msg.Headers = SetHeader(msg.Headers, DeadLetterTimeHeader, []byte(timeutils.Now().Format(retryTimeHeaderLayout)))
+	err := h.cfg.Producer(ctx, msg)
+	if err != nil {
+		return errors.Wrapf(err, "produce to %q", h.cfg.DeadLetterTopic)
+	}
+	return nil
+}
+
+func (h *retryTopicsHandler) String() string {
+	return "retry topics"
+}
+
+// setRetryOriginalHeaders sets the "retry-original-*" headers, if they are not already defined by a previous failure.
+func setRetryOriginalHeaders(hs []kafka.Header, msg kafka.Message) []kafka.Header {
+	if _, ok := GetHeader(hs, RetryOriginalTopicHeader); ok {
+		return hs
+	}
+	hs = SetHeader(hs, RetryOriginalTopicHeader, []byte(msg.Topic))
+	hs = SetHeader(hs, RetryOriginalPartitionHeader, []byte(strconv.Itoa(msg.Partition)))
+	hs = SetHeader(hs, RetryOriginalOffsetHeader, []byte(strconv.FormatInt(msg.Offset, 10)))
+	if !msg.Time.IsZero() {
+		hs = SetHeader(hs, RetryOriginalTimeHeader, []byte(msg.Time.Format(retryTimeHeaderLayout)))
+	}
+	return hs
+}
+
+// GetRetryAttempt returns the number of retry attempts from the "retry-attempt" header.
+//
+// It returns 0 if the header is not defined or is invalid.
+func GetRetryAttempt(hs []kafka.Header) int {
+	v, ok := GetHeader(hs, RetryAttemptHeader)
+	if !ok {
+		return 0
+	}
+	attempt, err := strconv.Atoi(string(v))
+	if err != nil || attempt < 0 {
+		return 0
+	}
+	return attempt
+}
@@ -0,0 +1,174 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"github.com/siddhant2408/golang-libraries/timeutils"
+)
+
+func newTestRetryConfig(produced *[]kafka.Message) *RetryConfig {
+	return &RetryConfig{
+		Topic: "main",
+		Retries: []RetryTopic{
+			{
+				Topic: "retry-1m",
+				Delay: 1 * time.Minute,
+			},
+			{
+				Topic: "retry-10m",
+				Delay: 10 * time.Minute,
+			},
+		},
+		DeadLetterTopic: "dlt",
+		Producer: func(ctx context.Context, msgs ...kafka.Message) error {
+			*produced = append(*produced, msgs...)
+			return nil
+		},
+	}
+}
+
+func consumeTestRetryConfig(tb testing.TB, cfg *RetryConfig, msg kafka.Message, prErr error) {
+	tb.Helper()
+	c := cfg.NewConsumer(func(ctx context.Context, msg kafka.Message) error {
+		return prErr
+	}, func(ctx context.Context, err error) {})
+	err := c.consumeMessage(context.Background(), &testFetchCommitter{
+		commit: func(context.Context, ...kafka.Message) error {
+			return nil
+		},
+	}, msg)
+	if err != nil {
+		testutils.FatalErr(tb, err)
+	}
+}
+
+func TestRetryConfig(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeutils.SetFixed(now)
+	defer timeutils.InitFixed()
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	msg := kafka.Message{
+		Topic:     "main",
+		Partition: 2,
+		Offset:    123,
+		Value:     []byte("value"),
+		Time:      now.Add(-1 * time.Hour),
+	}
+	prErr := errors.New("error")
+	for i, expectedTopic := range []string{"retry-1m", "retry-10m", "dlt"} {
+		consumeTestRetryConfig(t, cfg, msg, prErr)
+		if len(produced) != i+1 {
+			t.Fatalf("unexpected produced count: got %d, want %d", len(produced), i+1)
+		}
+		newMsg := produced[i]
+		if newMsg.Topic != expectedTopic {
+			t.Fatalf("unexpected topic: got %q, want %q", newMsg.Topic, expectedTopic)
+		}
+		if string(newMsg.Value) != "value" {
+			t.Fatalf("unexpected value: %q", newMsg.Value)
+		}
+		// Simulate the message consumed from the retry topic, and produced again to the main topic.
+		msg = newMsg
+		msg.Topic = "main"
+		msg.Partition = 0
+		msg.Offset = int64(456 + i)
+	}
+	checkTestRetryConfigRetryMessage(t, produced[1], now)
+	checkTestRetryConfigDeadLetterMessage(t, produced[2], now)
+}
+
+func checkTestRetryConfigRetryMessage(tb testing.TB, msg kafka.Message, now time.Time) {
+	tb.Helper()
+	if attempt := GetRetryAttempt(msg.Headers); attempt != 2 {
+		tb.Fatalf("unexpected attempt: got %d, want %d", attempt, 2)
+	}
+	waitUntil, _ := GetHeader(msg.Headers, WaitUntilHeader)
+	if string(waitUntil) != now.Add(10*time.Minute).Format(waitUntilHeaderLayout) {
+		tb.Fatalf("unexpected wait until: %s", waitUntil)
+	}
+}
+
+func checkTestRetryConfigDeadLetterMessage(tb testing.TB, msg kafka.Message, now time.Time) {
+	tb.Helper()
+	for k, expected := range map[string]string{
+		RetryAttemptHeader:           "2",
+		RetryOriginalTopicHeader:     "main",
+		RetryOriginalPartitionHeader: "2",
+		RetryOriginalOffsetHeader:    "123",
+		RetryOriginalTimeHeader:      now.Add(-1 * time.Hour).Format(retryTimeHeaderLayout),
+		DeadLetterErrorHeader:        "Kafka consumer: Kafka retry topics: error",
+		DeadLetterTimeHeader:         now.Format(retryTimeHeaderLayout),
+	} {
+		v, _ := GetHeader(msg.Headers, k)
+		if string(v) != expected {
+			tb.Fatalf("unexpected header %q: got %q, want %q", k, v, expected)
+		}
+	}
+	if _, ok := GetHeader(msg.Headers, DeadLetterErrorStackHeader); !ok {
+		tb.Fatal("no error stack header")
+	}
+	if _, ok := GetHeader(msg.Headers, WaitUntilHeader); ok {
+		tb.Fatal("wait until header is defined")
+	}
+}
+
+func TestRetryConfigNotTemporary(t *testing.T) {
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	consumeTestRetryConfig(t, cfg, kafka.Message{Topic: "main"}, errors.WithTemporary(errors.New("error"), false))
+	if len(produced) != 1 || produced[0].Topic != "dlt" {
+		t.Fatalf("unexpected produced messages: %v", produced)
+	}
+}
+
+func TestRetryConfigNoDeadLetterTopic(t *testing.T) {
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	cfg.DeadLetterTopic = ""
+	consumeTestRetryConfig(t, cfg, kafka.Message{Topic: "main"}, errors.WithTemporary(errors.New("error"), false))
+	if len(produced) != 0 {
+		t.Fatalf("unexpected produced messages: %v", produced)
+	}
+}
+
+func TestRetryConfigOtherHandler(t *testing.T) {
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	consumeTestRetryConfig(t, cfg, kafka.Message{Topic: "main"}, ConsumerErrorWithHandler(errors.New("error"), ConsumerNoop))
+	if len(produced) != 0 {
+		t.Fatalf("unexpected produced messages: %v", produced)
+	}
+}
+
+func TestRetryConfigErrorProducer(t *testing.T) {
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	cfg.Producer = func(ctx context.Context, msgs ...kafka.Message) error {
+		return errors.New("error")
+	}
+	c := cfg.NewConsumer(func(ctx context.Context, msg kafka.Message) error {
+		return errors.New("error")
+	}, func(ctx context.Context, err error) {})
+	err := c.consumeMessage(context.Background(), &testFetchCommitter{}, kafka.Message{Topic: "main"})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestGetRetryAttemptInvalid(t *testing.T) {
+	attempt := GetRetryAttempt([]kafka.Header{
+		{
+			Key:   RetryAttemptHeader,
+			Value: []byte("invalid"),
+		},
+	})
+	if attempt != 0 {
+		t.Fatalf("unexpected attempt: got %d, want %d", attempt, 0)
+	}
+}
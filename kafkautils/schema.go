@@ -0,0 +1,264 @@
+package kafkautils
+
+import (
+	"reflect" //nolint:depguard // reflect is required in order to generate the schema of a Go type.
+	"strconv"
+	"strings"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SchemaFormat is the format of a schema.
+type SchemaFormat string
+
+// Schema formats.
+const (
+	SchemaFormatJSON     SchemaFormat = "json"
+	SchemaFormatProtobuf SchemaFormat = "protobuf"
+)
+
+// Schema is a schema registered in a SchemaRegistry.
+type Schema struct {
+	ID         int              `json:"id"`
+	Subject    string           `json:"subject"`
+	Version    int              `json:"version"`
+	Definition SchemaDefinition `json:"definition"`
+}
+
+// SchemaDefinition is the definition of a schema.
+//
+// It is generated by a Codec from a value.
+type SchemaDefinition struct {
+	Format SchemaFormat `json:"format"`
+	// Name is the name of the type, e.g. the Go type or the protobuf message full name.
+	Name   string        `json:"name"`
+	Fields []SchemaField `json:"fields"`
+}
+
+func (d SchemaDefinition) equal(other SchemaDefinition) bool {
+	if d.Format != other.Format || d.Name != other.Name || len(d.Fields) != len(other.Fields) {
+		return false
+	}
+	for i, f := range d.Fields {
+		if f != other.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaField is a field of a SchemaDefinition.
+type SchemaField struct {
+	Name string `json:"name"`
+	// Number is the protobuf field number.
+	// If it is defined, the fields are compared by number instead of name.
+	Number   int    `json:"number,omitempty"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+func (f SchemaField) key() string {
+	if f.Number != 0 {
+		return "#" + strconv.Itoa(f.Number)
+	}
+	return f.Name
+}
+
+// SchemaCompatibility is a compatibility mode between the versions of a subject.
+type SchemaCompatibility string
+
+// Schema compatibility modes.
+const (
+	// SchemaCompatibilityNone doesn't check the compatibility.
+	SchemaCompatibilityNone SchemaCompatibility = "none"
+	// SchemaCompatibilityBackward checks that the new schema can read the data written with the previous schema.
+	// The consumers must be upgraded before the producers.
+	SchemaCompatibilityBackward SchemaCompatibility = "backward"
+	// SchemaCompatibilityForward checks that the previous schema can read the data written with the new schema.
+	// The producers must be upgraded before the consumers.
+	SchemaCompatibilityForward SchemaCompatibility = "forward"
+	// SchemaCompatibilityFull checks the backward and forward compatibility.
+	SchemaCompatibilityFull SchemaCompatibility = "full"
+)
+
+// CheckSchemaCompatibility checks the compatibility between the previous and the new definitions of a subject.
+//
+// The rules are:
+//   - the format can't be changed
+//   - the type of a field can't be changed
+//   - a required field of the reader must exist in the writer
+func CheckSchemaCompatibility(c SchemaCompatibility, prev, next SchemaDefinition) error {
+	switch c {
+	case SchemaCompatibilityNone:
+		return nil
+	case SchemaCompatibilityBackward:
+		return checkSchemaCanRead(next, prev)
+	case SchemaCompatibilityForward:
+		return checkSchemaCanRead(prev, next)
+	case SchemaCompatibilityFull:
+		err := checkSchemaCanRead(next, prev)
+		if err != nil {
+			return errors.Wrap(err, "backward")
+		}
+		err = checkSchemaCanRead(prev, next)
+		if err != nil {
+			return errors.Wrap(err, "forward")
+		}
+		return nil
+	}
+	return errors.Newf("unknown schema compatibility %q", c)
+}
+
+func checkSchemaCanRead(reader, writer SchemaDefinition) error {
+	if reader.Format != writer.Format {
+		return errors.Newf("format changed from %q to %q", writer.Format, reader.Format)
+	}
+	writerFields := make(map[string]SchemaField, len(writer.Fields))
+	for _, f := range writer.Fields {
+		writerFields[f.key()] = f
+	}
+	for _, rf := range reader.Fields {
+		wf, ok := writerFields[rf.key()]
+		if !ok {
+			if rf.Required {
+				return errors.Newf("required field %q is missing", rf.Name)
+			}
+			continue
+		}
+		if rf.Type != wf.Type {
+			return errors.Newf("field %q: type changed from %q to %q", rf.Name, wf.Type, rf.Type)
+		}
+	}
+	return nil
+}
+
+// getJSONSchemaDefinition returns the definition of a Go value encoded with encoding/json.
+//
+// A field is required if it is not a pointer and doesn't have the "omitempty" option.
+func getJSONSchemaDefinition(v interface{}) (SchemaDefinition, error) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return SchemaDefinition{}, errors.Newf("unsupported type %v: not a struct", reflect.TypeOf(v))
+	}
+	return SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Name:   typ.String(),
+		Fields: getJSONSchemaFields(typ),
+	}, nil
+}
+
+func getJSONSchemaFields(typ reflect.Type) []SchemaField {
+	var fs []SchemaField
+	for i := 0; i < typ.NumField(); i++ {
+		fs = append(fs, getJSONSchemaStructFields(typ.Field(i))...)
+	}
+	return fs
+}
+
+// getJSONSchemaStructFields returns the fields of a struct field.
+// An embedded struct returns its own fields, because they are promoted by encoding/json.
+func getJSONSchemaStructFields(sf reflect.StructField) []SchemaField {
+	name, opts, ok := parseJSONFieldTag(sf.Tag.Get("json"))
+	if !ok {
+		return nil
+	}
+	ft := sf.Type
+	if sf.Anonymous && name == "" {
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			return getJSONSchemaFields(ft)
+		}
+	}
+	if sf.PkgPath != "" {
+		return nil // Not exported.
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	return []SchemaField{
+		{
+			Name:     name,
+			Type:     getJSONSchemaType(sf.Type),
+			Required: ft.Kind() != reflect.Ptr && !strings.Contains(","+opts+",", ",omitempty,"),
+		},
+	}
+}
+
+// parseJSONFieldTag returns the name and the options of a "json" field tag.
+// It returns false if the field is ignored.
+func parseJSONFieldTag(tag string) (name string, opts string, ok bool) {
+	if tag == "-" {
+		return "", "", false
+	}
+	name = tag
+	if j := strings.IndexByte(tag, ','); j >= 0 {
+		name, opts = tag[:j], tag[j+1:]
+	}
+	return name, opts, true
+}
+
+func getJSONSchemaType(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "string" // Encoded in base64.
+		}
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "any"
+}
+
+// getProtobufSchemaDefinition returns the definition of a protobuf message.
+func getProtobufSchemaDefinition(md protoreflect.MessageDescriptor) SchemaDefinition {
+	def := SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Name:   string(md.FullName()),
+	}
+	fds := md.Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		typ := getProtobufSchemaType(fd)
+		switch {
+		case fd.IsMap():
+			typ = "map<" + getProtobufSchemaType(fd.MapKey()) + ", " + getProtobufSchemaType(fd.MapValue()) + ">"
+		case fd.IsList():
+			typ = "repeated " + typ
+		}
+		def.Fields = append(def.Fields, SchemaField{
+			Name:     string(fd.Name()),
+			Number:   int(fd.Number()),
+			Type:     typ,
+			Required: fd.Cardinality() == protoreflect.Required,
+		})
+	}
+	return def
+}
+
+func getProtobufSchemaType(fd protoreflect.FieldDescriptor) string {
+	if fd.Message() != nil {
+		return string(fd.Message().FullName())
+	}
+	if fd.Enum() != nil {
+		return string(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
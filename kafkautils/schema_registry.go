@@ -0,0 +1,186 @@
+package kafkautils
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+)
+
+// SchemaRegistry stores the schemas.
+//
+// It allows to plug a remote schema registry client.
+// FileSchemaRegistry is a local implementation.
+type SchemaRegistry interface {
+	// Register registers a definition for a subject, and returns the schema.
+	// If the definition is already registered for the subject, the existing schema is returned.
+	// It returns an error if the definition is not compatible with the latest version of the subject.
+	Register(ctx context.Context, subject string, def SchemaDefinition) (*Schema, error)
+	// GetSchema returns a schema by ID.
+	// It returns a non-temporary error if the schema doesn't exist.
+	GetSchema(ctx context.Context, id int) (*Schema, error)
+}
+
+// FileSchemaRegistry is a SchemaRegistry stored in a local JSON file.
+//
+// It should be used for tests and development.
+// The file is read on the first call, and written after each registration.
+// It is safe for concurrent use, but the file should not be shared by several processes.
+type FileSchemaRegistry struct {
+	// Path is the path of the file.
+	// If it is empty, the schemas are stored in memory only.
+	Path string
+	// Compatibility is the compatibility mode checked when a new version is registered.
+	// Default: SchemaCompatibilityBackward.
+	Compatibility SchemaCompatibility
+
+	mu      sync.Mutex
+	loaded  bool
+	schemas []*Schema
+}
+
+type fileSchemaRegistryData struct {
+	Schemas []*Schema `json:"schemas"`
+}
+
+// Register implements SchemaRegistry.
+func (r *FileSchemaRegistry) Register(ctx context.Context, subject string, def SchemaDefinition) (*Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka file schema registry: load")
+	}
+	existing, latest, maxID := r.find(subject, def)
+	if existing != nil {
+		return copySchema(existing), nil
+	}
+	s := &Schema{
+		ID:         maxID + 1,
+		Subject:    subject,
+		Version:    1,
+		Definition: def,
+	}
+	if latest != nil {
+		err = CheckSchemaCompatibility(r.getCompatibility(), latest.Definition, def)
+		if err != nil {
+			err = errors.Wrapf(err, "subject %q: incompatible with version %d", subject, latest.Version)
+			return nil, errors.Wrap(errors.WithTemporary(err, false), "Kafka file schema registry")
+		}
+		s.Version = latest.Version + 1
+	}
+	r.schemas = append(r.schemas, s)
+	err = r.save()
+	if err != nil {
+		r.schemas = r.schemas[:len(r.schemas)-1]
+		return nil, errors.Wrap(err, "Kafka file schema registry: save")
+	}
+	return copySchema(s), nil
+}
+
+// GetSchema implements SchemaRegistry.
+func (r *FileSchemaRegistry) GetSchema(ctx context.Context, id int) (*Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka file schema registry: load")
+	}
+	for _, s := range r.schemas {
+		if s.ID == id {
+			return copySchema(s), nil
+		}
+	}
+	err = errors.Newf("Kafka file schema registry: schema %d not found", id)
+	return nil, errors.WithTemporary(err, false)
+}
+
+// load reads the file, if it is not loaded yet.
+// The lock must be held.
+func (r *FileSchemaRegistry) load() error {
+	if r.loaded {
+		return nil
+	}
+	if r.Path != "" {
+		b, err := os.ReadFile(r.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "read file")
+		}
+		if err == nil {
+			var data fileSchemaRegistryData
+			err = json.Unmarshal(b, &data)
+			if err != nil {
+				return errors.Wrap(err, "JSON unmarshal")
+			}
+			r.schemas = data.Schemas
+		}
+	}
+	r.loaded = true
+	return nil
+}
+
+// save writes the file atomically.
+// The lock must be held.
+func (r *FileSchemaRegistry) save() error {
+	if r.Path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(fileSchemaRegistryData{Schemas: r.schemas}, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "JSON marshal")
+	}
+	f, err := os.CreateTemp(filepath.Dir(r.Path), filepath.Base(r.Path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // It fails after the rename.
+	_, err = f.Write(b)
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "write temporary file")
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close temporary file")
+	}
+	err = os.Rename(f.Name(), r.Path)
+	if err != nil {
+		return errors.Wrap(err, "rename temporary file")
+	}
+	return nil
+}
+
+func (r *FileSchemaRegistry) getCompatibility() SchemaCompatibility {
+	if r.Compatibility != "" {
+		return r.Compatibility
+	}
+	return SchemaCompatibilityBackward
+}
+
+// find returns the schema of a subject with the same definition, the latest schema of the subject, and the maximum ID.
+func (r *FileSchemaRegistry) find(subject string, def SchemaDefinition) (existing *Schema, latest *Schema, maxID int) {
+	for _, s := range r.schemas {
+		if s.ID > maxID {
+			maxID = s.ID
+		}
+		if s.Subject != subject {
+			continue
+		}
+		if s.Definition.equal(def) {
+			return s, nil, maxID
+		}
+		if latest == nil || s.Version > latest.Version {
+			latest = s
+		}
+	}
+	return nil, latest, maxID
+}
+
+func copySchema(s *Schema) *Schema {
+	tmp := *s
+	tmp.Definition.Fields = append([]SchemaField(nil), s.Definition.Fields...)
+	return &tmp
+}
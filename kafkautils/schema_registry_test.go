@@ -0,0 +1,141 @@
+package kafkautils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+var testSchemaDefinitionV1 = SchemaDefinition{
+	Format: SchemaFormatJSON,
+	Name:   "test",
+	Fields: []SchemaField{
+		{Name: "a", Type: "string", Required: true},
+	},
+}
+
+var testSchemaDefinitionV2 = SchemaDefinition{
+	Format: SchemaFormatJSON,
+	Name:   "test",
+	Fields: []SchemaField{
+		{Name: "a", Type: "string", Required: true},
+		{Name: "b", Type: "string"},
+	},
+}
+
+func TestFileSchemaRegistry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	r := &FileSchemaRegistry{
+		Path: path,
+	}
+	s1, err := r.Register(ctx, "subject", testSchemaDefinitionV1)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected schema", s1, &Schema{
+		ID:         1,
+		Subject:    "subject",
+		Version:    1,
+		Definition: testSchemaDefinitionV1,
+	})
+	s, err := r.Register(ctx, "subject", testSchemaDefinitionV1)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected schema", s, s1)
+	s2, err := r.Register(ctx, "subject", testSchemaDefinitionV2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if s2.ID != 2 || s2.Version != 2 {
+		t.Fatalf("unexpected schema: %+v", s2)
+	}
+	s3, err := r.Register(ctx, "other", testSchemaDefinitionV1)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if s3.ID != 3 || s3.Version != 1 {
+		t.Fatalf("unexpected schema: %+v", s3)
+	}
+	// The schemas are loaded from the file.
+	r = &FileSchemaRegistry{
+		Path: path,
+	}
+	s, err = r.GetSchema(ctx, 2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected schema", s, s2)
+}
+
+func TestFileSchemaRegistryErrorIncompatible(t *testing.T) {
+	ctx := context.Background()
+	r := &FileSchemaRegistry{}
+	_, err := r.Register(ctx, "subject", testSchemaDefinitionV2)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	_, err = r.Register(ctx, "subject", SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Name:   "test",
+		Fields: []SchemaField{
+			{Name: "a", Type: "number", Required: true},
+		},
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if errors.IsTemporary(err) {
+		t.Fatal("temporary")
+	}
+}
+
+func TestFileSchemaRegistryCompatibilityNone(t *testing.T) {
+	ctx := context.Background()
+	r := &FileSchemaRegistry{
+		Compatibility: SchemaCompatibilityNone,
+	}
+	_, err := r.Register(ctx, "subject", testSchemaDefinitionV1)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	_, err = r.Register(ctx, "subject", SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+}
+
+func TestFileSchemaRegistryErrorNotFound(t *testing.T) {
+	ctx := context.Background()
+	r := &FileSchemaRegistry{}
+	_, err := r.GetSchema(ctx, 1)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if errors.IsTemporary(err) {
+		t.Fatal("temporary")
+	}
+}
+
+func TestFileSchemaRegistryErrorInvalidFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	err := os.WriteFile(path, []byte("invalid"), 0o600)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	r := &FileSchemaRegistry{
+		Path: path,
+	}
+	_, err = r.GetSchema(ctx, 1)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
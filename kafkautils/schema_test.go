@@ -0,0 +1,175 @@
+package kafkautils
+
+import (
+	"testing"
+
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type testSchemaEmbedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type testSchemaValue struct {
+	testSchemaEmbedded
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Enabled  *bool             `json:"enabled"`
+	Data     []byte            `json:"data"`
+	Tags     []string          `json:"tags,omitempty"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Ignored  string            `json:"-"`
+	NoTag    float64
+	internal string //nolint:unused,structcheck // Not exported, so it is ignored.
+}
+
+func TestJSONCodecSchema(t *testing.T) {
+	def, err := JSONCodec{}.Schema(&testSchemaValue{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected definition", def, SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Name:   "kafkautils.testSchemaValue",
+		Fields: []SchemaField{
+			{Name: "embedded", Type: "string", Required: true},
+			{Name: "name", Type: "string", Required: true},
+			{Name: "count", Type: "number"},
+			{Name: "enabled", Type: "boolean"},
+			{Name: "data", Type: "string", Required: true},
+			{Name: "tags", Type: "array"},
+			{Name: "attrs", Type: "object"},
+			{Name: "NoTag", Type: "number", Required: true},
+		},
+	})
+}
+
+func TestJSONCodecSchemaErrorNotStruct(t *testing.T) {
+	_, err := JSONCodec{}.Schema("string")
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestProtobufCodecSchema(t *testing.T) {
+	def, err := ProtobufCodec{}.Schema(&structpb.ListValue{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected definition", def, SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Name:   "google.protobuf.ListValue",
+		Fields: []SchemaField{
+			{Name: "values", Number: 1, Type: "repeated google.protobuf.Value"},
+		},
+	})
+	def, err = ProtobufCodec{}.Schema(&structpb.Struct{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected fields", def.Fields, []SchemaField{
+		{Name: "fields", Number: 1, Type: "map<string, google.protobuf.Value>"},
+	})
+}
+
+func TestProtobufCodecSchemaErrorNotMessage(t *testing.T) {
+	_, err := ProtobufCodec{}.Schema("string")
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestCheckSchemaCompatibility(t *testing.T) {
+	v1 := SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Fields: []SchemaField{
+			{Name: "a", Type: "string", Required: true},
+			{Name: "b", Type: "number"},
+		},
+	}
+	v2AddRequired := SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Fields: append(append([]SchemaField(nil), v1.Fields...), SchemaField{Name: "c", Type: "string", Required: true}),
+	}
+	v2RemoveRequired := SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Fields: []SchemaField{
+			{Name: "b", Type: "number"},
+		},
+	}
+	v2AddOptional := SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Fields: append(append([]SchemaField(nil), v1.Fields...), SchemaField{Name: "c", Type: "string"}),
+	}
+	v2ChangeType := SchemaDefinition{
+		Format: SchemaFormatJSON,
+		Fields: []SchemaField{
+			{Name: "a", Type: "string", Required: true},
+			{Name: "b", Type: "string"},
+		},
+	}
+	v2Protobuf := SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Fields: v1.Fields,
+	}
+	for _, tc := range []struct {
+		name       string
+		c          SchemaCompatibility
+		next       SchemaDefinition
+		compatible bool
+	}{
+		{"BackwardAddRequired", SchemaCompatibilityBackward, v2AddRequired, false},
+		{"BackwardRemoveRequired", SchemaCompatibilityBackward, v2RemoveRequired, true},
+		{"BackwardAddOptional", SchemaCompatibilityBackward, v2AddOptional, true},
+		{"ForwardAddRequired", SchemaCompatibilityForward, v2AddRequired, true},
+		{"ForwardRemoveRequired", SchemaCompatibilityForward, v2RemoveRequired, false},
+		{"FullAddRequired", SchemaCompatibilityFull, v2AddRequired, false},
+		{"FullRemoveRequired", SchemaCompatibilityFull, v2RemoveRequired, false},
+		{"FullAddOptional", SchemaCompatibilityFull, v2AddOptional, true},
+		{"FullChangeType", SchemaCompatibilityFull, v2ChangeType, false},
+		{"FullFormat", SchemaCompatibilityFull, v2Protobuf, false},
+		{"None", SchemaCompatibilityNone, v2ChangeType, true},
+		{"Unknown", SchemaCompatibility("unknown"), v1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckSchemaCompatibility(tc.c, v1, tc.next)
+			if tc.compatible && err != nil {
+				testutils.FatalErr(t, err)
+			}
+			if !tc.compatible && err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestCheckSchemaCompatibilityProtobufNumber(t *testing.T) {
+	v1 := SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Fields: []SchemaField{
+			{Name: "a", Number: 1, Type: "string"},
+		},
+	}
+	v2Renamed := SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Fields: []SchemaField{
+			{Name: "b", Number: 1, Type: "string"},
+		},
+	}
+	err := CheckSchemaCompatibility(SchemaCompatibilityFull, v1, v2Renamed)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	v2Renumbered := SchemaDefinition{
+		Format: SchemaFormatProtobuf,
+		Fields: []SchemaField{
+			{Name: "a", Number: 2, Type: "int64"},
+			{Name: "b", Number: 1, Type: "int64"},
+		},
+	}
+	err = CheckSchemaCompatibility(SchemaCompatibilityFull, v1, v2Renumbered)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
@@ -0,0 +1,310 @@
+package kafkautils
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect" //nolint:depguard // The schemas are cached by Go type.
+	"strconv"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes values.
+type Codec interface {
+	Format() SchemaFormat
+	// Schema returns the definition of a value.
+	Schema(v interface{}) (SchemaDefinition, error)
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is a Codec for encoding/json.
+//
+// The values must be structs (or pointers to structs).
+type JSONCodec struct{}
+
+// Format implements Codec.
+func (JSONCodec) Format() SchemaFormat {
+	return SchemaFormatJSON
+}
+
+// Schema implements Codec.
+func (JSONCodec) Schema(v interface{}) (SchemaDefinition, error) {
+	return getJSONSchemaDefinition(v)
+}
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "JSON marshal")
+	}
+	return b, nil
+}
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return errors.Wrap(err, "JSON unmarshal")
+	}
+	return nil
+}
+
+// ProtobufCodec is a Codec for protobuf.
+//
+// The values must implement proto.Message.
+type ProtobufCodec struct{}
+
+// Format implements Codec.
+func (ProtobufCodec) Format() SchemaFormat {
+	return SchemaFormatProtobuf
+}
+
+// Schema implements Codec.
+func (ProtobufCodec) Schema(v interface{}) (SchemaDefinition, error) {
+	m, err := getProtoMessage(v)
+	if err != nil {
+		return SchemaDefinition{}, err
+	}
+	return getProtobufSchemaDefinition(m.ProtoReflect().Descriptor()), nil
+}
+
+// Marshal implements Codec.
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, err := getProtoMessage(v)
+	if err != nil {
+		return nil, err
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "protobuf marshal")
+	}
+	return b, nil
+}
+
+// Unmarshal implements Codec.
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, err := getProtoMessage(v)
+	if err != nil {
+		return err
+	}
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		return errors.Wrap(err, "protobuf unmarshal")
+	}
+	return nil
+}
+
+func getProtoMessage(v interface{}) (proto.Message, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Newf("unsupported type %T: not a proto.Message", v)
+	}
+	return m, nil
+}
+
+// SchemaIDHeader is the header containing the schema ID (decimal), if Serde.Header is enabled.
+const SchemaIDHeader = "schema-id"
+
+const (
+	serdeMagicByte      = 0
+	serdeMagicPrefixLen = 5
+)
+
+// Serde serializes and deserializes the values of the messages, with a schema registry.
+//
+// The schema ID is stored either:
+//   - in the wire-format prefix (default): a magic byte 0, followed by the ID (4 bytes, big endian)
+//   - in the "schema-id" header, if Header is enabled
+//
+// Serialize registers the schema of the value in the registry.
+// Deserialize checks that the schema of the message exists, and uses the same format as the codec.
+// The decode errors are not temporary, see IsSerdeDecodeError().
+// So the messages are discarded by Consumer, or sent to the dead letter topic by RetryConfig.
+//
+// The schemas are cached.
+type Serde struct {
+	Registry SchemaRegistry
+	Codec    Codec
+	// Subject is the subject of the registered schemas, e.g. "<topic>-value".
+	Subject string
+	// Header stores the schema ID in the "schema-id" header, instead of the wire-format prefix.
+	Header bool
+
+	mu          sync.Mutex
+	schemaTypes map[reflect.Type]*Schema
+	schemaIDs   map[int]*Schema
+}
+
+// Serialize encodes a value to the message value, and defines the schema ID.
+func (s *Serde) Serialize(ctx context.Context, msg *kafka.Message, v interface{}) error {
+	sc, err := s.getTypeSchema(ctx, v)
+	if err != nil {
+		return errors.Wrap(err, "Kafka serde: serialize: get schema")
+	}
+	b, err := s.Codec.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "Kafka serde: serialize")
+	}
+	if s.Header {
+		msg.Value = b
+		msg.Headers = SetHeader(msg.Headers, SchemaIDHeader, []byte(strconv.Itoa(sc.ID)))
+		return nil
+	}
+	value := make([]byte, serdeMagicPrefixLen+len(b))
+	value[0] = serdeMagicByte
+	binary.BigEndian.PutUint32(value[1:serdeMagicPrefixLen], uint32(sc.ID))
+	copy(value[serdeMagicPrefixLen:], b)
+	msg.Value = value
+	return nil
+}
+
+func (s *Serde) getTypeSchema(ctx context.Context, v interface{}) (*Schema, error) {
+	typ := reflect.TypeOf(v)
+	s.mu.Lock()
+	sc, ok := s.schemaTypes[typ]
+	s.mu.Unlock()
+	if ok {
+		return sc, nil
+	}
+	def, err := s.Codec.Schema(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "definition")
+	}
+	sc, err = s.Registry.Register(ctx, s.Subject, def)
+	if err != nil {
+		return nil, errors.Wrap(err, "register")
+	}
+	s.mu.Lock()
+	if s.schemaTypes == nil {
+		s.schemaTypes = make(map[reflect.Type]*Schema)
+	}
+	s.schemaTypes[typ] = sc
+	s.mu.Unlock()
+	return sc, nil
+}
+
+// Deserialize decodes the message value to a value, and returns the schema of the message.
+func (s *Serde) Deserialize(ctx context.Context, msg kafka.Message, v interface{}) (*Schema, error) {
+	sc, b, err := s.deserialize(ctx, msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka serde: deserialize")
+	}
+	err = s.Codec.Unmarshal(b, v)
+	if err != nil {
+		err = errors.Wrapf(err, "schema %d", sc.ID)
+		return nil, errors.Wrap(newSerdeDecodeError(err), "Kafka serde: deserialize")
+	}
+	return sc, nil
+}
+
+func (s *Serde) deserialize(ctx context.Context, msg kafka.Message) (*Schema, []byte, error) {
+	id, b, err := s.getSchemaID(msg)
+	if err != nil {
+		return nil, nil, newSerdeDecodeError(err)
+	}
+	sc, err := s.getIDSchema(ctx, id)
+	if err != nil {
+		err = errors.Wrapf(err, "get schema %d", id)
+		if !errors.IsTemporary(err) {
+			err = newSerdeDecodeError(err)
+		}
+		return nil, nil, err
+	}
+	if sc.Definition.Format != s.Codec.Format() {
+		err = errors.Newf("schema %d: format %q doesn't match codec format %q", id, sc.Definition.Format, s.Codec.Format())
+		return nil, nil, newSerdeDecodeError(err)
+	}
+	return sc, b, nil
+}
+
+func (s *Serde) getSchemaID(msg kafka.Message) (int, []byte, error) {
+	if s.Header {
+		h, ok := GetHeader(msg.Headers, SchemaIDHeader)
+		if !ok {
+			return 0, nil, errors.New("missing schema ID header")
+		}
+		id, err := strconv.Atoi(string(h))
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "invalid schema ID header")
+		}
+		return id, msg.Value, nil
+	}
+	if len(msg.Value) < serdeMagicPrefixLen || msg.Value[0] != serdeMagicByte {
+		return 0, nil, errors.New("missing wire-format prefix")
+	}
+	id := int(binary.BigEndian.Uint32(msg.Value[1:serdeMagicPrefixLen]))
+	return id, msg.Value[serdeMagicPrefixLen:], nil
+}
+
+func (s *Serde) getIDSchema(ctx context.Context, id int) (*Schema, error) {
+	s.mu.Lock()
+	sc, ok := s.schemaIDs[id]
+	s.mu.Unlock()
+	if ok {
+		return sc, nil
+	}
+	sc, err := s.Registry.GetSchema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.schemaIDs == nil {
+		s.schemaIDs = make(map[int]*Schema)
+	}
+	s.schemaIDs[id] = sc
+	s.mu.Unlock()
+	return sc, nil
+}
+
+// WrapProcessor returns a ConsumerProcessor that deserializes the messages.
+//
+// newValue returns a new value, e.g. a pointer to a struct.
+// The decode errors are returned to the Consumer, and they are not temporary.
+func (s *Serde) WrapProcessor(newValue func() interface{}, pr func(ctx context.Context, msg kafka.Message, v interface{}) error) ConsumerProcessor {
+	return func(ctx context.Context, msg kafka.Message) error {
+		v := newValue()
+		_, err := s.Deserialize(ctx, msg, v)
+		if err != nil {
+			return err
+		}
+		return pr(ctx, msg, v)
+	}
+}
+
+// newSerdeDecodeError returns a decode error.
+//
+// It is not temporary, so it is handled by the consumer as an invalid message.
+func newSerdeDecodeError(err error) error {
+	err = &serdeDecodeError{
+		err: err,
+	}
+	return errors.WithTemporary(err, false)
+}
+
+type serdeDecodeError struct {
+	err error
+}
+
+func (err *serdeDecodeError) WriteErrorMessage(w errors.Writer, verbose bool) bool {
+	_, _ = w.WriteString("decode")
+	return true
+}
+
+func (err *serdeDecodeError) Error() string                 { return errors.Error(err) }
+func (err *serdeDecodeError) Format(s fmt.State, verb rune) { errors.Format(err, s, verb) }
+func (err *serdeDecodeError) Unwrap() error                 { return err.err }
+
+// IsSerdeDecodeError returns true if the error is a decode error returned by Serde.
+//
+// The message is invalid: the schema ID is missing or unknown, or the value can't be decoded.
+func IsSerdeDecodeError(err error) bool {
+	var werr *serdeDecodeError
+	return errors.As(err, &werr)
+}
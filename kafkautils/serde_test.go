@@ -0,0 +1,244 @@
+package kafkautils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/testutils"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testSerdeValue struct {
+	Name string `json:"name"`
+}
+
+func newTestSerde(codec Codec) *Serde {
+	return &Serde{
+		Registry: &FileSchemaRegistry{},
+		Codec:    codec,
+		Subject:  "test-value",
+	}
+}
+
+func TestSerdeJSON(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(JSONCodec{})
+	var msg kafka.Message
+	err := s.Serialize(ctx, &msg, &testSerdeValue{Name: "test"})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if expected := append([]byte{0, 0, 0, 0, 1}, `{"name":"test"}`...); !bytes.Equal(msg.Value, expected) {
+		t.Fatalf("unexpected value: got %q, want %q", msg.Value, expected)
+	}
+	v := new(testSerdeValue)
+	sc, err := s.Deserialize(ctx, msg, v)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected value", v, &testSerdeValue{Name: "test"})
+	if sc.ID != 1 || sc.Subject != "test-value" {
+		t.Fatalf("unexpected schema: %+v", sc)
+	}
+}
+
+func TestSerdeProtobufHeader(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(ProtobufCodec{})
+	s.Header = true
+	var msg kafka.Message
+	err := s.Serialize(ctx, &msg, wrapperspb.String("test"))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	h, ok := GetHeader(msg.Headers, SchemaIDHeader)
+	if !ok || string(h) != "1" {
+		t.Fatalf("unexpected schema ID header: %q", h)
+	}
+	v := new(wrapperspb.StringValue)
+	_, err = s.Deserialize(ctx, msg, v)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if !proto.Equal(v, wrapperspb.String("test")) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestSerdeSerializeErrorIncompatible(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(ProtobufCodec{})
+	var msg kafka.Message
+	err := s.Serialize(ctx, &msg, wrapperspb.String("test"))
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	err = s.Serialize(ctx, &msg, wrapperspb.Int64(1))
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestSerdeSerializeErrorCodec(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(ProtobufCodec{})
+	var msg kafka.Message
+	err := s.Serialize(ctx, &msg, &testSerdeValue{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestSerdeDeserializeDecodeError(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(JSONCodec{})
+	var valid kafka.Message
+	err := s.Serialize(ctx, &valid, &testSerdeValue{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	protobufSerde := newTestSerde(ProtobufCodec{})
+	protobufSerde.Registry = s.Registry
+	for _, tc := range []struct {
+		name  string
+		serde *Serde
+		msg   kafka.Message
+	}{
+		{
+			name:  "MissingPrefix",
+			serde: s,
+			msg:   kafka.Message{Value: []byte(`{}`)},
+		},
+		{
+			name:  "UnknownSchema",
+			serde: s,
+			msg:   kafka.Message{Value: []byte{0, 0, 0, 0, 2}},
+		},
+		{
+			name:  "InvalidValue",
+			serde: s,
+			msg:   kafka.Message{Value: []byte{0, 0, 0, 0, 1, 'a'}},
+		},
+		{
+			name:  "FormatMismatch",
+			serde: protobufSerde,
+			msg:   valid,
+		},
+		{
+			name:  "MissingHeader",
+			serde: &Serde{Registry: s.Registry, Codec: JSONCodec{}, Header: true},
+			msg:   valid,
+		},
+		{
+			name:  "InvalidHeader",
+			serde: &Serde{Registry: s.Registry, Codec: JSONCodec{}, Header: true},
+			msg: kafka.Message{
+				Headers: []kafka.Header{
+					{
+						Key:   SchemaIDHeader,
+						Value: []byte("invalid"),
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.serde.Deserialize(ctx, tc.msg, new(testSerdeValue))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !IsSerdeDecodeError(err) {
+				t.Fatalf("not a decode error: %v", err)
+			}
+			if errors.IsTemporary(err) {
+				t.Fatal("temporary")
+			}
+			if h := GetConsumerErrorHandler(err); h != nil {
+				t.Fatalf("unexpected handler: %v", h)
+			}
+		})
+	}
+}
+
+func TestSerdeRetryConfigDeadLetter(t *testing.T) {
+	s := newTestSerde(JSONCodec{})
+	var produced []kafka.Message
+	cfg := newTestRetryConfig(&produced)
+	c := cfg.NewConsumer(s.WrapProcessor(func() interface{} {
+		return new(testSerdeValue)
+	}, func(ctx context.Context, msg kafka.Message, v interface{}) error {
+		return nil
+	}), func(ctx context.Context, err error) {})
+	err := c.consumeMessage(context.Background(), &testFetchCommitter{
+		commit: func(context.Context, ...kafka.Message) error {
+			return nil
+		},
+	}, kafka.Message{
+		Topic: "main",
+		Value: []byte("invalid"),
+	})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	// The invalid message is not retried.
+	if len(produced) != 1 || produced[0].Topic != "dlt" {
+		t.Fatalf("unexpected produced messages: %v", produced)
+	}
+}
+
+func TestSerdeDeserializeErrorRegistryTemporary(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(JSONCodec{})
+	s.Registry = &testSchemaRegistryError{}
+	_, err := s.Deserialize(ctx, kafka.Message{Value: []byte{0, 0, 0, 0, 1}}, new(testSerdeValue))
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if IsSerdeDecodeError(err) {
+		t.Fatal("decode error")
+	}
+	if !errors.IsTemporary(err) {
+		t.Fatal("not temporary")
+	}
+}
+
+type testSchemaRegistryError struct{}
+
+func (r *testSchemaRegistryError) Register(ctx context.Context, subject string, def SchemaDefinition) (*Schema, error) {
+	return nil, errors.New("error")
+}
+
+func (r *testSchemaRegistryError) GetSchema(ctx context.Context, id int) (*Schema, error) {
+	return nil, errors.New("error")
+}
+
+func TestSerdeWrapProcessor(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSerde(JSONCodec{})
+	var msg kafka.Message
+	err := s.Serialize(ctx, &msg, &testSerdeValue{Name: "test"})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	var prCalled testutils.CallCounter
+	pr := s.WrapProcessor(func() interface{} {
+		return new(testSerdeValue)
+	}, func(ctx context.Context, msg kafka.Message, v interface{}) error {
+		prCalled.Call()
+		testutils.Compare(t, "unexpected value", v, &testSerdeValue{Name: "test"})
+		return nil
+	})
+	err = pr(ctx, msg)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	prCalled.AssertCalled(t)
+	err = pr(ctx, kafka.Message{})
+	if !IsSerdeDecodeError(err) {
+		t.Fatalf("not a decode error: %v", err)
+	}
+}
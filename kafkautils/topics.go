@@ -0,0 +1,334 @@
+package kafkautils
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	opentracing_ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
+)
+
+// TopicsClient is the subset of *kafka.Client used by Topics.
+type TopicsClient interface {
+	Metadata(context.Context, *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	CreateTopics(context.Context, *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error)
+	CreatePartitions(context.Context, *kafka.CreatePartitionsRequest) (*kafka.CreatePartitionsResponse, error)
+	DescribeConfigs(context.Context, *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error)
+}
+
+// TopicConfig represents a topic config.
+type TopicConfig struct {
+	Name              string `json:"name,omitempty" yaml:"name,omitempty"`
+	Partitions        int    `json:"partitions,omitempty" yaml:"partitions,omitempty"`
+	ReplicationFactor int    `json:"replication_factor,omitempty" yaml:"replication_factor,omitempty"`
+	// Configs are the topic configs, e.g. "retention.ms" or "cleanup.policy".
+	Configs map[string]string `json:"configs,omitempty" yaml:"configs,omitempty"`
+}
+
+// Topics represents a declarative topics specification.
+//
+// Ensure() creates the missing topics, increases the partitions, and reports the config drift.
+// The existing configs are not modified, and the partitions are never decreased.
+type Topics struct {
+	// Client is usually a *kafka.Client, see Config.NewClient().
+	Client TopicsClient  `json:"-" yaml:"-"`
+	Topics []TopicConfig `json:"topics,omitempty" yaml:"topics,omitempty"`
+	// DryRun doesn't modify the topics.
+	// The requests are sent with ValidateOnly, so they are validated by the broker.
+	DryRun bool `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+}
+
+// NewTopics returns a new Topics, with a client created by c.NewClient().
+func (c *Config) NewTopics(tcs ...TopicConfig) *Topics {
+	return &Topics{
+		Client: c.NewClient(),
+		Topics: tcs,
+	}
+}
+
+// TopicsReport is the report of Topics.Ensure().
+type TopicsReport struct {
+	// Created contains the created topics.
+	Created []string
+	// PartitionsIncreased contains the topics with increased partitions.
+	PartitionsIncreased []TopicPartitionsChange
+	// Drifts contains the differences between the specification and the existing topics, that are not fixed.
+	Drifts []TopicDrift
+}
+
+// HasChanges returns true if topics are created or modified.
+func (r *TopicsReport) HasChanges() bool {
+	return len(r.Created) > 0 || len(r.PartitionsIncreased) > 0
+}
+
+// TopicPartitionsChange is a change of the partitions count of a topic.
+type TopicPartitionsChange struct {
+	Topic string
+	From  int
+	To    int
+}
+
+// TopicDrift is a difference between the specification and an existing topic.
+//
+// Name is a topic config name, or "partitions" or "replication_factor".
+type TopicDrift struct {
+	Topic    string
+	Name     string
+	Expected string
+	Actual   string
+}
+
+// Topic drift names that are not topic configs.
+const (
+	TopicDriftPartitions        = "partitions"
+	TopicDriftReplicationFactor = "replication_factor"
+)
+
+// Ensure ensures that the topics match the specification.
+//
+// In dry-run mode, the report contains the changes that would be applied.
+func (t *Topics) Ensure(ctx context.Context) (_ *TopicsReport, err error) {
+	span, spanFinish := startTraceChildSpan(&ctx, "topics.ensure", &err)
+	defer spanFinish()
+	tracingutils.SetSpanServiceName(span, tracingExternalServiceName)
+	tracingutils.SetSpanType(span, tracingutils.AppTypeRPC)
+	opentracing_ext.SpanKindRPCClient.Set(span)
+	setTraceSpanTag(span, "dry_run", t.DryRun)
+	existing, err := t.getExisting(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka topics: get existing")
+	}
+	pl := t.plan(existing)
+	r := &TopicsReport{
+		Drifts: pl.drifts,
+	}
+	err = t.create(ctx, pl.creates)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka topics: create")
+	}
+	for _, tc := range pl.creates {
+		r.Created = append(r.Created, tc.Name)
+	}
+	err = t.increasePartitions(ctx, pl.increases)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka topics: increase partitions")
+	}
+	r.PartitionsIncreased = pl.increases
+	drifts, err := t.getConfigDrifts(ctx, pl.describes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Kafka topics: get config drifts")
+	}
+	r.Drifts = append(r.Drifts, drifts...)
+	return r, nil
+}
+
+// topicsPlan contains the operations required to match the specification.
+type topicsPlan struct {
+	creates   []TopicConfig
+	increases []TopicPartitionsChange
+	// describes contains the topics whose configs must be compared.
+	describes []TopicConfig
+	drifts    []TopicDrift
+}
+
+// plan compares the specification with the existing topics.
+func (t *Topics) plan(existing map[string]kafka.Topic) *topicsPlan {
+	pl := new(topicsPlan)
+	for _, tc := range t.Topics {
+		kt, ok := existing[tc.Name]
+		if !ok {
+			pl.creates = append(pl.creates, tc)
+			continue
+		}
+		pl.diffPartitions(tc, kt)
+		pl.diffReplicationFactor(tc, kt)
+		if len(tc.Configs) > 0 {
+			pl.describes = append(pl.describes, tc)
+		}
+	}
+	return pl
+}
+
+// diffPartitions increases the partitions if there are less than expected, and reports a drift if there are more.
+func (pl *topicsPlan) diffPartitions(tc TopicConfig, kt kafka.Topic) {
+	partitions := len(kt.Partitions)
+	if tc.Partitions > partitions {
+		pl.increases = append(pl.increases, TopicPartitionsChange{
+			Topic: tc.Name,
+			From:  partitions,
+			To:    tc.Partitions,
+		})
+	} else if tc.Partitions > 0 && tc.Partitions < partitions {
+		pl.drifts = append(pl.drifts, TopicDrift{
+			Topic:    tc.Name,
+			Name:     TopicDriftPartitions,
+			Expected: strconv.Itoa(tc.Partitions),
+			Actual:   strconv.Itoa(partitions),
+		})
+	}
+}
+
+func (pl *topicsPlan) diffReplicationFactor(tc TopicConfig, kt kafka.Topic) {
+	if tc.ReplicationFactor > 0 && len(kt.Partitions) > 0 && len(kt.Partitions[0].Replicas) != tc.ReplicationFactor {
+		pl.drifts = append(pl.drifts, TopicDrift{
+			Topic:    tc.Name,
+			Name:     TopicDriftReplicationFactor,
+			Expected: strconv.Itoa(tc.ReplicationFactor),
+			Actual:   strconv.Itoa(len(kt.Partitions[0].Replicas)),
+		})
+	}
+}
+
+func (t *Topics) getExisting(ctx context.Context) (map[string]kafka.Topic, error) {
+	names := make([]string, len(t.Topics))
+	for i, tc := range t.Topics {
+		names[i] = tc.Name
+	}
+	resp, err := t.Client.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: names,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "metadata")
+	}
+	existing := make(map[string]kafka.Topic, len(resp.Topics))
+	for _, kt := range resp.Topics {
+		if kt.Error != nil {
+			if errors.Is(kt.Error, kafka.UnknownTopicOrPartition) {
+				continue
+			}
+			return nil, errors.Wrapf(kt.Error, "topic %q", kt.Name)
+		}
+		existing[kt.Name] = kt
+	}
+	return existing, nil
+}
+
+func (t *Topics) create(ctx context.Context, tcs []TopicConfig) error {
+	if len(tcs) == 0 {
+		return nil
+	}
+	req := &kafka.CreateTopicsRequest{
+		ValidateOnly: t.DryRun,
+	}
+	for _, tc := range tcs {
+		ktc := kafka.TopicConfig{
+			Topic:             tc.Name,
+			NumPartitions:     -1,
+			ReplicationFactor: -1,
+		}
+		if tc.Partitions > 0 {
+			ktc.NumPartitions = tc.Partitions
+		}
+		if tc.ReplicationFactor > 0 {
+			ktc.ReplicationFactor = tc.ReplicationFactor
+		}
+		for _, name := range getSortedTopicConfigNames(tc.Configs) {
+			ktc.ConfigEntries = append(ktc.ConfigEntries, kafka.ConfigEntry{
+				ConfigName:  name,
+				ConfigValue: tc.Configs[name],
+			})
+		}
+		req.Topics = append(req.Topics, ktc)
+	}
+	resp, err := t.Client.CreateTopics(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	return getTopicsResponseError(resp.Errors)
+}
+
+func (t *Topics) increasePartitions(ctx context.Context, changes []TopicPartitionsChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	req := &kafka.CreatePartitionsRequest{
+		ValidateOnly: t.DryRun,
+	}
+	for _, c := range changes {
+		req.Topics = append(req.Topics, kafka.TopicPartitionsConfig{
+			Name:  c.Topic,
+			Count: int32(c.To),
+		})
+	}
+	resp, err := t.Client.CreatePartitions(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	return getTopicsResponseError(resp.Errors)
+}
+
+func (t *Topics) getConfigDrifts(ctx context.Context, tcs []TopicConfig) ([]TopicDrift, error) {
+	if len(tcs) == 0 {
+		return nil, nil
+	}
+	req := &kafka.DescribeConfigsRequest{}
+	for _, tc := range tcs {
+		req.Resources = append(req.Resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: tc.Name,
+			ConfigNames:  getSortedTopicConfigNames(tc.Configs),
+		})
+	}
+	resp, err := t.Client.DescribeConfigs(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "describe configs")
+	}
+	actuals := make(map[string]map[string]string, len(resp.Resources))
+	for _, res := range resp.Resources {
+		if res.Error != nil {
+			return nil, errors.Wrapf(res.Error, "topic %q", res.ResourceName)
+		}
+		m := make(map[string]string, len(res.ConfigEntries))
+		for _, e := range res.ConfigEntries {
+			m[e.ConfigName] = e.ConfigValue
+		}
+		actuals[res.ResourceName] = m
+	}
+	var drifts []TopicDrift
+	for _, tc := range tcs {
+		for _, name := range getSortedTopicConfigNames(tc.Configs) {
+			expected := tc.Configs[name]
+			actual := actuals[tc.Name][name]
+			if actual != expected {
+				drifts = append(drifts, TopicDrift{
+					Topic:    tc.Name,
+					Name:     name,
+					Expected: expected,
+					Actual:   actual,
+				})
+			}
+		}
+	}
+	return drifts, nil
+}
+
+func getSortedTopicConfigNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names) // For stable output.
+	return names
+}
+
+func getTopicsResponseError(errs map[string]error) error {
+	names := make([]string, 0, len(errs))
+	for name, err := range errs {
+		if err != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names) // For stable output.
+	err := errs[names[0]]
+	err = errors.Wrapf(err, "topic %q", names[0])
+	if len(names) > 1 {
+		err = wrapErrorValue(err, "topics", names)
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/kafkatest"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func newTestTopics(tb testing.TB) (*Topics, *kafkatest.Broker) {
+	tb.Helper()
+	ctx := context.Background()
+	b := kafkatest.NewBroker(tb)
+	_, err := b.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{
+			{
+				Topic:             "existing",
+				NumPartitions:     2,
+				ReplicationFactor: 1,
+				ConfigEntries: []kafka.ConfigEntry{
+					{
+						ConfigName:  "retention.ms",
+						ConfigValue: "1000",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		testutils.FatalErr(tb, err)
+	}
+	t := &Topics{
+		Client: b,
+		Topics: []TopicConfig{
+			{
+				Name:       "new",
+				Partitions: 3,
+				Configs: map[string]string{
+					"cleanup.policy": "compact",
+				},
+			},
+			{
+				Name:              "existing",
+				Partitions:        4,
+				ReplicationFactor: 3,
+				Configs: map[string]string{
+					"retention.ms":   "2000",
+					"cleanup.policy": "delete",
+				},
+			},
+		},
+	}
+	return t, b
+}
+
+func TestTopicsEnsure(t *testing.T) {
+	ctx := context.Background()
+	ts, b := newTestTopics(t)
+	r, err := ts.Ensure(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	expected := &TopicsReport{
+		Created: []string{"new"},
+		PartitionsIncreased: []TopicPartitionsChange{
+			{
+				Topic: "existing",
+				From:  2,
+				To:    4,
+			},
+		},
+		Drifts: []TopicDrift{
+			{
+				Topic:    "existing",
+				Name:     TopicDriftReplicationFactor,
+				Expected: "3",
+				Actual:   "1",
+			},
+			{
+				Topic:    "existing",
+				Name:     "cleanup.policy",
+				Expected: "delete",
+			},
+			{
+				Topic:    "existing",
+				Name:     "retention.ms",
+				Expected: "2000",
+				Actual:   "1000",
+			},
+		},
+	}
+	testutils.Compare(t, "unexpected report", r, expected)
+	if !r.HasChanges() {
+		t.Fatal("no changes")
+	}
+	if p := b.Partitions("new"); p != 3 {
+		t.Fatalf("unexpected partitions: got %d, want %d", p, 3)
+	}
+	if p := b.Partitions("existing"); p != 4 {
+		t.Fatalf("unexpected partitions: got %d, want %d", p, 4)
+	}
+	// Ensure is idempotent.
+	r, err = ts.Ensure(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	if r.HasChanges() {
+		t.Fatalf("unexpected changes: %+v", r)
+	}
+}
+
+func TestTopicsEnsureDryRun(t *testing.T) {
+	ctx := context.Background()
+	ts, b := newTestTopics(t)
+	ts.DryRun = true
+	r, err := ts.Ensure(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected created", r.Created, []string{"new"})
+	testutils.Compare(t, "unexpected partitions increased", r.PartitionsIncreased, []TopicPartitionsChange{
+		{
+			Topic: "existing",
+			From:  2,
+			To:    4,
+		},
+	})
+	if p := b.Partitions("new"); p != 0 {
+		t.Fatalf("the topic is created")
+	}
+	if p := b.Partitions("existing"); p != 2 {
+		t.Fatalf("unexpected partitions: got %d, want %d", p, 2)
+	}
+}
+
+func TestTopicsEnsurePartitionsDecrease(t *testing.T) {
+	ctx := context.Background()
+	ts, _ := newTestTopics(t)
+	ts.Topics = []TopicConfig{
+		{
+			Name:       "existing",
+			Partitions: 1,
+		},
+	}
+	r, err := ts.Ensure(ctx)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	testutils.Compare(t, "unexpected report", r, &TopicsReport{
+		Drifts: []TopicDrift{
+			{
+				Topic:    "existing",
+				Name:     TopicDriftPartitions,
+				Expected: "1",
+				Actual:   "2",
+			},
+		},
+	})
+}
+
+func TestTopicsEnsureError(t *testing.T) {
+	ctx := context.Background()
+	ts, b := newTestTopics(t)
+	ts.Topics = []TopicConfig{
+		{
+			Name:       "existing",
+			Partitions: 2,
+			Configs: map[string]string{
+				"retention.ms": "1000",
+			},
+		},
+	}
+	ts.Client = &testTopicsClientErrorDescribe{TopicsClient: b}
+	_, err := ts.Ensure(ctx)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+type testTopicsClientErrorDescribe struct {
+	TopicsClient
+}
+
+func (c *testTopicsClientErrorDescribe) DescribeConfigs(ctx context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error) {
+	return &kafka.DescribeConfigsResponse{
+		Resources: []kafka.DescribeConfigResponseResource{
+			{
+				ResourceName: "existing",
+				Error:        kafka.Unknown,
+			},
+		},
+	}, nil
+}
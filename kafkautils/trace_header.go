@@ -0,0 +1,169 @@
+package kafkautils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Trace headers.
+const (
+	// TraceHeaderParent is the W3C Trace Context header.
+	// See https://www.w3.org/TR/trace-context/ .
+	TraceHeaderParent = "traceparent"
+	// TraceHeaderDatadogTraceID is the Datadog trace ID header.
+	TraceHeaderDatadogTraceID = "x-datadog-trace-id"
+	// TraceHeaderDatadogParentID is the Datadog parent span ID header.
+	TraceHeaderDatadogParentID = "x-datadog-parent-id"
+	// TraceHeaderDatadogSamplingPriority is the Datadog sampling priority header.
+	TraceHeaderDatadogSamplingPriority = "x-datadog-sampling-priority"
+)
+
+// InjectTraceHeaders injects the span context of the current span into the headers.
+//
+// The headers are written by the tracer of the span (the Datadog headers for the Datadog tracer).
+// If the Datadog headers are written, the W3C "traceparent" header is also written.
+// The existing trace headers are replaced.
+//
+// It returns a new slice, the given slice is not modified.
+// If the context has no span, the headers are returned unchanged.
+func InjectTraceHeaders(ctx context.Context, hs []kafka.Header) []kafka.Header {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return hs
+	}
+	m := make(opentracing.TextMapCarrier)
+	err := span.Tracer().Inject(span.Context(), opentracing.TextMap, m)
+	if err != nil || len(m) == 0 {
+		return hs
+	}
+	tp, ok := getTraceParentFromDatadog(m)
+	if ok {
+		m[TraceHeaderParent] = tp
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // For stable output.
+	hs = append([]kafka.Header(nil), hs...)
+	for _, k := range keys {
+		hs = SetHeader(hs, k, []byte(m[k]))
+	}
+	return hs
+}
+
+// ExtractTraceContext extracts a span context from the headers, with the global tracer.
+//
+// The headers written by the tracer are used first (the Datadog headers for the Datadog tracer).
+// If the Datadog headers are not defined, the W3C "traceparent" header is converted to Datadog headers.
+//
+// It returns nil if there is no valid span context.
+func ExtractTraceContext(hs []kafka.Header) opentracing.SpanContext {
+	if len(hs) == 0 {
+		return nil
+	}
+	m := make(opentracing.TextMapCarrier, len(hs))
+	for _, h := range hs {
+		if _, ok := m[h.Key]; !ok {
+			m[h.Key] = string(h.Value)
+		}
+	}
+	if _, ok := m[TraceHeaderDatadogTraceID]; !ok {
+		setDatadogFromTraceParent(m)
+	}
+	sc, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, m)
+	if err != nil {
+		// Invalid trace headers must not prevent the consumption of the message.
+		return nil
+	}
+	return sc
+}
+
+// getTraceParentFromDatadog returns a W3C "traceparent" value from Datadog headers.
+//
+// The Datadog IDs are 64 bits, so the high 64 bits of the W3C trace ID are zero.
+func getTraceParentFromDatadog(m opentracing.TextMapCarrier) (string, bool) {
+	traceID, err := strconv.ParseUint(m[TraceHeaderDatadogTraceID], 10, 64)
+	if err != nil || traceID == 0 {
+		return "", false
+	}
+	parentID, err := strconv.ParseUint(m[TraceHeaderDatadogParentID], 10, 64)
+	if err != nil || parentID == 0 {
+		return "", false
+	}
+	flags := 0
+	priority, err := strconv.Atoi(m[TraceHeaderDatadogSamplingPriority])
+	if err == nil && priority > 0 {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%032x-%016x-%02x", traceID, parentID, flags), true
+}
+
+// setDatadogFromTraceParent sets the Datadog headers from the W3C "traceparent" header.
+//
+// Only the low 64 bits of the W3C trace ID are kept.
+func setDatadogFromTraceParent(m opentracing.TextMapCarrier) {
+	traceID, parentID, sampled, ok := parseTraceParent(m[TraceHeaderParent])
+	if !ok {
+		return
+	}
+	m[TraceHeaderDatadogTraceID] = strconv.FormatUint(traceID, 10)
+	m[TraceHeaderDatadogParentID] = strconv.FormatUint(parentID, 10)
+	priority := "0"
+	if sampled {
+		priority = "1"
+	}
+	m[TraceHeaderDatadogSamplingPriority] = priority
+}
+
+func parseTraceParent(s string) (traceID uint64, parentID uint64, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return 0, 0, false, false
+	}
+	version, traceIDHex, parentIDHex, flagsHex := parts[0], parts[1], parts[2], parts[3]
+	if !isTraceParentVersionValid(version, len(parts)) {
+		return 0, 0, false, false
+	}
+	if !isHexField(traceIDHex, 32) || !isHexField(parentIDHex, 16) || !isHexField(flagsHex, 2) {
+		return 0, 0, false, false
+	}
+	// The values are valid hexadecimal, so there is no parsing error.
+	traceID, _ = strconv.ParseUint(traceIDHex[16:], 16, 64)
+	parentID, _ = strconv.ParseUint(parentIDHex, 16, 64)
+	flags, _ := strconv.ParseUint(flagsHex, 16, 8)
+	if traceID == 0 || parentID == 0 {
+		return 0, 0, false, false
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// isTraceParentVersionValid returns true if the version of a "traceparent" header is supported.
+// The version "00" has exactly 4 fields, the future versions can have more.
+func isTraceParentVersionValid(version string, fields int) bool {
+	if !isHexField(version, 2) || version == "ff" {
+		return false
+	}
+	return version != "00" || fields == 4
+}
+
+// isHexField returns true if the string is lowercase hexadecimal with the given length.
+func isHexField(s string, length int) bool {
+	return len(s) == length && isHex(s)
+}
+
+// isHex returns true if the string is lowercase hexadecimal.
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package kafkautils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/segmentio/kafka-go"
+	"github.com/siddhant2408/golang-libraries/testutils"
+)
+
+func setTestGlobalTracer(tb testing.TB) *mocktracer.MockTracer {
+	tb.Helper()
+	tr := mocktracer.New()
+	old := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tr)
+	tb.Cleanup(func() {
+		opentracing.SetGlobalTracer(old)
+	})
+	return tr
+}
+
+func TestInjectExtractTraceHeaders(t *testing.T) {
+	tr := setTestGlobalTracer(t)
+	span := tr.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	hs := []kafka.Header{
+		{
+			Key:   "a",
+			Value: []byte("value a"),
+		},
+	}
+	newHs := InjectTraceHeaders(ctx, hs)
+	if len(hs) != 1 {
+		t.Fatalf("the given headers are modified: %v", hs)
+	}
+	if len(newHs) <= 1 {
+		t.Fatalf("no trace headers: %v", newHs)
+	}
+	// Injecting again replaces the existing trace headers.
+	newHs2 := InjectTraceHeaders(ctx, newHs)
+	if len(newHs2) != len(newHs) {
+		t.Fatalf("unexpected headers count: got %d, want %d", len(newHs2), len(newHs))
+	}
+	sc := ExtractTraceContext(newHs)
+	if sc == nil {
+		t.Fatal("no span context")
+	}
+	msc := sc.(mocktracer.MockSpanContext)                  //nolint:errcheck
+	expected := span.Context().(mocktracer.MockSpanContext) //nolint:errcheck
+	if msc.TraceID != expected.TraceID || msc.SpanID != expected.SpanID {
+		t.Fatalf("unexpected span context: got %+v, want %+v", msc, expected)
+	}
+}
+
+func TestInjectTraceHeadersNoSpan(t *testing.T) {
+	hs := []kafka.Header{
+		{
+			Key:   "a",
+			Value: []byte("value a"),
+		},
+	}
+	newHs := InjectTraceHeaders(context.Background(), hs)
+	testutils.Compare(t, "unexpected headers", newHs, hs)
+}
+
+func TestExtractTraceContextNone(t *testing.T) {
+	setTestGlobalTracer(t)
+	sc := ExtractTraceContext([]kafka.Header{
+		{
+			Key:   "a",
+			Value: []byte("value a"),
+		},
+	})
+	if sc != nil {
+		t.Fatalf("unexpected span context: %v", sc)
+	}
+}
+
+func TestTraceParentDatadog(t *testing.T) {
+	m := opentracing.TextMapCarrier{
+		TraceHeaderDatadogTraceID:          "1234",
+		TraceHeaderDatadogParentID:         "5678",
+		TraceHeaderDatadogSamplingPriority: "1",
+	}
+	tp, ok := getTraceParentFromDatadog(m)
+	if !ok {
+		t.Fatal("not ok")
+	}
+	expectedTP := "00-000000000000000000000000000004d2-000000000000162e-01"
+	if tp != expectedTP {
+		t.Fatalf("unexpected traceparent: got %q, want %q", tp, expectedTP)
+	}
+	m = opentracing.TextMapCarrier{
+		TraceHeaderParent: tp,
+	}
+	setDatadogFromTraceParent(m)
+	testutils.Compare(t, "unexpected headers", m, opentracing.TextMapCarrier{
+		TraceHeaderParent:                  tp,
+		TraceHeaderDatadogTraceID:          "1234",
+		TraceHeaderDatadogParentID:         "5678",
+		TraceHeaderDatadogSamplingPriority: "1",
+	})
+}
+
+func TestTraceParentDatadogInvalid(t *testing.T) {
+	_, ok := getTraceParentFromDatadog(opentracing.TextMapCarrier{
+		TraceHeaderDatadogTraceID: "invalid",
+	})
+	if ok {
+		t.Fatal("ok")
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		value    string
+		traceID  uint64
+		parentID uint64
+		sampled  bool
+		ok       bool
+	}{
+		{
+			name:     "Sampled",
+			value:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			traceID:  0xa3ce929d0e0e4736,
+			parentID: 0x00f067aa0ba902b7,
+			sampled:  true,
+			ok:       true,
+		},
+		{
+			name:     "NotSampled",
+			value:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			traceID:  0xa3ce929d0e0e4736,
+			parentID: 0x00f067aa0ba902b7,
+			ok:       true,
+		},
+		{
+			name:     "FutureVersion",
+			value:    "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			traceID:  0xa3ce929d0e0e4736,
+			parentID: 0x00f067aa0ba902b7,
+			sampled:  true,
+			ok:       true,
+		},
+		{
+			name:  "Empty",
+			value: "",
+		},
+		{
+			name:  "InvalidVersion",
+			value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "ExtraFieldVersion00",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			name:  "Uppercase",
+			value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "ZeroParentID",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			traceID, parentID, sampled, ok := parseTraceParent(tc.value)
+			if ok != tc.ok || traceID != tc.traceID || parentID != tc.parentID || sampled != tc.sampled {
+				t.Fatalf("unexpected result: got (%d, %d, %t, %t), want (%d, %d, %t, %t)", traceID, parentID, sampled, ok, tc.traceID, tc.parentID, tc.sampled, tc.ok)
+			}
+		})
+	}
+}
+
+func TestConsumerTraceContext(t *testing.T) {
+	ctx := context.Background()
+	tr := setTestGlobalTracer(t)
+	var msg kafka.Message
+	pr := func(ctx context.Context, msgs ...kafka.Message) error {
+		msg = msgs[0]
+		return nil
+	}
+	p := TopicProducer{
+		Producer: pr,
+		Topic:    "test",
+	}
+	parent := tr.StartSpan("parent")
+	err := p.Produce(opentracing.ContextWithSpan(ctx, parent), kafka.Message{})
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	parent.Finish()
+	c := &Consumer{
+		Processor: func(ctx context.Context, msg kafka.Message) error {
+			return nil
+		},
+	}
+	err = c.consumeMessage(context.Background(), &testFetchCommitter{
+		commit: func(context.Context, ...kafka.Message) error {
+			return nil
+		},
+	}, msg)
+	if err != nil {
+		testutils.FatalErr(t, err)
+	}
+	var span *mocktracer.MockSpan
+	for _, s := range tr.FinishedSpans() {
+		if s.OperationName == "kafka.consumer" {
+			span = s
+		}
+	}
+	if span == nil {
+		t.Fatal("no consumer span")
+	}
+	parentSC := parent.Context().(mocktracer.MockSpanContext) //nolint:errcheck
+	if span.SpanContext.TraceID != parentSC.TraceID || span.ParentID != parentSC.SpanID {
+		t.Fatalf("consumer span is not a child of the producer span: got trace %d parent %d, want trace %d parent %d", span.SpanContext.TraceID, span.ParentID, parentSC.TraceID, parentSC.SpanID)
+	}
+}
@@ -18,6 +18,11 @@ func startTraceRootSpan(pctx *context.Context, op string, perr *error) (opentrac
 	return tracingutils.StartRootSpan(pctx, "kafka."+op, perr)
 }
 
+// startTraceRemoteChildSpan starts a span that continues the trace propagated in the message headers.
+func startTraceRemoteChildSpan(pctx *context.Context, parent opentracing.SpanContext, op string, perr *error) (opentracing.Span, closeutils.F) {
+	return tracingutils.StartRemoteChildSpan(pctx, parent, "kafka."+op, perr)
+}
+
 func startTraceChildSpan(pctx *context.Context, op string, perr *error) (opentracing.Span, closeutils.F) {
 	return tracingutils.StartChildSpan(pctx, "kafka."+op, perr)
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"time"
 
+	opentracing_ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/segmentio/kafka-go"
 	"github.com/siddhant2408/golang-libraries/ctxutils"
 	"github.com/siddhant2408/golang-libraries/errors"
+	"github.com/siddhant2408/golang-libraries/goroutine"
 	"github.com/siddhant2408/golang-libraries/timeutils"
+	"github.com/siddhant2408/golang-libraries/tracingutils"
 )
 
 const (
@@ -24,14 +27,16 @@ type WaitProducer struct {
 
 // Produce adds a "wait-until" header and produces the messages.
 func (p *WaitProducer) Produce(ctx context.Context, msgs ...kafka.Message) error {
-	waitUntil := timeutils.Now().Add(p.Wait)
-	waitUntilStr := waitUntil.Format(waitUntilHeaderLayout)
-	waitUntilBytes := []byte(waitUntilStr)
+	setWaitUntilHeader(msgs, timeutils.Now().Add(p.Wait))
+	return p.Producer(ctx, msgs...)
+}
+
+func setWaitUntilHeader(msgs []kafka.Message, waitUntil time.Time) {
+	waitUntilBytes := []byte(waitUntil.Format(waitUntilHeaderLayout))
 	for i, msg := range msgs {
 		msg.Headers = SetHeader(msg.Headers, WaitUntilHeader, waitUntilBytes)
 		msgs[i] = msg
 	}
-	return p.Producer(ctx, msgs...)
 }
 
 // WaitConsumer allows to wait before processing a message.
@@ -42,40 +47,127 @@ func (p *WaitProducer) Produce(ctx context.Context, msgs ...kafka.Message) error
 //  - produce the message to Producer ("main" topic)
 //  - commit the message
 //
-// For each message, it waits until the date specified in the "wait-until" header.
 // If the header is not defined or is invalid, it doesn't wait.
 //
-// Warning: each consumer should read a single partition only.
-// Otherwise the wait delay might be wrongly interpreted.
-// The topic should have a single partition.
+// It doesn't sleep on a message.
+// If a message is not due yet, its partition is paused: the following messages of this partition are kept in memory, and the partition is resumed when the message is due.
+// The other partitions are not blocked, and the messages that are already due are forwarded immediately.
+// The messages of a partition are forwarded and committed in order, so the delays of a topic should be similar, see DelayProducer.
+//
+// A kafka.Reader can't pause the fetching of a single partition, so the pause only happens in memory.
+// When a message is fetched for a partition that has MaxPending messages, fetching is paused for all the partitions, until this partition has room.
+// The messages of the other partitions that are already in memory are still forwarded when they are due.
 type WaitConsumer struct {
 	Producer Producer
+	// MaxPending is the maximum count of fetched messages per partition that are not forwarded yet.
+	// Default: 100.
+	MaxPending int
 }
 
 // Consume consumes messages from a reader.
 func (c *WaitConsumer) Consume(ctx context.Context, r FetchCommitter) error {
-	for !ctxutils.IsDone(ctx) {
-		err := c.consume(ctx, r)
-		if err != nil {
-			return errors.Wrap(err, "wait consumer")
-		}
+	err := c.consume(ctx, r)
+	if err != nil {
+		return errors.Wrap(err, "wait consumer")
 	}
 	return nil
 }
 
 func (c *WaitConsumer) consume(ctx context.Context, r FetchCommitter) error {
-	msg, err := r.FetchMessage(ctx)
-	if err != nil {
+	fetchCtx, cancel := context.WithCancel(ctx)
+	fetched := make(chan waitConsumerFetchResult)
+	waitFetch := goroutine.Go(func() {
+		c.fetch(fetchCtx, r, fetched)
+	})
+	defer waitFetch()
+	defer cancel() // Must be called before waitFetch().
+	q := newWaitQueue(c.getMaxPending())
+	for {
+		err := c.forwardDue(ctx, r, q)
+		if err != nil {
+			return err
+		}
 		if ctxutils.IsDone(ctx) {
 			return nil
 		}
-		return errors.Wrap(err, "fetch")
+		fetchedC := fetched
+		if !q.release() {
+			fetchedC = nil // Pause fetching.
+		}
+		err = c.wait(ctx, fetchedC, q)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// wait waits until a message is fetched, the next pending message is due, or the context is done.
+func (c *WaitConsumer) wait(ctx context.Context, fetched <-chan waitConsumerFetchResult, q *waitQueue) error {
+	var tmC <-chan time.Time
+	next, ok := q.next()
+	if ok {
+		tm := time.NewTimer(timeutils.Until(next))
+		defer tm.Stop()
+		tmC = tm.C
 	}
-	c.wait(ctx, msg)
-	if ctxutils.IsDone(ctx) {
-		return nil
+	select {
+	case res := <-fetched:
+		if res.err != nil {
+			if ctxutils.IsDone(ctx) {
+				return nil
+			}
+			return errors.Wrap(res.err, "fetch")
+		}
+		q.push(res.msg)
+	case <-tmC:
+		q.reached = next
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+type waitConsumerFetchResult struct {
+	msg kafka.Message
+	err error
+}
+
+func (c *WaitConsumer) fetch(ctx context.Context, r FetchCommitter, fetched chan<- waitConsumerFetchResult) {
+	for {
+		msg, err := r.FetchMessage(ctx)
+		select {
+		case fetched <- waitConsumerFetchResult{msg: msg, err: err}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *WaitConsumer) forwardDue(ctx context.Context, r FetchCommitter, q *waitQueue) error {
+	for {
+		msg, ok := q.popDue()
+		if !ok {
+			return nil
+		}
+		if ctxutils.IsDone(ctx) {
+			return nil
+		}
+		err := c.forwardMessage(r, msg)
+		if err != nil {
+			return errors.Wrap(err, "message")
+		}
 	}
-	ctx = context.Background() // Don't want to be interrupted
+}
+
+func (c *WaitConsumer) forwardMessage(r FetchCommitter, msg kafka.Message) (err error) {
+	ctx := context.Background() // Don't want to be interrupted
+	span, spanFinish := startTraceRemoteChildSpan(&ctx, ExtractTraceContext(msg.Headers), "wait_consumer", &err)
+	defer spanFinish()
+	tracingutils.SetSpanType(span, tracingutils.SpanTypeMessageConsumer)
+	opentracing_ext.SpanKindConsumer.Set(span)
+	setTraceSpanTagsMessageConsumer(span, msg)
 	newMsg := CopyMessage(msg)
 	newMsg.Headers = DeleteHeader(newMsg.Headers, WaitUntilHeader)
 	err = c.Producer(ctx, newMsg)
@@ -89,30 +181,128 @@ func (c *WaitConsumer) consume(ctx context.Context, r FetchCommitter) error {
 	return nil
 }
 
-func (c *WaitConsumer) wait(ctx context.Context, msg kafka.Message) {
+func (c *WaitConsumer) getMaxPending() int {
+	if c.MaxPending > 0 {
+		return c.MaxPending
+	}
+	return 100
+}
+
+// getWaitUntil returns the date defined by the "wait-until" header.
+//
+// If the header is not defined or is invalid, it returns false.
+func getWaitUntil(msg kafka.Message) (time.Time, bool) {
 	waitUntilBytes, ok := GetHeader(msg.Headers, WaitUntilHeader)
 	if !ok {
-		// If the header is not defined: don't wait.
-		return
+		return time.Time{}, false
 	}
-	waitUntilStr := string(waitUntilBytes)
-	waitUntil, err := time.Parse(waitUntilHeaderLayout, waitUntilStr)
+	waitUntil, err := time.Parse(waitUntilHeaderLayout, string(waitUntilBytes))
 	if err != nil {
-		// If the date format is invalid: don't wait.
-		return
+		return time.Time{}, false
 	}
-	dur := timeutils.Until(waitUntil)
-	if dur <= 0 {
-		// If the date is in the past: don't wait.
+	return waitUntil, true
+}
+
+// waitQueue contains the fetched messages, by partition, in fetch order.
+//
+// A partition is paused while its first message is not due.
+// A partition contains at most maxPartition messages, the message fetched for a full partition is held until it has room.
+type waitQueue struct {
+	partitions   map[waitQueuePartitionKey][]waitQueueMessage
+	maxPartition int
+	held         *kafka.Message
+	// reached is the last date reached by a timer.
+	// The messages before it are due, even if the current time is not updated (e.g. fixed time in tests).
+	reached time.Time
+}
+
+type waitQueuePartitionKey struct {
+	topic     string
+	partition int
+}
+
+type waitQueueMessage struct {
+	msg       kafka.Message
+	waitUntil time.Time
+}
+
+func newWaitQueue(maxPartition int) *waitQueue {
+	return &waitQueue{
+		partitions:   make(map[waitQueuePartitionKey][]waitQueueMessage),
+		maxPartition: maxPartition,
+	}
+}
+
+// push adds a message to its partition.
+// If the partition is full, the message is held.
+func (q *waitQueue) push(msg kafka.Message) {
+	k := getWaitQueuePartitionKey(msg)
+	if len(q.partitions[k]) >= q.maxPartition {
+		q.held = &msg
 		return
 	}
-	tm := time.NewTimer(dur)
-	defer tm.Stop()
-	// Wait until the date or the context is done.
-	select {
-	case <-tm.C:
-	case <-ctx.Done():
+	waitUntil, _ := getWaitUntil(msg) // If the header is not defined or is invalid, the zero date is always due.
+	q.partitions[k] = append(q.partitions[k], waitQueueMessage{
+		msg:       msg,
+		waitUntil: waitUntil,
+	})
+}
+
+// release adds the held message to its partition if it has room.
+// It returns false if a message is still held, and fetching must be paused.
+func (q *waitQueue) release() bool {
+	if q.held == nil {
+		return true
+	}
+	msg := *q.held
+	if len(q.partitions[getWaitQueuePartitionKey(msg)]) >= q.maxPartition {
+		return false
+	}
+	q.held = nil
+	q.push(msg)
+	return true
+}
+
+func getWaitQueuePartitionKey(msg kafka.Message) waitQueuePartitionKey {
+	return waitQueuePartitionKey{
+		topic:     msg.Topic,
+		partition: msg.Partition,
+	}
+}
+
+// popDue removes and returns the first message of a partition that is due.
+func (q *waitQueue) popDue() (kafka.Message, bool) {
+	now := timeutils.Now()
+	if q.reached.After(now) {
+		now = q.reached
+	}
+	for k, wms := range q.partitions {
+		if wms[0].waitUntil.After(now) {
+			continue
+		}
+		msg := wms[0].msg
+		if len(wms) == 1 {
+			delete(q.partitions, k)
+		} else {
+			wms[0] = waitQueueMessage{} // Allow garbage collection.
+			q.partitions[k] = wms[1:]
+		}
+		return msg, true
+	}
+	return kafka.Message{}, false
+}
+
+// next returns the date of the next message that will be due.
+func (q *waitQueue) next() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, wms := range q.partitions {
+		if !found || wms[0].waitUntil.Before(next) {
+			next = wms[0].waitUntil
+			found = true
+		}
 	}
+	return next, found
 }
 
 // RunWaitConsumers runs wait consumers.
@@ -3,6 +3,7 @@ package timeutils
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/siddhant2408/golang-libraries/errors"
@@ -10,10 +11,11 @@ import (
 
 // Now returns the current time (real by default).
 func Now() time.Time {
-	return now()
+	return now.Load().(func() time.Time)()
 }
 
-var now func() time.Time
+// now is an atomic value, because the time can be changed by a test while it is read by other goroutines.
+var now atomic.Value
 
 func init() {
 	InitReal()
@@ -21,7 +23,7 @@ func init() {
 
 // InitReal initializes the time to real time.
 func InitReal() {
-	now = time.Now
+	now.Store(time.Now)
 }
 
 // InitFixed initializes the time to a fixed value.
@@ -31,9 +33,9 @@ func InitFixed() {
 
 // SetFixed sets the time to a fixed value.
 func SetFixed(t time.Time) {
-	now = func() time.Time {
+	now.Store(func() time.Time {
 		return t
-	}
+	})
 }
 
 // Since is a replacement for `time.Since()`.
@@ -84,6 +84,20 @@ func StartRootSpanWithTracer(pctx *context.Context, tr opentracing.Tracer, opera
 	return startSpan(pctx, tr, operationName, nil, perr)
 }
 
+// StartRemoteChildSpan starts a span with the global tracer, that is a child of a remote span context.
+// The remote span context is usually extracted from the headers of a request or message.
+// If the parent is nil, it starts a root span.
+// It returns a "close" function that allows to finish the span.
+// The pctx parameter updates the context with  new one containing the span.
+// The perr parameter automatically tracks the returned error.
+func StartRemoteChildSpan(pctx *context.Context, parent opentracing.SpanContext, operationName string, perr *error) (opentracing.Span, closeutils.F) {
+	var opts []opentracing.StartSpanOption
+	if parent != nil {
+		opts = []opentracing.StartSpanOption{opentracing.ChildOf(parent)}
+	}
+	return startSpan(pctx, opentracing.GlobalTracer(), operationName, opts, perr)
+}
+
 // StartChildSpan start a child span.
 // If the current context has no span, a noop span is returned.
 // It uses the same tracer as the current span.